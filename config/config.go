@@ -54,6 +54,8 @@ var (
 const (
 	// DefStoresRefreshInterval is the default value of StoresRefreshInterval
 	DefStoresRefreshInterval = 60
+	// DefTxnEntrySizeLimit is the default value of TxnEntrySizeLimit, it's 6 MiB.
+	DefTxnEntrySizeLimit = 6 * 1024 * 1024
 )
 
 func init() {
@@ -82,6 +84,9 @@ type Config struct {
 	RegionsRefreshInterval uint64
 	// EnablePreload indicates whether to preload region info when initializing the client.
 	EnablePreload bool
+	// TxnEntrySizeLimit is the size limit of a key-value entry of transactions in bytes, which is reported by the
+	// errors built by error.NewErrEntryTooLarge. A MemBuffer enforces the limit set by its SetEntrySizeLimit.
+	TxnEntrySizeLimit uint64
 }
 
 // DefaultConfig returns the default configuration.
@@ -99,6 +104,7 @@ func DefaultConfig() Config {
 		TxnScope:              "",
 		EnableAsyncCommit:     false,
 		Enable1PC:             false,
+		TxnEntrySizeLimit:     DefTxnEntrySizeLimit,
 	}
 }
 
@@ -0,0 +1,527 @@
+// Copyright 2024 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package error
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/deadlock"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pkg/errors"
+	"github.com/tikv/client-go/v2/util/redact"
+)
+
+// ErrorCode is the stable code of an error defined in this package, which can be used to identify the error
+// after it's propagated across services. The codes must never be changed or reused.
+type ErrorCode int
+
+// CodeGeneric is the code of errors not defined in this package.
+const CodeGeneric ErrorCode = 0
+
+// Codes of the sentinel errors.
+const (
+	CodeBodyMissing                 ErrorCode = 1
+	CodeTiDBShuttingDown            ErrorCode = 2
+	CodeNotExist                    ErrorCode = 3
+	CodeCannotSetNilValue           ErrorCode = 4
+	CodeInvalidTxn                  ErrorCode = 5
+	CodeTiKVServerTimeout           ErrorCode = 6
+	CodeTiFlashServerTimeout        ErrorCode = 7
+	CodeQueryInterrupted            ErrorCode = 8
+	CodeTiKVStaleCommand            ErrorCode = 9
+	CodeTiKVMaxTimestampNotSynced   ErrorCode = 10
+	CodeLockAcquireFailAndNoWaitSet ErrorCode = 11
+	CodeResolveLockTimeout          ErrorCode = 12
+	CodeLockWaitTimeout             ErrorCode = 13
+	CodeTiKVServerBusy              ErrorCode = 14
+	CodeTiFlashServerBusy           ErrorCode = 15
+	CodeRegionUnavailable           ErrorCode = 16
+	CodeRegionDataNotReady          ErrorCode = 17
+	CodeRegionNotInitialized        ErrorCode = 18
+	CodeTiKVDiskFull                ErrorCode = 19
+	CodeRegionRecoveryInProgress    ErrorCode = 20
+	CodeRegionFlashbackInProgress   ErrorCode = 21
+	CodeRegionFlashbackNotPrepared  ErrorCode = 22
+	CodeIsWitness                   ErrorCode = 23
+	CodeUnknown                     ErrorCode = 24
+	CodeResultUndetermined          ErrorCode = 25
+	CodeWriteInBestEffortTxn        ErrorCode = 26
+	CodeSnapshotInvalidated         ErrorCode = 27
+	CodeClientClosed                ErrorCode = 28
+	CodeNotLeader                   ErrorCode = 29
+	CodeRegionNotFound              ErrorCode = 30
+	CodeKeyNotInRegion              ErrorCode = 31
+	CodeEpochNotMatch               ErrorCode = 32
+	CodeStoreNotMatch               ErrorCode = 33
+	CodeRaftEntryTooLarge           ErrorCode = 34
+	CodeReadIndexNotReady           ErrorCode = 35
+	CodeProposalInMergingMode       ErrorCode = 36
+	CodeMismatchPeerID              ErrorCode = 37
+	CodeBucketVersionNotMatch       ErrorCode = 38
+)
+
+// Codes of the error types.
+const (
+	CodeQueryInterruptedWithSignal    ErrorCode = 100
+	CodeDeadlock                      ErrorCode = 101
+	CodePDError                       ErrorCode = 102
+	CodeKeyExist                      ErrorCode = 103
+	CodeWriteConflict                 ErrorCode = 104
+	CodeWriteConflictInLatch          ErrorCode = 105
+	CodeRetryable                     ErrorCode = 106
+	CodeFlashbackInProgress           ErrorCode = 107
+	CodeTxnTooLarge                   ErrorCode = 108
+	CodeEntryTooLarge                 ErrorCode = 109
+	CodePDServerTimeout               ErrorCode = 110
+	CodeGCTooEarly                    ErrorCode = 111
+	CodeSnapshotLostToGC              ErrorCode = 112
+	CodeInvalidSavepoint              ErrorCode = 113
+	CodeKeyTTLUnsupported             ErrorCode = 114
+	CodeTokenLimit                    ErrorCode = 115
+	CodeAssertionFailed               ErrorCode = 116
+	CodeLockOnlyIfExistsNoReturnValue ErrorCode = 117
+	CodeLockOnlyIfExistsNoPrimaryKey  ErrorCode = 118
+	CodePDNoLeader                    ErrorCode = 119
+	CodeRegion                        ErrorCode = 120
+	CodeDataNotReady                  ErrorCode = 121
+	CodeMultipleKeyErrors             ErrorCode = 122
+	CodeLockWaitTimeoutDetail         ErrorCode = 123
+)
+
+var sentinelCodes = map[error]ErrorCode{
+	ErrBodyMissing:                 CodeBodyMissing,
+	ErrTiDBShuttingDown:            CodeTiDBShuttingDown,
+	ErrNotExist:                    CodeNotExist,
+	ErrCannotSetNilValue:           CodeCannotSetNilValue,
+	ErrInvalidTxn:                  CodeInvalidTxn,
+	ErrTiKVServerTimeout:           CodeTiKVServerTimeout,
+	ErrTiFlashServerTimeout:        CodeTiFlashServerTimeout,
+	ErrQueryInterrupted:            CodeQueryInterrupted,
+	ErrTiKVStaleCommand:            CodeTiKVStaleCommand,
+	ErrTiKVMaxTimestampNotSynced:   CodeTiKVMaxTimestampNotSynced,
+	ErrLockAcquireFailAndNoWaitSet: CodeLockAcquireFailAndNoWaitSet,
+	ErrResolveLockTimeout:          CodeResolveLockTimeout,
+	ErrLockWaitTimeout:             CodeLockWaitTimeout,
+	ErrTiKVServerBusy:              CodeTiKVServerBusy,
+	ErrTiFlashServerBusy:           CodeTiFlashServerBusy,
+	ErrRegionUnavailable:           CodeRegionUnavailable,
+	ErrRegionDataNotReady:          CodeRegionDataNotReady,
+	ErrRegionNotInitialized:        CodeRegionNotInitialized,
+	ErrTiKVDiskFull:                CodeTiKVDiskFull,
+	ErrRegionRecoveryInProgress:    CodeRegionRecoveryInProgress,
+	ErrRegionFlashbackInProgress:   CodeRegionFlashbackInProgress,
+	ErrRegionFlashbackNotPrepared:  CodeRegionFlashbackNotPrepared,
+	ErrIsWitness:                   CodeIsWitness,
+	ErrUnknown:                     CodeUnknown,
+	ErrResultUndetermined:          CodeResultUndetermined,
+	ErrWriteInBestEffortTxn:        CodeWriteInBestEffortTxn,
+	ErrSnapshotInvalidated:         CodeSnapshotInvalidated,
+	ErrClientClosed:                CodeClientClosed,
+	ErrNotLeader:                   CodeNotLeader,
+	ErrRegionNotFound:              CodeRegionNotFound,
+	ErrKeyNotInRegion:              CodeKeyNotInRegion,
+	ErrEpochNotMatch:               CodeEpochNotMatch,
+	ErrStoreNotMatch:               CodeStoreNotMatch,
+	ErrRaftEntryTooLarge:           CodeRaftEntryTooLarge,
+	ErrReadIndexNotReady:           CodeReadIndexNotReady,
+	ErrProposalInMergingMode:       CodeProposalInMergingMode,
+	ErrMismatchPeerID:              CodeMismatchPeerID,
+	ErrBucketVersionNotMatch:       CodeBucketVersionNotMatch,
+}
+
+var codeSentinels = func() map[ErrorCode]error {
+	m := make(map[ErrorCode]error, len(sentinelCodes))
+	for err, code := range sentinelCodes {
+		m[code] = err
+	}
+	return m
+}()
+
+// CodeOf returns the code of the outermost error defined in this package in the chain of err.
+// It returns CodeGeneric if there is none.
+func CodeOf(err error) ErrorCode {
+	for ; err != nil; err = stderrors.Unwrap(err) {
+		if code := codeOf(err); code != CodeGeneric {
+			return code
+		}
+	}
+	return CodeGeneric
+}
+
+func codeOf(err error) ErrorCode {
+	switch err.(type) {
+	case ErrQueryInterruptedWithSignal:
+		return CodeQueryInterruptedWithSignal
+	case *ErrDeadlock:
+		return CodeDeadlock
+	case *PDError:
+		return CodePDError
+	case *ErrKeyExist:
+		return CodeKeyExist
+	case *ErrWriteConflict:
+		return CodeWriteConflict
+	case *ErrWriteConflictInLatch:
+		return CodeWriteConflictInLatch
+	case *ErrRetryable:
+		return CodeRetryable
+	case *ErrFlashbackInProgress:
+		return CodeFlashbackInProgress
+	case *ErrTxnTooLarge:
+		return CodeTxnTooLarge
+	case *ErrEntryTooLarge:
+		return CodeEntryTooLarge
+	case *ErrPDServerTimeout:
+		return CodePDServerTimeout
+	case *ErrGCTooEarly:
+		return CodeGCTooEarly
+	case *ErrSnapshotLostToGC:
+		return CodeSnapshotLostToGC
+	case *ErrInvalidSavepoint:
+		return CodeInvalidSavepoint
+	case *ErrKeyTTLUnsupported:
+		return CodeKeyTTLUnsupported
+	case *ErrTokenLimit:
+		return CodeTokenLimit
+	case *ErrAssertionFailed:
+		return CodeAssertionFailed
+	case *ErrLockOnlyIfExistsNoReturnValue:
+		return CodeLockOnlyIfExistsNoReturnValue
+	case *ErrLockOnlyIfExistsNoPrimaryKey:
+		return CodeLockOnlyIfExistsNoPrimaryKey
+	case *ErrPDNoLeader:
+		return CodePDNoLeader
+	case *ErrRegion:
+		return CodeRegion
+	case *ErrDataNotReady:
+		return CodeDataNotReady
+	case *ErrMultipleKeyErrors:
+		return CodeMultipleKeyErrors
+	case *ErrLockWaitTimeoutDetail:
+		return CodeLockWaitTimeoutDetail
+	}
+	return sentinelCodes[err]
+}
+
+// errorPayload is the JSON form of an error.
+type errorPayload struct {
+	Code    ErrorCode       `json:"code"`
+	Message string          `json:"message"`
+	Detail  json.RawMessage `json:"detail,omitempty"`
+}
+
+// snapshotLostToGCDetail is the detail of ErrSnapshotLostToGC, whose cause is marshaled recursively.
+type snapshotLostToGCDetail struct {
+	StartTS uint64          `json:"start_ts"`
+	Cause   json.RawMessage `json:"cause"`
+}
+
+// regionDetail is the detail of ErrRegion, whose cause is marshaled recursively like snapshotLostToGCDetail.
+type regionDetail struct {
+	Cause              json.RawMessage `json:"cause"`
+	RegionID           uint64          `json:"region_id,omitempty"`
+	LeaderStoreID      uint64          `json:"leader_store_id,omitempty"`
+	Backoff            time.Duration   `json:"backoff,omitempty"`
+	RefreshRegionCache bool            `json:"refresh_region_cache,omitempty"`
+	Reason             string          `json:"reason,omitempty"`
+	Message            string          `json:"message,omitempty"`
+}
+
+// multipleKeyErrorsDetail is the detail of ErrMultipleKeyErrors, whose members are marshaled recursively in order.
+type multipleKeyErrorsDetail struct {
+	Errors []json.RawMessage `json:"errors"`
+}
+
+// MarshalError marshals err into JSON with its code, message and the fields of the outermost error defined in
+// this package, which can be reconstructed by UnmarshalError. If redact.NeedRedact is true, the keys in the error
+// are printed by redact.Key, so that a custom key redactor applies, and the values are dropped.
+func MarshalError(err error) ([]byte, error) {
+	if err == nil {
+		return []byte("null"), nil
+	}
+	p := errorPayload{Message: err.Error()}
+	var e error
+	for e = err; e != nil; e = stderrors.Unwrap(e) {
+		if p.Code = codeOf(e); p.Code != CodeGeneric {
+			break
+		}
+	}
+	if redact.NeedRedact() {
+		// the message is already redacted by the Error methods.
+		e = redactError(e)
+	}
+
+	var detail interface{}
+	switch x := e.(type) {
+	case *ErrSnapshotLostToGC:
+		cause, err := MarshalError(x.Cause)
+		if err != nil {
+			return nil, err
+		}
+		detail = snapshotLostToGCDetail{StartTS: x.StartTS, Cause: cause}
+	case *ErrRegion:
+		cause, err := MarshalError(x.Cause)
+		if err != nil {
+			return nil, err
+		}
+		detail = regionDetail{
+			Cause:              cause,
+			RegionID:           x.RegionID,
+			LeaderStoreID:      x.LeaderStoreID,
+			Backoff:            x.Backoff,
+			RefreshRegionCache: x.RefreshRegionCache,
+			Reason:             x.Reason,
+			Message:            x.Message,
+		}
+	case *ErrMultipleKeyErrors:
+		d := multipleKeyErrorsDetail{Errors: make([]json.RawMessage, 0, len(x.errs))}
+		for _, member := range x.errs {
+			m, err := MarshalError(member)
+			if err != nil {
+				return nil, err
+			}
+			d.Errors = append(d.Errors, m)
+		}
+		detail = d
+	case *ErrPDServerTimeout:
+		// the message is the only field.
+	default:
+		if _, ok := codeSentinels[p.Code]; !ok && p.Code != CodeGeneric {
+			detail = x
+		}
+	}
+	if detail != nil {
+		d, err := json.Marshal(detail)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		p.Detail = d
+	}
+	data, err := json.Marshal(p)
+	return data, errors.WithStack(err)
+}
+
+// UnmarshalError reconstructs the error marshaled by MarshalError. The sentinel errors are matched by errors.Is
+// and the error types are matched by errors.As, the message of the marshaled error is kept if it's wrapped.
+// An unknown code is unmarshaled into a generic error with the message. If data is malformed, the returned
+// error describes the failure.
+func UnmarshalError(data []byte) error {
+	var p *errorPayload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return errors.Wrap(err, "malformed error payload")
+	}
+	if p == nil {
+		return nil
+	}
+	e, err := p.decode()
+	if err != nil {
+		return errors.Wrapf(err, "malformed error payload of code %d", p.Code)
+	}
+	if e.Error() != p.Message {
+		return &unmarshaledError{msg: p.Message, cause: e}
+	}
+	return e
+}
+
+// decode reconstructs the error from the payload, the returned error is not nil if the detail is malformed.
+func (p *errorPayload) decode() (error, error) {
+	if e, ok := codeSentinels[p.Code]; ok {
+		return e, nil
+	}
+	var e error
+	switch p.Code {
+	case CodeQueryInterruptedWithSignal:
+		var x ErrQueryInterruptedWithSignal
+		if err := json.Unmarshal(p.Detail, &x); err != nil {
+			return nil, err
+		}
+		return x, nil
+	case CodeDeadlock:
+		e = &ErrDeadlock{}
+	case CodePDError:
+		e = &PDError{}
+	case CodeKeyExist:
+		e = &ErrKeyExist{}
+	case CodeWriteConflict:
+		e = &ErrWriteConflict{}
+	case CodeWriteConflictInLatch:
+		e = &ErrWriteConflictInLatch{}
+	case CodeRetryable:
+		e = &ErrRetryable{}
+	case CodeFlashbackInProgress:
+		e = &ErrFlashbackInProgress{}
+	case CodeTxnTooLarge:
+		e = &ErrTxnTooLarge{}
+	case CodeEntryTooLarge:
+		e = &ErrEntryTooLarge{}
+	case CodePDServerTimeout:
+		return NewErrPDServerTimeout(p.Message), nil
+	case CodeGCTooEarly:
+		e = &ErrGCTooEarly{}
+	case CodeSnapshotLostToGC:
+		var d snapshotLostToGCDetail
+		if err := json.Unmarshal(p.Detail, &d); err != nil {
+			return nil, err
+		}
+		return &ErrSnapshotLostToGC{StartTS: d.StartTS, Cause: UnmarshalError(d.Cause)}, nil
+	case CodeRegion:
+		var d regionDetail
+		if err := json.Unmarshal(p.Detail, &d); err != nil {
+			return nil, err
+		}
+		return &ErrRegion{
+			Cause:              UnmarshalError(d.Cause),
+			RegionID:           d.RegionID,
+			LeaderStoreID:      d.LeaderStoreID,
+			Backoff:            d.Backoff,
+			RefreshRegionCache: d.RefreshRegionCache,
+			Reason:             d.Reason,
+			Message:            d.Message,
+		}, nil
+	case CodeMultipleKeyErrors:
+		var d multipleKeyErrorsDetail
+		if err := json.Unmarshal(p.Detail, &d); err != nil {
+			return nil, err
+		}
+		if len(d.Errors) == 0 {
+			return nil, errors.New("no key error")
+		}
+		errs := make([]error, 0, len(d.Errors))
+		for _, m := range d.Errors {
+			errs = append(errs, UnmarshalError(m))
+		}
+		return &ErrMultipleKeyErrors{errs: errs}, nil
+	case CodeInvalidSavepoint:
+		e = &ErrInvalidSavepoint{}
+	case CodeKeyTTLUnsupported:
+		e = &ErrKeyTTLUnsupported{}
+	case CodeTokenLimit:
+		e = &ErrTokenLimit{}
+	case CodeAssertionFailed:
+		e = &ErrAssertionFailed{}
+	case CodeLockOnlyIfExistsNoReturnValue:
+		e = &ErrLockOnlyIfExistsNoReturnValue{}
+	case CodeLockOnlyIfExistsNoPrimaryKey:
+		e = &ErrLockOnlyIfExistsNoPrimaryKey{}
+	case CodePDNoLeader:
+		e = &ErrPDNoLeader{}
+	case CodeDataNotReady:
+		e = &ErrDataNotReady{}
+	case CodeLockWaitTimeoutDetail:
+		e = &ErrLockWaitTimeoutDetail{}
+	default:
+		return stderrors.New(p.Message), nil
+	}
+	if err := json.Unmarshal(p.Detail, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// unmarshaledError keeps the message of a marshaled error whose outermost error defined in this package is
+// wrapped, the reconstructed error is its cause.
+type unmarshaledError struct {
+	msg   string
+	cause error
+}
+
+func (e *unmarshaledError) Error() string {
+	return e.msg
+}
+
+// Unwrap returns the reconstructed error.
+func (e *unmarshaledError) Unwrap() error {
+	return e.cause
+}
+
+// Cause returns the reconstructed error, it's used by errors.Cause of github.com/pkg/errors.
+func (e *unmarshaledError) Cause() error {
+	return e.cause
+}
+
+// redactError returns a copy of err whose keys are printed by redact.Key and whose values and TiKV messages are
+// dropped, err itself is returned if it carries none of them.
+func redactError(err error) error {
+	switch x := err.(type) {
+	case *ErrDeadlock:
+		if x.Deadlock == nil {
+			return err
+		}
+		d := *x.Deadlock
+		d.LockKey = redactKey(d.LockKey)
+		d.WaitChain = make([]*deadlock.WaitForEntry, 0, len(x.WaitChain))
+		for _, w := range x.WaitChain {
+			entry := *w
+			entry.Key = redactKey(entry.Key)
+			d.WaitChain = append(d.WaitChain, &entry)
+		}
+		return &ErrDeadlock{Deadlock: &d, IsRetryable: x.IsRetryable}
+	case *ErrKeyExist:
+		var alreadyExist *kvrpcpb.AlreadyExist
+		if x.AlreadyExist != nil {
+			alreadyExist = &kvrpcpb.AlreadyExist{Key: redactKey(x.AlreadyExist.Key)}
+		}
+		return &ErrKeyExist{AlreadyExist: alreadyExist}
+	case *ErrWriteConflict:
+		if x.WriteConflict == nil {
+			return err
+		}
+		w := *x.WriteConflict
+		w.Key, w.Primary = redactKey(w.Key), redactKey(w.Primary)
+		return &ErrWriteConflict{WriteConflict: &w}
+	case *ErrAssertionFailed:
+		if x.AssertionFailed == nil {
+			return err
+		}
+		a := *x.AssertionFailed
+		a.Key = redactKey(a.Key)
+		return &ErrAssertionFailed{AssertionFailed: &a}
+	case *ErrLockOnlyIfExistsNoReturnValue:
+		e := *x
+		e.LockKey = redactKey(e.LockKey)
+		return &e
+	case *ErrLockOnlyIfExistsNoPrimaryKey:
+		e := *x
+		e.LockKey = redactKey(e.LockKey)
+		return &e
+	case *ErrEntryTooLarge:
+		e := *x
+		e.Key = redactKey(e.Key)
+		return &e
+	case *ErrTxnTooLarge:
+		if len(x.TopPrefixes) == 0 {
+			return err
+		}
+		e := ErrTxnTooLarge{Size: x.Size, TopPrefixes: make([]PrefixSize, 0, len(x.TopPrefixes))}
+		for _, p := range x.TopPrefixes {
+			e.TopPrefixes = append(e.TopPrefixes, PrefixSize{Prefix: redactKey(p.Prefix), Size: p.Size})
+		}
+		return &e
+	case *ErrLockWaitTimeoutDetail:
+		e := *x
+		e.Key, e.Primary = redactKey(e.Key), redactKey(e.Primary)
+		return &e
+	case *ErrRegion:
+		// the message of TiKV may contain the keys.
+		if x.Message == "" {
+			return err
+		}
+		e := *x
+		e.Message = ""
+		return &e
+	}
+	return err
+}
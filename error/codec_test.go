@@ -0,0 +1,220 @@
+// Copyright 2024 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package error
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/deadlock"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/pdpb"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/util/redact"
+)
+
+func roundTrip(t *testing.T, err error) error {
+	data, e := MarshalError(err)
+	require.Nil(t, e)
+	return UnmarshalError(data)
+}
+
+func TestMarshalErrorTypes(t *testing.T) {
+	txnStart := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+	cases := []struct {
+		err  error
+		code ErrorCode
+	}{
+		{ErrQueryInterruptedWithSignal{Signal: 1}, CodeQueryInterruptedWithSignal},
+		{&ErrDeadlock{Deadlock: &kvrpcpb.Deadlock{
+			LockTs:          1,
+			LockKey:         []byte("k"),
+			DeadlockKeyHash: 2,
+			WaitChain:       []*deadlock.WaitForEntry{{Txn: 1, WaitForTxn: 2, KeyHash: 3, Key: []byte("k1")}},
+		}, IsRetryable: true}, CodeDeadlock},
+		{&PDError{Err: &pdpb.Error{Type: pdpb.ErrorType_NOT_BOOTSTRAPPED, Message: "not bootstrapped"}}, CodePDError},
+		{&ErrKeyExist{AlreadyExist: &kvrpcpb.AlreadyExist{Key: []byte("k")}, Value: []byte("v")}, CodeKeyExist},
+		{NewErrWriteConflictWithArgs(1, 2, 3, []byte("k"), kvrpcpb.WriteConflict_PessimisticRetry), CodeWriteConflict},
+		{&ErrWriteConflictInLatch{StartTS: 1}, CodeWriteConflictInLatch},
+		{&ErrRetryable{Retryable: "retry"}, CodeRetryable},
+		{&ErrFlashbackInProgress{RegionID: 1, FlashbackVersion: 2}, CodeFlashbackInProgress},
+		{&ErrTxnTooLarge{Size: 1}, CodeTxnTooLarge},
+		{&ErrEntryTooLarge{Limit: 1, Size: 2}, CodeEntryTooLarge},
+		{&ErrEntryTooLarge{Limit: 1, Size: 2, Key: []byte("k")}, CodeEntryTooLarge},
+		{NewErrPDServerTimeout("pd timeout"), CodePDServerTimeout},
+		{&ErrGCTooEarly{TxnStartTS: txnStart, GCSafePoint: txnStart.Add(time.Hour)}, CodeGCTooEarly},
+		{&ErrSnapshotLostToGC{StartTS: 1, Cause: ErrRegionUnavailable}, CodeSnapshotLostToGC},
+		{&ErrInvalidSavepoint{Reason: "nil"}, CodeInvalidSavepoint},
+		{&ErrKeyTTLUnsupported{Reason: "unsupported"}, CodeKeyTTLUnsupported},
+		{&ErrTokenLimit{StoreID: 1}, CodeTokenLimit},
+		{&ErrAssertionFailed{AssertionFailed: &kvrpcpb.AssertionFailed{
+			StartTs: 1, Key: []byte("k"), Assertion: kvrpcpb.Assertion_Exist, ExistingStartTs: 2, ExistingCommitTs: 3,
+		}}, CodeAssertionFailed},
+		{&ErrLockOnlyIfExistsNoReturnValue{StartTS: 1, ForUpdateTs: 2, LockKey: []byte("k")}, CodeLockOnlyIfExistsNoReturnValue},
+		{&ErrLockOnlyIfExistsNoPrimaryKey{StartTS: 1, ForUpdateTs: 2, LockKey: []byte("k")}, CodeLockOnlyIfExistsNoPrimaryKey},
+		{&ErrPDNoLeader{Reason: "no leader"}, CodePDNoLeader},
+		{&ErrRegion{Cause: ErrNotLeader, RegionID: 1, LeaderStoreID: 2, RefreshRegionCache: true, Message: "not leader"}, CodeRegion},
+		{&ErrRegion{Cause: &ErrFlashbackInProgress{RegionID: 1, FlashbackVersion: 2}, RegionID: 1}, CodeRegion},
+		{&ErrRegion{Cause: ErrTiKVServerBusy, Backoff: time.Second, Reason: "busy"}, CodeRegion},
+		{&ErrDataNotReady{RegionID: 1, SafeTs: 2, PeerReadTs: 3}, CodeDataNotReady},
+		{&ErrRegion{Cause: &ErrDataNotReady{RegionID: 1, SafeTs: 2}, RegionID: 1}, CodeRegion},
+		{&ErrMultipleKeyErrors{errs: []error{&ErrWriteConflict{WriteConflict: &kvrpcpb.WriteConflict{StartTs: 1}}, &ErrRetryable{Retryable: "x"}}}, CodeMultipleKeyErrors},
+		{&ErrLockWaitTimeoutDetail{Key: []byte("k"), Primary: []byte("p"), LockTxnID: 1, WaitDuration: time.Second, ResolveAttempts: 2}, CodeLockWaitTimeoutDetail},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.code, CodeOf(c.err), c.err.Error())
+		err := roundTrip(t, c.err)
+		require.Equal(t, c.err, err)
+		require.Equal(t, c.code, CodeOf(err))
+
+		// the error wrapped with stack is reconstructed as the error itself.
+		err = roundTrip(t, errors.WithStack(c.err))
+		require.Equal(t, c.err, err)
+	}
+}
+
+func TestMarshalMultipleKeyErrors(t *testing.T) {
+	origin := ExtractKeyErrs([]*kvrpcpb.KeyError{
+		{Abort: "abort"},
+		{AlreadyExist: &kvrpcpb.AlreadyExist{Key: []byte("k1")}},
+		{Conflict: &kvrpcpb.WriteConflict{StartTs: 1, ConflictTs: 2, Key: []byte("k2")}},
+	})
+	err := roundTrip(t, errors.Wrap(origin, "prewrite"))
+	require.Equal(t, "prewrite: "+origin.Error(), err.Error())
+	require.Equal(t, CodeMultipleKeyErrors, CodeOf(err))
+
+	var multi *ErrMultipleKeyErrors
+	require.ErrorAs(t, err, &multi)
+	require.Len(t, multi.Unwrap(), 3)
+	var conflict *ErrWriteConflict
+	require.ErrorAs(t, multi.Primary(), &conflict)
+	require.Equal(t, []byte("k2"), conflict.Key)
+	require.True(t, IsErrKeyExist(err))
+	require.ErrorContains(t, multi.Unwrap()[2], "abort")
+
+	require.ErrorContains(t, UnmarshalError([]byte(`{"code":122,"detail":{"errors":[]}}`)), "malformed error payload of code 122")
+}
+
+func TestMarshalLockWaitTimeoutDetail(t *testing.T) {
+	origin := &ErrLockWaitTimeoutDetail{Key: []byte("k"), Primary: []byte("p"), LockTxnID: 1, WaitDuration: time.Second, ResolveAttempts: 2}
+	err := roundTrip(t, errors.WithStack(origin))
+	require.ErrorIs(t, err, ErrLockWaitTimeout)
+	detail, ok := ExtractLockWaitDetail(err)
+	require.True(t, ok)
+	require.Equal(t, origin, detail)
+}
+
+func TestMarshalErrorSentinels(t *testing.T) {
+	require.Len(t, sentinelCodes, len(codeSentinels))
+	for sentinel, code := range sentinelCodes {
+		require.Equal(t, code, CodeOf(errors.WithStack(sentinel)))
+		err := roundTrip(t, sentinel)
+		require.Same(t, sentinel, err)
+		require.ErrorIs(t, roundTrip(t, errors.WithStack(sentinel)), sentinel)
+	}
+}
+
+func TestMarshalErrorWrapped(t *testing.T) {
+	// the message of the wrapped error is kept, and the outermost known error is reconstructed.
+	origin := errors.Wrap(&ErrSnapshotLostToGC{StartTS: 1, Cause: errors.Wrap(ErrNotExist, "get")}, "read")
+	err := roundTrip(t, origin)
+	require.Equal(t, origin.Error(), err.Error())
+	require.Equal(t, CodeSnapshotLostToGC, CodeOf(err))
+	require.True(t, IsErrSnapshotLostToGC(err))
+	require.True(t, IsErrNotFound(err))
+
+	// unknown codes are unmarshaled into generic errors.
+	err = roundTrip(t, errors.New("some error"))
+	require.Equal(t, CodeGeneric, CodeOf(err))
+	require.Equal(t, "some error", err.Error())
+	err = UnmarshalError([]byte(`{"code":9999,"message":"from the future"}`))
+	require.Equal(t, CodeGeneric, CodeOf(err))
+	require.Equal(t, "from the future", err.Error())
+
+	require.Nil(t, roundTrip(t, nil))
+	require.ErrorContains(t, UnmarshalError([]byte("{")), "malformed error payload")
+	require.ErrorContains(t, UnmarshalError([]byte(`{"code":108,"detail":"x"}`)), "malformed error payload of code 108")
+}
+
+func TestMarshalErrorRedact(t *testing.T) {
+	redact.SetMode(redact.ModeMarker)
+	defer redact.SetMode(redact.ModeOff)
+
+	err := roundTrip(t, errors.Wrap(NewErrWriteConflictWithArgs(1, 2, 3, []byte("secret"), kvrpcpb.WriteConflict_Optimistic), "commit"))
+	require.NotContains(t, err.Error(), "secret")
+	require.Contains(t, err.Error(), "commit")
+	var conflict *ErrWriteConflict
+	require.ErrorAs(t, err, &conflict)
+	require.Equal(t, []byte(redact.Marker), conflict.Key)
+	require.Equal(t, uint64(1), conflict.StartTs)
+	require.Equal(t, uint64(2), conflict.ConflictTs)
+
+	err = roundTrip(t, &ErrKeyExist{AlreadyExist: &kvrpcpb.AlreadyExist{Key: []byte("secret")}, Value: []byte("secret")})
+	require.NotContains(t, err.Error(), "secret")
+	var keyExist *ErrKeyExist
+	require.ErrorAs(t, err, &keyExist)
+	require.Equal(t, []byte(redact.Marker), keyExist.GetKey())
+	require.Nil(t, keyExist.Value)
+
+	err = roundTrip(t, &ErrDeadlock{Deadlock: &kvrpcpb.Deadlock{
+		LockKey:   []byte("secret"),
+		WaitChain: []*deadlock.WaitForEntry{{Txn: 1, Key: []byte("secret")}},
+	}})
+	require.NotContains(t, err.Error(), "secret")
+
+	err = roundTrip(t, &ErrRegion{Cause: ErrKeyNotInRegion, RegionID: 1, Message: "key secret is not in region 1"})
+	require.NotContains(t, err.Error(), "secret")
+	require.Equal(t, &ErrRegion{Cause: ErrKeyNotInRegion, RegionID: 1}, err)
+
+	err = roundTrip(t, &ErrEntryTooLarge{Limit: 1, Size: 2, Key: []byte("secret")})
+	require.Equal(t, &ErrEntryTooLarge{Limit: 1, Size: 2, Key: []byte(redact.Marker)}, err)
+
+	err = roundTrip(t, &ErrLockWaitTimeoutDetail{Key: []byte("secret"), Primary: []byte("secret"), LockTxnID: 1})
+	require.NotContains(t, err.Error(), hex.EncodeToString([]byte("secret")))
+	require.ErrorIs(t, err, ErrLockWaitTimeout)
+
+	err = roundTrip(t, &ErrTxnTooLarge{Size: 1, TopPrefixes: []PrefixSize{{Prefix: []byte("secret"), Size: 1}}})
+	require.Equal(t, &ErrTxnTooLarge{Size: 1, TopPrefixes: []PrefixSize{{Prefix: []byte(redact.Marker), Size: 1}}}, err)
+
+	// errors without keys are not affected.
+	require.Equal(t, &ErrTxnTooLarge{Size: 1}, roundTrip(t, &ErrTxnTooLarge{Size: 1}))
+}
+
+func TestMarshalErrorCustomRedactor(t *testing.T) {
+	redact.SetMode(redact.ModeCustom)
+	redact.SetKeyRedactor(func(key []byte) string {
+		return "h(" + hex.EncodeToString(key) + ")"
+	})
+	defer func() {
+		redact.SetMode(redact.ModeOff)
+		redact.SetKeyRedactor(nil)
+	}()
+	hashed := "h(" + hex.EncodeToString([]byte("secret")) + ")"
+
+	// the keys are marshaled in the form of the custom redactor, so they can still be correlated.
+	err := roundTrip(t, NewErrWriteConflictWithArgs(1, 2, 3, []byte("secret"), kvrpcpb.WriteConflict_Optimistic))
+	require.Contains(t, err.Error(), hashed)
+	var conflict *ErrWriteConflict
+	require.ErrorAs(t, err, &conflict)
+	require.Equal(t, []byte(hashed), conflict.Key)
+
+	err = roundTrip(t, &ErrDeadlock{Deadlock: &kvrpcpb.Deadlock{LockKey: []byte("secret")}})
+	require.Contains(t, err.Error(), hashed)
+	var dl *ErrDeadlock
+	require.ErrorAs(t, err, &dl)
+	require.Equal(t, []byte(hashed), dl.LockKey)
+}
@@ -0,0 +1,210 @@
+// Copyright 2024 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package error
+
+import (
+	"container/heap"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/tikv/client-go/v2/util/redact"
+)
+
+// conflictRecorderShards is how many shards the conflict recorder has, the keys are spread to the shards by hash so
+// that the goroutines recording different keys rarely wait for each other.
+const conflictRecorderShards = 16
+
+// ConflictStat is the write conflicts counted on a key by the conflict recorder.
+type ConflictStat struct {
+	// Key is the conflicting key in hex, or its hash prefixed by "hash:" if redaction is on when it's recorded.
+	Key string
+	// Count is the number of conflicts on the key, it may be overestimated by at most Overcount since the recorder
+	// only keeps the hottest keys.
+	Count uint64
+	// Overcount is the max error of Count, the key has at least Count-Overcount conflicts.
+	Overcount uint64
+	// LastConflictTS is the conflict ts of the latest conflict on the key.
+	LastConflictTS uint64
+}
+
+var activeConflictRecorder atomic.Pointer[conflictRecorder]
+
+// EnableConflictRecorder starts to count the keys of the write conflicts created by NewErrWriteConflict, so that
+// ConflictHotspots reports the hottest capacity keys. The counts are estimated by the space-saving algorithm, each
+// of the 16 shards keeps at most capacity keys. Calling it again discards the counts, and a capacity not larger than
+// zero disables the recorder, which is the default.
+func EnableConflictRecorder(capacity int) {
+	if capacity <= 0 {
+		activeConflictRecorder.Store(nil)
+		return
+	}
+	activeConflictRecorder.Store(newConflictRecorder(capacity))
+}
+
+// ConflictHotspots returns the hottest keys of the write conflicts ordered by count, it returns nil if the recorder
+// is disabled.
+func ConflictHotspots() []ConflictStat {
+	r := activeConflictRecorder.Load()
+	if r == nil {
+		return nil
+	}
+	return r.hotspots()
+}
+
+// ResetConflictHotspots discards the counts of the recorder, the recorder is still enabled.
+func ResetConflictHotspots() {
+	if r := activeConflictRecorder.Load(); r != nil {
+		r.reset()
+	}
+}
+
+func recordConflict(conflict *kvrpcpb.WriteConflict) {
+	r := activeConflictRecorder.Load()
+	if r == nil || conflict == nil {
+		return
+	}
+	r.record(conflict.GetKey(), conflict.GetConflictTs())
+}
+
+type conflictRecorder struct {
+	capacity int
+	shards   [conflictRecorderShards]conflictShard
+}
+
+func newConflictRecorder(capacity int) *conflictRecorder {
+	r := &conflictRecorder{capacity: capacity}
+	for i := range r.shards {
+		r.shards[i].capacity = capacity
+		r.shards[i].index = make(map[string]*conflictEntry, capacity)
+	}
+	return r
+}
+
+func (r *conflictRecorder) record(key []byte, conflictTS uint64) {
+	h := fnv.New64a()
+	_, _ = h.Write(key)
+	sum := h.Sum64()
+	var k string
+	if redact.NeedRedact() {
+		k = fmt.Sprintf("hash:%016x", sum)
+	} else {
+		k = hex.EncodeToString(key)
+	}
+	r.shards[sum%conflictRecorderShards].record(k, conflictTS)
+}
+
+func (r *conflictRecorder) hotspots() []ConflictStat {
+	var stats []ConflictStat
+	for i := range r.shards {
+		stats = r.shards[i].appendStats(stats)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Count != stats[j].Count {
+			return stats[i].Count > stats[j].Count
+		}
+		return stats[i].Key < stats[j].Key
+	})
+	if len(stats) > r.capacity {
+		stats = stats[:r.capacity]
+	}
+	return stats
+}
+
+func (r *conflictRecorder) reset() {
+	for i := range r.shards {
+		r.shards[i].reset()
+	}
+}
+
+// conflictShard counts the keys by the space-saving algorithm: once it's full, a new key replaces the key with the
+// least count and inherits the count as its overcount.
+type conflictShard struct {
+	sync.Mutex
+	capacity int
+	index    map[string]*conflictEntry
+	// entries is a min-heap by count.
+	entries conflictHeap
+}
+
+type conflictEntry struct {
+	ConflictStat
+	pos int
+}
+
+func (s *conflictShard) record(key string, conflictTS uint64) {
+	s.Lock()
+	defer s.Unlock()
+	e, ok := s.index[key]
+	switch {
+	case ok:
+	case len(s.entries) < s.capacity:
+		e = &conflictEntry{ConflictStat: ConflictStat{Key: key}}
+		s.index[key] = e
+		heap.Push(&s.entries, e)
+	default:
+		e = s.entries[0]
+		delete(s.index, e.Key)
+		e.Key = key
+		e.Overcount = e.Count
+		s.index[key] = e
+	}
+	e.Count++
+	e.LastConflictTS = conflictTS
+	heap.Fix(&s.entries, e.pos)
+}
+
+func (s *conflictShard) appendStats(stats []ConflictStat) []ConflictStat {
+	s.Lock()
+	defer s.Unlock()
+	for _, e := range s.entries {
+		stats = append(stats, e.ConflictStat)
+	}
+	return stats
+}
+
+func (s *conflictShard) reset() {
+	s.Lock()
+	defer s.Unlock()
+	s.index = make(map[string]*conflictEntry, s.capacity)
+	s.entries = nil
+}
+
+type conflictHeap []*conflictEntry
+
+func (h conflictHeap) Len() int           { return len(h) }
+func (h conflictHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h conflictHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].pos = i
+	h[j].pos = j
+}
+
+func (h *conflictHeap) Push(x any) {
+	e := x.(*conflictEntry)
+	e.pos = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *conflictHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package error
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/util/redact"
+)
+
+func TestConflictRecorder(t *testing.T) {
+	// disabled by default.
+	NewErrWriteConflictWithArgs(1, 2, 3, []byte("k"), kvrpcpb.WriteConflict_Optimistic)
+	assert.Nil(t, ConflictHotspots())
+
+	const capacity = 10
+	EnableConflictRecorder(capacity)
+	defer EnableConflictRecorder(0)
+
+	// hot-i conflicts 1000*(5-i) times in total, and 4000 cold keys conflict once.
+	const workers = 8
+	hotCounts := map[string]uint64{}
+	for i := 0; i < 5; i++ {
+		hotCounts[hex.EncodeToString([]byte(fmt.Sprintf("hot-%d", i)))] = uint64(1000 * (5 - i))
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for n := 0; n < 625; n++ {
+				for i := 0; i < 5; i++ {
+					if n < 125*(5-i) {
+						key := []byte(fmt.Sprintf("hot-%d", i))
+						NewErrWriteConflictWithArgs(1, uint64(n), 3, key, kvrpcpb.WriteConflict_Optimistic)
+					}
+				}
+				if n < 500 {
+					key := []byte(fmt.Sprintf("cold-%d-%d", w, n))
+					ExtractKeyErr(&kvrpcpb.KeyError{Conflict: &kvrpcpb.WriteConflict{Key: key, ConflictTs: uint64(n)}})
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	total := uint64(workers * 500)
+	for _, c := range hotCounts {
+		total += c
+	}
+
+	hotspots := ConflictHotspots()
+	require.Len(t, hotspots, capacity)
+	for i, stat := range hotspots[:5] {
+		expected, ok := hotCounts[stat.Key]
+		require.True(t, ok, "unexpected hotspot %d: %s", i, stat.Key)
+		// the space-saving algorithm never underestimates, and the error is bounded by the conflicts per capacity.
+		assert.GreaterOrEqual(t, stat.Count, expected)
+		assert.LessOrEqual(t, stat.Count-stat.Overcount, expected)
+		assert.LessOrEqual(t, stat.Count, expected+total/capacity)
+	}
+
+	ResetConflictHotspots()
+	assert.Empty(t, ConflictHotspots())
+
+	// the key is hashed when redaction is on.
+	redact.SetMode(redact.ModeMarker)
+	defer redact.SetMode(redact.ModeOff)
+	NewErrWriteConflictWithArgs(1, 2, 3, []byte("secret"), kvrpcpb.WriteConflict_Optimistic)
+	hotspots = ConflictHotspots()
+	require.Len(t, hotspots, 1)
+	assert.True(t, strings.HasPrefix(hotspots[0].Key, "hash:"))
+	assert.NotContains(t, hotspots[0].Key, hex.EncodeToString([]byte("secret")))
+	assert.Equal(t, ConflictStat{Key: hotspots[0].Key, Count: 1, LastConflictTS: 2}, hotspots[0])
+}
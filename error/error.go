@@ -35,16 +35,21 @@
 package error
 
 import (
-	"encoding/hex"
+	stderrors "errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/gogo/protobuf/proto"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	"github.com/pingcap/kvproto/pkg/pdpb"
 	"github.com/pingcap/log"
 	"github.com/pkg/errors"
+	"github.com/tikv/client-go/v2/config"
 	"github.com/tikv/client-go/v2/internal/logutil"
+	"github.com/tikv/client-go/v2/metrics"
 	"github.com/tikv/client-go/v2/util"
+	"github.com/tikv/client-go/v2/util/redact"
 	"go.uber.org/zap"
 )
 
@@ -96,10 +101,36 @@ var (
 	ErrRegionFlashbackNotPrepared = errors.New("region is not prepared for the flashback")
 	// ErrIsWitness is the error when a request is send to a witness.
 	ErrIsWitness = errors.New("peer is witness")
+	// ErrNotLeader is the error when a request is sent to a peer which is not the leader of the region.
+	ErrNotLeader = errors.New("peer is not leader")
+	// ErrRegionNotFound is the error when the region is not found in the store.
+	ErrRegionNotFound = errors.New("region not found")
+	// ErrKeyNotInRegion is the error when the key of a request is out of the range of the region.
+	ErrKeyNotInRegion = errors.New("key not in region")
+	// ErrEpochNotMatch is the error when the region epoch of a request is stale.
+	ErrEpochNotMatch = errors.New("region epoch not match")
+	// ErrStoreNotMatch is the error when a request is sent to a store other than the one it's for.
+	ErrStoreNotMatch = errors.New("store not match")
+	// ErrRaftEntryTooLarge is the error when the raft entry of a write is larger than the limit of TiKV.
+	ErrRaftEntryTooLarge = errors.New("raft entry too large")
+	// ErrReadIndexNotReady is the error when the region can't serve the read index request yet.
+	ErrReadIndexNotReady = errors.New("read index not ready")
+	// ErrProposalInMergingMode is the error when a write is proposed to a region which is being merged.
+	ErrProposalInMergingMode = errors.New("region is merging")
+	// ErrMismatchPeerID is the error when the peer ID of a request doesn't match the peer in the store.
+	ErrMismatchPeerID = errors.New("peer id mismatch")
+	// ErrBucketVersionNotMatch is the error when the bucket version of a request is stale.
+	ErrBucketVersionNotMatch = errors.New("bucket version not match")
 	// ErrUnknown is the unknow error.
 	ErrUnknown = errors.New("unknown")
 	// ErrResultUndetermined is the error when execution result is unknown.
 	ErrResultUndetermined = errors.New("execution result undetermined")
+	// ErrWriteInBestEffortTxn is the error when writing in a transaction which is allowed to read beyond the GC safe point.
+	ErrWriteInBestEffortTxn = errors.New("cannot write in a transaction which allows reading beyond the gc safe point")
+	// ErrSnapshotInvalidated is the error when reading a MemBuffer snapshot whose data has been discarded by a reset or a rollback.
+	ErrSnapshotInvalidated = errors.New("membuffer snapshot is invalidated")
+	// ErrClientClosed is the error when using a client, or a transaction or snapshot of it, after it's closed.
+	ErrClientClosed = errors.New("client is closed")
 )
 
 type ErrQueryInterruptedWithSignal struct {
@@ -126,7 +157,7 @@ type ErrDeadlock struct {
 }
 
 func (d *ErrDeadlock) Error() string {
-	return d.Deadlock.String()
+	return redactKeyErr(&kvrpcpb.KeyError{Deadlock: d.Deadlock}).Deadlock.String()
 }
 
 // PDError wraps *pdpb.Error to implement the error interface.
@@ -149,7 +180,7 @@ type ErrKeyExist struct {
 }
 
 func (k *ErrKeyExist) Error() string {
-	return k.AlreadyExist.String()
+	return redactKeyErr(&kvrpcpb.KeyError{AlreadyExist: k.AlreadyExist}).AlreadyExist.String()
 }
 
 // IsErrKeyExist returns true if it is ErrKeyExist.
@@ -164,7 +195,30 @@ type ErrWriteConflict struct {
 }
 
 func (k *ErrWriteConflict) Error() string {
-	return fmt.Sprintf("write conflict { %s }", k.WriteConflict.String())
+	return fmt.Sprintf("write conflict { %s }", redactKeyErr(&kvrpcpb.KeyError{Conflict: k.WriteConflict}).Conflict.String())
+}
+
+// ConflictKey returns the conflicting key, which is redacted if redaction is enabled.
+func (k *ErrWriteConflict) ConflictKey() []byte {
+	if !redact.NeedRedact() {
+		return k.GetKey()
+	}
+	return redactKey(k.GetKey())
+}
+
+// StartTimestamp returns the start ts of the transaction that meets the conflict.
+func (k *ErrWriteConflict) StartTimestamp() uint64 {
+	return k.GetStartTs()
+}
+
+// ConflictTimestamp returns the start ts of the transaction that modified the key.
+func (k *ErrWriteConflict) ConflictTimestamp() uint64 {
+	return k.GetConflictTs()
+}
+
+// ConflictCommitTimestamp returns the commit ts of the transaction that modified the key.
+func (k *ErrWriteConflict) ConflictCommitTimestamp() uint64 {
+	return k.GetConflictCommitTs()
 }
 
 // IsErrWriteConflict returns true if it is ErrWriteConflict.
@@ -173,16 +227,22 @@ func IsErrWriteConflict(err error) bool {
 	return errors.As(err, &e)
 }
 
+// NewErrWriteConflict creates an ErrWriteConflict of the conflict reported by TiKV, and counts the key in the
+// conflict recorder if it's enabled by EnableConflictRecorder.
+func NewErrWriteConflict(conflict *kvrpcpb.WriteConflict) *ErrWriteConflict {
+	recordConflict(conflict)
+	return &ErrWriteConflict{WriteConflict: conflict}
+}
+
 // NewErrWriteConflictWithArgs generates an ErrWriteConflict with args.
 func NewErrWriteConflictWithArgs(startTs, conflictTs, conflictCommitTs uint64, key []byte, reason kvrpcpb.WriteConflict_Reason) *ErrWriteConflict {
-	conflict := kvrpcpb.WriteConflict{
+	return NewErrWriteConflict(&kvrpcpb.WriteConflict{
 		StartTs:          startTs,
 		ConflictTs:       conflictTs,
 		Key:              key,
 		ConflictCommitTs: conflictCommitTs,
 		Reason:           reason,
-	}
-	return &ErrWriteConflict{WriteConflict: &conflict}
+	})
 }
 
 // ErrWriteConflictInLatch is the error when the commit meets an write conflict error when local latch is enabled.
@@ -203,23 +263,150 @@ func (k *ErrRetryable) Error() string {
 	return k.Retryable
 }
 
+// IsRetryable returns true if err is or wraps an ErrRetryable, or a deadlock marked as retryable.
+func IsRetryable(err error) bool {
+	var retryable *ErrRetryable
+	if errors.As(err, &retryable) {
+		return true
+	}
+	var deadlock *ErrDeadlock
+	return errors.As(err, &deadlock) && deadlock.IsRetryable
+}
+
+// CombinePreferRetryable combines the errors of multiple regions into one, ignoring the nil ones. It returns nil if
+// all errors are nil and the error itself if there is only one. Otherwise the result wraps all the errors, so
+// IsRetryable returns true on it if any member is retryable. The retryable members come first, so they take
+// precedence in errors.As and the error message; the relative order of the members is kept otherwise.
+func CombinePreferRetryable(errs ...error) error {
+	var retryable, others []error
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if IsRetryable(err) {
+			retryable = append(retryable, err)
+		} else {
+			others = append(others, err)
+		}
+	}
+	combined := append(retryable, others...)
+	switch len(combined) {
+	case 0:
+		return nil
+	case 1:
+		return combined[0]
+	}
+	return stderrors.Join(combined...)
+}
+
+// ErrFlashbackInProgress is the error when a request is rejected because the region is in the flashback progress.
+// It matches ErrRegionFlashbackInProgress by errors.Is.
+type ErrFlashbackInProgress struct {
+	RegionID         uint64
+	FlashbackVersion uint64
+}
+
+func (e *ErrFlashbackInProgress) Error() string {
+	return fmt.Sprintf("region %d is in flashback progress, FlashbackStartTS is %d", e.RegionID, e.FlashbackVersion)
+}
+
+// Is implements the interface used by errors.Is.
+func (e *ErrFlashbackInProgress) Is(target error) bool {
+	return target == ErrRegionFlashbackInProgress
+}
+
+// IsFlashbackInProgress returns true if the request is rejected because the region is in the flashback progress.
+func IsFlashbackInProgress(err error) bool {
+	return errors.Is(err, ErrRegionFlashbackInProgress)
+}
+
+// ErrLockWaitTimeoutDetail is the error when waiting for a lock in a pessimistic transaction times out, it
+// matches ErrLockWaitTimeout by errors.Is and tells which lock the wait is blocked on.
+type ErrLockWaitTimeoutDetail struct {
+	// Key is the key being waited for.
+	Key []byte
+	// Primary is the primary key of the lock.
+	Primary []byte
+	// LockTxnID is the start ts of the transaction holding the lock.
+	LockTxnID uint64
+	// WaitDuration is how long the lock has been waited for.
+	WaitDuration time.Duration
+	// ResolveAttempts is the number of times the lock was tried to be resolved.
+	ResolveAttempts int
+}
+
+func (e *ErrLockWaitTimeoutDetail) Error() string {
+	return fmt.Sprintf("lock wait timeout, key: %s, primary: %s, lockTxnID: %d, waited: %v, resolve attempts: %d",
+		redact.Key(e.Key), redact.Key(e.Primary), e.LockTxnID, e.WaitDuration, e.ResolveAttempts)
+}
+
+// Is implements the interface used by errors.Is.
+func (e *ErrLockWaitTimeoutDetail) Is(target error) bool {
+	return target == ErrLockWaitTimeout
+}
+
+// ExtractLockWaitDetail returns the ErrLockWaitTimeoutDetail in the chain of err if there is one.
+func ExtractLockWaitDetail(err error) (*ErrLockWaitTimeoutDetail, bool) {
+	var e *ErrLockWaitTimeoutDetail
+	if errors.As(err, &e) {
+		return e, true
+	}
+	return nil, false
+}
+
 // ErrTxnTooLarge is the error when transaction is too large, lock time reached the maximum value.
 type ErrTxnTooLarge struct {
 	Size int
+	// TopPrefixes are the key prefixes whose entries take the most space of the transaction, in descending order of
+	// the size. It may be empty if unknown.
+	TopPrefixes []PrefixSize
+}
+
+// PrefixSize is the total size of the entries whose keys share the prefix.
+type PrefixSize struct {
+	Prefix []byte
+	Size   int
 }
 
 func (e *ErrTxnTooLarge) Error() string {
-	return fmt.Sprintf("txn too large, size: %v.", e.Size)
+	if len(e.TopPrefixes) == 0 {
+		return fmt.Sprintf("txn too large, size: %v.", e.Size)
+	}
+	var b strings.Builder
+	for i, p := range e.TopPrefixes {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%s: %d", redact.Key(p.Prefix), p.Size)
+	}
+	return fmt.Sprintf("txn too large, size: %v, top prefixes: [%s].", e.Size, b.String())
 }
 
 // ErrEntryTooLarge is the error when a key value entry is too large.
 type ErrEntryTooLarge struct {
 	Limit uint64
 	Size  uint64
+	// Key is the key of the entry, it may be nil if the key is unknown.
+	Key []byte
 }
 
 func (e *ErrEntryTooLarge) Error() string {
-	return fmt.Sprintf("entry size too large, size: %v,limit: %v.", e.Size, e.Limit)
+	if e.Key == nil {
+		return fmt.Sprintf("entry size too large, size: %v,limit: %v.", e.Size, e.Limit)
+	}
+	return fmt.Sprintf("entry size too large, key: %s, size: %v,limit: %v.", redact.Key(e.Key), e.Size, e.Limit)
+}
+
+// NewErrEntryTooLarge creates an ErrEntryTooLarge of an entry of size bytes, whose limit is the TxnEntrySizeLimit of
+// the global config.
+func NewErrEntryTooLarge(size uint64) *ErrEntryTooLarge {
+	return &ErrEntryTooLarge{Limit: config.GetGlobalConfig().TxnEntrySizeLimit, Size: size}
+}
+
+// IsErrEntryTooLarge returns true if it is ErrEntryTooLarge.
+func IsErrEntryTooLarge(err error) bool {
+	var e *ErrEntryTooLarge
+	return errors.As(err, &e)
 }
 
 // ErrPDServerTimeout is the error when pd server is timeout.
@@ -246,6 +433,74 @@ func (e *ErrGCTooEarly) Error() string {
 	return fmt.Sprintf("GC life time is shorter than transaction duration, transaction starts at %v, GC safe point is %v", e.TxnStartTS, e.GCSafePoint)
 }
 
+// ErrSnapshotLostToGC is the error when a read fails on a best-effort snapshot whose start ts has fallen
+// behind the GC safe point, which means the versions it needs may have been garbage collected.
+type ErrSnapshotLostToGC struct {
+	StartTS uint64
+	Cause   error
+}
+
+func (e *ErrSnapshotLostToGC) Error() string {
+	return fmt.Sprintf("snapshot at %d may be lost to GC: %v", e.StartTS, e.Cause)
+}
+
+// Unwrap returns the cause of the read failure.
+func (e *ErrSnapshotLostToGC) Unwrap() error {
+	return e.Cause
+}
+
+// IsErrSnapshotLostToGC returns true if it is ErrSnapshotLostToGC.
+func IsErrSnapshotLostToGC(err error) bool {
+	var e *ErrSnapshotLostToGC
+	return errors.As(err, &e)
+}
+
+// ErrInvalidSavepoint is the error when rolling back to a savepoint which is nil or represents a later state
+// than the current MemBuffer.
+type ErrInvalidSavepoint struct {
+	Reason string
+}
+
+func (e *ErrInvalidSavepoint) Error() string {
+	return fmt.Sprintf("invalid savepoint: %s", e.Reason)
+}
+
+// IsErrInvalidSavepoint returns true if it is ErrInvalidSavepoint.
+func IsErrInvalidSavepoint(err error) bool {
+	var e *ErrInvalidSavepoint
+	return errors.As(err, &e)
+}
+
+// ErrKeyTTLUnsupported is the error when setting a key with TTL which the transaction cannot honor.
+type ErrKeyTTLUnsupported struct {
+	Reason string
+}
+
+func (e *ErrKeyTTLUnsupported) Error() string {
+	return fmt.Sprintf("key ttl is unsupported: %s", e.Reason)
+}
+
+// IsErrKeyTTLUnsupported returns true if it is ErrKeyTTLUnsupported.
+func IsErrKeyTTLUnsupported(err error) bool {
+	var e *ErrKeyTTLUnsupported
+	return errors.As(err, &e)
+}
+
+// ErrPDNoLeader is the error when getting a timestamp fails fast because PD has no leader to serve it.
+type ErrPDNoLeader struct {
+	Reason string
+}
+
+func (e *ErrPDNoLeader) Error() string {
+	return fmt.Sprintf("pd has no leader: %s", e.Reason)
+}
+
+// IsErrPDNoLeader returns true if it is ErrPDNoLeader.
+func IsErrPDNoLeader(err error) bool {
+	var e *ErrPDNoLeader
+	return errors.As(err, &e)
+}
+
 // ErrTokenLimit is the error that token is up to the limit.
 type ErrTokenLimit struct {
 	StoreID uint64
@@ -260,6 +515,35 @@ type ErrAssertionFailed struct {
 	*kvrpcpb.AssertionFailed
 }
 
+// NewErrAssertionFailed creates an ErrAssertionFailed and counts it by the assertion.
+func NewErrAssertionFailed(assertionFailed *kvrpcpb.AssertionFailed) *ErrAssertionFailed {
+	metrics.TiKVTxnAssertionFailedCounter.WithLabelValues(assertionFailed.GetAssertion().String()).Inc()
+	return &ErrAssertionFailed{AssertionFailed: assertionFailed}
+}
+
+// RedactedKey returns the key whose assertion failed, which is redacted if redaction is enabled.
+func (e *ErrAssertionFailed) RedactedKey() []byte {
+	if !redact.NeedRedact() {
+		return e.GetKey()
+	}
+	return redactKey(e.GetKey())
+}
+
+// ExpectedAssertion returns the assertion of the key, Exist or NotExist.
+func (e *ErrAssertionFailed) ExpectedAssertion() kvrpcpb.Assertion {
+	return e.GetAssertion()
+}
+
+// ExistingStartTS returns the start ts of the existing write of the key, which is 0 if the key doesn't exist.
+func (e *ErrAssertionFailed) ExistingStartTS() uint64 {
+	return e.GetExistingStartTs()
+}
+
+// ExistingCommitTS returns the commit ts of the existing write of the key, which is 0 if the key doesn't exist.
+func (e *ErrAssertionFailed) ExistingCommitTS() uint64 {
+	return e.GetExistingCommitTs()
+}
+
 // ErrLockOnlyIfExistsNoReturnValue is used when the flag `LockOnlyIfExists` of `LockCtx` is set, but `ReturnValues` is not.
 type ErrLockOnlyIfExistsNoReturnValue struct {
 	StartTS     uint64
@@ -275,19 +559,19 @@ type ErrLockOnlyIfExistsNoPrimaryKey struct {
 }
 
 func (e *ErrAssertionFailed) Error() string {
-	return fmt.Sprintf("assertion failed { %s }", e.AssertionFailed.String())
+	return fmt.Sprintf("assertion failed { %s }", redactKeyErr(&kvrpcpb.KeyError{AssertionFailed: e.AssertionFailed}).AssertionFailed.String())
 }
 
 func (e *ErrLockOnlyIfExistsNoReturnValue) Error() string {
 	return fmt.Sprintf("LockOnlyIfExists is set for Lock Context, but ReturnValues is not set, "+
 		"StartTs is {%d}, ForUpdateTs is {%d}, one of lock keys is {%v}.",
-		e.StartTS, e.ForUpdateTs, hex.EncodeToString(e.LockKey))
+		e.StartTS, e.ForUpdateTs, redact.Key(e.LockKey))
 }
 
 func (e *ErrLockOnlyIfExistsNoPrimaryKey) Error() string {
 	return fmt.Sprintf("LockOnlyIfExists is set for Lock Context, but primary key of current transaction is not set, "+
 		"StartTs is {%d}, ForUpdateTs is {%d}, one of lock keys is {%s}",
-		e.StartTS, e.ForUpdateTs, hex.EncodeToString(e.LockKey))
+		e.StartTS, e.ForUpdateTs, redact.Key(e.LockKey))
 }
 
 // ExtractKeyErr extracts a KeyError.
@@ -300,7 +584,7 @@ func ExtractKeyErr(keyErr *kvrpcpb.KeyError) error {
 	}
 
 	if keyErr.Conflict != nil {
-		return errors.WithStack(&ErrWriteConflict{WriteConflict: keyErr.GetConflict()})
+		return errors.WithStack(NewErrWriteConflict(keyErr.GetConflict()))
 	}
 
 	if keyErr.Retryable != "" {
@@ -308,7 +592,7 @@ func ExtractKeyErr(keyErr *kvrpcpb.KeyError) error {
 	}
 
 	if keyErr.AssertionFailed != nil {
-		return &ErrAssertionFailed{AssertionFailed: keyErr.AssertionFailed}
+		return NewErrAssertionFailed(keyErr.AssertionFailed)
 	}
 
 	if keyErr.Abort != "" {
@@ -325,7 +609,104 @@ func ExtractKeyErr(keyErr *kvrpcpb.KeyError) error {
 		err := errors.Errorf("txn %d not found", keyErr.TxnNotFound.StartTs)
 		return err
 	}
-	return errors.Errorf("unexpected KeyError: %s", keyErr.String())
+	return errors.Errorf("unexpected KeyError: %s", RedactKeyErrIfNecessary(keyErr))
+}
+
+// Priorities of the members of ErrMultipleKeyErrors, the smaller one is more actionable.
+const (
+	keyErrPriorityConflict = iota
+	keyErrPriorityAlreadyExist
+	keyErrPriorityAssertionFailed
+	keyErrPriorityRetryable
+	keyErrPriorityAbort
+	keyErrPriorityOther
+)
+
+// ErrMultipleKeyErrors aggregates the KeyErrors returned by one request, see ExtractKeyErrs.
+// errors.Is and errors.As find any of its members.
+type ErrMultipleKeyErrors struct {
+	errs []error
+}
+
+func (e *ErrMultipleKeyErrors) Error() string {
+	msgs := make([]string, 0, len(e.errs))
+	for _, err := range e.errs {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d key errors: [%s]", len(e.errs), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the members ordered by priority.
+func (e *ErrMultipleKeyErrors) Unwrap() []error {
+	return e.errs
+}
+
+// Primary returns the most actionable member: write conflicts first, then already-exist, assertion failures,
+// retryable errors, aborts and the others.
+func (e *ErrMultipleKeyErrors) Primary() error {
+	return e.errs[0]
+}
+
+// ExtractKeyErrs extracts the KeyErrors of one request, such as a prewrite response, into an ErrMultipleKeyErrors.
+// The members are ordered by priority as Primary describes, keeping the response order within the same priority,
+// and identical write conflicts are reported once. The keys are redacted by the members, so the message of the
+// aggregated error is not redacted again. It returns nil if there is no KeyError.
+func ExtractKeyErrs(keyErrs []*kvrpcpb.KeyError) error {
+	var (
+		buckets   [keyErrPriorityOther + 1][]error
+		conflicts []*kvrpcpb.WriteConflict
+		n         int
+	)
+	for _, keyErr := range keyErrs {
+		if keyErr == nil {
+			continue
+		}
+		var (
+			err      error
+			priority int
+		)
+		if alreadyExist := keyErr.GetAlreadyExist(); alreadyExist != nil {
+			err, priority = &ErrKeyExist{AlreadyExist: alreadyExist}, keyErrPriorityAlreadyExist
+		} else {
+			err = ExtractKeyErr(keyErr)
+			var conflict *ErrWriteConflict
+			switch {
+			case errors.As(err, &conflict):
+				if containsConflict(conflicts, conflict.WriteConflict) {
+					continue
+				}
+				conflicts = append(conflicts, conflict.WriteConflict)
+				priority = keyErrPriorityConflict
+			case errors.As(err, new(*ErrAssertionFailed)):
+				priority = keyErrPriorityAssertionFailed
+			case IsRetryable(err):
+				priority = keyErrPriorityRetryable
+			case keyErr.Abort != "":
+				priority = keyErrPriorityAbort
+			default:
+				priority = keyErrPriorityOther
+			}
+		}
+		buckets[priority] = append(buckets[priority], err)
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	errs := make([]error, 0, n)
+	for _, bucket := range buckets {
+		errs = append(errs, bucket...)
+	}
+	return &ErrMultipleKeyErrors{errs: errs}
+}
+
+func containsConflict(conflicts []*kvrpcpb.WriteConflict, conflict *kvrpcpb.WriteConflict) bool {
+	for _, c := range conflicts {
+		if proto.Equal(c, conflict) {
+			return true
+		}
+	}
+	return false
 }
 
 // IsErrorUndetermined checks if the error is undetermined error.
@@ -0,0 +1,238 @@
+// Copyright 2024 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package error
+
+import (
+	stderrors "errors"
+	"testing"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pkg/errors"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/tikv/client-go/v2/config"
+	"github.com/tikv/client-go/v2/metrics"
+	"github.com/tikv/client-go/v2/util/redact"
+)
+
+func TestErrFlashbackInProgress(t *testing.T) {
+	var err error = &ErrFlashbackInProgress{RegionID: 1, FlashbackVersion: 2}
+	assert.True(t, errors.Is(err, ErrRegionFlashbackInProgress))
+	assert.False(t, errors.Is(err, ErrRegionFlashbackNotPrepared))
+	assert.True(t, IsFlashbackInProgress(err))
+	assert.Equal(t, "region 1 is in flashback progress, FlashbackStartTS is 2", err.Error())
+
+	err = errors.WithStack(err)
+	assert.True(t, IsFlashbackInProgress(err))
+	var flashbackErr *ErrFlashbackInProgress
+	assert.True(t, stderrors.As(err, &flashbackErr))
+	assert.Equal(t, uint64(1), flashbackErr.RegionID)
+	assert.Equal(t, uint64(2), flashbackErr.FlashbackVersion)
+
+	assert.True(t, IsFlashbackInProgress(ErrRegionFlashbackInProgress))
+	assert.False(t, IsFlashbackInProgress(ErrRegionFlashbackNotPrepared))
+	assert.False(t, IsFlashbackInProgress(nil))
+}
+
+func TestErrAssertionFailed(t *testing.T) {
+	readCounter := func(assertion kvrpcpb.Assertion) float64 {
+		pb := &dto.Metric{}
+		assert.Nil(t, metrics.TiKVTxnAssertionFailedCounter.WithLabelValues(assertion.String()).Write(pb))
+		return pb.GetCounter().GetValue()
+	}
+	before := readCounter(kvrpcpb.Assertion_Exist)
+
+	pb := &kvrpcpb.AssertionFailed{
+		StartTs: 1, Key: []byte("k"), Assertion: kvrpcpb.Assertion_Exist, ExistingStartTs: 2, ExistingCommitTs: 3,
+	}
+	err := ExtractKeyErr(&kvrpcpb.KeyError{AssertionFailed: pb})
+	var e *ErrAssertionFailed
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, before+1, readCounter(kvrpcpb.Assertion_Exist))
+	assert.Equal(t, []byte("k"), e.RedactedKey())
+	assert.Equal(t, kvrpcpb.Assertion_Exist, e.ExpectedAssertion())
+	// the fields of the protobuf are still promoted.
+	assert.Equal(t, []byte("k"), e.Key)
+	assert.Equal(t, kvrpcpb.Assertion_Exist, e.Assertion)
+	assert.Equal(t, uint64(1), e.StartTs)
+	assert.Equal(t, uint64(2), e.ExistingStartTS())
+	assert.Equal(t, uint64(3), e.ExistingCommitTS())
+
+	before = readCounter(kvrpcpb.Assertion_NotExist)
+	e = NewErrAssertionFailed(&kvrpcpb.AssertionFailed{Key: []byte("k"), Assertion: kvrpcpb.Assertion_NotExist})
+	assert.Equal(t, before+1, readCounter(kvrpcpb.Assertion_NotExist))
+	assert.Zero(t, e.ExistingCommitTS())
+
+	redact.SetMode(redact.ModeMarker)
+	defer redact.SetMode(redact.ModeOff)
+	assert.Equal(t, []byte("?"), e.RedactedKey())
+	// the key of the protobuf is not modified.
+	assert.Equal(t, []byte("k"), e.GetKey())
+}
+
+func TestErrWriteConflictAccessors(t *testing.T) {
+	err := ExtractKeyErr(&kvrpcpb.KeyError{Conflict: &kvrpcpb.WriteConflict{
+		StartTs: 1, ConflictTs: 2, Key: []byte("k"), ConflictCommitTs: 3, Reason: kvrpcpb.WriteConflict_Optimistic,
+	}})
+	var e *ErrWriteConflict
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, []byte("k"), e.ConflictKey())
+	assert.Equal(t, uint64(1), e.StartTimestamp())
+	assert.Equal(t, uint64(2), e.ConflictTimestamp())
+	assert.Equal(t, uint64(3), e.ConflictCommitTimestamp())
+
+	// the accessors are safe on an error without the conflict.
+	e = &ErrWriteConflict{}
+	assert.Nil(t, e.ConflictKey())
+	assert.Zero(t, e.ConflictCommitTimestamp())
+
+	redact.SetMode(redact.ModeMarker)
+	defer redact.SetMode(redact.ModeOff)
+	e = NewErrWriteConflictWithArgs(1, 2, 3, []byte("k"), kvrpcpb.WriteConflict_Optimistic)
+	assert.Equal(t, []byte("?"), e.ConflictKey())
+	assert.Equal(t, []byte("k"), e.GetKey())
+}
+
+func TestErrTxnTooLarge(t *testing.T) {
+	err := &ErrTxnTooLarge{Size: 100}
+	assert.Equal(t, "txn too large, size: 100.", err.Error())
+	err.TopPrefixes = []PrefixSize{{Prefix: []byte("a"), Size: 60}, {Prefix: []byte("b"), Size: 40}}
+	assert.Equal(t, "txn too large, size: 100, top prefixes: [61: 60, 62: 40].", err.Error())
+}
+
+func TestNewErrEntryTooLarge(t *testing.T) {
+	err := NewErrEntryTooLarge(100)
+	assert.Equal(t, uint64(config.DefTxnEntrySizeLimit), err.Limit)
+	assert.Equal(t, uint64(100), err.Size)
+	assert.Nil(t, err.Key)
+
+	restore := config.UpdateGlobal(func(conf *config.Config) {
+		conf.TxnEntrySizeLimit = 64
+	})
+	defer restore()
+	err = NewErrEntryTooLarge(100)
+	assert.Equal(t, uint64(64), err.Limit)
+	assert.Equal(t, "entry size too large, size: 100,limit: 64.", err.Error())
+
+	assert.True(t, IsErrEntryTooLarge(errors.WithStack(err)))
+	assert.False(t, IsErrEntryTooLarge(&ErrTxnTooLarge{Size: 100}))
+	assert.False(t, IsErrEntryTooLarge(nil))
+}
+
+func TestErrLockWaitTimeoutDetail(t *testing.T) {
+	detail := &ErrLockWaitTimeoutDetail{
+		Key: []byte("k"), Primary: []byte("p"), LockTxnID: 1, WaitDuration: time.Second, ResolveAttempts: 2,
+	}
+	err := errors.WithStack(detail)
+	assert.True(t, errors.Is(err, ErrLockWaitTimeout))
+	assert.True(t, stderrors.Is(err, ErrLockWaitTimeout))
+	assert.False(t, errors.Is(err, ErrLockAcquireFailAndNoWaitSet))
+	assert.Equal(t, CodeLockWaitTimeoutDetail, CodeOf(err))
+	assert.Equal(t, "lock wait timeout, key: 6b, primary: 70, lockTxnID: 1, waited: 1s, resolve attempts: 2", detail.Error())
+
+	e, ok := ExtractLockWaitDetail(err)
+	assert.True(t, ok)
+	assert.Same(t, detail, e)
+	_, ok = ExtractLockWaitDetail(ErrLockWaitTimeout)
+	assert.False(t, ok)
+	_, ok = ExtractLockWaitDetail(nil)
+	assert.False(t, ok)
+
+	redact.SetMode(redact.ModeMarker)
+	defer redact.SetMode(redact.ModeOff)
+	assert.Equal(t, "lock wait timeout, key: ?, primary: ?, lockTxnID: 1, waited: 1s, resolve attempts: 2", detail.Error())
+}
+
+func TestCombinePreferRetryable(t *testing.T) {
+	assert.Nil(t, CombinePreferRetryable())
+	assert.Nil(t, CombinePreferRetryable(nil, nil))
+
+	nonRetryable := errors.New("non-retryable")
+	retryable := errors.WithStack(&ErrRetryable{Retryable: "retryable"})
+	deadlock := &ErrDeadlock{Deadlock: &kvrpcpb.Deadlock{}, IsRetryable: true}
+	assert.Equal(t, nonRetryable, CombinePreferRetryable(nil, nonRetryable))
+	assert.False(t, IsRetryable(CombinePreferRetryable(nonRetryable, errors.New("another"))))
+
+	err := CombinePreferRetryable(nonRetryable, nil, retryable)
+	assert.True(t, IsRetryable(err))
+	assert.True(t, errors.Is(err, nonRetryable))
+	assert.Equal(t, "retryable\nnon-retryable", err.Error())
+
+	err = CombinePreferRetryable(nonRetryable, deadlock)
+	assert.True(t, IsRetryable(err))
+	var d *ErrDeadlock
+	assert.True(t, stderrors.As(err, &d))
+
+	deadlock = &ErrDeadlock{Deadlock: &kvrpcpb.Deadlock{}}
+	assert.False(t, IsRetryable(CombinePreferRetryable(nonRetryable, deadlock)))
+}
+
+func TestExtractKeyErrs(t *testing.T) {
+	assert.Nil(t, ExtractKeyErrs(nil))
+	assert.Nil(t, ExtractKeyErrs([]*kvrpcpb.KeyError{nil}))
+
+	conflict := &kvrpcpb.WriteConflict{StartTs: 1, ConflictTs: 2, ConflictCommitTs: 3, Key: []byte("k1")}
+	keyErrs := []*kvrpcpb.KeyError{
+		{Abort: "abort"},
+		{Retryable: "retryable"},
+		{AssertionFailed: &kvrpcpb.AssertionFailed{Key: []byte("k2")}},
+		{Conflict: conflict},
+		{AlreadyExist: &kvrpcpb.AlreadyExist{Key: []byte("k3")}},
+		{Conflict: &kvrpcpb.WriteConflict{StartTs: 1, ConflictTs: 2, ConflictCommitTs: 3, Key: []byte("k1")}},
+		{Conflict: &kvrpcpb.WriteConflict{StartTs: 1, ConflictTs: 4, ConflictCommitTs: 5, Key: []byte("k1")}},
+		{TxnNotFound: &kvrpcpb.TxnNotFound{StartTs: 1}},
+	}
+	err := ExtractKeyErrs(keyErrs)
+	var multi *ErrMultipleKeyErrors
+	assert.True(t, stderrors.As(err, &multi))
+	members := multi.Unwrap()
+	// the identical conflict is deduplicated.
+	assert.Len(t, members, 7)
+
+	var c *ErrWriteConflict
+	assert.True(t, stderrors.As(members[0], &c))
+	assert.Equal(t, conflict, c.WriteConflict)
+	assert.True(t, stderrors.As(members[1], &c))
+	assert.Equal(t, uint64(4), c.ConflictTs)
+	assert.True(t, IsErrKeyExist(members[2]))
+	var assertionFailed *ErrAssertionFailed
+	assert.True(t, stderrors.As(members[3], &assertionFailed))
+	assert.True(t, IsRetryable(members[4]))
+	assert.Contains(t, members[5].Error(), "tikv aborts txn: abort")
+	assert.Contains(t, members[6].Error(), "txn 1 not found")
+	assert.Equal(t, members[0], multi.Primary())
+
+	// every member can be found in the aggregated error.
+	assert.True(t, stderrors.As(err, &c))
+	assert.Equal(t, conflict, c.WriteConflict)
+	assert.True(t, IsErrWriteConflict(err))
+	assert.True(t, IsErrKeyExist(err))
+	assert.True(t, stderrors.As(err, &assertionFailed))
+	assert.Equal(t, []byte("k2"), assertionFailed.RedactedKey())
+	assert.True(t, IsRetryable(err))
+
+	// the primary follows the priority even without conflicts.
+	err = ExtractKeyErrs([]*kvrpcpb.KeyError{{Retryable: "retryable"}, {AssertionFailed: &kvrpcpb.AssertionFailed{Key: []byte("k")}}})
+	assert.True(t, stderrors.As(err, &multi))
+	assert.True(t, stderrors.As(multi.Primary(), &assertionFailed))
+
+	redact.SetMode(redact.ModeMarker)
+	defer redact.SetMode(redact.ModeOff)
+	err = ExtractKeyErrs([]*kvrpcpb.KeyError{{Conflict: conflict}, {AlreadyExist: &kvrpcpb.AlreadyExist{Key: []byte("k3")}}})
+	assert.NotContains(t, err.Error(), "k1")
+	assert.NotContains(t, err.Error(), "k3")
+	assert.Contains(t, err.Error(), "2 key errors: [write conflict {")
+}
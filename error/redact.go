@@ -0,0 +1,80 @@
+// Copyright 2024 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package error
+
+import (
+	"github.com/gogo/protobuf/proto"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/tikv/client-go/v2/util/redact"
+)
+
+// RedactKeyErrIfNecessary returns the string of keyErr, in which the keys are printed by the strategy of the redact
+// package if it's enabled, including the keys of the locks, the write conflicts and the deadlock wait chain.
+func RedactKeyErrIfNecessary(keyErr *kvrpcpb.KeyError) string {
+	return redactKeyErr(keyErr).String()
+}
+
+// redactKeyErr returns a copy of keyErr whose keys are redacted, keyErr itself is returned if redaction is disabled.
+func redactKeyErr(keyErr *kvrpcpb.KeyError) *kvrpcpb.KeyError {
+	if keyErr == nil || !redact.NeedRedact() {
+		return keyErr
+	}
+	keyErr = proto.Clone(keyErr).(*kvrpcpb.KeyError)
+	redactLockInfo(keyErr.Locked)
+	if c := keyErr.Conflict; c != nil {
+		c.Key = redactKey(c.Key)
+		c.Primary = redactKey(c.Primary)
+	}
+	if e := keyErr.AlreadyExist; e != nil {
+		e.Key = redactKey(e.Key)
+	}
+	if d := keyErr.Deadlock; d != nil {
+		d.LockKey = redactKey(d.LockKey)
+		for _, entry := range d.WaitChain {
+			entry.Key = redactKey(entry.Key)
+		}
+	}
+	if e := keyErr.CommitTsExpired; e != nil {
+		e.Key = redactKey(e.Key)
+	}
+	if e := keyErr.TxnNotFound; e != nil {
+		e.PrimaryKey = redactKey(e.PrimaryKey)
+	}
+	if e := keyErr.AssertionFailed; e != nil {
+		e.Key = redactKey(e.Key)
+	}
+	if e := keyErr.PrimaryMismatch; e != nil {
+		redactLockInfo(e.LockInfo)
+	}
+	return keyErr
+}
+
+func redactLockInfo(lock *kvrpcpb.LockInfo) {
+	if lock == nil {
+		return
+	}
+	lock.PrimaryLock = redactKey(lock.PrimaryLock)
+	lock.Key = redactKey(lock.Key)
+	for i, secondary := range lock.Secondaries {
+		lock.Secondaries[i] = redactKey(secondary)
+	}
+}
+
+func redactKey(key []byte) []byte {
+	if key == nil {
+		return nil
+	}
+	return []byte(redact.Key(key))
+}
@@ -0,0 +1,99 @@
+// Copyright 2024 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package error
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pingcap/kvproto/pkg/deadlock"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/util/redact"
+)
+
+func TestRedactKeyErr(t *testing.T) {
+	defer func() {
+		redact.SetMode(redact.ModeOff)
+		redact.SetKeyRedactor(nil)
+	}()
+	redact.SetKeyRedactor(func(key []byte) string {
+		return "h(" + strings.ToUpper(string(key)) + ")"
+	})
+
+	keyErr := &kvrpcpb.KeyError{
+		Locked: &kvrpcpb.LockInfo{
+			PrimaryLock: []byte("pk"),
+			Key:         []byte("lk"),
+			Secondaries: [][]byte{[]byte("s1"), []byte("s2")},
+			LockVersion: 10,
+		},
+		Deadlock: &kvrpcpb.Deadlock{
+			LockKey:   []byte("dk"),
+			WaitChain: []*deadlock.WaitForEntry{{Key: []byte("wk")}},
+		},
+		TxnNotFound: &kvrpcpb.TxnNotFound{PrimaryKey: []byte("tk")},
+	}
+	keys := []string{"pk", "lk", "s1", "s2", "dk", "wk", "tk"}
+	original := keyErr.String()
+
+	// the keys are kept if the redaction is off.
+	require.Equal(t, original, RedactKeyErrIfNecessary(keyErr))
+
+	redact.SetMode(redact.ModeMarker)
+	s := RedactKeyErrIfNecessary(keyErr)
+	for _, k := range keys {
+		require.NotContains(t, s, `"`+k+`"`)
+	}
+	require.Contains(t, s, `primary_lock:"?"`)
+	require.Contains(t, s, `secondaries:"?" secondaries:"?"`)
+	require.Contains(t, s, "lock_version:10")
+
+	redact.SetMode(redact.ModeCustom)
+	s = RedactKeyErrIfNecessary(keyErr)
+	for _, k := range keys {
+		require.NotContains(t, s, `"`+k+`"`)
+		require.Contains(t, s, `"h(`+strings.ToUpper(k)+`)"`)
+	}
+	// the original error is not modified.
+	require.Equal(t, original, keyErr.String())
+}
+
+func TestRedactErrorMessages(t *testing.T) {
+	defer redact.SetMode(redact.ModeOff)
+	conflict := NewErrWriteConflictWithArgs(1, 2, 3, []byte("ck"), kvrpcpb.WriteConflict_Optimistic)
+	keyExist := &ErrKeyExist{AlreadyExist: &kvrpcpb.AlreadyExist{Key: []byte("ek")}}
+	assertion := &ErrAssertionFailed{AssertionFailed: &kvrpcpb.AssertionFailed{Key: []byte("ak")}}
+	lockOnly := &ErrLockOnlyIfExistsNoReturnValue{LockKey: []byte("ok")}
+	unexpected := ExtractKeyErr(&kvrpcpb.KeyError{Locked: &kvrpcpb.LockInfo{Key: []byte("xk")}})
+
+	require.Contains(t, conflict.Error(), `key:"ck"`)
+	require.Contains(t, keyExist.Error(), `key:"ek"`)
+	require.Contains(t, assertion.Error(), `key:"ak"`)
+	require.Contains(t, lockOnly.Error(), "{6f6b}")
+	require.Contains(t, unexpected.Error(), `key:"xk"`)
+
+	redact.SetMode(redact.ModeMarker)
+	require.Contains(t, conflict.Error(), `key:"?"`)
+	require.Contains(t, keyExist.Error(), `key:"?"`)
+	require.Contains(t, assertion.Error(), `key:"?"`)
+	require.Contains(t, lockOnly.Error(), "{?}")
+	// the message of an unexpected key error is generated when it's extracted.
+	require.Contains(t, unexpected.Error(), `key:"xk"`)
+	unexpected = ExtractKeyErr(&kvrpcpb.KeyError{Locked: &kvrpcpb.LockInfo{Key: []byte("xk")}})
+	require.Contains(t, unexpected.Error(), `key:"?"`)
+	// the keys of the errors are not modified.
+	require.Equal(t, []byte("ck"), conflict.Key)
+}
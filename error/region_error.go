@@ -0,0 +1,180 @@
+// Copyright 2024 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package error
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/errorpb"
+	"github.com/pkg/errors"
+	"github.com/tikv/client-go/v2/util/redact"
+)
+
+// ErrRegion is a region error returned by TiKV, which is extracted by ExtractRegionErr. It matches Cause by
+// errors.Is and errors.As.
+type ErrRegion struct {
+	// Cause is the sentinel error of the variant, or ErrFlashbackInProgress for FlashbackInProgress and ErrDataNotReady
+	// for DataIsNotReady.
+	Cause error
+	// RegionID is the ID of the requested region, it's zero if the variant doesn't carry it.
+	RegionID uint64
+	// LeaderStoreID is the store of the leader hinted by NotLeader, it's zero if the leader is unknown.
+	LeaderStoreID uint64
+	// Backoff is the backoff time suggested by ServerIsBusy.
+	Backoff time.Duration
+	// RefreshRegionCache tells whether the cached region is stale and should be reloaded before retrying.
+	RefreshRegionCache bool
+	// Reason is the reason carried by ServerIsBusy, ReadIndexNotReady and DiskFull.
+	Reason string
+	// Message is the raw message of the region error.
+	Message string
+}
+
+func (e *ErrRegion) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Cause.Error())
+	if e.RegionID != 0 {
+		fmt.Fprintf(&b, ", region_id: %d", e.RegionID)
+	}
+	if e.LeaderStoreID != 0 {
+		fmt.Fprintf(&b, ", leader_store_id: %d", e.LeaderStoreID)
+	}
+	if e.Backoff != 0 {
+		fmt.Fprintf(&b, ", backoff: %v", e.Backoff)
+	}
+	if e.Reason != "" {
+		fmt.Fprintf(&b, ", reason: %s", e.Reason)
+	}
+	// the message of TiKV may contain the keys.
+	if e.Message != "" && !redact.NeedRedact() {
+		fmt.Fprintf(&b, ", message: %s", e.Message)
+	}
+	return b.String()
+}
+
+// Unwrap returns the cause.
+func (e *ErrRegion) Unwrap() error {
+	return e.Cause
+}
+
+// ErrDataNotReady is the error when a stale read is rejected because the safe ts of the peer hasn't advanced to the
+// read ts. It matches ErrRegionDataNotReady by errors.Is, the stale read can either wait for the safe ts to catch up
+// with the read ts or fall back to the leader.
+type ErrDataNotReady struct {
+	RegionID uint64
+	// SafeTs is the safe ts of the peer when the read is rejected.
+	SafeTs uint64
+	// PeerReadTs is the ts the peer is read at, it's zero if it's unknown.
+	PeerReadTs uint64
+}
+
+// NewErrDataNotReady creates an ErrDataNotReady from the region error of the stale read at readTs.
+func NewErrDataNotReady(dataIsNotReady *errorpb.DataIsNotReady, readTs uint64) *ErrDataNotReady {
+	return &ErrDataNotReady{
+		RegionID:   dataIsNotReady.GetRegionId(),
+		SafeTs:     dataIsNotReady.GetSafeTs(),
+		PeerReadTs: readTs,
+	}
+}
+
+func (e *ErrDataNotReady) Error() string {
+	return fmt.Sprintf("region %d data not ready, safe_ts: %d, read_ts: %d", e.RegionID, e.SafeTs, e.PeerReadTs)
+}
+
+// Is implements the interface used by errors.Is.
+func (e *ErrDataNotReady) Is(target error) bool {
+	return target == ErrRegionDataNotReady
+}
+
+// ExtractRegionErr extracts a region error. The error matches the sentinel of the variant by errors.Is, and it can
+// be converted to ErrRegion by errors.As to get the region ID and the retry hints. A region error without a known
+// variant matches ErrUnknown. It returns nil if regionErr is nil.
+func ExtractRegionErr(regionErr *errorpb.Error) error {
+	if regionErr == nil {
+		return nil
+	}
+	e := &ErrRegion{Message: regionErr.GetMessage()}
+	switch {
+	case regionErr.GetNotLeader() != nil:
+		notLeader := regionErr.GetNotLeader()
+		e.Cause, e.RegionID, e.RefreshRegionCache = ErrNotLeader, notLeader.GetRegionId(), true
+		e.LeaderStoreID = notLeader.GetLeader().GetStoreId()
+	case regionErr.GetRegionNotFound() != nil:
+		e.Cause, e.RegionID, e.RefreshRegionCache = ErrRegionNotFound, regionErr.GetRegionNotFound().GetRegionId(), true
+	case regionErr.GetKeyNotInRegion() != nil:
+		e.Cause, e.RegionID, e.RefreshRegionCache = ErrKeyNotInRegion, regionErr.GetKeyNotInRegion().GetRegionId(), true
+	case regionErr.GetEpochNotMatch() != nil:
+		e.Cause, e.RefreshRegionCache = ErrEpochNotMatch, true
+	case regionErr.GetServerIsBusy() != nil:
+		serverIsBusy := regionErr.GetServerIsBusy()
+		e.Cause, e.Reason = ErrTiKVServerBusy, serverIsBusy.GetReason()
+		e.Backoff = time.Duration(serverIsBusy.GetBackoffMs()) * time.Millisecond
+	case regionErr.GetStaleCommand() != nil:
+		e.Cause = ErrTiKVStaleCommand
+	case regionErr.GetStoreNotMatch() != nil:
+		e.Cause, e.RefreshRegionCache = ErrStoreNotMatch, true
+	case regionErr.GetRaftEntryTooLarge() != nil:
+		e.Cause, e.RegionID = ErrRaftEntryTooLarge, regionErr.GetRaftEntryTooLarge().GetRegionId()
+	case regionErr.GetMaxTimestampNotSynced() != nil:
+		e.Cause = ErrTiKVMaxTimestampNotSynced
+	case regionErr.GetReadIndexNotReady() != nil:
+		readIndexNotReady := regionErr.GetReadIndexNotReady()
+		e.Cause, e.RegionID, e.Reason = ErrReadIndexNotReady, readIndexNotReady.GetRegionId(), readIndexNotReady.GetReason()
+	case regionErr.GetProposalInMergingMode() != nil:
+		e.Cause, e.RegionID = ErrProposalInMergingMode, regionErr.GetProposalInMergingMode().GetRegionId()
+	case regionErr.GetDataIsNotReady() != nil:
+		dataIsNotReady := regionErr.GetDataIsNotReady()
+		e.Cause, e.RegionID = NewErrDataNotReady(dataIsNotReady, 0), dataIsNotReady.GetRegionId()
+	case regionErr.GetRegionNotInitialized() != nil:
+		e.Cause, e.RegionID = ErrRegionNotInitialized, regionErr.GetRegionNotInitialized().GetRegionId()
+	case regionErr.GetDiskFull() != nil:
+		e.Cause, e.Reason = ErrTiKVDiskFull, regionErr.GetDiskFull().GetReason()
+	case regionErr.GetRecoveryInProgress() != nil:
+		e.Cause, e.RefreshRegionCache = ErrRegionRecoveryInProgress, true
+		e.RegionID = regionErr.GetRecoveryInProgress().GetRegionId()
+	case regionErr.GetFlashbackInProgress() != nil:
+		flashbackInProgress := regionErr.GetFlashbackInProgress()
+		e.Cause = &ErrFlashbackInProgress{
+			RegionID:         flashbackInProgress.GetRegionId(),
+			FlashbackVersion: flashbackInProgress.GetFlashbackStartTs(),
+		}
+		e.RegionID = flashbackInProgress.GetRegionId()
+	case regionErr.GetFlashbackNotPrepared() != nil:
+		e.Cause, e.RegionID = ErrRegionFlashbackNotPrepared, regionErr.GetFlashbackNotPrepared().GetRegionId()
+	case regionErr.GetIsWitness() != nil:
+		e.Cause, e.RegionID, e.RefreshRegionCache = ErrIsWitness, regionErr.GetIsWitness().GetRegionId(), true
+	case regionErr.GetMismatchPeerId() != nil:
+		e.Cause, e.RefreshRegionCache = ErrMismatchPeerID, true
+	case regionErr.GetBucketVersionNotMatch() != nil:
+		e.Cause, e.RefreshRegionCache = ErrBucketVersionNotMatch, true
+	default:
+		// the region cache is also invalidated by the region request sender on unknown region errors.
+		e.Cause, e.RefreshRegionCache = ErrUnknown, true
+	}
+	return errors.WithStack(e)
+}
+
+// RegionErrorRetryInfo returns the backoff time suggested by the server and whether the region cache should be
+// refreshed before retrying the region error extracted by ExtractRegionErr. It returns zero values for the other
+// errors.
+func RegionErrorRetryInfo(err error) (backoff time.Duration, refreshRegionCache bool) {
+	var e *ErrRegion
+	if errors.As(err, &e) {
+		return e.Backoff, e.RefreshRegionCache
+	}
+	return 0, false
+}
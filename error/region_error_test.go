@@ -0,0 +1,143 @@
+// Copyright 2024 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package error
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/errorpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/util/redact"
+)
+
+func TestExtractRegionErr(t *testing.T) {
+	cases := []struct {
+		field    string
+		err      *errorpb.Error
+		cause    error
+		expected ErrRegion
+	}{
+		{"NotLeader", &errorpb.Error{NotLeader: &errorpb.NotLeader{RegionId: 1, Leader: &metapb.Peer{Id: 2, StoreId: 3}}},
+			ErrNotLeader, ErrRegion{RegionID: 1, LeaderStoreID: 3, RefreshRegionCache: true}},
+		{"RegionNotFound", &errorpb.Error{RegionNotFound: &errorpb.RegionNotFound{RegionId: 1}},
+			ErrRegionNotFound, ErrRegion{RegionID: 1, RefreshRegionCache: true}},
+		{"KeyNotInRegion", &errorpb.Error{KeyNotInRegion: &errorpb.KeyNotInRegion{Key: []byte("k"), RegionId: 1}},
+			ErrKeyNotInRegion, ErrRegion{RegionID: 1, RefreshRegionCache: true}},
+		{"EpochNotMatch", &errorpb.Error{EpochNotMatch: &errorpb.EpochNotMatch{CurrentRegions: []*metapb.Region{{Id: 1}}}},
+			ErrEpochNotMatch, ErrRegion{RefreshRegionCache: true}},
+		{"ServerIsBusy", &errorpb.Error{ServerIsBusy: &errorpb.ServerIsBusy{Reason: "busy", BackoffMs: 100}},
+			ErrTiKVServerBusy, ErrRegion{Backoff: 100 * time.Millisecond, Reason: "busy"}},
+		{"StaleCommand", &errorpb.Error{StaleCommand: &errorpb.StaleCommand{}},
+			ErrTiKVStaleCommand, ErrRegion{}},
+		{"StoreNotMatch", &errorpb.Error{StoreNotMatch: &errorpb.StoreNotMatch{RequestStoreId: 1, ActualStoreId: 2}},
+			ErrStoreNotMatch, ErrRegion{RefreshRegionCache: true}},
+		{"RaftEntryTooLarge", &errorpb.Error{RaftEntryTooLarge: &errorpb.RaftEntryTooLarge{RegionId: 1, EntrySize: 2}},
+			ErrRaftEntryTooLarge, ErrRegion{RegionID: 1}},
+		{"MaxTimestampNotSynced", &errorpb.Error{MaxTimestampNotSynced: &errorpb.MaxTimestampNotSynced{}},
+			ErrTiKVMaxTimestampNotSynced, ErrRegion{}},
+		{"ReadIndexNotReady", &errorpb.Error{ReadIndexNotReady: &errorpb.ReadIndexNotReady{Reason: "applying", RegionId: 1}},
+			ErrReadIndexNotReady, ErrRegion{RegionID: 1, Reason: "applying"}},
+		{"ProposalInMergingMode", &errorpb.Error{ProposalInMergingMode: &errorpb.ProposalInMergingMode{RegionId: 1}},
+			ErrProposalInMergingMode, ErrRegion{RegionID: 1}},
+		{"DataIsNotReady", &errorpb.Error{DataIsNotReady: &errorpb.DataIsNotReady{RegionId: 1, PeerId: 2, SafeTs: 3}},
+			ErrRegionDataNotReady, ErrRegion{RegionID: 1}},
+		{"RegionNotInitialized", &errorpb.Error{RegionNotInitialized: &errorpb.RegionNotInitialized{RegionId: 1}},
+			ErrRegionNotInitialized, ErrRegion{RegionID: 1}},
+		{"DiskFull", &errorpb.Error{DiskFull: &errorpb.DiskFull{StoreId: []uint64{1}, Reason: "full"}},
+			ErrTiKVDiskFull, ErrRegion{Reason: "full"}},
+		{"RecoveryInProgress", &errorpb.Error{RecoveryInProgress: &errorpb.RecoveryInProgress{RegionId: 1}},
+			ErrRegionRecoveryInProgress, ErrRegion{RegionID: 1, RefreshRegionCache: true}},
+		{"FlashbackInProgress", &errorpb.Error{FlashbackInProgress: &errorpb.FlashbackInProgress{RegionId: 1, FlashbackStartTs: 2}},
+			ErrRegionFlashbackInProgress, ErrRegion{RegionID: 1}},
+		{"FlashbackNotPrepared", &errorpb.Error{FlashbackNotPrepared: &errorpb.FlashbackNotPrepared{RegionId: 1}},
+			ErrRegionFlashbackNotPrepared, ErrRegion{RegionID: 1}},
+		{"IsWitness", &errorpb.Error{IsWitness: &errorpb.IsWitness{RegionId: 1}},
+			ErrIsWitness, ErrRegion{RegionID: 1, RefreshRegionCache: true}},
+		{"MismatchPeerId", &errorpb.Error{MismatchPeerId: &errorpb.MismatchPeerId{RequestPeerId: 1, StorePeerId: 2}},
+			ErrMismatchPeerID, ErrRegion{RefreshRegionCache: true}},
+		{"BucketVersionNotMatch", &errorpb.Error{BucketVersionNotMatch: &errorpb.BucketVersionNotMatch{Version: 1}},
+			ErrBucketVersionNotMatch, ErrRegion{RefreshRegionCache: true}},
+		{"Message", &errorpb.Error{Message: "something new"},
+			ErrUnknown, ErrRegion{RefreshRegionCache: true, Message: "something new"}},
+	}
+
+	// every field of errorpb.Error is covered.
+	covered := map[string]bool{}
+	for _, c := range cases {
+		covered[c.field] = true
+	}
+	typ := reflect.TypeOf(errorpb.Error{})
+	for i := 0; i < typ.NumField(); i++ {
+		if name := typ.Field(i).Name; !strings.HasPrefix(name, "XXX_") {
+			assert.True(t, covered[name], "errorpb.Error.%s is not covered", name)
+		}
+	}
+
+	for _, c := range cases {
+		err := ExtractRegionErr(c.err)
+		require.Error(t, err, c.field)
+		assert.ErrorIs(t, err, c.cause, c.field)
+		var regionErr *ErrRegion
+		require.True(t, errors.As(err, &regionErr), c.field)
+		c.expected.Cause = regionErr.Cause
+		assert.Equal(t, c.expected, *regionErr, c.field)
+		backoff, refresh := RegionErrorRetryInfo(err)
+		assert.Equal(t, c.expected.Backoff, backoff, c.field)
+		assert.Equal(t, c.expected.RefreshRegionCache, refresh, c.field)
+	}
+
+	var flashbackErr *ErrFlashbackInProgress
+	require.True(t, errors.As(ExtractRegionErr(cases[15].err), &flashbackErr))
+	assert.Equal(t, ErrFlashbackInProgress{RegionID: 1, FlashbackVersion: 2}, *flashbackErr)
+	var dataNotReadyErr *ErrDataNotReady
+	require.True(t, errors.As(ExtractRegionErr(cases[11].err), &dataNotReadyErr))
+	assert.Equal(t, ErrDataNotReady{RegionID: 1, SafeTs: 3}, *dataNotReadyErr)
+
+	assert.Nil(t, ExtractRegionErr(nil))
+	backoff, refresh := RegionErrorRetryInfo(ErrTiKVServerBusy)
+	assert.Zero(t, backoff)
+	assert.False(t, refresh)
+}
+
+func TestErrDataNotReady(t *testing.T) {
+	err := NewErrDataNotReady(&errorpb.DataIsNotReady{RegionId: 1, PeerId: 2, SafeTs: 3}, 4)
+	assert.Equal(t, ErrDataNotReady{RegionID: 1, SafeTs: 3, PeerReadTs: 4}, *err)
+	assert.Equal(t, "region 1 data not ready, safe_ts: 3, read_ts: 4", err.Error())
+	assert.ErrorIs(t, errors.WithStack(err), ErrRegionDataNotReady)
+	assert.NotErrorIs(t, err, ErrRegionNotInitialized)
+	assert.Equal(t, ErrDataNotReady{}, *NewErrDataNotReady(nil, 0))
+}
+
+func TestErrRegionMessage(t *testing.T) {
+	err := ExtractRegionErr(&errorpb.Error{
+		Message:   "peer is not leader for region 1",
+		NotLeader: &errorpb.NotLeader{RegionId: 1, Leader: &metapb.Peer{StoreId: 3}},
+	})
+	assert.Equal(t, "peer is not leader, region_id: 1, leader_store_id: 3, message: peer is not leader for region 1", err.Error())
+	err = ExtractRegionErr(&errorpb.Error{ServerIsBusy: &errorpb.ServerIsBusy{Reason: "busy", BackoffMs: 100}})
+	assert.Equal(t, "tikv server busy, backoff: 100ms, reason: busy", err.Error())
+
+	// the message is dropped when redaction is on since it may contain the keys.
+	redact.SetMode(redact.ModeMarker)
+	defer redact.SetMode(redact.ModeOff)
+	err = ExtractRegionErr(&errorpb.Error{Message: "key 6b is not in region 1", KeyNotInRegion: &errorpb.KeyNotInRegion{RegionId: 1}})
+	assert.Equal(t, "key not in region, region_id: 1", err.Error())
+}
@@ -192,6 +192,21 @@ func (s *testCommitterSuite) TestDeleteYourWritesTTL() {
 	}
 }
 
+func (s *testCommitterSuite) TestSetWithTTL() {
+	txn := s.begin()
+	s.Nil(txn.Set([]byte("a"), []byte("a1")))
+	h := txn.GetMemBuffer().Staging()
+	err := txn.SetWithTTL([]byte("b"), []byte("b1"), 10)
+	s.True(tikverr.IsErrKeyTTLUnsupported(err))
+	// the rejected write leaves nothing in the buffer, so the staging rolls back cleanly.
+	s.Equal(1, txn.Len())
+	txn.GetMemBuffer().Cleanup(h)
+	// a zero ttl is the same as Set.
+	s.Nil(txn.SetWithTTL([]byte("c"), []byte("c1"), 0))
+	s.Nil(txn.Commit(context.Background()))
+	s.checkValues(map[string]string{"a": "a1", "c": "c1"})
+}
+
 func (s *testCommitterSuite) TestCommitRollback() {
 	s.mustCommit(map[string]string{
 		"a": "a",
@@ -1123,7 +1138,7 @@ func (s *testCommitterSuite) TestPessimisticLockAllowLockWithConflictError() {
 			}
 			err := txn.LockKeys(context.Background(), lockCtx, key)
 			s.NotNil(err)
-			s.Equal(tikverr.ErrLockWaitTimeout.Error(), err.Error())
+			s.ErrorIs(err, tikverr.ErrLockWaitTimeout)
 			s.Equal([]string{}, txn.GetAggressiveLockingKeys())
 
 			// Abort the blocking transaction.
@@ -1687,7 +1702,7 @@ func (s *testCommitterSuite) TestAcquireFalseTimeoutLock() {
 	lockCtx = kv.NewLockCtx(txn2.StartTS(), 200, time.Now())
 	err = txn2.LockKeys(context.Background(), lockCtx, k2)
 	// cannot acquire lock in time thus error
-	s.Equal(err.Error(), tikverr.ErrLockWaitTimeout.Error())
+	s.ErrorIs(err, tikverr.ErrLockWaitTimeout)
 }
 
 func (s *testCommitterSuite) getLockInfo(key []byte) *kvrpcpb.LockInfo {
@@ -1825,7 +1840,7 @@ func (s *testCommitterSuite) TestPessimisticLockPrimary() {
 	s.Nil(failpoint.Disable("tikvclient/txnNotFoundRetTTL"))
 	s.Nil(err)
 	waitErr := <-doneCh
-	s.Equal(tikverr.ErrLockWaitTimeout, errors.Unwrap(waitErr))
+	s.ErrorIs(waitErr, tikverr.ErrLockWaitTimeout)
 }
 
 type kvFilter struct{}
@@ -2482,6 +2497,36 @@ func (s *testCommitterSuite) TestFlagsInMemBufferMutations() {
 	})
 }
 
+func (s *testCommitterSuite) TestPurgeTombstonesKeepsMutations() {
+	s.mustCommit(map[string]string{"pa": "1", "pb": "1"})
+
+	txn := s.begin()
+	memdb := txn.GetMemBuffer()
+	s.Nil(txn.Delete([]byte("pa")))
+	s.Nil(memdb.SetWithFlags([]byte("pn"), []byte("1"), kv.SetNewlyInserted))
+	s.Nil(txn.Delete([]byte("pn")))
+	s.Nil(txn.Set([]byte("pc"), []byte("1")))
+	n, err := memdb.PurgeTombstonesBefore(memdb.Checkpoint())
+	s.Nil(err)
+	// only the deletion of the key inserted by the transaction is purged.
+	s.Equal(1, n)
+
+	committer, err := txn.NewCommitter(0)
+	s.Nil(err)
+	s.Nil(committer.InitKeysAndMutations())
+	mutations := committer.GetMutations()
+	ops := make(map[string]kvrpcpb.Op, mutations.Len())
+	for i := 0; i < mutations.Len(); i++ {
+		ops[string(mutations.GetKey(i))] = mutations.GetOp(i)
+	}
+	s.Equal(map[string]kvrpcpb.Op{"pa": kvrpcpb.Op_Del, "pc": kvrpcpb.Op_Put}, ops)
+
+	s.Nil(txn.Commit(context.Background()))
+	_, err = s.begin().Get(context.Background(), []byte("pa"))
+	s.True(tikverr.IsErrNotFound(err))
+	s.checkValues(map[string]string{"pb": "1", "pc": "1"})
+}
+
 func (s *testCommitterSuite) TestExtractKeyExistsErr() {
 	txn := s.begin()
 	err := txn.Set([]byte("de"), []byte("ef"))
@@ -1013,7 +1013,14 @@ func (s *testLockSuite) TestLockWaitTimeLimit() {
 	lockCtx = kv.NewLockCtx(txn2.StartTS(), 200, time.Now())
 	err = txn2.LockKeys(context.Background(), lockCtx, k2)
 	// cannot acquire lock in time thus error
-	s.Equal(tikverr.ErrLockWaitTimeout.Error(), err.Error())
+	s.ErrorIs(err, tikverr.ErrLockWaitTimeout)
+	detail, ok := tikverr.ExtractLockWaitDetail(err)
+	s.True(ok)
+	s.Equal(k2, detail.Key)
+	s.Equal(k1, detail.Primary)
+	s.Equal(txn1.StartTS(), detail.LockTxnID)
+	s.GreaterOrEqual(detail.WaitDuration, 200*time.Millisecond)
+	s.Positive(detail.ResolveAttempts)
 	s.GreaterOrEqual(time.Since(lockCtx.WaitStartTime), 200*time.Millisecond)
 	s.Less(time.Since(lockCtx.WaitStartTime), 800*time.Millisecond)
 
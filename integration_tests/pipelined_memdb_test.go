@@ -33,6 +33,7 @@ import (
 	"github.com/tikv/client-go/v2/testutils"
 	"github.com/tikv/client-go/v2/tikv"
 	"github.com/tikv/client-go/v2/tikvrpc"
+	"github.com/tikv/client-go/v2/tikvrpc/interceptor"
 	"github.com/tikv/client-go/v2/txnkv"
 	"github.com/tikv/client-go/v2/txnkv/transaction"
 	"github.com/tikv/client-go/v2/txnkv/txnlock"
@@ -137,6 +138,28 @@ func (s *testPipelinedMemDBSuite) TestPipelinedAndFlush() {
 	}
 }
 
+func (s *testPipelinedMemDBSuite) TestPipelinedSetWithTTL() {
+	ctx := context.Background()
+	txn, err := s.store.Begin(tikv.WithPipelinedMemDB())
+	s.Nil(err)
+	err = txn.SetWithTTL([]byte("k1"), []byte("v1"), 10)
+	s.True(tikverr.IsErrKeyTTLUnsupported(err))
+	s.Nil(txn.SetWithTTL([]byte("k2"), []byte("v2"), 0))
+	flushed, err := txn.GetMemBuffer().Flush(true)
+	s.Nil(err)
+	s.True(flushed)
+	s.Nil(txn.Commit(ctx))
+
+	txn, err = s.store.Begin()
+	s.Nil(err)
+	defer txn.Rollback()
+	_, err = txn.Get(ctx, []byte("k1"))
+	s.True(tikverr.IsErrNotFound(err))
+	val, err := txn.Get(ctx, []byte("k2"))
+	s.Nil(err)
+	s.Equal([]byte("v2"), val)
+}
+
 func (s *testPipelinedMemDBSuite) TestPipelinedMemDBBufferGet() {
 	ctx := context.Background()
 	txn, err := s.store.Begin(tikv.WithPipelinedMemDB())
@@ -160,6 +183,42 @@ func (s *testPipelinedMemDBSuite) TestPipelinedMemDBBufferGet() {
 	s.Nil(txn.Rollback())
 }
 
+func (s *testPipelinedMemDBSuite) TestPipelinedBatchGetConcurrency() {
+	ctx := context.Background()
+	batchGets := func(opts ...tikv.TxnOption) int {
+		txn, err := s.store.Begin(append(opts, tikv.WithPipelinedMemDB())...)
+		s.Nil(err)
+		var count atomic.Int32
+		txn.SetRPCInterceptor(interceptor.NewRPCInterceptor("count-buffer-batch-get", func(next interceptor.RPCInterceptorFunc) interceptor.RPCInterceptorFunc {
+			return func(target string, req *tikvrpc.Request) (*tikvrpc.Response, error) {
+				if req.Type == tikvrpc.CmdBufferBatchGet {
+					count.Add(1)
+				}
+				return next(target, req)
+			}
+		}))
+		keys := make([][]byte, 0, 256)
+		for i := 0; i < 256; i++ {
+			key := []byte(strconv.Itoa(i))
+			keys = append(keys, key)
+			s.Nil(txn.Set(key, key))
+		}
+		flushed, err := txn.GetMemBuffer().Flush(true)
+		s.Nil(err)
+		s.True(flushed)
+		s.Nil(txn.GetMemBuffer().FlushWait())
+		m, err := txn.GetMemBuffer().BatchGet(ctx, keys)
+		s.Nil(err)
+		s.Len(m, len(keys))
+		s.Nil(txn.Rollback())
+		return int(count.Load())
+	}
+	s.Equal(2, batchGets(tikv.WithPipelinedBatchGetConcurrency(2)))
+	s.Equal(1, batchGets(tikv.WithPipelinedBatchGetConcurrency(1)))
+	// 256 keys are split into 8 batches of minBatchGetKeysPerWorker keys by default.
+	s.Equal(8, batchGets())
+}
+
 func (s *testPipelinedMemDBSuite) TestPipelinedFlushBlock() {
 	txn, err := s.store.Begin(tikv.WithPipelinedMemDB())
 	s.Nil(err)
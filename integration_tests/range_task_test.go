@@ -37,15 +37,24 @@ package tikv_test
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"runtime"
 	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/stretchr/testify/suite"
 	"github.com/tikv/client-go/v2/kv"
 	"github.com/tikv/client-go/v2/testutils"
 	"github.com/tikv/client-go/v2/tikv"
 	"github.com/tikv/client-go/v2/txnkv/rangetask"
+	"github.com/tikv/client-go/v2/util"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 func TestRangeTask(t *testing.T) {
@@ -224,6 +233,66 @@ func (s *testRangeTaskSuite) TestRangeTask() {
 	}
 }
 
+func (s *testRangeTaskSuite) TestRangeTaskEnumerateRanges() {
+	handler := func(ctx context.Context, r kv.KeyRange) (rangetask.TaskStat, error) {
+		s.Fail("the handler should not be called")
+		return rangetask.TaskStat{}, nil
+	}
+	runner := rangetask.NewRangeTaskRunner("test-enumerate-runner", s.store, 1, handler)
+
+	for regionsPerTask := 1; regionsPerTask <= 5; regionsPerTask++ {
+		runner.SetRegionsPerTask(regionsPerTask)
+		for i, r := range s.testRanges {
+			ranges, err := runner.EnumerateRanges(context.Background(), r.StartKey, r.EndKey)
+			s.Nil(err)
+			s.Equal(batchRanges(s.expectedRanges[i], regionsPerTask), ranges)
+		}
+	}
+	s.Equal(0, runner.CompletedRegions())
+}
+
+func (s *testRangeTaskSuite) TestRangeTaskPlan() {
+	// Split some regions further so that the regions are uneven.
+	for _, key := range []string{"c1", "c2", "c3", "c4", "m5"} {
+		region, _, _, _ := s.cluster.GetRegionByKey([]byte(key))
+		newRegionID := s.cluster.AllocID()
+		newPeerID := s.cluster.AllocID()
+		s.cluster.Split(region.Id, newRegionID, []byte(key), []uint64{newPeerID}, newPeerID)
+	}
+
+	var mu sync.Mutex
+	var dispatched []kv.KeyRange
+	handler := func(ctx context.Context, r kv.KeyRange) (rangetask.TaskStat, error) {
+		mu.Lock()
+		dispatched = append(dispatched, r)
+		mu.Unlock()
+		return rangetask.TaskStat{CompletedRegions: 1}, nil
+	}
+	runner := rangetask.NewRangeTaskRunner("test-plan-runner", s.store, 1, handler)
+	ranges := append(s.testRanges, makeRange("b", "d"), makeRange("c0", "n"))
+
+	for regionsPerTask := 1; regionsPerTask <= 5; regionsPerTask++ {
+		for _, r := range ranges {
+			// each task covers one region when regionsPerTask is 1.
+			runner.SetRegionsPerTask(1)
+			regions, err := runner.EnumerateRanges(context.Background(), r.StartKey, r.EndKey)
+			s.Nil(err)
+
+			runner.SetRegionsPerTask(regionsPerTask)
+			completed := runner.CompletedRegions()
+			plan, err := runner.Plan(context.Background(), r.StartKey, r.EndKey)
+			s.Nil(err)
+			s.Equal(len(regions), plan.Regions)
+			s.Equal(completed, runner.CompletedRegions())
+
+			dispatched = nil
+			s.Nil(runner.RunOnRange(context.Background(), r.StartKey, r.EndKey))
+			s.Equal(plan.Ranges, append([]kv.KeyRange{}, dispatched...))
+			s.Equal(len(plan.Ranges), runner.CompletedRegions())
+		}
+	}
+}
+
 func (s *testRangeTaskSuite) testRangeTaskErrorImpl(concurrency int) {
 	for i, r := range s.testRanges {
 		// Iterate all sub tasks and make it an error
@@ -263,3 +332,676 @@ func (s *testRangeTaskSuite) TestRangeTaskError() {
 		s.testRangeTaskErrorImpl(concurrency)
 	}
 }
+
+func (s *testRangeTaskSuite) TestRangeTaskRetry() {
+	errRetryable := errors.New("retryable error")
+	r := s.testRanges[4]
+	subRanges := s.expectedRanges[4]
+	errKey := subRanges[1].StartKey
+
+	newRunner := func(failures int) (*rangetask.Runner, *int32) {
+		var attempts int32
+		handler := func(ctx context.Context, r kv.KeyRange) (rangetask.TaskStat, error) {
+			if bytes.Equal(r.StartKey, errKey) && atomic.AddInt32(&attempts, 1) <= int32(failures) {
+				return rangetask.TaskStat{FailedRegions: 1}, errRetryable
+			}
+			return rangetask.TaskStat{CompletedRegions: 1}, nil
+		}
+		runner := rangetask.NewRangeTaskRunner("test-retry-runner", s.store, 2, handler)
+		runner.SetRegionsPerTask(1)
+		runner.SetTaskRetryBackoff(time.Millisecond, 5*time.Millisecond)
+		runner.SetRetryableChecker(func(err error) bool { return errors.Is(err, errRetryable) })
+		return runner, &attempts
+	}
+
+	// the task fails twice then succeeds.
+	runner, attempts := newRunner(2)
+	runner.SetTaskMaxRetry(3)
+	s.Nil(runner.RunOnRange(context.Background(), r.StartKey, r.EndKey))
+	s.Equal(int32(3), atomic.LoadInt32(attempts))
+	s.Equal(len(subRanges), runner.CompletedRegions())
+	s.Equal(0, runner.FailedRegions())
+
+	// the retries are exhausted, and the failed regions are counted once.
+	runner, attempts = newRunner(3)
+	runner.SetTaskMaxRetry(2)
+	s.NotNil(runner.RunOnRange(context.Background(), r.StartKey, r.EndKey))
+	s.Equal(int32(3), atomic.LoadInt32(attempts))
+	s.Equal(1, runner.FailedRegions())
+
+	// the error is not retryable by default.
+	runner, attempts = newRunner(2)
+	runner.SetTaskMaxRetry(3)
+	runner.SetRetryableChecker(nil)
+	s.NotNil(runner.RunOnRange(context.Background(), r.StartKey, r.EndKey))
+	s.Equal(int32(1), atomic.LoadInt32(attempts))
+	s.Equal(1, runner.FailedRegions())
+
+	// the retries back off with the backoffer of the given type.
+	runner, attempts = newRunner(2)
+	runner.SetTaskMaxRetry(3)
+	runner.SetTaskRetryBackoffer(tikv.NewBackofferWithVars(context.Background(), 1000, nil), tikv.BoTiKVRPC())
+	s.Nil(runner.RunOnRange(context.Background(), r.StartKey, r.EndKey))
+	s.Equal(int32(3), atomic.LoadInt32(attempts))
+	s.Equal(0, runner.FailedRegions())
+
+	// the backoffer doesn't make the errors retryable.
+	runner, attempts = newRunner(2)
+	runner.SetTaskMaxRetry(3)
+	runner.SetRetryableChecker(nil)
+	runner.SetTaskRetryBackoffer(tikv.NewBackofferWithVars(context.Background(), 1000, nil), tikv.BoTiKVRPC())
+	s.NotNil(runner.RunOnRange(context.Background(), r.StartKey, r.EndKey))
+	s.Equal(int32(1), atomic.LoadInt32(attempts))
+
+	// the retries stop once the backoffer exceeds its max sleep.
+	runner, attempts = newRunner(10)
+	runner.SetTaskMaxRetry(10)
+	runner.SetTaskRetryBackoffer(tikv.NewBackofferWithVars(context.Background(), 1, nil), tikv.BoTiKVRPC())
+	s.NotNil(runner.RunOnRange(context.Background(), r.StartKey, r.EndKey))
+	s.Less(atomic.LoadInt32(attempts), int32(10))
+}
+
+func (s *testRangeTaskSuite) TestRangeTaskRunWithStat() {
+	r := s.testRanges[4]
+	subRanges := s.expectedRanges[4]
+	errKey := subRanges[1].StartKey
+	var fail atomic.Bool
+	handler := func(ctx context.Context, r kv.KeyRange) (rangetask.TaskStat, error) {
+		if fail.Load() && bytes.Equal(r.StartKey, errKey) {
+			return rangetask.TaskStat{FailedRegions: 1}, errors.New("error")
+		}
+		return rangetask.TaskStat{CompletedRegions: 1}, nil
+	}
+	runner := rangetask.NewRangeTaskRunner("test-stat-runner", s.store, 1, handler)
+	runner.SetRegionsPerTask(1)
+
+	fail.Store(true)
+	failedStat, err := runner.RunOnRangeWithStat(context.Background(), r.StartKey, r.EndKey)
+	s.NotNil(err)
+	s.Equal(1, failedStat.FailedRegions)
+	s.Equal(runner.CompletedRegions(), failedStat.CompletedRegions)
+
+	// the counters are reset by the next run, while the returned stat is not changed.
+	fail.Store(false)
+	stat, err := runner.RunOnRangeWithStat(context.Background(), r.StartKey, r.EndKey)
+	s.Nil(err)
+	s.Equal(rangetask.TaskStat{CompletedRegions: len(subRanges)}, stat)
+	s.Equal(0, runner.FailedRegions())
+	s.Equal(1, failedStat.FailedRegions)
+}
+
+func (s *testRangeTaskSuite) TestRangeTaskBestEffort() {
+	r := s.testRanges[0]
+	subRanges := s.expectedRanges[0]
+	errTest := errors.New("test error")
+	// the regions starting with a vowel fail.
+	handler := func(ctx context.Context, r kv.KeyRange) (rangetask.TaskStat, error) {
+		if len(r.StartKey) > 0 && bytes.ContainsAny(r.StartKey[:1], "aeiou") {
+			return rangetask.TaskStat{FailedRegions: 1}, errTest
+		}
+		return rangetask.TaskStat{CompletedRegions: 1}, nil
+	}
+	for concurrency := 1; concurrency < 5; concurrency++ {
+		runner := rangetask.NewRangeTaskRunner("test-best-effort-runner", s.store, concurrency, handler)
+		runner.SetRegionsPerTask(1)
+
+		stat, errs := runner.RunOnRangeBestEffort(context.Background(), r.StartKey, r.EndKey)
+		s.Equal(rangetask.TaskStat{CompletedRegions: len(subRanges) - 5, FailedRegions: 5}, stat)
+		s.Len(errs, 5)
+		for i, vowel := range "aeiou" {
+			var taskErr *rangetask.TaskError
+			s.ErrorAs(errs[i], &taskErr)
+			s.ErrorIs(errs[i], errTest)
+			s.Equal([]byte{byte(vowel)}, taskErr.Range.StartKey)
+			s.Equal([]byte{byte(vowel) + 1}, taskErr.Range.EndKey)
+		}
+
+		// RunOnRange still stops at the first failure.
+		err := runner.RunOnRange(context.Background(), r.StartKey, r.EndKey)
+		s.ErrorIs(err, errTest)
+		s.Less(runner.CompletedRegions(), len(subRanges)-5)
+	}
+
+	// the run stops if ctx is canceled.
+	runner := rangetask.NewRangeTaskRunner("test-best-effort-runner", s.store, 1, handler)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, errs := runner.RunOnRangeBestEffort(ctx, r.StartKey, r.EndKey)
+	s.NotEmpty(errs)
+	s.ErrorIs(errs[len(errs)-1], context.Canceled)
+}
+
+func (s *testRangeTaskSuite) TestRangeTaskSkippedRegions() {
+	r := s.testRanges[0]
+	subRanges := s.expectedRanges[0]
+	// only the regions starting with a vowel have something to do.
+	handler := func(ctx context.Context, r kv.KeyRange) (rangetask.TaskStat, error) {
+		if len(r.StartKey) > 0 && bytes.ContainsAny(r.StartKey[:1], "aeiou") {
+			return rangetask.TaskStat{CompletedRegions: 1}, nil
+		}
+		return rangetask.TaskStat{SkippedRegions: 1}, nil
+	}
+	runner := rangetask.NewRangeTaskRunner("test-skip-runner", s.store, 4, handler)
+	runner.SetRegionsPerTask(1)
+
+	stat, err := runner.RunOnRangeWithStat(context.Background(), r.StartKey, r.EndKey)
+	s.Nil(err)
+	s.Equal(rangetask.TaskStat{CompletedRegions: 5, SkippedRegions: len(subRanges) - 5}, stat)
+	s.Equal(5, runner.CompletedRegions())
+	s.Equal(len(subRanges)-5, runner.SkippedRegions())
+	s.Equal(0, runner.FailedRegions())
+}
+
+func (s *testRangeTaskSuite) TestRangeTaskAdaptiveBatching() {
+	// testRanges[0] is the whole key space.
+	r := s.testRanges[0]
+	subRanges := s.expectedRanges[0]
+	ranges := make(chan *kv.KeyRange, 100)
+	handler := func(ctx context.Context, r kv.KeyRange) (rangetask.TaskStat, error) {
+		ranges <- &r
+		return rangetask.TaskStat{CompletedRegions: 1}, nil
+	}
+	runner := rangetask.NewRangeTaskRunner("test-adaptive-runner", s.store, 1, handler)
+	runner.SetAdaptiveBatching(1, 8)
+
+	s.Nil(runner.RunOnRange(context.Background(), r.StartKey, r.EndKey))
+	tasks := collect(ranges)
+	// The first task contains only one region, and the later ones grow since the handler is fast.
+	s.Equal(subRanges[0], tasks[0])
+	s.Less(len(tasks), len(subRanges))
+	s.Equal(r.StartKey, tasks[0].StartKey)
+	s.Equal(r.EndKey, tasks[len(tasks)-1].EndKey)
+	for i := 1; i < len(tasks); i++ {
+		s.Equal(tasks[i-1].EndKey, tasks[i].StartKey)
+	}
+}
+
+func (s *testRangeTaskSuite) TestRangeTaskSetConcurrency() {
+	r := s.testRanges[0]
+	subRanges := s.expectedRanges[0]
+	var running, maxRunning, handled int32
+	handler := func(ctx context.Context, r kv.KeyRange) (rangetask.TaskStat, error) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		atomic.AddInt32(&handled, 1)
+		return rangetask.TaskStat{CompletedRegions: 1}, nil
+	}
+	runner := rangetask.NewRangeTaskRunner("test-concurrency-runner", s.store, 1, handler)
+	runner.SetRegionsPerTask(1)
+
+	goroutines := runtime.NumGoroutine()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- runner.RunOnRange(context.Background(), r.StartKey, r.EndKey)
+	}()
+
+	s.Eventually(func() bool { return atomic.LoadInt32(&handled) >= 2 }, 5*time.Second, time.Millisecond)
+	s.Equal(int32(1), atomic.LoadInt32(&maxRunning))
+	runner.SetConcurrency(4)
+	s.Eventually(func() bool { return atomic.LoadInt32(&maxRunning) == 4 }, 5*time.Second, time.Millisecond)
+
+	runner.SetConcurrency(1)
+	// The excess workers exit after their current tasks.
+	s.Eventually(func() bool { return atomic.LoadInt32(&running) <= 1 }, 5*time.Second, time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	atomic.StoreInt32(&maxRunning, 0)
+
+	s.Nil(<-errCh)
+	s.LessOrEqual(atomic.LoadInt32(&maxRunning), int32(1))
+	s.Equal(len(subRanges), runner.CompletedRegions())
+	s.Eventually(func() bool { return runtime.NumGoroutine() <= goroutines }, 5*time.Second, 10*time.Millisecond)
+}
+
+func (s *testRangeTaskSuite) TestRangeTaskRunFrom() {
+	r := s.testRanges[4]
+	subRanges := s.expectedRanges[4]
+	ranges := make(chan *kv.KeyRange, 100)
+	handler := func(ctx context.Context, r kv.KeyRange) (rangetask.TaskStat, error) {
+		ranges <- &r
+		return rangetask.TaskStat{CompletedRegions: 1}, nil
+	}
+	runner := rangetask.NewRangeTaskRunner("test-resume-runner", s.store, 2, handler)
+	runner.SetRegionsPerTask(1)
+
+	// resume from the end key of a completed task.
+	s.Nil(runner.RunOnRangeFrom(context.Background(), subRanges[1].EndKey, r.StartKey, r.EndKey))
+	s.checkRanges(collect(ranges), subRanges[2:])
+	s.Equal(len(subRanges)-2, runner.CompletedRegions())
+
+	// resume from the start key is the same as RunOnRange.
+	s.Nil(runner.RunOnRangeFrom(context.Background(), r.StartKey, r.StartKey, r.EndKey))
+	s.checkRanges(collect(ranges), subRanges)
+
+	// the resume key must be within the range.
+	s.NotNil(runner.RunOnRangeFrom(context.Background(), r.EndKey, r.StartKey, r.EndKey))
+	s.NotNil(runner.RunOnRangeFrom(context.Background(), []byte(""), r.StartKey, r.EndKey))
+	s.Empty(collect(ranges))
+}
+
+func (s *testRangeTaskSuite) TestRangeTaskResumeKey() {
+	r := s.testRanges[3]
+	subRanges := s.expectedRanges[3]
+	blockedKey := subRanges[1].StartKey
+	var (
+		handled   atomic.Int32
+		unblockCh = make(chan struct{})
+	)
+	handler := func(ctx context.Context, r kv.KeyRange) (rangetask.TaskStat, error) {
+		if bytes.Equal(r.StartKey, blockedKey) {
+			// the task fails after the tasks behind it are completed.
+			<-unblockCh
+			return rangetask.TaskStat{FailedRegions: 1}, errors.New("injected")
+		}
+		handled.Add(1)
+		return rangetask.TaskStat{CompletedRegions: 1}, nil
+	}
+	runner := rangetask.NewRangeTaskRunner("test-resume-key-runner", s.store, 4, handler)
+	runner.SetRegionsPerTask(1)
+	s.Nil(runner.ResumeKey())
+	var (
+		mu         sync.Mutex
+		reported   []string
+		resumeKeys []string
+	)
+	runner.SetProgressCallback(func(stat rangetask.TaskStat, lastKey []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		reported = append(reported, string(lastKey))
+		resumeKeys = append(resumeKeys, string(runner.ResumeKey()))
+	})
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- runner.RunOnRange(context.Background(), r.StartKey, r.EndKey)
+	}()
+	s.Eventually(func() bool { return int(handled.Load()) == len(subRanges)-1 }, 5*time.Second, time.Millisecond)
+	// the keys after the blocked task are reported, but the resume key stays before it.
+	s.Equal(subRanges[1].StartKey, runner.ResumeKey())
+	close(unblockCh)
+	s.NotNil(<-errCh)
+	s.Equal(subRanges[1].StartKey, runner.ResumeKey())
+	mu.Lock()
+	s.Contains(reported, string(subRanges[len(subRanges)-1].EndKey))
+	for _, key := range resumeKeys {
+		s.Contains([]string{string(subRanges[0].StartKey), string(subRanges[1].StartKey)}, key)
+	}
+	mu.Unlock()
+
+	// resuming from the resume key redoes the failed task.
+	ranges := make(chan *kv.KeyRange, 100)
+	runner = rangetask.NewRangeTaskRunner("test-resume-key-runner", s.store, 4, func(ctx context.Context, r kv.KeyRange) (rangetask.TaskStat, error) {
+		ranges <- &r
+		return rangetask.TaskStat{CompletedRegions: 1}, nil
+	})
+	runner.SetRegionsPerTask(1)
+	s.Nil(runner.RunOnRangeFrom(context.Background(), subRanges[1].StartKey, r.StartKey, r.EndKey))
+	s.checkRanges(collect(ranges), subRanges[1:])
+	s.Equal(r.EndKey, runner.ResumeKey())
+
+	// the resume key isn't tracked for multiple ranges.
+	s.Nil(runner.RunOnRanges(context.Background(), []kv.KeyRange{r}))
+	s.Nil(runner.ResumeKey())
+}
+
+func (s *testRangeTaskSuite) TestRangeTaskRunOnRanges() {
+	ranges := []kv.KeyRange{
+		makeRange("a", "c"),
+		makeRange("b", "e"),
+		makeRange("", "b"),
+		makeRange("x", ""),
+		makeRange("m", "m\x00"),
+		makeRange("c1", "f"),
+		makeRange("a", "z"),
+		makeRange("k", "l"),
+		makeRange("d", "d\x01"),
+		makeRange("q", "t"),
+	}
+	for concurrency := 1; concurrency < 4; concurrency++ {
+		var (
+			mu      sync.Mutex
+			handled []kv.KeyRange
+		)
+		handler := func(ctx context.Context, r kv.KeyRange) (rangetask.TaskStat, error) {
+			mu.Lock()
+			handled = append(handled, r)
+			mu.Unlock()
+			return rangetask.TaskStat{CompletedRegions: 1}, nil
+		}
+		runner := rangetask.NewRangeTaskRunner("test-ranges-runner", s.store, concurrency, handler)
+		runner.SetRegionsPerTask(1)
+
+		// every region gets one task for each range covering it.
+		var expected []kv.KeyRange
+		for _, r := range ranges {
+			subRanges, err := runner.EnumerateRanges(context.Background(), r.StartKey, r.EndKey)
+			s.Nil(err)
+			expected = append(expected, subRanges...)
+		}
+		s.Nil(runner.RunOnRanges(context.Background(), ranges))
+		s.ElementsMatch(expected, handled)
+		s.Equal(len(expected), runner.CompletedRegions())
+
+		if concurrency == 1 {
+			// the first task of each range is dispatched before the second task of any range.
+			for i, r := range ranges {
+				s.Equal(r.StartKey, handled[i].StartKey)
+			}
+		}
+	}
+
+	runner := rangetask.NewRangeTaskRunner("test-ranges-runner", s.store, 1, func(ctx context.Context, r kv.KeyRange) (rangetask.TaskStat, error) {
+		s.Fail("the handler should not be called")
+		return rangetask.TaskStat{}, nil
+	})
+	s.Nil(runner.RunOnRanges(context.Background(), nil))
+	s.NotNil(runner.RunOnRanges(context.Background(), []kv.KeyRange{makeRange("a", "b"), makeRange("c", "c")}))
+	s.NotNil(runner.RunOnRanges(context.Background(), []kv.KeyRange{makeRange("d", "c")}))
+}
+
+func (s *testRangeTaskSuite) TestRangeTaskRegionEpoch() {
+	r := s.testRanges[3]
+	subRanges := s.expectedRanges[3]
+	var mu sync.Mutex
+	epochs := make(map[string]*metapb.RegionEpoch)
+	handler := func(ctx context.Context, r kv.KeyRange) (rangetask.TaskStat, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		epochs[string(r.StartKey)] = util.RegionEpochFromCtx(ctx)
+		return rangetask.TaskStat{CompletedRegions: 1}, nil
+	}
+	runner := rangetask.NewRangeTaskRunner("test-epoch-runner", s.store, 4, handler)
+	runner.SetRegionsPerTask(1)
+	s.Nil(runner.RunOnRange(context.Background(), r.StartKey, r.EndKey))
+
+	s.Len(epochs, len(subRanges))
+	for _, subRange := range subRanges {
+		region, _, _, _ := s.cluster.GetRegionByKey(subRange.StartKey)
+		s.Equal(region.GetRegionEpoch(), epochs[string(subRange.StartKey)])
+	}
+	s.Nil(util.RegionEpochFromCtx(context.Background()))
+}
+
+func (s *testRangeTaskSuite) TestRangeTaskProgressCallback() {
+	r := s.testRanges[3]
+	subRanges := s.expectedRanges[3]
+	handler := func(ctx context.Context, r kv.KeyRange) (rangetask.TaskStat, error) {
+		return rangetask.TaskStat{CompletedRegions: 1}, nil
+	}
+
+	runner := rangetask.NewRangeTaskRunner("test-progress-runner", s.store, 3, handler)
+	runner.SetRegionsPerTask(1)
+	var (
+		mu        sync.Mutex
+		completed int
+		lastKeys  []string
+	)
+	runner.SetProgressCallback(func(stat rangetask.TaskStat, lastKey []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		completed += stat.CompletedRegions
+		lastKeys = append(lastKeys, string(lastKey))
+	})
+	s.Nil(runner.RunOnRange(context.Background(), r.StartKey, r.EndKey))
+	s.Equal(len(subRanges), completed)
+	expectedKeys := make([]string, 0, len(subRanges))
+	for _, subRange := range subRanges {
+		expectedKeys = append(expectedKeys, string(subRange.EndKey))
+	}
+	s.ElementsMatch(expectedKeys, lastKeys)
+
+	// a nil callback is ignored.
+	runner.SetProgressCallback(nil)
+	s.Nil(runner.RunOnRange(context.Background(), r.StartKey, r.EndKey))
+
+	// the failed tasks are not reported.
+	failedKey := subRanges[1].StartKey
+	runner = rangetask.NewRangeTaskRunner("test-progress-runner", s.store, 3, func(ctx context.Context, r kv.KeyRange) (rangetask.TaskStat, error) {
+		if bytes.Equal(r.StartKey, failedKey) {
+			return rangetask.TaskStat{FailedRegions: 1}, errors.New("injected")
+		}
+		return rangetask.TaskStat{CompletedRegions: 1}, nil
+	})
+	runner.SetRegionsPerTask(1)
+	completed, lastKeys = 0, nil
+	runner.SetProgressCallback(func(stat rangetask.TaskStat, lastKey []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		s.Zero(stat.FailedRegions)
+		completed += stat.CompletedRegions
+		lastKeys = append(lastKeys, string(lastKey))
+	})
+	_, errs := runner.RunOnRangeBestEffort(context.Background(), r.StartKey, r.EndKey)
+	s.Len(errs, 1)
+	s.Equal(len(subRanges)-1, completed)
+	s.ElementsMatch(append(expectedKeys[:1:1], expectedKeys[2:]...), lastKeys)
+}
+
+func (s *testRangeTaskSuite) TestRangeTaskSlowLog() {
+	r := s.testRanges[3]
+	subRanges := s.expectedRanges[3]
+	slowKey := []byte("m")
+	handler := func(ctx context.Context, r kv.KeyRange) (rangetask.TaskStat, error) {
+		if bytes.Equal(r.StartKey, slowKey) {
+			time.Sleep(200 * time.Millisecond)
+		}
+		return rangetask.TaskStat{CompletedRegions: 1}, nil
+	}
+
+	var (
+		buf       bytes.Buffer
+		slowLogs  atomic.Int32
+		finishLog atomic.Int32
+	)
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.Lock(zapcore.AddSync(&buf)), zapcore.DebugLevel)
+	logger := zap.New(core, zap.Hooks(func(e zapcore.Entry) error {
+		switch e.Message {
+		case "range task handler is slow":
+			s.Equal(zapcore.WarnLevel, e.Level)
+			slowLogs.Add(1)
+		case "range task finished":
+			finishLog.Add(1)
+		}
+		return nil
+	}))
+	ctx := tikv.WithLogContext(context.Background(), logger)
+
+	runner := rangetask.NewRangeTaskRunner("test-slow-log-runner", s.store, 4, handler)
+	runner.SetRegionsPerTask(1)
+	// the slow log is disabled by default.
+	s.Nil(runner.RunOnRange(ctx, r.StartKey, r.EndKey))
+	s.Zero(slowLogs.Load())
+	s.Equal(int32(1), finishLog.Load())
+
+	buf.Reset()
+	runner.SetSlowTaskThreshold(100 * time.Millisecond)
+	s.Nil(runner.RunOnRange(ctx, r.StartKey, r.EndKey))
+	s.Equal(int32(1), slowLogs.Load())
+	s.Equal(len(subRanges), runner.CompletedRegions())
+
+	var slowLog, finished map[string]any
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var entry map[string]any
+		s.Require().Nil(json.Unmarshal(line, &entry))
+		switch entry["msg"] {
+		case "range task handler is slow":
+			slowLog = entry
+		case "range task finished":
+			finished = entry
+		}
+	}
+	s.Require().NotNil(slowLog)
+	s.Equal("test-slow-log-runner", slowLog["name"])
+	s.Equal("6d", slowLog["startKey"])
+	s.Equal("6e", slowLog["endKey"])
+	s.Equal(float64(1), slowLog["completed regions"])
+	s.Equal(float64(0), slowLog["failed regions"])
+	s.GreaterOrEqual(slowLog["cost time"], 0.2)
+	s.Require().NotNil(finished)
+	s.GreaterOrEqual(finished["max handle time"], 0.2)
+	s.Greater(finished["p99 handle time"], 0.0)
+	s.LessOrEqual(finished["p99 handle time"], finished["max handle time"])
+}
+
+func (s *testRangeTaskSuite) TestRangeTaskRateLimit() {
+	r := s.testRanges[0]
+	subRanges := s.expectedRanges[0]
+	handler := func(ctx context.Context, r kv.KeyRange) (rangetask.TaskStat, error) {
+		return rangetask.TaskStat{CompletedRegions: 1}, nil
+	}
+
+	const regionsPerSecond = 100
+	runner := rangetask.NewRangeTaskRunner("test-rate-limit-runner", s.store, 4, handler)
+	runner.SetRegionsPerTask(1)
+	// SetRateLimit and SetMaxRegionsPerSecond set the same limiter, the later one takes effect.
+	runner.SetMaxRegionsPerSecond(1)
+	runner.SetRateLimit(regionsPerSecond)
+	start := time.Now()
+	s.Nil(runner.RunOnRange(context.Background(), r.StartKey, r.EndKey))
+	s.Equal(len(subRanges), runner.CompletedRegions())
+	// the first task is allowed by the initial token.
+	minDuration := time.Duration(len(subRanges)-1) * time.Second / regionsPerSecond
+	s.GreaterOrEqual(time.Since(start), minDuration)
+	s.Less(time.Since(start), minDuration*3/2)
+
+	// the runner stops promptly when the context is canceled while waiting for tokens.
+	runner = rangetask.NewRangeTaskRunner("test-rate-limit-runner", s.store, 4, handler)
+	runner.SetRegionsPerTask(1)
+	runner.SetRateLimit(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	s.NotNil(runner.RunOnRange(ctx, r.StartKey, r.EndKey))
+	s.Less(time.Since(start), time.Second)
+	s.Less(runner.CompletedRegions(), len(subRanges))
+}
+
+func (s *testRangeTaskSuite) TestRangeTaskMaxRegionsPerSecond() {
+	r := s.testRanges[0]
+	subRanges := s.expectedRanges[0]
+	// each task reports more regions than loaded, which are reconciled after the handler returns.
+	const regionsPerTask = 10
+	handler := func(ctx context.Context, r kv.KeyRange) (rangetask.TaskStat, error) {
+		return rangetask.TaskStat{CompletedRegions: regionsPerTask}, nil
+	}
+
+	const regionsPerSecond = 200
+	runner := rangetask.NewRangeTaskRunner("test-max-regions-per-second-runner", s.store, 4, handler)
+	runner.SetRegionsPerTask(1)
+	runner.SetMaxRegionsPerSecond(regionsPerSecond)
+	start := time.Now()
+	s.Nil(runner.RunOnRange(context.Background(), r.StartKey, r.EndKey))
+	elapsed := time.Since(start)
+	s.Equal(len(subRanges)*regionsPerTask, runner.CompletedRegions())
+	// the regions of the last task of each worker are reserved after it's handled, so they don't delay the run.
+	expected := time.Duration((len(subRanges)-4)*regionsPerTask) * time.Second / regionsPerSecond
+	s.GreaterOrEqual(elapsed, expected*9/10)
+	s.Less(elapsed, expected*3/2)
+
+	// the limit can be lifted while running, which wakes up the workers waiting for tokens.
+	var tasks atomic.Int32
+	runner = rangetask.NewRangeTaskRunner("test-max-regions-per-second-runner", s.store, 4,
+		func(ctx context.Context, r kv.KeyRange) (rangetask.TaskStat, error) {
+			if tasks.Add(1) == 1 {
+				runner.SetMaxRegionsPerSecond(0)
+			}
+			return rangetask.TaskStat{CompletedRegions: 1}, nil
+		})
+	runner.SetRegionsPerTask(1)
+	runner.SetMaxRegionsPerSecond(1)
+	start = time.Now()
+	s.Nil(runner.RunOnRange(context.Background(), r.StartKey, r.EndKey))
+	s.Equal(len(subRanges), runner.CompletedRegions())
+	s.Less(time.Since(start), time.Second)
+
+	// the workers stop promptly when the context is canceled while waiting for tokens.
+	runner = rangetask.NewRangeTaskRunner("test-max-regions-per-second-runner", s.store, 4, handler)
+	runner.SetRegionsPerTask(1)
+	runner.SetMaxRegionsPerSecond(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	s.NotNil(runner.RunOnRange(ctx, r.StartKey, r.EndKey))
+	s.Less(time.Since(start), time.Second)
+	s.Less(runner.CompletedRegions(), len(subRanges)*regionsPerTask)
+}
+
+func (s *testRangeTaskSuite) TestRangeTaskQuiesce() {
+	r := s.testRanges[0]
+	subRanges := s.expectedRanges[0]
+	const concurrency = 2
+
+	var (
+		runner   *rangetask.Runner
+		once     sync.Once
+		mu       sync.Mutex
+		started  int
+		finished []kv.KeyRange
+	)
+	handler := func(ctx context.Context, r kv.KeyRange) (rangetask.TaskStat, error) {
+		mu.Lock()
+		started++
+		mu.Unlock()
+		once.Do(runner.Quiesce)
+		// the enqueued tasks are not abandoned.
+		time.Sleep(20 * time.Millisecond)
+		s.Nil(ctx.Err())
+		mu.Lock()
+		finished = append(finished, r)
+		mu.Unlock()
+		return rangetask.TaskStat{CompletedRegions: 1}, nil
+	}
+	runner = rangetask.NewRangeTaskRunner("test-quiesce-runner", s.store, concurrency, handler)
+	runner.SetRegionsPerTask(1)
+	s.Nil(runner.RunOnRange(context.Background(), r.StartKey, r.EndKey))
+
+	mu.Lock()
+	defer mu.Unlock()
+	s.Equal(started, len(finished))
+	s.Equal(len(finished), runner.CompletedRegions())
+	// no more tasks than the busy workers, the buffered channel and the one being pushed are run.
+	s.LessOrEqual(len(finished), 2*concurrency+1)
+	s.Less(len(finished), len(subRanges))
+	// the finished tasks are the leading ranges, nothing after them is loaded and sent.
+	sort.Slice(finished, func(i, j int) bool {
+		return bytes.Compare(finished[i].StartKey, finished[j].StartKey) < 0
+	})
+	s.Equal(subRanges[:len(finished)], finished)
+
+	// a quiesced runner doesn't run any task.
+	s.Nil(runner.RunOnRange(context.Background(), r.StartKey, r.EndKey))
+	s.Equal(0, runner.CompletedRegions())
+}
+
+func (s *testRangeTaskSuite) TestGroupKeysByRegion() {
+	keys := [][]byte{
+		[]byte("\x00"), []byte("a"), []byte("a1"), []byte("b"), []byte("a\xff"), []byte("y1"), []byte("z"), []byte("zz"),
+	}
+	expected := map[string][][]byte{
+		"":  {[]byte("\x00")},
+		"a": {[]byte("a"), []byte("a1"), []byte("a\xff")},
+		"b": {[]byte("b")},
+		"y": {[]byte("y1")},
+		"z": {[]byte("z"), []byte("zz")},
+	}
+
+	// The region cache is empty, the regions should be loaded on demand.
+	groups, err := rangetask.GroupKeysByRegion(context.Background(), s.store, keys)
+	s.Require().Nil(err)
+	s.Len(groups, len(expected))
+	for startKey, expectedKeys := range expected {
+		loc, err := s.store.GetRegionCache().LocateKey(tikv.NewNoopBackoff(context.Background()), []byte(startKey))
+		s.Require().Nil(err)
+		s.Equal(expectedKeys, groups[loc.Region.GetID()], "region starts at %q", startKey)
+	}
+
+	groups, err = rangetask.GroupKeysByRegion(context.Background(), s.store, nil)
+	s.Nil(err)
+	s.Empty(groups)
+}
@@ -37,14 +37,19 @@ package tikv_test
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/suite"
-	"github.com/tikv/client-go/v2/error"
+	tikverr "github.com/tikv/client-go/v2/error"
 	"github.com/tikv/client-go/v2/tikv"
+	"github.com/tikv/client-go/v2/tikvrpc"
+	"github.com/tikv/client-go/v2/tikvrpc/interceptor"
 	"github.com/tikv/client-go/v2/txnkv/transaction"
 )
 
@@ -117,7 +122,7 @@ func (s *testSafePointSuite) TestSafePoint() {
 	_, geterr2 := txn2.Get(context.TODO(), encodeKey(s.prefix, s08d("key", 0)))
 	s.NotNil(geterr2)
 
-	_, isFallBehind := errors.Cause(geterr2).(*error.ErrGCTooEarly)
+	_, isFallBehind := errors.Cause(geterr2).(*tikverr.ErrGCTooEarly)
 	isMayFallBehind := strings.Contains(geterr2.Error(), "start timestamp may fall behind safe point")
 	isBehind := isFallBehind || isMayFallBehind
 	s.True(isBehind)
@@ -129,7 +134,7 @@ func (s *testSafePointSuite) TestSafePoint() {
 
 	_, seekerr := txn3.Iter(encodeKey(s.prefix, ""), nil)
 	s.NotNil(seekerr)
-	_, isFallBehind = errors.Cause(geterr2).(*error.ErrGCTooEarly)
+	_, isFallBehind = errors.Cause(geterr2).(*tikverr.ErrGCTooEarly)
 	isMayFallBehind = strings.Contains(geterr2.Error(), "start timestamp may fall behind safe point")
 	isBehind = isFallBehind || isMayFallBehind
 	s.True(isBehind)
@@ -142,8 +147,173 @@ func (s *testSafePointSuite) TestSafePoint() {
 
 	_, batchgeterr := toTiDBTxn(&txn4).BatchGet(context.Background(), toTiDBKeys(keys))
 	s.NotNil(batchgeterr)
-	_, isFallBehind = errors.Cause(geterr2).(*error.ErrGCTooEarly)
+	_, isFallBehind = errors.Cause(geterr2).(*tikverr.ErrGCTooEarly)
 	isMayFallBehind = strings.Contains(geterr2.Error(), "start timestamp may fall behind safe point")
 	isBehind = isFallBehind || isMayFallBehind
 	s.True(isBehind)
 }
+
+func (s *testSafePointSuite) TestAllowReadBeyondSafePoint() {
+	txn := s.beginTxn()
+	for i := 0; i < 10; i++ {
+		err := txn.Set(encodeKey(s.prefix, s08d("best_effort", i)), valueBytes(i))
+		s.Nil(err)
+	}
+	err := txn.Commit(context.Background())
+	s.Nil(err)
+
+	txn2 := s.beginTxn()
+	txn2.SetAllowReadBeyondSafePoint(true)
+	s.True(txn2.GetSnapshot().IsBestEffort())
+	s.waitUntilErrorPlugIn(txn2.StartTS())
+
+	// reads skip the safe point validation.
+	val, err := txn2.Get(context.TODO(), encodeKey(s.prefix, s08d("best_effort", 0)))
+	s.Nil(err)
+	s.Equal(valueBytes(0), val)
+	it, err := txn2.Iter(encodeKey(s.prefix, s08d("best_effort", 0)), encodeKey(s.prefix, s08d("best_effort", 9)))
+	s.Nil(err)
+	cnt := 0
+	for it.Valid() {
+		cnt++
+		s.Nil(it.Next())
+	}
+	it.Close()
+	s.Equal(9, cnt)
+
+	// writes are forbidden.
+	s.True(errors.Is(txn2.Set(encodeKey(s.prefix, s08d("best_effort", 0)), valueBytes(1)), tikverr.ErrWriteInBestEffortTxn))
+	s.True(errors.Is(txn2.Delete(encodeKey(s.prefix, s08d("best_effort", 0))), tikverr.ErrWriteInBestEffortTxn))
+	s.Nil(txn2.GetMemBuffer().Set(encodeKey(s.prefix, s08d("best_effort", 0)), valueBytes(1)))
+	s.True(errors.Is(txn2.Commit(context.Background()), tikverr.ErrWriteInBestEffortTxn))
+
+	// a read-only best-effort transaction can be committed.
+	txn3 := s.beginTxn()
+	txn3.SetAllowReadBeyondSafePoint(true)
+	s.waitUntilErrorPlugIn(txn3.StartTS())
+	_, err = txn3.Get(context.TODO(), encodeKey(s.prefix, s08d("best_effort", 1)))
+	s.Nil(err)
+	s.Nil(txn3.Commit(context.Background()))
+
+	// the default transaction still checks the safe point.
+	txn4 := s.beginTxn()
+	s.False(txn4.GetSnapshot().IsBestEffort())
+	s.waitUntilErrorPlugIn(txn4.StartTS())
+	_, err = txn4.Get(context.TODO(), encodeKey(s.prefix, s08d("best_effort", 2)))
+	s.NotNil(err)
+}
+
+func (s *testSafePointSuite) TestSnapshotLostToGC() {
+	txn := s.beginTxn()
+	for i := 0; i < 3; i++ {
+		s.Nil(txn.Set(encodeKey(s.prefix, s08d("lost_to_gc", i)), valueBytes(i)))
+	}
+	s.Nil(txn.Commit(context.Background()))
+
+	txn2 := s.beginTxn()
+	txn2.SetAllowReadBeyondSafePoint(true)
+	var abort string
+	txn2.SetRPCInterceptor(interceptor.NewRPCInterceptor("inject-key-error", func(next interceptor.RPCInterceptorFunc) interceptor.RPCInterceptorFunc {
+		return func(target string, req *tikvrpc.Request) (*tikvrpc.Response, error) {
+			if req.Type == tikvrpc.CmdGet && abort != "" {
+				return &tikvrpc.Response{Resp: &kvrpcpb.GetResponse{Error: &kvrpcpb.KeyError{Abort: abort}}}, nil
+			}
+			return next(target, req)
+		}
+	}))
+	s.waitUntilErrorPlugIn(txn2.StartTS())
+
+	// the value of a committed version is gone.
+	abort = "default not found: key:lost_to_gc, maybe read truncated/dropped table data?"
+	_, err := txn2.Get(context.Background(), encodeKey(s.prefix, s08d("lost_to_gc", 0)))
+	s.True(tikverr.IsErrSnapshotLostToGC(err))
+
+	// errors unrelated to GC are returned unchanged.
+	abort = "some other error"
+	_, err = txn2.Get(context.Background(), encodeKey(s.prefix, s08d("lost_to_gc", 1)))
+	s.NotNil(err)
+	s.False(tikverr.IsErrSnapshotLostToGC(err))
+	s.Contains(err.Error(), "some other error")
+
+	abort = ""
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = txn2.Get(ctx, encodeKey(s.prefix, s08d("lost_to_gc", 2)))
+	s.NotNil(err)
+	s.False(tikverr.IsErrSnapshotLostToGC(err))
+	s.True(errors.Is(err, context.Canceled))
+	s.Nil(txn2.Rollback())
+}
+
+func (s *testSafePointSuite) TestListAndRemoveSafePoints() {
+	ctx := context.Background()
+	spkv := tikv.NewMockSafePointKV()
+	s.Nil(spkv.Put(tikv.GcSavedSafePoint, "10"))
+	s.Nil(spkv.Put(tikv.GcSafePointPrefix+"br", `{"service_id":"br-1","expired_at":1700000000,"safe_point":50}`))
+	s.Nil(spkv.Put(tikv.GcSafePointPrefix+"cdc", "200"))
+	s.Nil(spkv.Put(tikv.GcSafePointPrefix+"broken", "not a safe point"))
+	s.Nil(spkv.Put("/other/key", "1"))
+
+	entries, err := tikv.ListSafePoints(ctx, spkv)
+	s.Nil(err)
+	s.Equal([]tikv.SafePointEntry{
+		{Key: tikv.GcSafePointPrefix + "br", ServiceID: "br-1", SafePoint: 50, ExpiredAt: time.Unix(1700000000, 0),
+			Value: `{"service_id":"br-1","expired_at":1700000000,"safe_point":50}`},
+		{Key: tikv.GcSafePointPrefix + "broken", ServiceID: "broken", Value: "not a safe point"},
+		{Key: tikv.GcSafePointPrefix + "cdc", ServiceID: "cdc", SafePoint: 200, Value: "200"},
+	}, entries)
+
+	// the safe point of the GC worker is neither listed nor removed, and it doesn't count as the minimum.
+	s.NotNil(tikv.RemoveSafePoint(ctx, spkv, tikv.GcSavedSafePoint, false))
+	s.NotNil(tikv.RemoveSafePoint(ctx, spkv, tikv.GcSavedSafePoint, true))
+	v, err := spkv.Get(tikv.GcSavedSafePoint)
+	s.Nil(err)
+	s.Equal("10", v)
+
+	// the minimum safe point is removed only with force.
+	s.NotNil(tikv.RemoveSafePoint(ctx, spkv, tikv.GcSafePointPrefix+"br", false))
+	s.Nil(tikv.RemoveSafePoint(ctx, spkv, tikv.GcSafePointPrefix+"cdc", false))
+	s.Nil(tikv.RemoveSafePoint(ctx, spkv, tikv.GcSafePointPrefix+"broken", false))
+	s.Nil(tikv.RemoveSafePoint(ctx, spkv, tikv.GcSafePointPrefix+"br", true))
+	s.NotNil(tikv.RemoveSafePoint(ctx, spkv, tikv.GcSafePointPrefix+"br", true))
+
+	entries, err = tikv.ListSafePoints(ctx, spkv)
+	s.Nil(err)
+	s.Empty(entries)
+	v, err = spkv.Get(tikv.GcSavedSafePoint)
+	s.Nil(err)
+	s.Equal("10", v)
+	v, err = spkv.Get("/other/key")
+	s.Nil(err)
+	s.Equal("1", v)
+}
+
+func (s *testSafePointSuite) TestPDHTTPSafePointKV() {
+	_, err := tikv.NewPDHTTPSafePointKV(nil, nil)
+	s.NotNil(err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Equal("/pd/api/v1/gc/safepoint", r.URL.Path)
+		fmt.Fprint(w, `{"service_gc_safe_points":[{"service_id":"br-1","expired_at":1700000000,"safe_point":50}],"gc_safe_point":100}`)
+	}))
+	defer server.Close()
+	// the unreachable address is skipped.
+	spkv, err := tikv.NewPDHTTPSafePointKV([]string{"http://127.0.0.1:0", strings.TrimPrefix(server.URL, "http://")}, nil)
+	s.Nil(err)
+	defer spkv.Close()
+
+	v, err := spkv.Get(tikv.GcSavedSafePoint)
+	s.Nil(err)
+	s.Equal("100", v)
+	v, err = spkv.Get("/other/key")
+	s.Nil(err)
+	s.Empty(v)
+	s.NotNil(spkv.Put(tikv.GcSavedSafePoint, "200"))
+
+	entries, err := tikv.ListSafePoints(context.Background(), spkv)
+	s.Nil(err)
+	s.Equal([]tikv.SafePointEntry{
+		{Key: tikv.GcSafePointPrefix + "br-1", ServiceID: "br-1", SafePoint: 50, ExpiredAt: time.Unix(1700000000, 0),
+			Value: `{"service_id":"br-1","expired_at":1700000000,"safe_point":50}`},
+	}, entries)
+}
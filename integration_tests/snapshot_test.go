@@ -45,9 +45,12 @@ import (
 	"github.com/pingcap/failpoint"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/kv"
 	"github.com/tikv/client-go/v2/oracle"
+	"github.com/tikv/client-go/v2/testutils"
 	"github.com/tikv/client-go/v2/tikv"
 	"github.com/tikv/client-go/v2/tikvrpc"
 	"github.com/tikv/client-go/v2/txnkv"
@@ -347,6 +350,30 @@ func (s *testSnapshotSuite) TestSnapshotRuntimeStats() {
 		"scan_detail: {total_process_keys: 20, total_process_keys_size: 20, total_keys: 30, get_snapshot_time: 1µs, " +
 		"rocksdb: {delete_skipped_count: 10, key_skipped_count: 2, block: {cache_hit_count: 20, read_count: 40, read_byte: 30 Bytes}}}"
 	s.Equal(expect, snapshot.FormatStats())
+
+	execDetails := runtimeStats.GetExecDetails()
+	s.Equal(int64(20), execDetails.ScanDetail.ProcessedKeys)
+	s.Equal(uint64(20), execDetails.ScanDetail.RocksdbBlockCacheHitCount)
+	s.Equal(200*time.Millisecond, execDetails.TimeDetail.WaitTime)
+	snapshot.MergeExecDetail(&kvrpcpb.ExecDetailsV2{
+		TimeDetailV2: &kvrpcpb.TimeDetailV2{
+			ProcessSuspendWallTimeNs: uint64(time.Millisecond),
+		},
+		ScanDetailV2: &kvrpcpb.ScanDetailV2{
+			ReadPoolScheduleWaitNanos: uint64(time.Millisecond),
+		},
+	})
+	// the returned details are not affected by later requests.
+	s.Equal(time.Duration(0), execDetails.ScanDetail.ReadPoolScheduleWaitDuration)
+	execDetails = runtimeStats.GetExecDetails()
+	s.Equal(time.Millisecond, execDetails.ScanDetail.ReadPoolScheduleWaitDuration)
+	s.Equal(time.Millisecond, execDetails.TimeDetail.SuspendTime)
+
+	// the exec details are aggregated when merging the stats of snapshots in one transaction.
+	txnStats := runtimeStats.Clone()
+	txnStats.Merge(runtimeStats)
+	s.Equal(int64(40), txnStats.GetExecDetails().ScanDetail.ProcessedKeys)
+	s.Equal(int64(20), runtimeStats.GetExecDetails().ScanDetail.ProcessedKeys)
 }
 
 func (s *testSnapshotSuite) TestRCRead() {
@@ -420,3 +447,96 @@ func (s *testSnapshotSuite) TestSnapshotCacheBypassMaxUint64() {
 	snapshot.BatchGet(context.Background(), [][]byte{[]byte("y"), []byte("z")})
 	s.Empty(snapshot.SnapCache())
 }
+
+func TestSnapshotAllowEmptyValues(t *testing.T) {
+	client, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+	require.Nil(t, err)
+	testutils.BootstrapWithSingleStore(cluster)
+	kvStore, err := tikv.NewTestTiKVStore(client, pdClient, nil, nil, 0, tikv.WithAllowEmptyValues(true))
+	require.Nil(t, err)
+	store := tikv.StoreProbe{KVStore: kvStore}
+	defer store.Close()
+	ctx := context.Background()
+
+	// An empty value can't be written through the MemBuffer, so prewrite the mutation directly. Only the primary
+	// key is committed, the lock of the empty value is resolved by the reads.
+	empty, filled, missing := []byte("k_empty"), []byte("k_filled"), []byte("k_missing")
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.Set(filled, []byte("v")))
+	committer, err := txn.NewCommitter(1)
+	require.Nil(t, err)
+	muts := transaction.NewPlainMutations(2)
+	muts.Push(kvrpcpb.Op_Put, empty, []byte{}, false, false, false, false)
+	muts.Push(kvrpcpb.Op_Put, filled, []byte("v"), false, false, false, false)
+	require.Nil(t, committer.PrewriteMutations(ctx, &muts))
+	commitTS, err := store.GetOracle().GetTimestamp(ctx, &oracle.Option{TxnScope: oracle.GlobalTxnScope})
+	require.Nil(t, err)
+	committer.SetCommitTS(commitTS)
+	require.Nil(t, committer.CommitMutations(ctx))
+
+	// The default behavior reads the empty value as a nonexistent key.
+	txn, err = store.Begin()
+	require.Nil(t, err)
+	txn.SetAllowEmptyValues(false)
+	_, err = txn.Get(ctx, empty)
+	require.True(t, error.IsErrNotFound(err))
+	m, err := txn.BatchGet(ctx, [][]byte{empty, filled, missing})
+	require.Nil(t, err)
+	require.Equal(t, map[string][]byte{string(filled): []byte("v")}, m)
+
+	txn, err = store.Begin()
+	require.Nil(t, err)
+	val, err := txn.Get(ctx, empty)
+	require.Nil(t, err)
+	require.NotNil(t, val)
+	require.Empty(t, val)
+	_, err = txn.Get(ctx, missing)
+	require.True(t, error.IsErrNotFound(err))
+	// Read again to hit the snapshot cache.
+	val, err = txn.Get(ctx, empty)
+	require.Nil(t, err)
+	require.NotNil(t, val)
+	_, err = txn.Get(ctx, missing)
+	require.True(t, error.IsErrNotFound(err))
+
+	snapshot := store.GetSnapshot(math.MaxUint64)
+	m, err = snapshot.BatchGet(ctx, [][]byte{empty, filled, missing})
+	require.Nil(t, err)
+	require.Equal(t, map[string][]byte{string(empty): {}, string(filled): []byte("v")}, m)
+	m, err = txn.BatchGet(ctx, [][]byte{empty, filled, missing})
+	require.Nil(t, err)
+	require.Equal(t, map[string][]byte{string(empty): {}, string(filled): []byte("v")}, m)
+
+	// Scans return the key with the empty value.
+	it, err := txn.Iter([]byte("k_"), []byte("k_z"))
+	require.Nil(t, err)
+	var keys []string
+	for it.Valid() {
+		keys = append(keys, string(it.Key()))
+		require.Nil(t, it.Next())
+	}
+	it.Close()
+	require.Equal(t, []string{string(empty), string(filled)}, keys)
+
+	// A delete in the MemBuffer still hides the empty value.
+	require.Nil(t, txn.Delete(empty))
+	_, err = txn.Get(ctx, empty)
+	require.True(t, error.IsErrNotFound(err))
+	require.Nil(t, txn.Rollback())
+
+	// The key with the empty value exists, so the presumed-not-exist insert fails.
+	txn, err = store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.GetMemBuffer().SetWithFlags(empty, []byte("v"), kv.SetPresumeKeyNotExists))
+	err = txn.Commit(ctx)
+	require.NotNil(t, err)
+	_, ok := errors.Cause(err).(*error.ErrKeyExist)
+	require.True(t, ok, "%v", err)
+
+	// Writing an empty value is still rejected.
+	txn, err = store.Begin()
+	require.Nil(t, err)
+	require.NotNil(t, txn.Set(empty, []byte{}))
+	require.Nil(t, txn.Rollback())
+}
@@ -42,9 +42,13 @@ import (
 
 	"github.com/pingcap/failpoint"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+	tikverr "github.com/tikv/client-go/v2/error"
 	"github.com/tikv/client-go/v2/oracle"
 	"github.com/tikv/client-go/v2/oracle/oracles"
+	"github.com/tikv/client-go/v2/testutils"
 	"github.com/tikv/client-go/v2/tikv"
 	"github.com/tikv/client-go/v2/tikvrpc"
 	"github.com/tikv/client-go/v2/txnkv"
@@ -111,6 +115,28 @@ func (s *testStoreSuite) TestOracle() {
 	wg.Wait()
 }
 
+func (s *testStoreSuite) TestOracleInjectError() {
+	s.store.GetOracle().Close()
+	o := oracles.NewMockOracle()
+	s.store.SetOracle(o)
+
+	ctx := context.Background()
+	t1, err := s.store.GetTimestampWithRetry(tikv.NewBackofferWithVars(ctx, 100, nil), oracle.GlobalTxnScope)
+	s.Nil(err)
+
+	// Check retry.
+	o.InjectErrorTimes(errors.New("mock tso error"), 1)
+	t2, err := s.store.GetTimestampWithRetry(tikv.NewBackofferWithVars(ctx, 5000, nil), oracle.GlobalTxnScope)
+	s.Nil(err)
+	s.Less(t1, t2)
+
+	// Check expiry with the manual clock.
+	o.SetTS(t2)
+	s.False(s.store.GetOracle().IsExpired(t2, 50, &oracle.Option{}))
+	o.AdvanceTS(100 * time.Millisecond)
+	s.True(s.store.GetOracle().IsExpired(t2, 50, &oracle.Option{}))
+}
+
 type checkRequestClient struct {
 	tikv.Client
 	priority kvrpcpb.CommandPri
@@ -133,6 +159,27 @@ func (c *checkRequestClient) SendRequest(ctx context.Context, addr string, req *
 	return resp, err
 }
 
+func (s *testStoreSuite) TestMockOracleOption() {
+	client, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+	s.Require().Nil(err)
+	testutils.BootstrapWithSingleStore(cluster)
+	o := oracles.NewMockOracle()
+	store, err := tikv.NewTestTiKVStore(client, pdClient, nil, nil, 0, tikv.WithOracle(o))
+	s.Require().Nil(err)
+	defer store.Close()
+	s.Same(o, store.GetOracle())
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	o.SetTS(oracle.GoTimeToTS(now))
+	ts, err := store.CurrentTimestamp(oracle.GlobalTxnScope)
+	s.Nil(err)
+	s.Equal(oracle.GoTimeToTS(now), ts)
+	o.AdvanceTS(10 * time.Second)
+	staleTS, err := store.GetOracle().GetStaleTimestamp(context.Background(), oracle.GlobalTxnScope, 5)
+	s.Nil(err)
+	s.Equal(oracle.GoTimeToTS(now.Add(5*time.Second)), staleTS)
+}
+
 func (s *testStoreSuite) TestRequestPriority() {
 	client := &checkRequestClient{
 		Client: s.store.GetTiKVClient(),
@@ -192,3 +239,78 @@ func (s *testStoreSuite) TestFailBusyServerKV() {
 	s.Nil(err)
 	s.Equal(val, []byte("value"))
 }
+
+func TestClosedStore(t *testing.T) {
+	client, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+	require.Nil(t, err)
+	testutils.BootstrapWithSingleStore(cluster)
+	store, err := tikv.NewTestTiKVStore(client, pdClient, nil, nil, 0)
+	require.Nil(t, err)
+
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.Set([]byte("k"), []byte("v")))
+	require.Nil(t, txn.Commit(context.Background()))
+	ts, err := store.CurrentTimestamp(oracle.GlobalTxnScope)
+	require.Nil(t, err)
+
+	// the operations in flight when the store is closed either complete or fail with ErrClientClosed.
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			for {
+				if i%2 == 0 {
+					_, err = store.GetSnapshot(ts).Get(context.Background(), []byte("k"))
+				} else {
+					err = func() error {
+						txn, err := store.Begin()
+						if err != nil {
+							return err
+						}
+						if err = txn.Set([]byte{'k', byte(i)}, []byte("v")); err != nil {
+							return err
+						}
+						return txn.Commit(context.Background())
+					}()
+				}
+				if err != nil {
+					errs[i] = err
+					return
+				}
+			}
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	require.Nil(t, store.Close())
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "operations are not finished after the store is closed")
+	}
+	for _, err := range errs {
+		require.True(t, errors.Is(err, tikverr.ErrClientClosed) || tikverr.IsErrorUndetermined(err), "%+v", err)
+	}
+
+	// the operations after the store is closed fail with ErrClientClosed.
+	_, err = store.Begin()
+	require.True(t, errors.Is(err, tikverr.ErrClientClosed))
+	snapshot := store.GetSnapshot(ts)
+	_, err = snapshot.Get(context.Background(), []byte("k"))
+	require.True(t, errors.Is(err, tikverr.ErrClientClosed))
+	_, err = snapshot.BatchGet(context.Background(), [][]byte{[]byte("k")})
+	require.True(t, errors.Is(err, tikverr.ErrClientClosed))
+	_, err = snapshot.Iter(nil, nil)
+	require.True(t, errors.Is(err, tikverr.ErrClientClosed))
+	_, err = store.SendReq(tikv.NewBackofferWithVars(context.Background(), 100, nil),
+		tikvrpc.NewRequest(tikvrpc.CmdGet, &kvrpcpb.GetRequest{Key: []byte("k"), Version: ts}), tikv.RegionVerID{}, time.Second)
+	require.True(t, errors.Is(err, tikverr.ErrClientClosed))
+}
@@ -0,0 +1,605 @@
+// Copyright 2024 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/keyspacepb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/oracle"
+	"github.com/tikv/client-go/v2/oracle/oracles"
+	"github.com/tikv/client-go/v2/testutils"
+	"github.com/tikv/client-go/v2/tikv"
+	"github.com/tikv/client-go/v2/txnkv"
+	"github.com/tikv/client-go/v2/txnkv/transaction"
+	pd "github.com/tikv/pd/client"
+)
+
+type closeCountingPDClient struct {
+	pd.Client
+	closed atomic.Int32
+}
+
+func (c *closeCountingPDClient) Close() {
+	c.closed.Add(1)
+	c.Client.Close()
+}
+
+func TestNewClientWithPD(t *testing.T) {
+	mockClient, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+	require.Nil(t, err)
+	defer mockClient.Close()
+	testutils.BootstrapWithSingleStore(cluster)
+
+	// the caller-owned pd client is not closed along with the client.
+	pdCli := &closeCountingPDClient{Client: pdClient}
+	spkv := tikv.NewMockSafePointKV()
+	client, err := txnkv.NewClientWithPD(pdCli, txnkv.WithSafePointKV(spkv))
+	require.Nil(t, err)
+	require.Same(t, spkv, client.GetSafePointKV())
+	ts, err := client.GetTimestamp(context.Background())
+	require.Nil(t, err)
+	require.NotZero(t, ts)
+	require.Nil(t, client.Close())
+	require.Equal(t, int32(0), pdCli.closed.Load())
+
+	// the safe point kv defaults to an in-memory one, and the owned pd client is closed.
+	client, err = txnkv.NewClientWithPD(pdCli, txnkv.WithOwnedPDClient())
+	require.Nil(t, err)
+	require.IsType(t, &tikv.MockSafePointKV{}, client.GetSafePointKV())
+	require.Nil(t, client.Close())
+	require.Equal(t, int32(1), pdCli.closed.Load())
+}
+
+type discoveryPDClient struct {
+	pd.Client
+	clusterID uint64
+	urls      []string
+}
+
+func (c *discoveryPDClient) GetClusterID(context.Context) uint64 {
+	return c.clusterID
+}
+
+func (c *discoveryPDClient) GetServiceDiscovery() pd.ServiceDiscovery {
+	return urlsServiceDiscovery{urls: c.urls}
+}
+
+type urlsServiceDiscovery struct {
+	pd.ServiceDiscovery
+	urls []string
+}
+
+func (d urlsServiceDiscovery) GetServiceURLs() []string {
+	return d.urls
+}
+
+func TestNewClientWithPDClient(t *testing.T) {
+	mockClient, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+	require.Nil(t, err)
+	defer mockClient.Close()
+	testutils.BootstrapWithSingleStore(cluster)
+	pdAddrs := []string{"127.0.0.1:2379", "127.0.0.2:2379"}
+
+	_, err = txnkv.NewClient(pdAddrs, txnkv.WithPDClient(nil))
+	require.NotNil(t, err)
+
+	// the supplied pd client is used instead of dialing pdAddrs, and it's not closed along with the client.
+	pdCli := &closeCountingPDClient{Client: pdClient}
+	spkv := tikv.NewMockSafePointKV()
+	client, err := txnkv.NewClient(pdAddrs, txnkv.WithPDClient(pdCli), txnkv.WithSafePointKV(spkv))
+	require.Nil(t, err)
+	ts, err := client.GetTimestamp(context.Background())
+	require.Nil(t, err)
+	require.NotZero(t, ts)
+	require.Nil(t, client.Close())
+	require.Equal(t, int32(0), pdCli.closed.Load())
+
+	client, err = txnkv.NewClient(pdAddrs, txnkv.WithPDClient(pdCli), txnkv.WithSafePointKV(spkv), txnkv.WithOwnedPDClient())
+	require.Nil(t, err)
+	require.Nil(t, client.Close())
+	require.Equal(t, int32(1), pdCli.closed.Load())
+
+	// the pd client must be connected to the cluster served by pdAddrs.
+	_, err = txnkv.NewClient(pdAddrs, txnkv.WithPDClient(&discoveryPDClient{Client: pdClient}), txnkv.WithSafePointKV(spkv))
+	require.ErrorContains(t, err, "not connected to any cluster")
+	_, err = txnkv.NewClient(pdAddrs, txnkv.WithPDClient(&discoveryPDClient{
+		Client:    pdClient,
+		clusterID: 1,
+		urls:      []string{"http://127.0.0.3:2379"},
+	}), txnkv.WithSafePointKV(spkv))
+	require.ErrorContains(t, err, "doesn't match the pd addresses")
+	client, err = txnkv.NewClient(pdAddrs, txnkv.WithPDClient(&discoveryPDClient{
+		Client:    pdClient,
+		clusterID: 1,
+		urls:      []string{"http://127.0.0.3:2379", "http://127.0.0.2:2379"},
+	}), txnkv.WithSafePointKV(spkv))
+	require.Nil(t, err)
+	require.Nil(t, client.Close())
+}
+
+type tsoCountingPDClient struct {
+	pd.Client
+	tsoCalls atomic.Int32
+}
+
+func (c *tsoCountingPDClient) GetTS(ctx context.Context) (int64, int64, error) {
+	c.tsoCalls.Add(1)
+	return c.Client.GetTS(ctx)
+}
+
+func (c *tsoCountingPDClient) GetTSAsync(ctx context.Context) pd.TSFuture {
+	c.tsoCalls.Add(1)
+	return c.Client.GetTSAsync(ctx)
+}
+
+func TestClientWithOracle(t *testing.T) {
+	mockClient, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+	require.Nil(t, err)
+	defer mockClient.Close()
+	testutils.BootstrapWithSingleStore(cluster)
+	pdCli := &tsoCountingPDClient{Client: pdClient}
+
+	_, err = txnkv.NewClientWithPD(pdCli, txnkv.WithOracle(nil))
+	require.NotNil(t, err)
+
+	o := oracles.NewMockOracle()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	o.SetTS(oracle.GoTimeToTS(now))
+	client, err := txnkv.NewClientWithPD(pdCli, txnkv.WithOracle(o))
+	require.Nil(t, err)
+	require.Same(t, o, client.GetOracle())
+	ts, err := client.GetTimestamp(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, oracle.GoTimeToTS(now), ts)
+	o.SetTS(oracle.GoTimeToTS(now.Add(time.Second)))
+	ts, err = client.GetTimestamp(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, oracle.GoTimeToTS(now.Add(time.Second)), ts)
+	require.Nil(t, client.Close())
+	require.Equal(t, int32(0), pdCli.tsoCalls.Load())
+}
+
+func TestClientGetTimestampWithOptions(t *testing.T) {
+	mockClient, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+	require.Nil(t, err)
+	defer mockClient.Close()
+	testutils.BootstrapWithSingleStore(cluster)
+	o := oracles.NewMockOracle()
+	client, err := txnkv.NewClientWithPD(pdClient, txnkv.WithOracle(o))
+	require.Nil(t, err)
+	defer client.Close()
+	ctx := context.Background()
+	noLeader := errors.New("rpc error: pd is not leader")
+
+	// retry until the leader switch finishes.
+	o.InjectErrorTimes(noLeader, 2)
+	ts, details, err := client.GetTimestampWithOptions(ctx, txnkv.TSOptions{})
+	require.Nil(t, err)
+	require.NotZero(t, ts)
+	require.Equal(t, 3, details.Attempts)
+	require.Greater(t, details.TotalBackoff, time.Duration(0))
+	require.Equal(t, pdClient.GetLeaderURL(), details.ServedBy)
+
+	// retries are bounded by the max attempts.
+	o.InjectErrorTimes(noLeader, 5)
+	_, details, err = client.GetTimestampWithOptions(ctx, txnkv.TSOptions{MaxAttempts: 2})
+	require.NotNil(t, err)
+	require.Equal(t, 2, details.Attempts)
+	require.Empty(t, details.ServedBy)
+
+	// fail fast if there is no leader.
+	o.InjectErrorTimes(noLeader, 5)
+	_, details, err = client.GetTimestampWithOptions(ctx, txnkv.TSOptions{FailFastOnNoLeader: true})
+	require.True(t, tikverr.IsErrPDNoLeader(err))
+	require.Equal(t, 1, details.Attempts)
+	require.Zero(t, details.TotalBackoff)
+
+	// other errors are still retried with the fast-fail option.
+	o.InjectErrorTimes(errors.New("mock tso error"), 1)
+	_, details, err = client.GetTimestampWithOptions(ctx, txnkv.TSOptions{FailFastOnNoLeader: true})
+	require.Nil(t, err)
+	require.Equal(t, 2, details.Attempts)
+}
+
+func TestClientWithClientName(t *testing.T) {
+	mockClient, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+	require.Nil(t, err)
+	defer mockClient.Close()
+	testutils.BootstrapWithSingleStore(cluster)
+
+	client, err := txnkv.NewClientWithPD(pdClient)
+	require.Nil(t, err)
+	require.Equal(t, fmt.Sprintf("tikv-%v", pdClient.GetClusterID(context.Background())), client.UUID())
+	require.Nil(t, client.Close())
+
+	client, err = txnkv.NewClientWithPD(pdClient, txnkv.WithClientName("orders-service-pod-7"))
+	require.Nil(t, err)
+	require.Equal(t, "orders-service-pod-7", client.UUID())
+	require.Nil(t, client.Close())
+}
+
+func TestClientCloseGracefully(t *testing.T) {
+	mockClient, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+	require.Nil(t, err)
+	defer mockClient.Close()
+	testutils.BootstrapWithSingleStore(cluster)
+
+	// the active transactions are drained before the client is closed. The transactions are read-only, since the
+	// client doesn't send requests to the mock TiKV.
+	client, err := txnkv.NewClientWithPD(pdClient)
+	require.Nil(t, err)
+	txns := make([]*transaction.KVTxn, 4)
+	for i := range txns {
+		txns[i], err = client.Begin()
+		require.Nil(t, err)
+	}
+	closed := make(chan error, 1)
+	go func() {
+		closed <- client.CloseGracefully(context.Background())
+	}()
+	require.Eventually(t, func() bool {
+		_, err := client.Begin()
+		return errors.Is(err, tikverr.ErrTiDBShuttingDown)
+	}, time.Second, 10*time.Millisecond)
+	var wg sync.WaitGroup
+	for i, txn := range txns {
+		wg.Add(1)
+		go func(i int, txn *transaction.KVTxn) {
+			defer wg.Done()
+			time.Sleep(time.Duration(i) * 50 * time.Millisecond)
+			select {
+			case <-closed:
+				require.FailNow(t, "client is closed before the transactions are drained")
+			default:
+			}
+			if i%2 == 0 {
+				require.Nil(t, txn.Commit(context.Background()))
+			} else {
+				require.Nil(t, txn.Rollback())
+			}
+		}(i, txn)
+	}
+	wg.Wait()
+	select {
+	case err := <-closed:
+		require.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "client is not closed after the transactions are drained")
+	}
+
+	// the client is closed when the deadline exceeds, with the count of the undrained transactions.
+	client, err = txnkv.NewClientWithPD(pdClient)
+	require.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		txn, err := client.Begin()
+		require.Nil(t, err)
+		if i == 0 {
+			require.Nil(t, txn.Rollback())
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = client.CloseGracefully(ctx)
+	var notDrained *txnkv.ErrTxnsNotDrained
+	require.True(t, errors.As(err, &notDrained))
+	require.Equal(t, 2, notDrained.ActiveTxns)
+	_, err = client.Begin()
+	require.True(t, errors.Is(err, tikverr.ErrTiDBShuttingDown))
+}
+
+func TestClientWithSafePointKVFactory(t *testing.T) {
+	mockClient, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+	require.Nil(t, err)
+	defer mockClient.Close()
+	testutils.BootstrapWithSingleStore(cluster)
+
+	spkv := tikv.NewMemSafePointKV()
+	client, err := txnkv.NewClientWithPD(pdClient, txnkv.WithSafePointKVFactory(func() (tikv.SafePointKV, error) {
+		return spkv, nil
+	}))
+	require.Nil(t, err)
+	require.Same(t, spkv, client.GetSafePointKV())
+	// the transaction is read-only, since the client doesn't send requests to the mock TiKV.
+	txn, err := client.Begin()
+	require.Nil(t, err)
+	require.Nil(t, txn.Commit(context.Background()))
+	require.Nil(t, client.Close())
+
+	// the failure of the factory is reported with the kind of the safe point kv.
+	factoryErr := errors.New("mock factory error")
+	_, err = txnkv.NewClientWithPD(pdClient, txnkv.WithSafePointKVFactory(func() (tikv.SafePointKV, error) {
+		return nil, factoryErr
+	}))
+	require.ErrorIs(t, err, factoryErr)
+	require.ErrorContains(t, err, "failed to create the custom safe point kv")
+}
+
+func TestClientGetRegionCache(t *testing.T) {
+	mockClient, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+	require.Nil(t, err)
+	defer mockClient.Close()
+	_, regionIDs, _ := testutils.BootstrapWithMultiRegions(cluster, []byte("b"), []byte("d"))
+	client, err := txnkv.NewClientWithPD(pdClient)
+	require.Nil(t, err)
+	defer client.Close()
+
+	cache := client.GetRegionCache()
+	require.Same(t, client.KVStore.GetRegionCache(), cache)
+
+	// the region cache works with the keys of the transactions, and the codec maps them to the keys in TiKV.
+	bo := tikv.NewBackofferWithVars(context.Background(), 1000, nil)
+	loc, err := cache.LocateKey(bo, []byte("c"))
+	require.Nil(t, err)
+	require.Equal(t, regionIDs[1], loc.Region.GetID())
+	require.Equal(t, []byte("b"), loc.StartKey)
+	require.Equal(t, []byte("d"), loc.EndKey)
+	codec := client.GetPDClient().(*tikv.CodecPDClient).GetCodec()
+	start, end := codec.EncodeRegionRange(loc.StartKey, loc.EndKey)
+	key := codec.EncodeRegionKey([]byte("c"))
+	require.True(t, bytes.Compare(start, key) <= 0 && bytes.Compare(key, end) < 0)
+	require.NotEqual(t, []byte("c"), key)
+
+	_, err = cache.BatchLoadRegionsFromKey(bo, []byte(""), 3)
+	require.Nil(t, err)
+	loc, err = cache.LocateKey(bo, []byte("e"))
+	require.Nil(t, err)
+	require.Equal(t, regionIDs[2], loc.Region.GetID())
+}
+
+func TestClientPing(t *testing.T) {
+	mockClient, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+	require.Nil(t, err)
+	defer mockClient.Close()
+	testutils.BootstrapWithSingleStore(cluster)
+	o := oracles.NewMockOracle()
+	client, err := txnkv.NewClientWithPD(pdClient, txnkv.WithOracle(o))
+	require.Nil(t, err)
+
+	require.Nil(t, client.Ping(context.Background()))
+	o.InjectErrorTimes(errors.New("mock tso error"), 1)
+	require.Nil(t, client.Ping(context.Background()))
+
+	// the retries are bounded by a short backoff.
+	o.InjectError(errors.New("mock tso error"))
+	start := time.Now()
+	err = client.Ping(context.Background())
+	require.ErrorContains(t, err, "failed to ping the cluster")
+	var pdTimeout *tikverr.ErrPDServerTimeout
+	require.ErrorAs(t, err, &pdTimeout)
+	require.Less(t, time.Since(start), 10*time.Second)
+
+	// the retries stop once the context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	require.NotNil(t, client.Ping(ctx))
+	require.Less(t, time.Since(start), time.Second)
+
+	// the retries stop once the client is closed.
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Ping(context.Background())
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.Nil(t, client.Close())
+	require.ErrorIs(t, <-done, tikverr.ErrClientClosed)
+	require.ErrorIs(t, client.Ping(context.Background()), tikverr.ErrClientClosed)
+}
+
+// countingAsyncOracle counts the async TSO requests.
+type countingAsyncOracle struct {
+	*oracles.MockOracle
+	requests atomic.Int32
+}
+
+func (o *countingAsyncOracle) GetTimestampAsync(ctx context.Context, opt *oracle.Option) oracle.Future {
+	o.requests.Add(1)
+	return o.MockOracle.GetTimestampAsync(ctx, opt)
+}
+
+func TestClientGetTimestampBatch(t *testing.T) {
+	mockClient, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+	require.Nil(t, err)
+	defer mockClient.Close()
+	testutils.BootstrapWithSingleStore(cluster)
+	o := &countingAsyncOracle{MockOracle: oracles.NewMockOracle()}
+	client, err := txnkv.NewClientWithPD(pdClient, txnkv.WithOracle(o))
+	require.Nil(t, err)
+	defer client.Close()
+	ctx := context.Background()
+
+	checkBatch := func(tss []uint64, n int) {
+		require.Len(t, tss, n)
+		for i := 1; i < len(tss); i++ {
+			require.Less(t, tss[i-1], tss[i])
+		}
+	}
+
+	before, err := client.GetTimestamp(ctx)
+	require.Nil(t, err)
+	tss, err := client.GetTimestampBatch(ctx, 100)
+	require.Nil(t, err)
+	checkBatch(tss, 100)
+	require.Less(t, before, tss[0])
+	require.Equal(t, int32(100), o.requests.Load())
+	after, err := client.GetTimestamp(ctx)
+	require.Nil(t, err)
+	require.Less(t, tss[99], after)
+
+	// only the failed requests are retried.
+	o.requests.Store(0)
+	o.InjectErrorTimes(errors.New("mock tso error"), 3)
+	tss, err = client.GetTimestampBatch(ctx, 10)
+	require.Nil(t, err)
+	checkBatch(tss, 10)
+	require.Equal(t, int32(13), o.requests.Load())
+
+	_, err = client.GetTimestampBatch(ctx, 0)
+	require.NotNil(t, err)
+	_, err = client.GetTimestampBatch(ctx, 1<<18+1)
+	require.NotNil(t, err)
+
+	// the retries stop once the context is done.
+	o.InjectError(errors.New("mock tso error"))
+	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, err = client.GetTimestampBatch(ctx, 10)
+	require.NotNil(t, err)
+}
+
+type keyspacePDClient struct {
+	pd.Client
+	meta *keyspacepb.KeyspaceMeta
+}
+
+func (c *keyspacePDClient) LoadKeyspace(ctx context.Context, name string) (*keyspacepb.KeyspaceMeta, error) {
+	if name != c.meta.Name {
+		return nil, errors.Errorf("keyspace %s not found", name)
+	}
+	return c.meta, nil
+}
+
+func TestClientKeyspace(t *testing.T) {
+	mockClient, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+	require.Nil(t, err)
+	defer mockClient.Close()
+	testutils.BootstrapWithSingleStore(cluster)
+
+	client, err := txnkv.NewClientWithPD(pdClient)
+	require.Nil(t, err)
+	_, ok := client.KeyspaceID()
+	require.False(t, ok)
+	require.Empty(t, client.KeyspaceName())
+	require.Nil(t, client.KeyspaceMeta())
+	require.True(t, client.ContainsKey(nil))
+	require.True(t, client.ContainsKey([]byte("x\x00\x00\x2a")))
+	require.Nil(t, client.Close())
+
+	pdCli := &keyspacePDClient{
+		Client: pdClient,
+		meta:   &keyspacepb.KeyspaceMeta{Id: 42, Name: "ks1", State: keyspacepb.KeyspaceState_ENABLED},
+	}
+	client, err = txnkv.NewClientWithPD(pdCli, txnkv.WithAPIVersion(kvrpcpb.APIVersion_V2), txnkv.WithKeyspace("ks1"))
+	require.Nil(t, err)
+	id, ok := client.KeyspaceID()
+	require.True(t, ok)
+	require.Equal(t, uint32(42), id)
+	require.Equal(t, "ks1", client.KeyspaceName())
+	meta := client.KeyspaceMeta()
+	require.Equal(t, pdCli.meta, meta)
+	// the returned meta is a copy.
+	meta.Name = "ks2"
+	require.Equal(t, "ks1", client.KeyspaceName())
+	require.True(t, client.ContainsKey([]byte("x\x00\x00\x2a")))
+	require.True(t, client.ContainsKey([]byte("x\x00\x00\x2ak")))
+	require.False(t, client.ContainsKey([]byte("x\x00\x00\x2b")))
+	require.False(t, client.ContainsKey([]byte("x\x00\x00\x29\xff")))
+	require.Nil(t, client.Close())
+
+	// WithKeyspaceID doesn't load the keyspace meta.
+	client, err = txnkv.NewClientWithPD(pdClient, txnkv.WithAPIVersion(kvrpcpb.APIVersion_V2), txnkv.WithKeyspaceID(7))
+	require.Nil(t, err)
+	id, ok = client.KeyspaceID()
+	require.True(t, ok)
+	require.Equal(t, uint32(7), id)
+	require.Empty(t, client.KeyspaceName())
+	require.Equal(t, uint32(7), client.KeyspaceMeta().GetId())
+	require.Nil(t, client.Close())
+
+	_, err = txnkv.NewClientWithPD(pdCli, txnkv.WithAPIVersion(kvrpcpb.APIVersion_V2), txnkv.WithKeyspace("ks1"),
+		txnkv.WithKeyspaceID(42))
+	require.NotNil(t, err)
+}
+
+type optionRecordingPDClient struct {
+	pd.Client
+	mu      sync.Mutex
+	options map[pd.DynamicOption]interface{}
+	// unsupported makes UpdateOption fail like a PD client not in the PD service mode.
+	unsupported bool
+}
+
+func (c *optionRecordingPDClient) UpdateOption(option pd.DynamicOption, value interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.unsupported && option == pd.EnableTSOFollowerProxy {
+		return errors.New("tso follower proxy is only supported in PD service mode")
+	}
+	c.options[option] = value
+	return c.Client.UpdateOption(option, value)
+}
+
+func (c *optionRecordingPDClient) option(option pd.DynamicOption) interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.options[option]
+}
+
+func TestClientTSOOptions(t *testing.T) {
+	mockClient, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+	require.Nil(t, err)
+	defer mockClient.Close()
+	testutils.BootstrapWithSingleStore(cluster)
+	pdCli := &optionRecordingPDClient{Client: pdClient, options: make(map[pd.DynamicOption]interface{})}
+
+	// the invalid options are rejected at construction.
+	_, err = txnkv.NewClientWithPD(pdCli, txnkv.WithMaxTSOBatchWaitInterval(time.Second))
+	require.ErrorContains(t, err, "invalid max TSO batch wait interval")
+	_, err = txnkv.NewClientWithPD(pdCli, txnkv.WithTSOFollowerProxy(true), txnkv.WithOracle(oracles.NewMockOracle()))
+	require.Error(t, err)
+	require.Empty(t, pdCli.options)
+
+	client, err := txnkv.NewClientWithPD(pdCli,
+		txnkv.WithTSOFollowerProxy(true), txnkv.WithMaxTSOBatchWaitInterval(2*time.Millisecond))
+	require.Nil(t, err)
+	defer client.Close()
+	require.Equal(t, true, pdCli.option(pd.EnableTSOFollowerProxy))
+	require.Equal(t, 2*time.Millisecond, pdCli.option(pd.MaxTSOBatchWaitInterval))
+	ts, err := client.GetTimestamp(context.Background())
+	require.Nil(t, err)
+
+	// the options can be toggled at runtime, and the timestamps are still allocated.
+	require.Nil(t, client.UpdateTSOOptions(txnkv.TSOFollowerProxy(false), txnkv.TSOMaxBatchWaitInterval(0)))
+	require.Equal(t, false, pdCli.option(pd.EnableTSOFollowerProxy))
+	require.Equal(t, time.Duration(0), pdCli.option(pd.MaxTSOBatchWaitInterval))
+	ts2, err := client.GetTimestamp(context.Background())
+	require.Nil(t, err)
+	require.Greater(t, ts2, ts)
+
+	require.ErrorContains(t, client.UpdateTSOOptions(txnkv.TSOMaxBatchWaitInterval(-1)), "invalid max TSO batch wait interval")
+	pdCli.unsupported = true
+	err = client.UpdateTSOOptions(txnkv.TSOFollowerProxy(true))
+	require.ErrorContains(t, err, "failed to set the TSO follower proxy")
+	require.Equal(t, false, pdCli.option(pd.EnableTSOFollowerProxy))
+	_, err = client.GetTimestamp(context.Background())
+	require.Nil(t, err)
+
+	// the options don't apply to a custom oracle.
+	oracleClient, err := txnkv.NewClientWithPD(pdCli, txnkv.WithOracle(oracles.NewMockOracle()))
+	require.Nil(t, err)
+	defer oracleClient.Close()
+	require.Error(t, oracleClient.UpdateTSOOptions(txnkv.TSOFollowerProxy(true)))
+}
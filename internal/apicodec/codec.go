@@ -1,12 +1,18 @@
 package apicodec
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
 
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/keyspacepb"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/tikvpb"
+	"github.com/tikv/client-go/v2/metrics"
 	"github.com/tikv/client-go/v2/tikvrpc"
+	"github.com/tikv/client-go/v2/util"
+	"github.com/tikv/client-go/v2/util/redact"
 )
 
 type (
@@ -51,11 +57,19 @@ type Codec interface {
 	GetKeyspaceID() KeyspaceID
 	// GetKeyspaceMeta return the keyspace meta of the codec.
 	GetKeyspaceMeta() *keyspacepb.KeyspaceMeta
+	// GetKeyspaceRange returns the encoded range [start, end) of the keyspace, nil means unbounded.
+	GetKeyspaceRange() (start, end []byte)
 	// EncodeRequest encodes with the given Codec.
 	// NOTE: req is reused on retry. MUST encode on cloned request, other than overwrite the original.
 	EncodeRequest(req *tikvrpc.Request) (*tikvrpc.Request, error)
 	// DecodeResponse decode the resp with the given codec.
 	DecodeResponse(req *tikvrpc.Request, resp *tikvrpc.Response) (*tikvrpc.Response, error)
+	// EncodeBatchRequestEntry encodes the request embedded in a request entry of BatchCommands like EncodeRequest.
+	// The entry is not modified.
+	EncodeBatchRequestEntry(entry *tikvpb.BatchCommandsRequest_Request) (*tikvpb.BatchCommandsRequest_Request, error)
+	// DecodeBatchResponseEntry decodes the response entry of BatchCommands to the encoded request entry like
+	// DecodeResponse.
+	DecodeBatchResponseEntry(entry *tikvpb.BatchCommandsRequest_Request, resp *tikvpb.BatchCommandsResponse_Response) (*tikvpb.BatchCommandsResponse_Response, error)
 	// EncodeRegionKey encode region's key.
 	EncodeRegionKey(key []byte) []byte
 	// DecodeRegionKey decode region's key
@@ -74,6 +88,44 @@ type Codec interface {
 	EncodeKey(key []byte) []byte
 	// DecodeKey decode a key.
 	DecodeKey(encoded []byte) ([]byte, error)
+	// EncodeKeys encodes the keys like EncodeKey, the empty keys are kept as is since they mean no bound.
+	EncodeKeys(keys [][]byte) [][]byte
+	// DecodeKeys decodes the keys like DecodeKey, the empty keys are kept as is since they mean no bound.
+	DecodeKeys(encoded [][]byte) ([][]byte, error)
+}
+
+// CodecOption configures the Codec created by NewCodecV1 or NewCodecV2.
+type CodecOption func(*codecOptions)
+
+// OnDecodeError sets the callback invoked with the encoded key and the error when DecodeRegionKey or
+// DecodeRegionRange fails. It's only for observation, the error is still returned to the caller.
+func OnDecodeError(fn func(encoded []byte, err error)) CodecOption {
+	return func(o *codecOptions) {
+		o.onDecodeError = fn
+	}
+}
+
+type codecOptions struct {
+	// version labels the metrics of the codec.
+	version       string
+	onDecodeError func(encoded []byte, err error)
+}
+
+func newCodecOptions(version string, opts []CodecOption) codecOptions {
+	o := codecOptions{version: version}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// decodeFailed records the failure to decode the encoded region key, and returns err as is.
+func (o *codecOptions) decodeFailed(encoded []byte, err error) error {
+	metrics.TiKVCodecDecodeErrorCounter.WithLabelValues(o.version).Inc()
+	if o.onDecodeError != nil {
+		o.onDecodeError(encoded, err)
+	}
+	return err
 }
 
 // DecodeKey split a key to it's keyspace prefix and actual key.
@@ -91,6 +143,52 @@ func DecodeKey(encoded []byte, version kvrpcpb.APIVersion) ([]byte, []byte, erro
 	return nil, nil, errors.Errorf("unsupported api version %s", version.String())
 }
 
+// EncodeRequest encodes the request with the given Codec. If a resource group tag is set in ctx by
+// util.WithResourceGroupTag, it's stamped to the request unless the request has a tag of its own.
+func EncodeRequest(ctx context.Context, c Codec, req *tikvrpc.Request) (*tikvrpc.Request, error) {
+	req, err := c.EncodeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if tag := util.ResourceGroupTagFromCtx(ctx); len(tag) > 0 && len(req.Context.ResourceGroupTag) == 0 {
+		// Shallow copy the request to avoid concurrent modification.
+		r := *req
+		r.Context.ResourceGroupTag = tag
+		tikvrpc.AttachContext(&r, r.Context)
+		req = &r
+	}
+	return req, nil
+}
+
+// encodeBatchRequestEntry encodes the request embedded in entry by c.EncodeRequest.
+func encodeBatchRequestEntry(c Codec, entry *tikvpb.BatchCommandsRequest_Request) (*tikvpb.BatchCommandsRequest_Request, error) {
+	req, err := tikvrpc.FromBatchCommandsRequest(entry)
+	if err != nil {
+		return nil, err
+	}
+	if req, err = c.EncodeRequest(req); err != nil {
+		return nil, err
+	}
+	return req.ToBatchCommandsRequest(), nil
+}
+
+// decodeBatchResponseEntry decodes the response embedded in resp by c.DecodeResponse.
+func decodeBatchResponseEntry(c Codec, entry *tikvpb.BatchCommandsRequest_Request, resp *tikvpb.BatchCommandsResponse_Response) (*tikvpb.BatchCommandsResponse_Response, error) {
+	req, err := tikvrpc.FromBatchCommandsRequest(entry)
+	if err != nil {
+		return nil, err
+	}
+	r, err := tikvrpc.FromBatchCommandsResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	// The response is decoded in place, so resp embeds the decoded one.
+	if _, err = c.DecodeResponse(req, r); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
 func attachAPICtx(c Codec, req *tikvrpc.Request) *tikvrpc.Request {
 	// Shallow copy the request to avoid concurrent modification.
 	r := *req
@@ -119,3 +217,42 @@ func attachAPICtx(c Codec, req *tikvrpc.Request) *tikvrpc.Request {
 
 	return &r
 }
+
+// ContainsKey returns whether the encoded key is in the keyspace range of c, see Codec.GetKeyspaceRange. It's always
+// true for the codecs without keyspace.
+func ContainsKey(c Codec, encodedKey []byte) bool {
+	start, end := c.GetKeyspaceRange()
+	if len(start) > 0 && bytes.Compare(encodedKey, start) < 0 {
+		return false
+	}
+	return len(end) == 0 || bytes.Compare(encodedKey, end) < 0
+}
+
+// DescribeRegion returns a compact description of the region range "[start, end)" in user keys for logging.
+// The encoded bounds are decoded by c, a bound failed to decode is shown as its raw bytes with the "undecodable:"
+// prefix instead, and the keys are redacted. An empty bound is shown as "-inf" or "+inf".
+func DescribeRegion(c Codec, startKey, endKey []byte) string {
+	start := describeRegionBound(startKey, "-inf", func() ([]byte, error) {
+		start, _, err := c.DecodeRegionRange(startKey, nil)
+		return start, err
+	})
+	end := describeRegionBound(endKey, "+inf", func() ([]byte, error) {
+		_, end, err := c.DecodeRegionRange(nil, endKey)
+		return end, err
+	})
+	return "[" + start + ", " + end + ")"
+}
+
+func describeRegionBound(encoded []byte, unbounded string, decode func() ([]byte, error)) string {
+	if len(encoded) == 0 {
+		return unbounded
+	}
+	key, err := decode()
+	if err != nil {
+		return "undecodable:" + redact.Key(encoded)
+	}
+	if len(key) == 0 {
+		return unbounded
+	}
+	return redact.Key(key)
+}
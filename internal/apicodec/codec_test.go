@@ -3,9 +3,18 @@ package apicodec
 import (
 	"testing"
 
+	"github.com/gogo/protobuf/proto"
+	"github.com/pingcap/kvproto/pkg/coprocessor"
+	"github.com/pingcap/kvproto/pkg/errorpb"
+	"github.com/pingcap/kvproto/pkg/keyspacepb"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/kvproto/pkg/tikvpb"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
+	"github.com/tikv/client-go/v2/metrics"
 	"github.com/tikv/client-go/v2/tikvrpc"
+	"github.com/tikv/client-go/v2/util/redact"
 )
 
 func TestParseKeyspaceID(t *testing.T) {
@@ -57,3 +66,182 @@ func TestEncodeUnknownRequest(t *testing.T) {
 	_, err := c.EncodeRequest(req)
 	assert.Nil(t, err)
 }
+
+func TestDescribeRegion(t *testing.T) {
+	c := NewCodecV1(ModeTxn)
+	start, end := c.EncodeRegionRange([]byte("a"), []byte("b"))
+	assert.Equal(t, "[61, 62)", DescribeRegion(c, start, end))
+	assert.Equal(t, "[-inf, +inf)", DescribeRegion(c, nil, nil))
+	assert.Equal(t, "[61, +inf)", DescribeRegion(c, start, nil))
+	assert.Equal(t, "[-inf, undecodable:01)", DescribeRegion(c, nil, []byte{1}))
+
+	redact.SetMode(redact.ModeMarker)
+	defer redact.SetMode(redact.ModeOff)
+	assert.Equal(t, "[?, undecodable:?)", DescribeRegion(c, start, []byte{1}))
+}
+
+func TestOnDecodeError(t *testing.T) {
+	readCounter := func(version string) float64 {
+		pb := &dto.Metric{}
+		assert.Nil(t, metrics.TiKVCodecDecodeErrorCounter.WithLabelValues(version).Write(pb))
+		return pb.GetCounter().GetValue()
+	}
+
+	var failed [][]byte
+	onDecodeError := OnDecodeError(func(encoded []byte, err error) {
+		assert.NotNil(t, err)
+		failed = append(failed, encoded)
+	})
+
+	v1 := NewCodecV1(ModeTxn, onDecodeError)
+	before := readCounter("v1")
+	_, err := v1.DecodeRegionKey([]byte{1})
+	assert.NotNil(t, err)
+	_, _, err = v1.DecodeRegionRange(nil, []byte{2})
+	assert.NotNil(t, err)
+	start, end := v1.EncodeRegionRange([]byte("a"), []byte("b"))
+	_, _, err = v1.DecodeRegionRange(start, end)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{{1}, {2}}, failed)
+	assert.Equal(t, before+2, readCounter("v1"))
+
+	failed = nil
+	v2, err := NewCodecV2(ModeTxn, &keyspacepb.KeyspaceMeta{Id: 1}, onDecodeError)
+	assert.Nil(t, err)
+	before = readCounter("v2")
+	_, err = v2.DecodeRegionKey([]byte{3})
+	assert.NotNil(t, err)
+	// The key of another keyspace is out of bound.
+	other := (&memComparableCodec{}).encodeKey([]byte{'x', 0, 0, 2, 'a'})
+	_, err = v2.DecodeRegionKey(other)
+	assert.NotNil(t, err)
+	_, _, err = v2.DecodeRegionRange(other, nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, [][]byte{{3}, other, other}, failed)
+	assert.Equal(t, before+3, readCounter("v2"))
+}
+
+func TestCodecIdentity(t *testing.T) {
+	c := NewCodecIdentity()
+	assert.Equal(t, kvrpcpb.APIVersion_V1, c.GetAPIVersion())
+	assert.Equal(t, NullspaceID, c.GetKeyspaceID())
+
+	key := []byte("k")
+	assert.Equal(t, key, c.EncodeRegionKey(key))
+	decoded, err := c.DecodeRegionKey(key)
+	assert.Nil(t, err)
+	assert.Equal(t, key, decoded)
+	start, end := c.EncodeRegionRange([]byte("a"), nil)
+	assert.Equal(t, []byte("a"), start)
+	assert.Nil(t, end)
+
+	// the request and the region error are passed through as is.
+	req := tikvrpc.NewRequest(tikvrpc.CmdGet, &kvrpcpb.GetRequest{Key: key})
+	encoded, err := c.EncodeRequest(req)
+	assert.Nil(t, err)
+	assert.Same(t, req, encoded)
+	regionErr := &errorpb.Error{KeyNotInRegion: &errorpb.KeyNotInRegion{Key: key, StartKey: []byte("a"), EndKey: []byte("b")}}
+	resp := &tikvrpc.Response{Resp: &kvrpcpb.GetResponse{RegionError: regionErr}}
+	decodedResp, err := c.DecodeResponse(req, resp)
+	assert.Nil(t, err)
+	assert.Same(t, resp, decodedResp)
+	assert.Equal(t, &errorpb.KeyNotInRegion{Key: key, StartKey: []byte("a"), EndKey: []byte("b")}, decodedResp.Resp.(*kvrpcpb.GetResponse).RegionError.KeyNotInRegion)
+}
+
+func TestBatchRequestEntry(t *testing.T) {
+	v2, err := NewCodecV2(ModeTxn, &keyspacepb.KeyspaceMeta{Id: 1})
+	assert.Nil(t, err)
+	newReqs := []func() *tikvrpc.Request{
+		func() *tikvrpc.Request {
+			return tikvrpc.NewRequest(tikvrpc.CmdGet, &kvrpcpb.GetRequest{Key: []byte("k"), Version: 10})
+		},
+		func() *tikvrpc.Request {
+			return tikvrpc.NewRequest(tikvrpc.CmdPrewrite, &kvrpcpb.PrewriteRequest{
+				Mutations:    []*kvrpcpb.Mutation{{Op: kvrpcpb.Op_Put, Key: []byte("k1"), Value: []byte("v1")}, {Op: kvrpcpb.Op_Del, Key: []byte("k2")}},
+				PrimaryLock:  []byte("k1"),
+				Secondaries:  [][]byte{[]byte("k2")},
+				StartVersion: 10,
+			})
+		},
+		func() *tikvrpc.Request {
+			return tikvrpc.NewRequest(tikvrpc.CmdCommit, &kvrpcpb.CommitRequest{
+				Keys:          [][]byte{[]byte("k1"), []byte("k2")},
+				StartVersion:  10,
+				CommitVersion: 20,
+			})
+		},
+		func() *tikvrpc.Request {
+			return tikvrpc.NewRequest(tikvrpc.CmdPessimisticLock, &kvrpcpb.PessimisticLockRequest{
+				Mutations:    []*kvrpcpb.Mutation{{Op: kvrpcpb.Op_PessimisticLock, Key: []byte("k1")}},
+				PrimaryLock:  []byte("k1"),
+				StartVersion: 10,
+				ForUpdateTs:  15,
+			})
+		},
+		func() *tikvrpc.Request {
+			return tikvrpc.NewRequest(tikvrpc.CmdCop, &coprocessor.Request{
+				Tp:     104,
+				Ranges: []*coprocessor.KeyRange{{Start: []byte("a"), End: []byte("z")}},
+			})
+		},
+	}
+	for _, c := range []Codec{NewCodecV1(ModeTxn), v2} {
+		for _, newReq := range newReqs {
+			expected, err := c.EncodeRequest(newReq())
+			assert.Nil(t, err)
+			expectedBytes, err := proto.Marshal(expected.ToBatchCommandsRequest())
+			assert.Nil(t, err)
+
+			entry := newReq().ToBatchCommandsRequest()
+			origBytes, err := proto.Marshal(entry)
+			assert.Nil(t, err)
+			encoded, err := c.EncodeBatchRequestEntry(entry)
+			assert.Nil(t, err)
+			encodedBytes, err := proto.Marshal(encoded)
+			assert.Nil(t, err)
+			assert.Equal(t, expectedBytes, encodedBytes, "%s", expected.Type)
+
+			// The original entry is kept as is.
+			afterBytes, err := proto.Marshal(entry)
+			assert.Nil(t, err)
+			assert.Equal(t, origBytes, afterBytes, "%s", expected.Type)
+		}
+	}
+
+	entry := &tikvpb.BatchCommandsRequest_Request{Cmd: &tikvpb.BatchCommandsRequest_Request_Empty{}}
+	encoded, err := NewCodecV1(ModeTxn).EncodeBatchRequestEntry(entry)
+	assert.Nil(t, err)
+	assert.Equal(t, entry, encoded)
+}
+
+func TestBatchResponseEntry(t *testing.T) {
+	c, err := NewCodecV2(ModeTxn, &keyspacepb.KeyspaceMeta{Id: 1})
+	assert.Nil(t, err)
+	v2 := c.(*codecV2)
+	entry, err := c.EncodeBatchRequestEntry(tikvrpc.NewRequest(tikvrpc.CmdGet, &kvrpcpb.GetRequest{Key: []byte("k")}).ToBatchCommandsRequest())
+	assert.Nil(t, err)
+
+	resp := &tikvpb.BatchCommandsResponse_Response{
+		Cmd: &tikvpb.BatchCommandsResponse_Response_Get{
+			Get: &kvrpcpb.GetResponse{
+				RegionError: &errorpb.Error{
+					EpochNotMatch: &errorpb.EpochNotMatch{
+						CurrentRegions: []*metapb.Region{{
+							StartKey: v2.memCodec.encodeKey(v2.EncodeKey([]byte("a"))),
+							EndKey:   v2.memCodec.encodeKey(v2.EncodeKey([]byte("z"))),
+						}},
+					},
+				},
+				Error: &kvrpcpb.KeyError{Locked: &kvrpcpb.LockInfo{Key: v2.EncodeKey([]byte("k")), PrimaryLock: v2.EncodeKey([]byte("p"))}},
+			},
+		},
+	}
+	decoded, err := c.DecodeBatchResponseEntry(entry, resp)
+	assert.Nil(t, err)
+	get := decoded.GetGet()
+	region := get.GetRegionError().GetEpochNotMatch().GetCurrentRegions()[0]
+	assert.Equal(t, []byte("a"), region.StartKey)
+	assert.Equal(t, []byte("z"), region.EndKey)
+	assert.Equal(t, []byte("k"), get.GetError().GetLocked().GetKey())
+	assert.Equal(t, []byte("p"), get.GetError().GetLocked().GetPrimaryLock())
+}
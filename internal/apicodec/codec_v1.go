@@ -2,26 +2,32 @@ package apicodec
 
 import (
 	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/coprocessor"
 	"github.com/pingcap/kvproto/pkg/errorpb"
 	"github.com/pingcap/kvproto/pkg/keyspacepb"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/tikvpb"
 	"github.com/tikv/client-go/v2/tikvrpc"
 )
 
 type codecV1 struct {
+	codecOptions
 	memCodec memCodec
 }
 
 // NewCodecV1 returns a codec that can be used to encode/decode
 // keys and requests to and from APIv1 format.
-func NewCodecV1(mode Mode) Codec {
+func NewCodecV1(mode Mode, opts ...CodecOption) Codec {
+	c := &codecV1{codecOptions: newCodecOptions("v1", opts)}
 	switch mode {
 	case ModeRaw:
-		return &codecV1{memCodec: &defaultMemCodec{}}
+		c.memCodec = &defaultMemCodec{}
 	case ModeTxn:
-		return &codecV1{memCodec: &memComparableCodec{}}
+		c.memCodec = &memComparableCodec{}
+	default:
+		panic("unknown mode")
 	}
-	panic("unknown mode")
+	return c
 }
 
 func (c *codecV1) GetAPIVersion() kvrpcpb.APIVersion {
@@ -36,10 +42,22 @@ func (c *codecV1) GetKeyspaceID() KeyspaceID {
 	return NullspaceID
 }
 
+func (c *codecV1) GetKeyspaceRange() ([]byte, []byte) {
+	return nil, nil
+}
+
 func (c *codecV1) EncodeRequest(req *tikvrpc.Request) (*tikvrpc.Request, error) {
 	return attachAPICtx(c, req), nil
 }
 
+func (c *codecV1) EncodeBatchRequestEntry(entry *tikvpb.BatchCommandsRequest_Request) (*tikvpb.BatchCommandsRequest_Request, error) {
+	return encodeBatchRequestEntry(c, entry)
+}
+
+func (c *codecV1) DecodeBatchResponseEntry(entry *tikvpb.BatchCommandsRequest_Request, resp *tikvpb.BatchCommandsResponse_Response) (*tikvpb.BatchCommandsResponse_Response, error) {
+	return decodeBatchResponseEntry(c, entry, resp)
+}
+
 func (c *codecV1) DecodeResponse(req *tikvrpc.Request, resp *tikvrpc.Response) (*tikvrpc.Response, error) {
 	regionError, err := resp.GetRegionError()
 	// If GetRegionError returns error, it means the response does not contain region error to decode,
@@ -133,6 +151,39 @@ func (c *codecV1) DecodeResponse(req *tikvrpc.Request, resp *tikvrpc.Response) (
 	case tikvrpc.CmdRawChecksum:
 		r := resp.Resp.(*kvrpcpb.RawChecksumResponse)
 		r.RegionError = decodeRegionError
+	case tikvrpc.CmdUnsafeDestroyRange:
+		r := resp.Resp.(*kvrpcpb.UnsafeDestroyRangeResponse)
+		r.RegionError = decodeRegionError
+	case tikvrpc.CmdFlashbackToVersion:
+		r := resp.Resp.(*kvrpcpb.FlashbackToVersionResponse)
+		r.RegionError = decodeRegionError
+	case tikvrpc.CmdPrepareFlashbackToVersion:
+		r := resp.Resp.(*kvrpcpb.PrepareFlashbackToVersionResponse)
+		r.RegionError = decodeRegionError
+	case tikvrpc.CmdFlush:
+		r := resp.Resp.(*kvrpcpb.FlushResponse)
+		r.RegionError = decodeRegionError
+	case tikvrpc.CmdBufferBatchGet:
+		r := resp.Resp.(*kvrpcpb.BufferBatchGetResponse)
+		r.RegionError = decodeRegionError
+	case tikvrpc.CmdGetHealthFeedback:
+		r := resp.Resp.(*kvrpcpb.GetHealthFeedbackResponse)
+		r.RegionError = decodeRegionError
+	case tikvrpc.CmdCop:
+		r := resp.Resp.(*coprocessor.Response)
+		r.RegionError = decodeRegionError
+	case tikvrpc.CmdCopStream:
+		r := resp.Resp.(*tikvrpc.CopStreamResponse)
+		r.RegionError = decodeRegionError
+	case tikvrpc.CmdMvccGetByKey:
+		r := resp.Resp.(*kvrpcpb.MvccGetByKeyResponse)
+		r.RegionError = decodeRegionError
+	case tikvrpc.CmdMvccGetByStartTs:
+		r := resp.Resp.(*kvrpcpb.MvccGetByStartTsResponse)
+		r.RegionError = decodeRegionError
+	case tikvrpc.CmdSplitRegion:
+		r := resp.Resp.(*kvrpcpb.SplitRegionResponse)
+		r.RegionError = decodeRegionError
 	}
 	return resp, nil
 }
@@ -145,7 +196,11 @@ func (c *codecV1) DecodeRegionKey(encodedKey []byte) ([]byte, error) {
 	if len(encodedKey) == 0 {
 		return encodedKey, nil
 	}
-	return c.memCodec.decodeKey(encodedKey)
+	key, err := c.memCodec.decodeKey(encodedKey)
+	if err != nil {
+		return nil, c.decodeFailed(encodedKey, err)
+	}
+	return key, nil
 }
 
 func (c *codecV1) EncodeRegionRange(start, end []byte) ([]byte, []byte) {
@@ -167,6 +222,8 @@ func (c *codecV1) DecodeRegionRange(encodedStart, encodedEnd []byte) ([]byte, []
 	return start, end, nil
 }
 
+// decodeRegionError decodes the keys in the inner errors of regionError in place. Only KeyNotInRegion,
+// EpochNotMatch and BucketVersionNotMatch carry keys, the other inner errors only carry ids and timestamps.
 func (c *codecV1) decodeRegionError(regionError *errorpb.Error) (*errorpb.Error, error) {
 	if regionError == nil {
 		return nil, nil
@@ -186,6 +243,12 @@ func (c *codecV1) decodeRegionError(regionError *errorpb.Error) (*errorpb.Error,
 			}
 		}
 	}
+	if errInfo := regionError.BucketVersionNotMatch; errInfo != nil {
+		errInfo.Keys, err = c.DecodeBucketKeys(errInfo.Keys)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return regionError, nil
 }
 
@@ -193,6 +256,40 @@ func (c *codecV1) EncodeKey(key []byte) []byte {
 	return key
 }
 
+func (c *codecV1) EncodeKeys(keys [][]byte) [][]byte {
+	if keys == nil {
+		return nil
+	}
+	encoded := make([][]byte, len(keys))
+	for i, key := range keys {
+		if len(key) == 0 {
+			encoded[i] = key
+			continue
+		}
+		encoded[i] = c.EncodeKey(key)
+	}
+	return encoded
+}
+
+func (c *codecV1) DecodeKeys(encoded [][]byte) ([][]byte, error) {
+	if encoded == nil {
+		return nil, nil
+	}
+	keys := make([][]byte, len(encoded))
+	for i, key := range encoded {
+		if len(key) == 0 {
+			keys[i] = key
+			continue
+		}
+		k, err := c.DecodeKey(key)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = k
+	}
+	return keys, nil
+}
+
 func (c *codecV1) EncodeRange(start, end []byte) ([]byte, []byte) {
 	return start, end
 }
@@ -1,8 +1,110 @@
 package apicodec
 
 import (
+	"context"
+	"slices"
 	"testing"
+
+	"github.com/pingcap/kvproto/pkg/errorpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/tikvrpc"
+	"github.com/tikv/client-go/v2/util"
 )
 
 func TestV1DecodeBucketKey(t *testing.T) {
 }
+
+func TestV1EncodeRequestWithResourceGroupTag(t *testing.T) {
+	c := NewCodecV1(ModeTxn)
+	tag := []byte("background")
+
+	// the tag in context is stamped to the request.
+	req := tikvrpc.NewRequest(tikvrpc.CmdGet, &kvrpcpb.GetRequest{Key: []byte("k")})
+	encoded, err := EncodeRequest(util.WithResourceGroupTag(context.Background(), tag), c, req)
+	require.NoError(t, err)
+	require.Equal(t, tag, encoded.Context.ResourceGroupTag)
+	require.Equal(t, tag, encoded.Get().Context.ResourceGroupTag)
+	// the original request is not modified.
+	require.Nil(t, req.Context.ResourceGroupTag)
+
+	// the request without the tag in context is left untouched.
+	req = tikvrpc.NewRequest(tikvrpc.CmdGet, &kvrpcpb.GetRequest{Key: []byte("k")})
+	encoded, err = EncodeRequest(context.Background(), c, req)
+	require.NoError(t, err)
+	require.Empty(t, encoded.Context.ResourceGroupTag)
+	require.Empty(t, encoded.Get().Context.ResourceGroupTag)
+
+	// the tag of the request itself takes precedence.
+	req = tikvrpc.NewRequest(tikvrpc.CmdGet, &kvrpcpb.GetRequest{Key: []byte("k")}, kvrpcpb.Context{ResourceGroupTag: []byte("query")})
+	encoded, err = EncodeRequest(util.WithResourceGroupTag(context.Background(), tag), c, req)
+	require.NoError(t, err)
+	require.Equal(t, []byte("query"), encoded.Context.ResourceGroupTag)
+	require.Equal(t, []byte("query"), encoded.Get().Context.ResourceGroupTag)
+}
+
+func TestV1DecodeResponseRegionError(t *testing.T) {
+	c := NewCodecV1(ModeTxn)
+	start, end := []byte("a"), []byte("b")
+	encodedStart, encodedEnd := c.EncodeRegionRange(start, end)
+
+	tested := 0
+	// Walk through all the command types, GenRegionErrorResp fails for the unknown ones.
+	for cmd := tikvrpc.CmdType(0); cmd < tikvrpc.CmdEmpty+256; cmd++ {
+		regionErr := &errorpb.Error{
+			KeyNotInRegion: &errorpb.KeyNotInRegion{StartKey: slices.Clone(encodedStart), EndKey: slices.Clone(encodedEnd)},
+		}
+		resp, err := tikvrpc.GenRegionErrorResp(&tikvrpc.Request{Type: cmd}, regionErr)
+		if err != nil || resp.Resp == nil {
+			continue
+		}
+		tested++
+		decoded, err := c.DecodeResponse(&tikvrpc.Request{Type: cmd}, resp)
+		require.NoError(t, err, cmd.String())
+		decodedErr, err := decoded.GetRegionError()
+		require.NoError(t, err, cmd.String())
+		require.NotNil(t, decodedErr, cmd.String())
+		require.Equal(t, start, decodedErr.KeyNotInRegion.StartKey, cmd.String())
+		require.Equal(t, end, decodedErr.KeyNotInRegion.EndKey, cmd.String())
+	}
+	require.Greater(t, tested, 0)
+}
+
+func TestV1DecodeRegionErrorInnerErrors(t *testing.T) {
+	c := NewCodecV1(ModeTxn).(*codecV1)
+	start, end := c.EncodeRegionRange([]byte("a"), []byte("c"))
+	regionErr := &errorpb.Error{
+		KeyNotInRegion: &errorpb.KeyNotInRegion{Key: []byte("d"), RegionId: 1, StartKey: start, EndKey: end},
+		BucketVersionNotMatch: &errorpb.BucketVersionNotMatch{
+			Version: 2,
+			Keys:    [][]byte{start, c.EncodeRegionKey([]byte("b")), end},
+		},
+		RegionNotFound: &errorpb.RegionNotFound{RegionId: 1},
+		DataIsNotReady: &errorpb.DataIsNotReady{RegionId: 1, PeerId: 2, SafeTs: 3},
+	}
+
+	result, err := c.decodeRegionError(regionErr)
+	require.NoError(t, err)
+	// the key itself is not encoded in API v1.
+	require.Equal(t, &errorpb.KeyNotInRegion{Key: []byte("d"), RegionId: 1, StartKey: []byte("a"), EndKey: []byte("c")}, result.KeyNotInRegion)
+	require.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c")}, result.BucketVersionNotMatch.Keys)
+	require.Equal(t, &errorpb.RegionNotFound{RegionId: 1}, result.RegionNotFound)
+	require.Equal(t, &errorpb.DataIsNotReady{RegionId: 1, PeerId: 2, SafeTs: 3}, result.DataIsNotReady)
+}
+
+func TestV1EncodeKeys(t *testing.T) {
+	c := NewCodecV1(ModeRaw)
+	require.Nil(t, c.EncodeKeys(nil))
+	keys := [][]byte{nil, []byte("a"), {}, []byte("b")}
+	encoded := c.EncodeKeys(keys)
+	require.Equal(t, keys, encoded)
+	require.Nil(t, encoded[0])
+	require.NotNil(t, encoded[2])
+
+	decoded, err := c.DecodeKeys(encoded)
+	require.NoError(t, err)
+	require.Equal(t, keys, decoded)
+	decoded, err = c.DecodeKeys(nil)
+	require.NoError(t, err)
+	require.Nil(t, decoded)
+}
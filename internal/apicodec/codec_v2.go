@@ -10,6 +10,7 @@ import (
 	"github.com/pingcap/kvproto/pkg/keyspacepb"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/kvproto/pkg/tikvpb"
 	"github.com/pkg/errors"
 	"github.com/tikv/client-go/v2/internal/logutil"
 	"github.com/tikv/client-go/v2/tikvrpc"
@@ -50,6 +51,7 @@ func BuildKeyspaceName(name string) string {
 
 // codecV2 is used to encode/decode keys and request into APIv2 format.
 type codecV2 struct {
+	codecOptions
 	prefix       []byte
 	endKey       []byte
 	memCodec     memCodec
@@ -58,7 +60,7 @@ type codecV2 struct {
 
 // NewCodecV2 returns a codec that can be used to encode/decode
 // keys and requests to and from APIv2 format.
-func NewCodecV2(mode Mode, keyspaceMeta *keyspacepb.KeyspaceMeta) (Codec, error) {
+func NewCodecV2(mode Mode, keyspaceMeta *keyspacepb.KeyspaceMeta, opts ...CodecOption) (Codec, error) {
 	keyspaceID := keyspaceMeta.Id
 	if keyspaceID > maxKeyspaceID {
 		return nil, errors.Errorf("keyspaceID %d is out of range, maximum is %d", keyspaceID, maxKeyspaceID)
@@ -68,6 +70,7 @@ func NewCodecV2(mode Mode, keyspaceMeta *keyspacepb.KeyspaceMeta) (Codec, error)
 		return nil, err
 	}
 	codec := &codecV2{
+		codecOptions: newCodecOptions("v2", opts),
 		// Region keys in CodecV2 are always encoded in memory comparable form.
 		memCodec:     &memComparableCodec{},
 		keyspaceMeta: keyspaceMeta,
@@ -111,6 +114,11 @@ func (c *codecV2) GetKeyspaceID() KeyspaceID {
 	return KeyspaceID(c.keyspaceMeta.Id)
 }
 
+// GetKeyspaceRange returns the range from the prefix of the keyspace to the prefix of the next keyspace.
+func (c *codecV2) GetKeyspaceRange() ([]byte, []byte) {
+	return bytes.Clone(c.prefix), bytes.Clone(c.endKey)
+}
+
 func (c *codecV2) GetKeyspaceMeta() *keyspacepb.KeyspaceMeta {
 	return c.keyspaceMeta
 }
@@ -287,6 +295,17 @@ func (c *codecV2) EncodeRequest(req *tikvrpc.Request) (*tikvrpc.Request, error)
 	return req, nil
 }
 
+// EncodeBatchRequestEntry encodes the request embedded in entry like EncodeRequest, the keys are prefixed by the
+// keyspace.
+func (c *codecV2) EncodeBatchRequestEntry(entry *tikvpb.BatchCommandsRequest_Request) (*tikvpb.BatchCommandsRequest_Request, error) {
+	return encodeBatchRequestEntry(c, entry)
+}
+
+// DecodeBatchResponseEntry decodes the response embedded in resp like DecodeResponse.
+func (c *codecV2) DecodeBatchResponseEntry(entry *tikvpb.BatchCommandsRequest_Request, resp *tikvpb.BatchCommandsResponse_Response) (*tikvpb.BatchCommandsResponse_Response, error) {
+	return decodeBatchResponseEntry(c, entry, resp)
+}
+
 // DecodeResponse decode the resp with the given codec.
 func (c *codecV2) DecodeResponse(req *tikvrpc.Request, resp *tikvrpc.Response) (*tikvrpc.Response, error) {
 	var err error
@@ -610,9 +629,13 @@ func (c *codecV2) EncodeRegionKey(key []byte) []byte {
 func (c *codecV2) DecodeRegionKey(encodedKey []byte) ([]byte, error) {
 	memDecoded, err := c.memCodec.decodeKey(encodedKey)
 	if err != nil {
-		return nil, err
+		return nil, c.decodeFailed(encodedKey, err)
 	}
-	return c.DecodeKey(memDecoded)
+	key, err := c.DecodeKey(memDecoded)
+	if err != nil {
+		return nil, c.decodeFailed(encodedKey, err)
+	}
+	return key, nil
 }
 
 // EncodeRegionRange first append appropriate prefix to start and end,
@@ -629,20 +652,25 @@ func (c *codecV2) EncodeRegionRange(start, end []byte) ([]byte, []byte) {
 // Note that empty byte slice/ nil slice requires special treatment.
 func (c *codecV2) DecodeRegionRange(encodedStart, encodedEnd []byte) ([]byte, []byte, error) {
 	var err error
+	memStart, memEnd := encodedStart, encodedEnd
 	if len(encodedStart) != 0 {
-		encodedStart, err = c.memCodec.decodeKey(encodedStart)
+		memStart, err = c.memCodec.decodeKey(encodedStart)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, c.decodeFailed(encodedStart, err)
 		}
 	}
 	if len(encodedEnd) != 0 {
-		encodedEnd, err = c.memCodec.decodeKey(encodedEnd)
+		memEnd, err = c.memCodec.decodeKey(encodedEnd)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, c.decodeFailed(encodedEnd, err)
 		}
 	}
 
-	return c.DecodeRange(encodedStart, encodedEnd)
+	start, end, err := c.DecodeRange(memStart, memEnd)
+	if err != nil {
+		return nil, nil, c.decodeFailed(encodedStart, err)
+	}
+	return start, end, nil
 }
 
 func (c *codecV2) EncodeRange(start, end []byte) ([]byte, []byte) {
@@ -706,6 +734,43 @@ func (c *codecV2) DecodeKey(encodedKey []byte) ([]byte, error) {
 	return encodedKey[len(c.prefix):], nil
 }
 
+// EncodeKeys encodes the non-empty keys with the keyspace prefix. Unlike encodeKeys for the keys of requests, an
+// empty key is kept empty rather than encoded to the prefix.
+func (c *codecV2) EncodeKeys(keys [][]byte) [][]byte {
+	if keys == nil {
+		return nil
+	}
+	encoded := make([][]byte, len(keys))
+	for i, key := range keys {
+		if len(key) == 0 {
+			encoded[i] = key
+			continue
+		}
+		encoded[i] = c.EncodeKey(key)
+	}
+	return encoded
+}
+
+// DecodeKeys removes the keyspace prefix from the non-empty keys, it fails if any of them is out of the keyspace.
+func (c *codecV2) DecodeKeys(encoded [][]byte) ([][]byte, error) {
+	if encoded == nil {
+		return nil, nil
+	}
+	keys := make([][]byte, len(encoded))
+	for i, key := range encoded {
+		if len(key) == 0 {
+			keys[i] = key
+			continue
+		}
+		k, err := c.DecodeKey(key)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = k
+	}
+	return keys, nil
+}
+
 func (c *codecV2) encodeKeyRange(keyRange *kvrpcpb.KeyRange) *kvrpcpb.KeyRange {
 	encodedRange := &kvrpcpb.KeyRange{}
 	encodedRange.StartKey, encodedRange.EndKey = c.encodeRange(keyRange.StartKey, keyRange.EndKey, false)
@@ -840,6 +905,8 @@ func (c *codecV2) encodeStoreBatchTasks(tasks []*coprocessor.StoreBatchTask) []*
 	return encodedTasks
 }
 
+// decodeRegionError removes the keyspace prefix from the keys in regionError, and drops the regions out of the
+// keyspace from EpochNotMatch.
 func (c *codecV2) decodeRegionError(regionError *errorpb.Error) (*errorpb.Error, error) {
 	if regionError == nil {
 		return nil, nil
@@ -871,6 +938,12 @@ func (c *codecV2) decodeRegionError(regionError *errorpb.Error) (*errorpb.Error,
 		}
 		errInfo.CurrentRegions = decodedRegions
 	}
+	if errInfo := regionError.BucketVersionNotMatch; errInfo != nil {
+		errInfo.Keys, err = c.DecodeBucketKeys(errInfo.Keys)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return regionError, nil
 }
@@ -1,6 +1,7 @@
 package apicodec
 
 import (
+	"bytes"
 	"math"
 	"testing"
 
@@ -278,10 +279,89 @@ func (suite *testCodecV2Suite) TestDecodeEpochNotMatch() {
 	}
 }
 
+func (suite *testCodecV2Suite) TestDecodeRegionErrorInnerErrors() {
+	re := suite.Require()
+	c := suite.codec
+	start, end := c.EncodeRegionRange([]byte("a"), []byte("c"))
+	regionErr := &errorpb.Error{
+		KeyNotInRegion: &errorpb.KeyNotInRegion{
+			Key:      c.EncodeKey([]byte("d")),
+			RegionId: 1,
+			StartKey: start,
+			EndKey:   end,
+		},
+		BucketVersionNotMatch: &errorpb.BucketVersionNotMatch{
+			Version: 2,
+			Keys:    [][]byte{c.EncodeRegionKey([]byte("")), c.EncodeRegionKey([]byte("b")), c.memCodec.encodeKey(keyspaceEndKey)},
+		},
+		RegionNotFound:      &errorpb.RegionNotFound{RegionId: 1},
+		DataIsNotReady:      &errorpb.DataIsNotReady{RegionId: 1, PeerId: 2, SafeTs: 3},
+		FlashbackInProgress: &errorpb.FlashbackInProgress{RegionId: 1, FlashbackStartTs: 4},
+	}
+
+	result, err := c.decodeRegionError(regionErr)
+	re.NoError(err)
+	re.Equal(&errorpb.KeyNotInRegion{Key: []byte("d"), RegionId: 1, StartKey: []byte("a"), EndKey: []byte("c")}, result.KeyNotInRegion)
+	re.Equal(uint64(2), result.BucketVersionNotMatch.Version)
+	re.Equal([][]byte{{}, []byte("b"), {}}, result.BucketVersionNotMatch.Keys)
+	// the inner errors without keys are kept as is.
+	re.Equal(&errorpb.RegionNotFound{RegionId: 1}, result.RegionNotFound)
+	re.Equal(&errorpb.DataIsNotReady{RegionId: 1, PeerId: 2, SafeTs: 3}, result.DataIsNotReady)
+	re.Equal(&errorpb.FlashbackInProgress{RegionId: 1, FlashbackStartTs: 4}, result.FlashbackInProgress)
+}
+
+func (suite *testCodecV2Suite) TestEncodeKeys() {
+	re := suite.Require()
+	c := suite.codec
+	re.Nil(c.EncodeKeys(nil))
+	encoded := c.EncodeKeys([][]byte{nil, []byte("a"), {}, []byte("b")})
+	re.Equal([][]byte{nil, c.EncodeKey([]byte("a")), {}, c.EncodeKey([]byte("b"))}, encoded)
+	re.Nil(encoded[0])
+	re.NotNil(encoded[2])
+
+	decoded, err := c.DecodeKeys(encoded)
+	re.NoError(err)
+	re.Equal([][]byte{nil, []byte("a"), {}, []byte("b")}, decoded)
+
+	// the keys out of the keyspace can't be decoded.
+	_, err = c.DecodeKeys([][]byte{c.EncodeKey([]byte("a")), append(prevKeyspacePrefix, 'a')})
+	re.ErrorIs(err, errKeyOutOfBound)
+}
+
 func (suite *testCodecV2Suite) TestGetKeyspaceID() {
 	suite.Equal(KeyspaceID(testKeyspaceID), suite.codec.GetKeyspaceID())
 }
 
+func (suite *testCodecV2Suite) TestGetKeyspaceRange() {
+	start, end := suite.codec.GetKeyspaceRange()
+	suite.Equal(keyspacePrefix, start)
+	suite.Equal([]byte{'r', 0, 16, 147}, end)
+	// all keys of the keyspace are in the range.
+	key := suite.codec.EncodeKey([]byte{0xff, 0xff})
+	suite.True(bytes.Compare(start, key) <= 0 && bytes.Compare(key, end) < 0)
+	// the returned range is a copy.
+	start[0] = 'x'
+	suite.Equal(keyspacePrefix, suite.codec.GetKeyspace())
+
+	start, end = NewCodecV1(ModeRaw).GetKeyspaceRange()
+	suite.Nil(start)
+	suite.Nil(end)
+}
+
+func (suite *testCodecV2Suite) TestContainsKey() {
+	start, end := suite.codec.GetKeyspaceRange()
+	suite.True(ContainsKey(suite.codec, start))
+	suite.True(ContainsKey(suite.codec, suite.codec.EncodeKey([]byte{0xff, 0xff})))
+	suite.False(ContainsKey(suite.codec, end))
+	suite.False(ContainsKey(suite.codec, start[:3]))
+	suite.False(ContainsKey(suite.codec, []byte{'r', 0, 16, 145, 0xff}))
+	suite.False(ContainsKey(suite.codec, nil))
+
+	codecV1 := NewCodecV1(ModeRaw)
+	suite.True(ContainsKey(codecV1, nil))
+	suite.True(ContainsKey(codecV1, start))
+}
+
 func (suite *testCodecV2Suite) TestEncodeMPPRequest() {
 	req, err := suite.codec.EncodeRequest(&tikvrpc.Request{
 		Type: tikvrpc.CmdMPPTask,
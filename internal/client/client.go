@@ -37,6 +37,7 @@ package client
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"math"
@@ -400,6 +401,7 @@ func (a *connArray) Close() {
 type option struct {
 	gRPCDialOptions []grpc.DialOption
 	security        config.Security
+	tlsConfig       *tls.Config
 	dialTimeout     time.Duration
 	codec           apicodec.Codec
 }
@@ -414,6 +416,14 @@ func WithSecurity(security config.Security) Opt {
 	}
 }
 
+// WithTLSConfig is used to set the TLS config of the connections, which overrides the one derived from the
+// security config.
+func WithTLSConfig(tlsConfig *tls.Config) Opt {
+	return func(c *option) {
+		c.tlsConfig = tlsConfig
+	}
+}
+
 // WithGRPCDialOptions is used to set the grpc.DialOption.
 func WithGRPCDialOptions(grpcDialOptions ...grpc.DialOption) Opt {
 	return func(c *option) {
@@ -506,6 +516,11 @@ func (c *RPCClient) createConnArray(addr string, enableBatch bool, opts ...func(
 			opt(&client)
 		}
 		ver := c.vers[addr] + 1
+		dialOpts := c.option.gRPCDialOptions
+		if c.option.tlsConfig != nil {
+			// The transport credentials given later override the ones derived from the security config.
+			dialOpts = append([]grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(c.option.tlsConfig))}, dialOpts...)
+		}
 		array, err = newConnArray(
 			client.GrpcConnectionCount,
 			addr,
@@ -516,7 +531,7 @@ func (c *RPCClient) createConnArray(addr string, enableBatch bool, opts ...func(
 			c.option.dialTimeout,
 			c.connMonitor,
 			c.eventListener,
-			c.option.gRPCDialOptions)
+			dialOpts)
 
 		if err != nil {
 			return nil, err
@@ -568,6 +583,9 @@ type sendReqCounterCacheValue struct {
 }
 
 func (c *RPCClient) updateTiKVSendReqHistogram(req *tikvrpc.Request, resp *tikvrpc.Response, start time.Time, staleRead bool) {
+	if !metrics.Enabled() {
+		return
+	}
 	elapsed := time.Since(start)
 	secs := elapsed.Seconds()
 	storeID := req.Context.GetPeer().GetStoreId()
@@ -685,6 +703,8 @@ func (c *RPCClient) sendRequest(ctx context.Context, addr string, req *tikvrpc.R
 	// request to TiDB is not high frequency.
 	pri := req.GetResourceControlContext().GetOverridePriority()
 	if config.GetGlobalConfig().TiKVClient.MaxBatchSize > 0 && enableBatch {
+		// req has been encoded by SendRequest, so the batch entry needn't be encoded by
+		// Codec.EncodeBatchRequestEntry again.
 		if batchReq := req.ToBatchCommandsRequest(); batchReq != nil {
 			defer trace.StartRegion(ctx, req.Type.String()).End()
 			return wrapErrConn(sendBatchRequest(ctx, addr, req.ForwardedHost, connArray.batchConn, batchReq, timeout, pri))
@@ -732,7 +752,7 @@ func (c *RPCClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.R
 	}
 
 	codec := c.option.codec
-	req, err := codec.EncodeRequest(req)
+	req, err := apicodec.EncodeRequest(ctx, codec, req)
 	if err != nil {
 		return nil, err
 	}
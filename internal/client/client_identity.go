@@ -0,0 +1,53 @@
+// Copyright 2024 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/tikv/client-go/v2/tikvrpc"
+)
+
+var _ Client = identityClient{}
+
+type identityClient struct {
+	Client
+	identity string
+}
+
+// NewIdentityClient creates a Client which attaches the identity of the client to the requests as the session
+// alias of kvrpcpb.SourceStmt, so that TiKV can attribute the requests to the client in its logs. The session
+// alias already set in a request is kept.
+func NewIdentityClient(client Client, identity string) Client {
+	return identityClient{Client: client, identity: identity}
+}
+
+func (c identityClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	if len(c.identity) == 0 || len(req.Context.SourceStmt.GetSessionAlias()) > 0 {
+		return c.Client.SendRequest(ctx, addr, req, timeout)
+	}
+	// Shallow copy the request to avoid concurrent modification.
+	r := *req
+	stmt := kvrpcpb.SourceStmt{}
+	if req.Context.SourceStmt != nil {
+		stmt = *req.Context.SourceStmt
+	}
+	stmt.SessionAlias = c.identity
+	r.Context.SourceStmt = &stmt
+	tikvrpc.AttachContext(&r, r.Context)
+	return c.Client.SendRequest(ctx, addr, &r, timeout)
+}
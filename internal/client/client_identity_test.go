@@ -0,0 +1,61 @@
+// Copyright 2024 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/tikv/client-go/v2/tikvrpc"
+)
+
+type recordingClient struct {
+	emptyClient
+	req *tikvrpc.Request
+}
+
+func (c *recordingClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	c.req = req
+	return nil, nil
+}
+
+func TestIdentityClient(t *testing.T) {
+	inner := &recordingClient{}
+	client := NewIdentityClient(inner, "orders-service-pod-7")
+
+	req := tikvrpc.NewRequest(tikvrpc.CmdGet, &kvrpcpb.GetRequest{Key: []byte("k")}, kvrpcpb.Context{RegionId: 1})
+	_, _ = client.SendRequest(context.Background(), "", req, 0)
+	assert.Equal(t, "orders-service-pod-7", inner.req.Context.SourceStmt.GetSessionAlias())
+	assert.Equal(t, "orders-service-pod-7", inner.req.Get().GetContext().GetSourceStmt().GetSessionAlias())
+	assert.Equal(t, uint64(1), inner.req.Get().GetContext().GetRegionId())
+	// the original request is not modified.
+	assert.Nil(t, req.Context.SourceStmt)
+
+	// the other fields of the source stmt are kept.
+	req = tikvrpc.NewRequest(tikvrpc.CmdGet, &kvrpcpb.GetRequest{}, kvrpcpb.Context{SourceStmt: &kvrpcpb.SourceStmt{ConnectionId: 2}})
+	_, _ = client.SendRequest(context.Background(), "", req, 0)
+	assert.Equal(t, "orders-service-pod-7", inner.req.Context.SourceStmt.GetSessionAlias())
+	assert.Equal(t, uint64(2), inner.req.Context.SourceStmt.GetConnectionId())
+	assert.Empty(t, req.Context.SourceStmt.GetSessionAlias())
+
+	// the alias set by the caller is kept.
+	req = tikvrpc.NewRequest(tikvrpc.CmdGet, &kvrpcpb.GetRequest{}, kvrpcpb.Context{SourceStmt: &kvrpcpb.SourceStmt{SessionAlias: "alias"}})
+	_, _ = client.SendRequest(context.Background(), "", req, 0)
+	assert.Same(t, req, inner.req)
+	assert.Equal(t, "alias", inner.req.Context.SourceStmt.GetSessionAlias())
+}
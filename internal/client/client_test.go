@@ -36,9 +36,11 @@ package client
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"math"
 	"math/rand"
+	"net"
 	"runtime"
 	"strconv"
 	"strings"
@@ -99,6 +101,39 @@ func TestConn(t *testing.T) {
 	assert.Nil(t, conn4)
 }
 
+func TestConnWithTLSConfig(t *testing.T) {
+	defer config.UpdateGlobal(func(conf *config.Config) {
+		conf.TiKVClient.MaxBatchSize = 0
+		conf.TiKVClient.GrpcConnectionCount = 1
+	})()
+
+	// firstByte dials the listener and returns the first byte sent by the client, a TLS handshake starts with 0x16
+	// while a plaintext HTTP/2 connection starts with the preface "PRI".
+	firstByte := func(client *RPCClient) byte {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer l.Close()
+		conn, err := client.getConnArray(l.Addr().String(), false)
+		require.NoError(t, err)
+		conn.Get().Connect()
+		c, err := l.Accept()
+		require.NoError(t, err)
+		defer c.Close()
+		b := make([]byte, 1)
+		_, err = c.Read(b)
+		require.NoError(t, err)
+		return b[0]
+	}
+
+	client := NewRPCClient()
+	defer client.Close()
+	require.Equal(t, byte('P'), firstByte(client))
+
+	client = NewRPCClient(WithTLSConfig(&tls.Config{InsecureSkipVerify: true}))
+	defer client.Close()
+	require.Equal(t, byte(0x16), firstByte(client))
+}
+
 func TestGetConnAfterClose(t *testing.T) {
 	client := NewRPCClient()
 	defer client.Close()
@@ -72,6 +72,16 @@ func NewCodecPDClientWithKeyspace(mode apicodec.Mode, client pd.Client, keyspace
 	return &CodecPDClient{client, codec}, nil
 }
 
+// NewCodecPDClientWithKeyspaceID creates a CodecPDClient in API v2 with keyspace ID, the keyspace meta is not loaded
+// from PD so only its ID is set.
+func NewCodecPDClientWithKeyspaceID(mode apicodec.Mode, client pd.Client, keyspaceID uint32) (*CodecPDClient, error) {
+	codec, err := apicodec.NewCodecV2(mode, &keyspacepb.KeyspaceMeta{Id: keyspaceID})
+	if err != nil {
+		return nil, err
+	}
+	return &CodecPDClient{client, codec}, nil
+}
+
 // GetKeyspaceID attempts to retrieve keyspace ID corresponding to the given keyspace name from PD.
 func GetKeyspaceID(client pd.Client, name string) (uint32, error) {
 	meta, err := client.LoadKeyspace(context.Background(), apicodec.BuildKeyspaceName(name))
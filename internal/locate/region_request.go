@@ -1522,10 +1522,10 @@ func (s *RegionRequestSender) onRegionError(
 		if req != nil && s.replicaSelector != nil && s.replicaSelector.onFlashbackInProgress(req) {
 			return true, nil
 		}
-		return false, errors.Errorf(
-			"region %d is in flashback progress, FlashbackStartTS is %d",
-			flashbackInProgress.GetRegionId(), flashbackInProgress.GetFlashbackStartTs(),
-		)
+		return false, errors.WithStack(&tikverr.ErrFlashbackInProgress{
+			RegionID:         flashbackInProgress.GetRegionId(),
+			FlashbackVersion: flashbackInProgress.GetFlashbackStartTs(),
+		})
 	}
 	// This error means a second-phase flashback request is sent to a region that is not
 	// prepared for the flashback before, it should stop retrying immediately to avoid
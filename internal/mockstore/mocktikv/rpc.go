@@ -183,7 +183,8 @@ func (h kvHandler) handleKvGet(req *kvrpcpb.GetRequest) *kvrpcpb.GetResponse {
 		}
 	}
 	return &kvrpcpb.GetResponse{
-		Value: val,
+		Value:    val,
+		NotFound: val == nil,
 	}
 }
 
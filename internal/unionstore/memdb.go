@@ -36,13 +36,19 @@ package unionstore
 
 import (
 	"bytes"
+	"cmp"
 	"fmt"
 	"math"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"unsafe"
 
+	"github.com/pkg/errors"
 	tikverr "github.com/tikv/client-go/v2/error"
 	"github.com/tikv/client-go/v2/kv"
+	"github.com/tikv/client-go/v2/metrics"
+	"github.com/tikv/client-go/v2/util/redact"
 )
 
 var tombstone = []byte{}
@@ -67,8 +73,8 @@ func (h MemKeyHandle) toAddr() memdbArenaAddr {
 //
 // The value map is rollbackable, that means you can use the `Staging`, `Release` and `Cleanup` API to safely modify KVs.
 //
-// The flags map is not rollbackable. There are two types of flag, persistent and non-persistent.
-// When discarding a newly added KV in `Cleanup`, the non-persistent flags will be cleared.
+// The flags map is not rollbackable except for `ClearAllFlags`. There are two types of flag, persistent and
+// non-persistent. When discarding a newly added KV in `Cleanup`, the non-persistent flags will be cleared.
 // If there are persistent flags associated with key, we will keep this key in node without value.
 type MemDB struct {
 	// This RWMutex only used to ensure memdbSnapGetter.Get will not race with
@@ -82,10 +88,24 @@ type MemDB struct {
 	bufferSizeLimit uint64
 	count           int
 	size            int
+	// writes counts the Set and Delete calls which reach the MemDB.
+	writes uint64
+	// snapshotSeq is increased when the data of the existing snapshots may be discarded, see MemBufferSnapshot.
+	snapshotSeq uint64
 
 	vlogInvalid bool
 	dirty       bool
 	stages      []MemDBCheckpoint
+	// savepoints are the named savepoints from the oldest to the latest, see Savepoint.
+	savepoints []namedSavepoint
+	// flagsClears records the flags cleared by each ClearAllFlags call, so that they can be restored.
+	flagsClears [][]savedKeyFlags
+	// largeEntryHandler is called for the entries exceeding entrySizeLimit, see SetLargeEntryHandler.
+	largeEntryHandler LargeEntryHandler
+	// memChangeHook and memThresholds are triggered when the memory footprint changes, see
+	// SetMemoryFootprintChangeHook and SetMemoryThresholds.
+	memChangeHook atomic.Pointer[func(uint64)]
+	memThresholds atomic.Pointer[memoryThresholds]
 	// when the MemDB is wrapper by upper RWMutex, we can skip the internal mutex.
 	skipMutex bool
 }
@@ -111,7 +131,7 @@ func (db *MemDB) Staging() int {
 		defer db.Unlock()
 	}
 
-	db.stages = append(db.stages, db.vlog.checkpoint())
+	db.stages = append(db.stages, db.checkpoint())
 	return len(db.stages)
 }
 
@@ -155,6 +175,7 @@ func (db *MemDB) Cleanup(h int) {
 	}
 
 	cp := &db.stages[h-1]
+	db.restoreFlags(cp.flagsClears)
 	if !db.vlogInvalid {
 		curr := db.vlog.checkpoint()
 		if !curr.isSamePosition(cp) {
@@ -166,14 +187,27 @@ func (db *MemDB) Cleanup(h int) {
 	db.vlog.onMemChange()
 }
 
-// Checkpoint returns a checkpoint of MemDB.
+// Checkpoint returns a checkpoint of MemDB. It doesn't modify the MemDB, so it can be used to get the current
+// position, e.g. to validate a savepoint.
 func (db *MemDB) Checkpoint() *MemDBCheckpoint {
-	cp := db.vlog.checkpoint()
+	if !db.skipMutex {
+		db.RLock()
+		defer db.RUnlock()
+	}
+	cp := db.checkpoint()
 	return &cp
 }
 
+func (db *MemDB) checkpoint() MemDBCheckpoint {
+	cp := db.vlog.checkpoint()
+	cp.flagsClears = len(db.flagsClears)
+	return cp
+}
+
 // RevertToCheckpoint reverts the MemDB to the checkpoint.
 func (db *MemDB) RevertToCheckpoint(cp *MemDBCheckpoint) {
+	db.snapshotSeq++
+	db.restoreFlags(cp.flagsClears)
 	db.vlog.revertToCheckpoint(db, cp)
 	db.vlog.truncate(cp)
 	db.vlog.onMemChange()
@@ -183,10 +217,14 @@ func (db *MemDB) RevertToCheckpoint(cp *MemDBCheckpoint) {
 func (db *MemDB) Reset() {
 	db.root = nullAddr
 	db.stages = db.stages[:0]
+	db.savepoints = nil
+	db.flagsClears = nil
 	db.dirty = false
 	db.vlogInvalid = false
 	db.size = 0
 	db.count = 0
+	db.writes = 0
+	db.snapshotSeq++
 	db.vlog.reset()
 	db.allocator.reset()
 }
@@ -195,6 +233,7 @@ func (db *MemDB) Reset() {
 // NOTE: any operation need value will panic after this function.
 func (db *MemDB) DiscardValues() {
 	db.vlogInvalid = true
+	db.snapshotSeq++
 	db.vlog.reset()
 }
 
@@ -259,6 +298,43 @@ func (db *MemDB) UpdateFlags(key []byte, ops ...kv.FlagsOp) {
 	_ = err // set without value will never fail
 }
 
+// savedKeyFlags is the flags of a node before they are cleared by ClearAllFlags.
+type savedKeyFlags struct {
+	addr  memdbArenaAddr
+	flags kv.KeyFlags
+}
+
+// ClearAllFlags resets the flags of all keys to zero in one pass, the values are kept. Unlike the other flag updates,
+// it's reverted by Cleanup and RevertToCheckpoint, which restore the flags cleared after the staging buffer is
+// created or the checkpoint is taken.
+func (db *MemDB) ClearAllFlags() {
+	if !db.skipMutex {
+		db.Lock()
+		defer db.Unlock()
+	}
+
+	var saved []savedKeyFlags
+	for it := db.IterWithFlags(nil, nil); it.Valid(); _ = it.Next() {
+		if flags := it.curr.getKeyFlags(); flags != 0 {
+			saved = append(saved, savedKeyFlags{addr: it.curr.addr, flags: flags})
+			it.curr.setKeyFlags(0)
+		}
+	}
+	db.flagsClears = append(db.flagsClears, saved)
+}
+
+// restoreFlags restores the flags cleared by the ClearAllFlags calls after the first n ones.
+func (db *MemDB) restoreFlags(n int) {
+	for i := len(db.flagsClears) - 1; i >= n; i-- {
+		for _, saved := range db.flagsClears[i] {
+			db.getNode(saved.addr).setKeyFlags(saved.flags)
+		}
+	}
+	if n < len(db.flagsClears) {
+		db.flagsClears = db.flagsClears[:n]
+	}
+}
+
 // Set sets the value for key k as v into kv store.
 // v must NOT be nil or empty, otherwise it returns ErrCannotSetNilValue.
 func (db *MemDB) Set(key []byte, value []byte) error {
@@ -276,6 +352,45 @@ func (db *MemDB) SetWithFlags(key []byte, value []byte, ops ...kv.FlagsOp) error
 	return db.set(key, value, ops...)
 }
 
+// TrySet sets the value of key like Set, but the buffer size limit is checked before writing. If the limit would be
+// exceeded, ErrTxnTooLarge carrying the projected size is returned and the MemDB is left untouched.
+func (db *MemDB) TrySet(key []byte, value []byte) error {
+	if len(value) == 0 {
+		return tikverr.ErrCannotSetNilValue
+	}
+	if !db.skipMutex {
+		db.Lock()
+		defer db.Unlock()
+	}
+	if db.vlogInvalid {
+		// panic for easier debugging.
+		panic("vlog is resetted")
+	}
+	// the value may be replaced by the large entry handler, whose size is what set accounts.
+	value, err := db.limitEntrySize(key, value)
+	if err != nil {
+		return err
+	}
+	if size := db.projectedSize(key, value); uint64(size) > db.bufferSizeLimit {
+		return &tikverr.ErrTxnTooLarge{Size: size}
+	}
+	return db.setLocked(key, value)
+}
+
+// projectedSize returns the size of the MemDB after setting value to key, accounted the same way as set: the key is
+// counted once its node is allocated, even if the node only has flags, and the value replaces the old one.
+func (db *MemDB) projectedSize(key, value []byte) int {
+	size := db.size + len(value)
+	x := db.traverse(key, false)
+	if x.isNull() {
+		return size + len(key)
+	}
+	if !x.vptr.isNull() {
+		size -= len(db.vlog.getValue(x.vptr))
+	}
+	return size
+}
+
 // Delete removes the entry for key k from kv store.
 func (db *MemDB) Delete(key []byte) error {
 	return db.set(key, tombstone)
@@ -309,6 +424,132 @@ func (db *MemDB) Len() int {
 	return db.count
 }
 
+// OpType is the type of a mutation yielded by MemDB.ChangesSince.
+type OpType int
+
+const (
+	// OpPut sets the value of a key.
+	OpPut OpType = iota
+	// OpDelete deletes a key, the value is empty.
+	OpDelete
+)
+
+// CurrentSeq returns the sequence of the latest write, which can be used as the watermark of ChangesSince. The
+// sequences are only tracked since the first call, and the values are no longer overwritten in place after that.
+func (db *MemDB) CurrentSeq() uint64 {
+	if !db.skipMutex {
+		db.Lock()
+		defer db.Unlock()
+	}
+	db.vlog.trackSeq = true
+	return db.vlog.seq
+}
+
+// ChangesSince calls f with the writes whose sequences are larger than seq in the order of the sequences. seq
+// should be returned by CurrentSeq, the writes reverted by Cleanup or RevertToCheckpoint and the keys removed by
+// RemoveFromBuffer or PurgeTombstonesBefore are skipped. The key and value are only valid in f, and f must not modify
+// the MemDB. It stops when f returns stop or an error, and the error is returned.
+func (db *MemDB) ChangesSince(seq uint64, f func(key, value []byte, op OpType, seq uint64) (stop bool, err error)) error {
+	if !db.skipMutex {
+		db.RLock()
+		defer db.RUnlock()
+	}
+	if db.vlogInvalid {
+		return errors.New("cannot iterate the changes of a MemDB whose values are discarded")
+	}
+
+	seqs := db.vlog.seqs
+	start, _ := slices.BinarySearchFunc(seqs, seq+1, func(s memdbVlogSeq, seq uint64) int {
+		return cmp.Compare(s.seq, seq)
+	})
+	for _, s := range seqs[start:] {
+		hdrOff := s.addr.off - memdbVlogHdrSize
+		block := db.vlog.blocks[s.addr.idx].buf
+		var hdr memdbVlogHdr
+		hdr.load(block[hdrOff:])
+		key := db.allocator.getNode(hdr.nodeAddr).getKey()
+		if db.traverse(key, false).addr != hdr.nodeAddr {
+			continue
+		}
+		value := block[hdrOff-hdr.valueLen : hdrOff]
+		op := OpPut
+		if len(value) == 0 {
+			op = OpDelete
+		}
+		if stop, err := f(key, value, op, s.seq); err != nil || stop {
+			return err
+		}
+	}
+	return nil
+}
+
+// IterChangesSince returns an iterator over the keys whose values are written after cp in the order of the keys, cp
+// must be taken from the MemDB. Only the part of the vlog after cp is walked, and a key written multiple times is
+// yielded once with its current value, which may be a deletion. The keys whose values are reverted, the keys removed
+// by RemoveFromBuffer or PurgeTombstonesBefore and the changes of flags are not yielded. Like the other iterators,
+// it's invalidated by writing the MemDB.
+// Like RevertToCheckpoint, the changes are complete only if they are made in a staging buffer created since cp, since
+// a value outside the staging buffers can be overwritten in place with a value of the same length, which leaves
+// nothing after cp in the vlog.
+func (db *MemDB) IterChangesSince(cp *MemDBCheckpoint) (ChangesIterator, error) {
+	if !db.skipMutex {
+		db.RLock()
+		defer db.RUnlock()
+	}
+	if db.vlogInvalid {
+		return nil, errors.New("cannot iterate the changes of a MemDB whose values are discarded")
+	}
+	if err := db.checkCheckpoint(cp); err != nil {
+		return nil, err
+	}
+
+	it := &memdbChangesIterator{}
+	cursor := db.vlog.checkpoint()
+	for !cursor.isSamePosition(cp) {
+		cursorAddr := memdbArenaAddr{idx: uint32(cursor.blocks - 1), off: uint32(cursor.offsetInBlock)}
+		hdrOff := cursorAddr.off - memdbVlogHdrSize
+		block := db.vlog.blocks[cursorAddr.idx].buf
+		var hdr memdbVlogHdr
+		hdr.load(block[hdrOff:])
+		// Only the latest value of a key is pointed by its node.
+		if node := db.allocator.getNode(hdr.nodeAddr); node.vptr == cursorAddr {
+			key := node.getKey()
+			if db.traverse(key, false).addr == hdr.nodeAddr {
+				it.changes = append(it.changes, memdbChange{key: key, value: block[hdrOff-hdr.valueLen : hdrOff]})
+			}
+		}
+		db.vlog.moveBackCursor(&cursor, &hdr)
+	}
+	slices.SortFunc(it.changes, func(a, b memdbChange) int {
+		return bytes.Compare(a.key, b.key)
+	})
+	return it, nil
+}
+
+// AssertKeysInRange checks that all keys in the MemDB, including the keys that only have flags, are within
+// [lower, upper). An empty upper means unbounded. It returns an error naming the first key out of the range, which
+// catches the keys encoded twice or with a wrong prefix. Since the keys are sorted, only the keys at both ends are
+// checked.
+func (db *MemDB) AssertKeysInRange(lower, upper []byte) error {
+	if !db.skipMutex {
+		db.RLock()
+		defer db.RUnlock()
+	}
+	if it := db.IterWithFlags(nil, nil); it.Valid() && bytes.Compare(it.Key(), lower) < 0 {
+		return keyOutOfRangeErr(it.Key(), lower, upper)
+	}
+	if len(upper) > 0 {
+		if it := db.IterWithFlags(upper, nil); it.Valid() {
+			return keyOutOfRangeErr(it.Key(), lower, upper)
+		}
+	}
+	return nil
+}
+
+func keyOutOfRangeErr(key, lower, upper []byte) error {
+	return errors.Errorf("key %s is out of range [%s, %s)", redact.Key(key), redact.Key(lower), redact.Key(upper))
+}
+
 // Size returns sum of keys and values length.
 func (db *MemDB) Size() int {
 	return db.size
@@ -319,6 +560,12 @@ func (db *MemDB) Dirty() bool {
 	return db.dirty
 }
 
+// WriteAmplificationStats returns the count of Set and Delete calls and the count of distinct keys in the DB,
+// the overwrite ratio can be computed by them.
+func (db *MemDB) WriteAmplificationStats() (totalWrites, distinctKeys uint64) {
+	return db.writes, uint64(db.count)
+}
+
 func (db *MemDB) set(key []byte, value []byte, ops ...kv.FlagsOp) error {
 	if !db.skipMutex {
 		db.Lock()
@@ -331,14 +578,49 @@ func (db *MemDB) set(key []byte, value []byte, ops ...kv.FlagsOp) error {
 	}
 
 	if value != nil {
-		if size := uint64(len(key) + len(value)); size > db.entrySizeLimit {
-			return &tikverr.ErrEntryTooLarge{
-				Limit: db.entrySizeLimit,
-				Size:  size,
-			}
+		var err error
+		if value, err = db.limitEntrySize(key, value); err != nil {
+			return err
 		}
 	}
+	return db.setLocked(key, value, ops...)
+}
 
+// limitEntrySize checks the entry against the entry size limit, and returns the value to be buffered, which is
+// replaced by the large entry handler if the entry is too large.
+func (db *MemDB) limitEntrySize(key, value []byte) ([]byte, error) {
+	size := uint64(len(key) + len(value))
+	if size <= db.entrySizeLimit {
+		return value, nil
+	}
+	// deletions are never passed to the handler, they can't be replaced by other values.
+	if db.largeEntryHandler == nil || len(value) == 0 {
+		return nil, &tikverr.ErrEntryTooLarge{
+			Limit: db.entrySizeLimit,
+			Size:  size,
+			Key:   key,
+		}
+	}
+	replacement, err := db.largeEntryHandler(key, value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "handle large entry, key: %s, size: %d, limit: %d", redact.Key(key), size, db.entrySizeLimit)
+	}
+	if len(replacement) == 0 {
+		return nil, errors.Errorf("the large entry handler returns an empty value, key: %s", redact.Key(key))
+	}
+	if size := uint64(len(key) + len(replacement)); size > db.entrySizeLimit {
+		return nil, &tikverr.ErrEntryTooLarge{
+			Limit: db.entrySizeLimit,
+			Size:  size,
+			Key:   key,
+		}
+	}
+	return replacement, nil
+}
+
+// setLocked sets the value and applies the flags ops to key, the value must be within the entry size limit. The
+// caller should hold the lock.
+func (db *MemDB) setLocked(key []byte, value []byte, ops ...kv.FlagsOp) error {
 	if len(db.stages) == 0 {
 		db.dirty = true
 	}
@@ -364,12 +646,46 @@ func (db *MemDB) set(key []byte, value []byte, ops ...kv.FlagsOp) error {
 	}
 
 	db.setValue(x, value)
+	db.writes++
 	if uint64(db.Size()) > db.bufferSizeLimit {
-		return &tikverr.ErrTxnTooLarge{Size: db.Size()}
+		return &tikverr.ErrTxnTooLarge{Size: db.Size(), TopPrefixes: db.topPrefixes()}
 	}
 	return nil
 }
 
+const (
+	// txnTooLargePrefixLen is the length of the key prefixes reported by ErrTxnTooLarge.
+	txnTooLargePrefixLen = 16
+	// txnTooLargeTopPrefixes is the max number of the key prefixes reported by ErrTxnTooLarge.
+	txnTooLargeTopPrefixes = 5
+)
+
+// topPrefixes sums up the sizes of the entries by the key prefixes and returns the largest ones. It walks the whole
+// tree, so it's only called when the buffer size limit is exceeded.
+func (db *MemDB) topPrefixes() []tikverr.PrefixSize {
+	sizes := make(map[string]int)
+	it := &MemdbIterator{db: db}
+	for it.seekToFirst(); it.Valid(); _ = it.Next() {
+		if it.isFlagsOnly() {
+			continue
+		}
+		key := it.Key()
+		prefix := key[:min(len(key), txnTooLargePrefixLen)]
+		sizes[string(prefix)] += len(key) + len(it.Value())
+	}
+	prefixes := make([]tikverr.PrefixSize, 0, len(sizes))
+	for prefix, size := range sizes {
+		prefixes = append(prefixes, tikverr.PrefixSize{Prefix: []byte(prefix), Size: size})
+	}
+	slices.SortFunc(prefixes, func(a, b tikverr.PrefixSize) int {
+		if a.Size != b.Size {
+			return cmp.Compare(b.Size, a.Size)
+		}
+		return bytes.Compare(a.Prefix, b.Prefix)
+	})
+	return prefixes[:min(len(prefixes), txnTooLargeTopPrefixes)]
+}
+
 func (db *MemDB) setValue(x memdbNodeAddr, value []byte) {
 	var activeCp *MemDBCheckpoint
 	if len(db.stages) > 0 {
@@ -384,7 +700,7 @@ func (db *MemDB) setValue(x memdbNodeAddr, value []byte) {
 	if len(oldVal) > 0 && db.vlog.canModify(activeCp, x.vptr) {
 		// For easier to implement, we only consider this case.
 		// It is the most common usage in TiDB's transaction buffers.
-		if len(oldVal) == len(value) {
+		if len(oldVal) == len(value) && !db.vlog.trackSeq {
 			copy(oldVal, value)
 			return
 		}
@@ -875,26 +1191,201 @@ func (db *MemDB) RemoveFromBuffer(key []byte) {
 	db.deleteNode(x)
 }
 
+// PurgeTombstonesBefore removes the nodes of the keys whose latest values are tombstones written before cp and
+// won't be committed, and returns how many keys are removed. A tombstone is committed as a Delete or Lock mutation
+// unless the key is newly inserted by the transaction and not locked, so only such tombstones are purged, the others
+// are kept to make sure the deletions reach TiKV. A purged key reads as not existing in the MemDB instead of
+// deleted, and the key doesn't exist in the snapshot either. cp must be taken from the MemDB and be stable, that
+// is, no staging buffer is created before it, since the MemDB can't be reverted to a state before cp any more. The
+// checkpoints after cp can still be reverted to. The existing snapshots are invalidated if any key is purged.
+func (db *MemDB) PurgeTombstonesBefore(cp *MemDBCheckpoint) (int, error) {
+	if !db.skipMutex {
+		db.Lock()
+		defer db.Unlock()
+	}
+	if db.vlogInvalid {
+		return 0, errors.New("cannot purge the tombstones of a MemDB whose values are discarded")
+	}
+	if err := db.checkStableCheckpoint(cp); err != nil {
+		return 0, err
+	}
+	return db.purgeTombstones(cp, isUncommittedTombstone), nil
+}
+
+// isUncommittedTombstone reports whether a tombstone with the flags is skipped when the mutations are built for 2PC.
+func isUncommittedTombstone(flags kv.KeyFlags) bool {
+	return flags.HasNewlyInserted() && !flags.HasLocked() && !flags.HasNeedLocked() && !flags.HasPresumeKeyNotExists()
+}
+
+// purgeTombstones removes the nodes of the keys whose latest values are tombstones and whose flags are accepted by
+// purgeable. If cp isn't nil, only the tombstones written before cp whose flags can't be restored by reverting to a
+// later checkpoint are removed. The caller should hold the lock.
+func (db *MemDB) purgeTombstones(cp *MemDBCheckpoint, purgeable func(kv.KeyFlags) bool) int {
+	// The flags cleared after cp may be restored to the nodes by reverting to a later checkpoint.
+	restorable := make(map[memdbArenaAddr]struct{})
+	if cp != nil {
+		for _, saved := range db.flagsClears[cp.flagsClears:] {
+			for _, f := range saved {
+				restorable[f.addr] = struct{}{}
+			}
+		}
+	}
+	var purged []memdbNodeAddr
+	for it := db.IterWithFlags(nil, nil); it.Valid(); _ = it.Next() {
+		x := it.curr
+		if x.vptr.isNull() || !purgeable(x.getKeyFlags()) {
+			continue
+		}
+		if cp != nil && db.vlog.canModify(cp, x.vptr) {
+			continue
+		}
+		if _, ok := restorable[x.addr]; ok {
+			continue
+		}
+		if IsTombstone(db.vlog.getValue(x.vptr)) {
+			purged = append(purged, x)
+		}
+	}
+	// deleteNode doesn't move the other nodes, so the collected addresses are still valid.
+	for _, x := range purged {
+		db.deleteNode(x)
+	}
+	if len(purged) > 0 {
+		db.snapshotSeq++
+	}
+	return len(purged)
+}
+
+func (db *MemDB) checkStableCheckpoint(cp *MemDBCheckpoint) error {
+	if err := db.checkCheckpoint(cp); err != nil {
+		return err
+	}
+	for i := range db.stages {
+		if cp.isAfter(&db.stages[i]) {
+			return errors.Errorf("checkpoint %+v is not stable, the staging buffer %d is created before it", *cp, i+1)
+		}
+	}
+	return nil
+}
+
+// checkCheckpoint checks that cp is taken from the MemDB and isn't reverted.
+func (db *MemDB) checkCheckpoint(cp *MemDBCheckpoint) error {
+	if cp == nil {
+		return errors.New("checkpoint is nil")
+	}
+	curr := db.checkpoint()
+	if cp.isAfter(&curr) || cp.flagsClears > len(db.flagsClears) ||
+		(cp.blocks > 0 && cp.offsetInBlock > db.vlog.blocks[cp.blocks-1].length) {
+		return errors.Errorf("checkpoint %+v doesn't belong to the MemDB", *cp)
+	}
+	return nil
+}
+
 // SetMemoryFootprintChangeHook sets the hook function that is triggered when memdb grows.
 func (db *MemDB) SetMemoryFootprintChangeHook(hook func(uint64)) {
-	innerHook := func() {
-		hook(db.allocator.capacity + db.vlog.capacity)
-	}
+	db.memChangeHook.Store(&hook)
+	db.installMemChangeHook()
+}
+
+// SetMemoryThresholds sets the thresholds of the memory footprint, the callback of a threshold is called once when
+// the memory footprint grows to its limit, and it's re-armed after the memory footprint drops below 80% of the
+// limit. It replaces the thresholds set before, and works along with the hook set by SetMemoryFootprintChangeHook.
+func (db *MemDB) SetMemoryThresholds(thresholds []MemoryThreshold) {
+	db.memThresholds.Store(newMemoryThresholds(thresholds))
+	db.installMemChangeHook()
+}
+
+func (db *MemDB) installMemChangeHook() {
+	innerHook := db.onMemChange
 	db.allocator.memChangeHook.Store(&innerHook)
 	db.vlog.memChangeHook.Store(&innerHook)
 }
 
+func (db *MemDB) onMemChange() {
+	mem := db.Mem()
+	if hook := db.memChangeHook.Load(); hook != nil {
+		(*hook)(mem)
+	}
+	db.memThresholds.Load().check(mem, db.Len())
+}
+
 // Mem returns the current memory footprint
 func (db *MemDB) Mem() uint64 {
 	return db.allocator.capacity + db.vlog.capacity
 }
 
+// ArenaStats describes how much of the memory allocated by the arenas of a MemDB is live.
+type ArenaStats struct {
+	// NodeCapacity is the total size of the blocks allocated for the nodes.
+	NodeCapacity uint64
+	// NodeBytes is the size of the nodes allocated in the blocks, including the freed ones.
+	NodeBytes uint64
+	// FreedNodeBytes is the size of the nodes freed by RemoveFromBuffer, which are not reused.
+	FreedNodeBytes uint64
+	// VlogCapacity is the total size of the blocks allocated for the values.
+	VlogCapacity uint64
+	// VlogBytes is the size of the values allocated in the blocks, including the overwritten ones.
+	VlogBytes uint64
+	// Keys is the number of the live nodes.
+	Keys int
+}
+
+// Fragmentation estimates the ratio of the allocated memory that is not used by the live nodes, that is, the
+// unused space at the end of the blocks and the freed nodes. The overwritten values are counted as live, since
+// they are kept for the staging buffers.
+func (s ArenaStats) Fragmentation() float64 {
+	capacity := s.NodeCapacity + s.VlogCapacity
+	if capacity == 0 {
+		return 0
+	}
+	return 1 - float64(s.NodeBytes-s.FreedNodeBytes+s.VlogBytes)/float64(capacity)
+}
+
+// Stats returns the memory usage of the arenas of the MemDB.
+func (db *MemDB) Stats() ArenaStats {
+	if !db.skipMutex {
+		db.RLock()
+		defer db.RUnlock()
+	}
+	return ArenaStats{
+		NodeCapacity:   db.allocator.capacity,
+		NodeBytes:      db.allocator.used(),
+		FreedNodeBytes: db.allocator.freedBytes,
+		VlogCapacity:   db.vlog.capacity,
+		VlogBytes:      db.vlog.used(),
+		Keys:           db.count,
+	}
+}
+
+// observeArenaStats reports the ArenaStats of a memdb of the given type to the metrics.
+func observeArenaStats(memdbType string, stats ArenaStats) {
+	metrics.TiKVMemDBArenaBytes.WithLabelValues(memdbType, "node_capacity").Set(float64(stats.NodeCapacity))
+	metrics.TiKVMemDBArenaBytes.WithLabelValues(memdbType, "node").Set(float64(stats.NodeBytes))
+	metrics.TiKVMemDBArenaBytes.WithLabelValues(memdbType, "freed_node").Set(float64(stats.FreedNodeBytes))
+	metrics.TiKVMemDBArenaBytes.WithLabelValues(memdbType, "vlog_capacity").Set(float64(stats.VlogCapacity))
+	metrics.TiKVMemDBArenaBytes.WithLabelValues(memdbType, "vlog").Set(float64(stats.VlogBytes))
+	metrics.TiKVMemDBArenaFragmentation.WithLabelValues(memdbType).Set(stats.Fragmentation())
+}
+
 // SetEntrySizeLimit sets the size limit for each entry and total buffer.
 func (db *MemDB) SetEntrySizeLimit(entryLimit, bufferLimit uint64) {
 	db.entrySizeLimit = entryLimit
 	db.bufferSizeLimit = bufferLimit
 }
 
+// LargeEntryHandler handles an entry whose size exceeds the entry size limit. It returns the value to be stored
+// instead, e.g. a reference to the original value spilled to an external storage. The replacement must be non-empty
+// and fit the entry size limit, otherwise the write fails with ErrEntryTooLarge. An error returned by the handler
+// fails the write and nothing is buffered.
+// The handler is called with the lock of the MemDB held, so it must not access the MemBuffer.
+type LargeEntryHandler func(key, value []byte) ([]byte, error)
+
+// SetLargeEntryHandler sets the handler for the entries exceeding the entry size limit. The entries are rejected
+// with ErrEntryTooLarge if the handler is nil.
+func (db *MemDB) SetLargeEntryHandler(h LargeEntryHandler) {
+	db.largeEntryHandler = h
+}
+
 func (db *MemDB) setSkipMutex(skip bool) {
 	db.skipMutex = skip
 }
@@ -142,6 +142,15 @@ func (a *memdbArena) onMemChange() {
 	}
 }
 
+// used returns the size of the allocated memory in all blocks.
+func (a *memdbArena) used() uint64 {
+	var used uint64
+	for i := range a.blocks {
+		used += uint64(a.blocks[i].length)
+	}
+	return used
+}
+
 func (a *memdbArena) allocInLastBlock(size int, align bool) (memdbArenaAddr, []byte) {
 	idx := len(a.blocks) - 1
 	offset, data := a.blocks[idx].alloc(size, align)
@@ -191,12 +200,18 @@ type MemDBCheckpoint struct {
 	blockSize     int
 	blocks        int
 	offsetInBlock int
+	// flagsClears is the number of the ClearAllFlags records of the MemDB when the checkpoint is taken.
+	flagsClears int
 }
 
 func (cp *MemDBCheckpoint) isSamePosition(other *MemDBCheckpoint) bool {
 	return cp.blocks == other.blocks && cp.offsetInBlock == other.offsetInBlock
 }
 
+func (cp *MemDBCheckpoint) isAfter(other *MemDBCheckpoint) bool {
+	return cp.blocks > other.blocks || (cp.blocks == other.blocks && cp.offsetInBlock > other.offsetInBlock)
+}
+
 func (a *memdbArena) checkpoint() MemDBCheckpoint {
 	snap := MemDBCheckpoint{
 		blockSize: a.blockSize,
@@ -232,6 +247,9 @@ type nodeAllocator struct {
 	// We then use this instead of NULL to mean the top or bottom
 	// end of the rb tree. It is a black node.
 	nullNode memdbNode
+
+	// freedBytes is the size of the freed nodes, which stay in the arena since they are not reused.
+	freedBytes uint64
 }
 
 func (a *nodeAllocator) init() {
@@ -251,10 +269,14 @@ func (a *nodeAllocator) getNode(addr memdbArenaAddr) *memdbNode {
 	return (*memdbNode)(unsafe.Pointer(&a.blocks[addr.idx].buf[addr.off]))
 }
 
+// memdbNodeSize returns the size of the node of a key with length klen.
+func memdbNodeSize(klen int) int {
+	return 8*4 + 2 + kv.FlagBytes + klen
+}
+
 func (a *nodeAllocator) allocNode(key []byte) (memdbArenaAddr, *memdbNode) {
-	nodeSize := 8*4 + 2 + kv.FlagBytes + len(key)
 	prevBlocks := len(a.blocks)
-	addr, mem := a.alloc(nodeSize, true)
+	addr, mem := a.alloc(memdbNodeSize(len(key)), true)
 	n := (*memdbNode)(unsafe.Pointer(&mem[0]))
 	n.vptr = nullAddr
 	n.klen = uint16(len(key))
@@ -268,6 +290,7 @@ func (a *nodeAllocator) allocNode(key []byte) (memdbArenaAddr, *memdbNode) {
 var testMode = false
 
 func (a *nodeAllocator) freeNode(addr memdbArenaAddr) {
+	a.freedBytes += uint64(memdbNodeSize(int(a.getNode(addr).klen)))
 	if testMode {
 		// Make it easier for debug.
 		n := a.getNode(addr)
@@ -285,11 +308,25 @@ func (a *nodeAllocator) freeNode(addr memdbArenaAddr) {
 func (a *nodeAllocator) reset() {
 	a.memdbArena.reset()
 	a.init()
+	a.freedBytes = 0
 }
 
 type memdbVlog struct {
 	memdbArena
 	memdb *MemDB
+	// trackSeq is set by the first MemDB.CurrentSeq. After that, every write is appended to the vlog and gets a
+	// sequence in seqs, the values are no longer overwritten in place.
+	trackSeq bool
+	// seq is the sequence of the latest write, it's never decreased even if the writes are reverted or reset.
+	seq uint64
+	// seqs are the sequences of the values in the vlog written since trackSeq is set, in the order of the vlog.
+	seqs []memdbVlogSeq
+}
+
+// memdbVlogSeq is the sequence of the value ended at addr.
+type memdbVlogSeq struct {
+	addr memdbArenaAddr
+	seq  uint64
 }
 
 const memdbVlogHdrSize = 8 + 8 + 4
@@ -328,6 +365,10 @@ func (l *memdbVlog) appendValue(nodeAddr memdbArenaAddr, oldValue memdbArenaAddr
 	hdr.store(mem[len(value):])
 
 	addr.off += uint32(size)
+	if l.trackSeq {
+		l.seq++
+		l.seqs = append(l.seqs, memdbVlogSeq{addr, l.seq})
+	}
 	if prevBlocks != len(l.blocks) {
 		l.onMemChange()
 	}
@@ -353,7 +394,7 @@ func (l *memdbVlog) getSnapshotValue(addr memdbArenaAddr, snap *MemDBCheckpoint)
 	if result.isNull() {
 		return nil, false
 	}
-	return l.getValue(addr), true
+	return l.getValue(result), true
 }
 
 func (l *memdbVlog) selectValueHistory(addr memdbArenaAddr, predicate func(memdbArenaAddr) bool) memdbArenaAddr {
@@ -368,6 +409,18 @@ func (l *memdbVlog) selectValueHistory(addr memdbArenaAddr, predicate func(memdb
 	return nullAddr
 }
 
+func (l *memdbVlog) truncate(snap *MemDBCheckpoint) {
+	l.memdbArena.truncate(snap)
+	for len(l.seqs) > 0 && l.canModify(snap, l.seqs[len(l.seqs)-1].addr) {
+		l.seqs = l.seqs[:len(l.seqs)-1]
+	}
+}
+
+func (l *memdbVlog) reset() {
+	l.memdbArena.reset()
+	l.seqs = l.seqs[:0]
+}
+
 func (l *memdbVlog) revertToCheckpoint(db *MemDB, cp *MemDBCheckpoint) {
 	cursor := l.checkpoint()
 	for !cp.isSamePosition(&cursor) {
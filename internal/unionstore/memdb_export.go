@@ -0,0 +1,278 @@
+// Copyright 2024 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unionstore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+
+	"github.com/pkg/errors"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/kv"
+)
+
+// The exported mutations are encoded as:
+//
+//	header:  magic (4 bytes) | version (1 byte)
+//	entry:   kind (1 byte) | key length (uvarint) | key | flags (2 bytes) [| value length (uvarint) | value]
+//	trailer: kind end (1 byte) | CRC32 (IEEE) of all the bytes above (4 bytes)
+//
+// The integers of fixed size are big endian.
+var mutationsMagic = []byte("TKVM")
+
+const (
+	mutationsVersion = 1
+
+	mutationEnd       = 0
+	mutationFlagsOnly = 1
+	mutationValue     = 2
+)
+
+// ExportMutations writes the keys in the MemDB with their flags and values to w, which can be restored by
+// ImportMutations into an empty MemBuffer. Only the final state is exported, the staging buffers are not kept. The
+// keys that only have flags are exported too, and an empty value, which means deletion, is kept as is.
+func (db *MemDB) ExportMutations(w io.Writer) error {
+	if !db.skipMutex {
+		db.RLock()
+		defer db.RUnlock()
+	}
+	if db.vlogInvalid {
+		return errors.New("cannot export a MemDB whose values are discarded")
+	}
+	return writeMutations(w, func(emit func(key []byte, flags kv.KeyFlags, hasValue bool, value []byte)) {
+		for it := db.IterWithFlags(nil, nil); it.Valid(); _ = it.Next() {
+			if !it.HasValue() {
+				emit(it.Key(), it.Flags(), false, nil)
+				continue
+			}
+			emit(it.Key(), it.Flags(), true, it.Value())
+		}
+	})
+}
+
+// Export writes the keys in the snapshot of the MemDB, see SnapshotGetter, to w in the format of ExportMutations,
+// which can be restored by Import. Unlike ExportMutations, the changes in the staging buffers are excluded, so it can
+// run with concurrent writes to the staging buffers like the reads of MemBufferSnapshot. The keys whose values are only
+// written in the staging buffers are skipped.
+func (db *MemDB) Export(w io.Writer) error {
+	db.RLock()
+	defer db.RUnlock()
+	if db.vlogInvalid {
+		return errors.New("cannot export a MemDB whose values are discarded")
+	}
+	cp := db.getSnapshot()
+	return writeMutations(w, func(emit func(key []byte, flags kv.KeyFlags, hasValue bool, value []byte)) {
+		it := &MemdbIterator{db: db, includeFlags: true}
+		for it.seekToFirst(); it.Valid(); _ = it.Next() {
+			if it.isFlagsOnly() {
+				emit(it.Key(), it.Flags(), false, nil)
+				continue
+			}
+			if v, ok := db.vlog.getSnapshotValue(it.curr.vptr, &cp); ok {
+				emit(it.Key(), it.Flags(), true, v)
+			}
+		}
+	})
+}
+
+// Import restores the keys written by Export into the MemDB like ImportMutations.
+func (db *MemDB) Import(r io.Reader) error {
+	return db.ImportMutations(r)
+}
+
+// writeMutations writes the header, the entries emitted by iterate in order and the trailer to w.
+func writeMutations(w io.Writer, iterate func(emit func(key []byte, flags kv.KeyFlags, hasValue bool, value []byte))) error {
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+	var buf [binary.MaxVarintLen64]byte
+	writeUvarint := func(v uint64) {
+		_, _ = bw.Write(buf[:binary.PutUvarint(buf[:], v)])
+	}
+
+	_, _ = bw.Write(mutationsMagic)
+	_ = bw.WriteByte(mutationsVersion)
+	iterate(func(key []byte, flags kv.KeyFlags, hasValue bool, value []byte) {
+		kind := byte(mutationFlagsOnly)
+		if hasValue {
+			kind = mutationValue
+		}
+		_ = bw.WriteByte(kind)
+		writeUvarint(uint64(len(key)))
+		_, _ = bw.Write(key)
+		_, _ = bw.Write(binary.BigEndian.AppendUint16(buf[:0], uint16(flags)))
+		if hasValue {
+			writeUvarint(uint64(len(value)))
+			_, _ = bw.Write(value)
+		}
+	})
+	_ = bw.WriteByte(mutationEnd)
+	// Flush before writing the checksum, so that crc covers all the bytes above.
+	if err := bw.Flush(); err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := w.Write(binary.BigEndian.AppendUint32(nil, crc.Sum32())); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// exportedMutation is an entry read by ImportMutations.
+type exportedMutation struct {
+	key      []byte
+	flags    kv.KeyFlags
+	hasValue bool
+	value    []byte
+}
+
+// ImportMutations restores the mutations written by ExportMutations into the MemDB, which must be empty and have no
+// staging buffers. The entry and buffer size limits set by SetEntrySizeLimit are checked while reading, and nothing
+// is imported if r is corrupted or any limit is exceeded.
+func (db *MemDB) ImportMutations(r io.Reader) error {
+	if !db.skipMutex {
+		db.Lock()
+		defer db.Unlock()
+	}
+	if db.count > 0 || len(db.stages) > 0 {
+		return errors.New("cannot import mutations into a non-empty MemDB")
+	}
+
+	mr := &mutationsReader{r: bufio.NewReader(r), crc: crc32.NewIEEE()}
+	header := mr.read(uint64(len(mutationsMagic) + 1))
+	if mr.err == nil && !bytes.Equal(header[:len(mutationsMagic)], mutationsMagic) {
+		return errors.New("invalid header of the exported mutations")
+	}
+	if mr.err == nil && header[len(mutationsMagic)] != mutationsVersion {
+		return errors.Errorf("unsupported version %d of the exported mutations", header[len(mutationsMagic)])
+	}
+
+	var (
+		mutations []exportedMutation
+		size      uint64
+	)
+	// size never exceeds bufferSizeLimit, so bufferSizeLimit-size doesn't overflow.
+	txnTooLarge := func(n uint64) error {
+		return &tikverr.ErrTxnTooLarge{Size: int(size + min(n, math.MaxInt-size))}
+	}
+	for mr.err == nil {
+		kind := mr.read(1)
+		if mr.err != nil || kind[0] == mutationEnd {
+			break
+		}
+		if kind[0] != mutationFlagsOnly && kind[0] != mutationValue {
+			return errors.Errorf("invalid kind %d of the exported mutation", kind[0])
+		}
+		m := exportedMutation{hasValue: kind[0] == mutationValue}
+		keyLen := mr.readUvarint()
+		if mr.err == nil && keyLen > db.bufferSizeLimit-size {
+			return txnTooLarge(keyLen)
+		}
+		m.key = mr.read(keyLen)
+		if flags := mr.read(2); mr.err == nil {
+			m.flags = kv.KeyFlags(binary.BigEndian.Uint16(flags))
+		}
+		size += keyLen
+		if m.hasValue {
+			valueLen := mr.readUvarint()
+			if mr.err != nil {
+				break
+			}
+			if entrySize := keyLen + valueLen; entrySize > db.entrySizeLimit || entrySize < keyLen {
+				return &tikverr.ErrEntryTooLarge{Limit: db.entrySizeLimit, Size: entrySize, Key: m.key}
+			}
+			if valueLen > db.bufferSizeLimit-size {
+				return txnTooLarge(valueLen)
+			}
+			m.value = mr.read(valueLen)
+			size += valueLen
+		}
+		mutations = append(mutations, m)
+	}
+	sum := mr.crc.Sum32()
+	checksum := mr.read(4)
+	if mr.err != nil {
+		return errors.Wrap(mr.err, "failed to read the exported mutations")
+	}
+	if binary.BigEndian.Uint32(checksum) != sum {
+		return errors.New("checksum mismatch of the exported mutations")
+	}
+
+	for _, m := range mutations {
+		x := db.traverse(m.key, true)
+		x.setKeyFlags(m.flags)
+		if m.hasValue {
+			db.setValue(x, m.value)
+			db.writes++
+		}
+	}
+	// Like the writes out of the staging buffers, any imported mutation makes the MemDB dirty.
+	db.dirty = len(mutations) > 0
+	return nil
+}
+
+// mutationsReader reads the exported mutations and computes the checksum of the bytes read. Once an error occurs,
+// it's kept in err and the later reads return nothing.
+type mutationsReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+	err error
+}
+
+// read reads n bytes. The buffer grows with the bytes actually read, so that a corrupted length doesn't allocate
+// a huge buffer at once.
+func (mr *mutationsReader) read(n uint64) []byte {
+	if mr.err != nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(io.MultiWriter(&buf, mr.crc), mr.r, int64(min(n, math.MaxInt64))); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		mr.err = err
+		return nil
+	}
+	if buf.Len() == 0 {
+		return []byte{}
+	}
+	return buf.Bytes()
+}
+
+func (mr *mutationsReader) readUvarint() uint64 {
+	if mr.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(byteReaderFunc(func() (byte, error) {
+		b, err := mr.r.ReadByte()
+		if err == nil {
+			_, _ = mr.crc.Write([]byte{b})
+		}
+		return b, err
+	}))
+	if err != nil {
+		mr.err = err
+	}
+	return v
+}
+
+type byteReaderFunc func() (byte, error)
+
+func (f byteReaderFunc) ReadByte() (byte, error) {
+	return f()
+}
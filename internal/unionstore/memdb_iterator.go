@@ -93,8 +93,13 @@ func (db *MemDB) IterWithFlags(k []byte, upperBound []byte) *MemdbIterator {
 
 // IterReverseWithFlags returns a reversed MemdbIterator.
 func (db *MemDB) IterReverseWithFlags(k []byte) *MemdbIterator {
+	return db.iterReverseWithFlags(k, nil)
+}
+
+func (db *MemDB) iterReverseWithFlags(k []byte, lowerBound []byte) *MemdbIterator {
 	i := &MemdbIterator{
 		db:           db,
+		start:        lowerBound,
 		end:          k,
 		reverse:      true,
 		includeFlags: true,
@@ -184,6 +189,33 @@ func (i *MemdbIterator) Next() error {
 	return nil
 }
 
+// Seek implements the SeekableIterator interface, it moves to the first entry whose key >= key, or <= key if the
+// iterator is reversed.
+func (i *MemdbIterator) Seek(key []byte) error {
+	if i.reverse {
+		// seek moves a reversed iterator to the last entry < the given key.
+		end := kv.NextKey(key)
+		if len(i.end) > 0 && bytes.Compare(end, i.end) > 0 {
+			end = i.end
+		}
+		i.seek(end)
+	} else {
+		if bytes.Compare(key, i.start) < 0 {
+			key = i.start
+		}
+		if len(key) == 0 {
+			i.seekToFirst()
+		} else {
+			i.seek(key)
+		}
+	}
+
+	if i.isFlagsOnly() && !i.includeFlags {
+		return i.Next()
+	}
+	return nil
+}
+
 // Close closes the current iterator.
 func (i *MemdbIterator) Close() {}
 
@@ -249,3 +281,45 @@ func (i *MemdbIterator) seek(key []byte) {
 func (i *MemdbIterator) isFlagsOnly() bool {
 	return !i.curr.isNull() && i.curr.vptr.isNull()
 }
+
+// memdbChange is a key changed since a checkpoint and its current value.
+type memdbChange struct {
+	key   []byte
+	value []byte
+}
+
+// memdbChangesIterator iterates the changes collected by MemDB.IterChangesSince.
+type memdbChangesIterator struct {
+	changes []memdbChange
+}
+
+// Valid returns true if the current iterator is valid.
+func (i *memdbChangesIterator) Valid() bool {
+	return len(i.changes) > 0
+}
+
+// Key returns current key.
+func (i *memdbChangesIterator) Key() []byte {
+	return i.changes[0].key
+}
+
+// Value returns current value, it's empty if the key is deleted.
+func (i *memdbChangesIterator) Value() []byte {
+	return i.changes[0].value
+}
+
+// Deleted returns true if the current key is deleted.
+func (i *memdbChangesIterator) Deleted() bool {
+	return IsTombstone(i.changes[0].value)
+}
+
+// Next goes the next change.
+func (i *memdbChangesIterator) Next() error {
+	i.changes = i.changes[1:]
+	return nil
+}
+
+// Close closes the current iterator.
+func (i *memdbChangesIterator) Close() {
+	i.changes = nil
+}
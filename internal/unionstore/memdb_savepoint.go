@@ -0,0 +1,107 @@
+// Copyright 2024 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unionstore
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	tikverr "github.com/tikv/client-go/v2/error"
+)
+
+// namedSavepoint is a savepoint created by Savepoint, which owns the staging buffer of handle.
+type namedSavepoint struct {
+	name   string
+	handle int
+	// stage is the checkpoint of the staging buffer when it's created, which tells whether the staging buffer is
+	// still the one of the savepoint.
+	stage MemDBCheckpoint
+}
+
+// Savepoint creates a savepoint named name by a new staging buffer, the changes after it can be discarded by
+// RollbackToSavepoint or merged into the enclosing scope by ReleaseSavepoint. An existing savepoint of the same name
+// is replaced, its changes stay in the enclosing scope of the new one. Like Staging, the savepoints don't change the
+// snapshot returned by SnapshotGetter, which reads the state before the first staging buffer.
+func (db *MemDB) Savepoint(name string) error {
+	db.pruneSavepoints()
+	if i := db.findSavepoint(name); i >= 0 {
+		db.savepoints = append(db.savepoints[:i], db.savepoints[i+1:]...)
+	}
+	h := db.Staging()
+	db.savepoints = append(db.savepoints, namedSavepoint{name: name, handle: h, stage: db.stages[h-1]})
+	return nil
+}
+
+// RollbackToSavepoint discards the changes after the savepoint named name, including the staging buffers created
+// after it, and removes the savepoints created after it. The savepoint itself is kept, so it can be rolled back to
+// again.
+func (db *MemDB) RollbackToSavepoint(name string) error {
+	i, err := db.getSavepoint(name)
+	if err != nil {
+		return err
+	}
+	sp := &db.savepoints[i]
+	for len(db.stages) >= sp.handle {
+		db.Cleanup(len(db.stages))
+	}
+	sp.handle = db.Staging()
+	sp.stage = db.stages[sp.handle-1]
+	db.savepoints = db.savepoints[:i+1]
+	return nil
+}
+
+// ReleaseSavepoint merges the changes after the savepoint named name into the enclosing scope, and removes the
+// savepoint with the savepoints created after it.
+func (db *MemDB) ReleaseSavepoint(name string) error {
+	i, err := db.getSavepoint(name)
+	if err != nil {
+		return err
+	}
+	for len(db.stages) >= db.savepoints[i].handle {
+		db.Release(len(db.stages))
+	}
+	db.savepoints = db.savepoints[:i]
+	return nil
+}
+
+func (db *MemDB) getSavepoint(name string) (int, error) {
+	db.pruneSavepoints()
+	i := db.findSavepoint(name)
+	if i < 0 {
+		return -1, errors.WithStack(&tikverr.ErrInvalidSavepoint{Reason: fmt.Sprintf("savepoint %s does not exist", name)})
+	}
+	return i, nil
+}
+
+func (db *MemDB) findSavepoint(name string) int {
+	for i := len(db.savepoints) - 1; i >= 0; i-- {
+		if db.savepoints[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// pruneSavepoints removes the savepoints whose staging buffers are released or cleaned up directly, or reverted by
+// RevertToCheckpoint, together with the savepoints created after them.
+func (db *MemDB) pruneSavepoints() {
+	curr := db.checkpoint()
+	for i, sp := range db.savepoints {
+		if sp.handle > len(db.stages) || db.stages[sp.handle-1] != sp.stage || sp.stage.isAfter(&curr) {
+			db.savepoints = db.savepoints[:i]
+			return
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2024 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unionstore
+
+import (
+	"encoding/json"
+	"slices"
+
+	"github.com/pkg/errors"
+	"github.com/tikv/client-go/v2/kv"
+)
+
+// memBufferState is the serialized form of a MemDB. The value log is kept as is, so that the values overwritten in
+// the staging buffers are restored too, and the staging buffers can be cleaned up after deserialization.
+type memBufferState struct {
+	// Stages is the number of the staging buffers.
+	Stages int `json:"stages"`
+	// Keys are all keys in the MemDB with their flags, including the keys that only have flags.
+	Keys []serializedKey `json:"keys"`
+	// Writes are the values in the value log in the order they are written.
+	Writes []serializedWrite `json:"writes"`
+}
+
+type serializedKey struct {
+	Key   []byte      `json:"key"`
+	Flags kv.KeyFlags `json:"flags"`
+}
+
+type serializedWrite struct {
+	// Stage is the number of the staging buffers when the value is written.
+	Stage int    `json:"stage"`
+	Key   []byte `json:"key"`
+	// Value is empty for a deletion.
+	Value []byte `json:"value"`
+}
+
+// Serialize captures the keys, values, flags and staging buffers of the MemDB, so that a test can restore the
+// exact state by DeserializeMemBuffer and replay what happened to it.
+func (db *MemDB) Serialize() ([]byte, error) {
+	if !db.skipMutex {
+		db.RLock()
+		defer db.RUnlock()
+	}
+	if db.vlogInvalid {
+		return nil, errors.New("cannot serialize a MemDB whose values are discarded")
+	}
+
+	state := memBufferState{Stages: len(db.stages)}
+	for it := db.IterWithFlags(nil, nil); it.Valid(); _ = it.Next() {
+		state.Keys = append(state.Keys, serializedKey{Key: slices.Clone(it.Key()), Flags: it.Flags()})
+	}
+
+	// Walk the value log backward, then reverse it into the written order.
+	cursor := db.vlog.checkpoint()
+	head := MemDBCheckpoint{}
+	for !cursor.isSamePosition(&head) {
+		hdrOff := cursor.offsetInBlock - memdbVlogHdrSize
+		block := db.vlog.blocks[cursor.blocks-1].buf
+		var hdr memdbVlogHdr
+		hdr.load(block[hdrOff:])
+		value := slices.Clone(block[hdrOff-int(hdr.valueLen) : hdrOff])
+		db.vlog.moveBackCursor(&cursor, &hdr)
+
+		// The node may be removed by RemoveFromBuffer.
+		key := db.allocator.getNode(hdr.nodeAddr).getKey()
+		if db.traverse(key, false).addr != hdr.nodeAddr {
+			continue
+		}
+		stage := 0
+		for stage < len(db.stages) && !db.stages[stage].isAfter(&cursor) {
+			stage++
+		}
+		if value == nil {
+			value = []byte{}
+		}
+		state.Writes = append(state.Writes, serializedWrite{Stage: stage, Key: slices.Clone(key), Value: value})
+	}
+	slices.Reverse(state.Writes)
+	return json.Marshal(state)
+}
+
+// DeserializeMemBuffer restores a MemBuffer from the data returned by Serialize, including the staging buffers.
+func DeserializeMemBuffer(data []byte) (MemBuffer, error) {
+	var state memBufferState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	db := newMemDB()
+	for _, k := range state.Keys {
+		db.traverse(k.Key, true)
+	}
+	for _, w := range state.Writes {
+		if w.Stage > state.Stages || w.Stage < len(db.stages) {
+			return nil, errors.Errorf("invalid stage %d of the write to key %s", w.Stage, kv.StrKey(w.Key))
+		}
+		for len(db.stages) < w.Stage {
+			db.Staging()
+		}
+		if w.Value == nil {
+			w.Value = []byte{}
+		}
+		if err := db.set(w.Key, w.Value); err != nil {
+			return nil, err
+		}
+	}
+	for len(db.stages) < state.Stages {
+		db.Staging()
+	}
+	// The flags are set at last since writing a value changes the flags.
+	for _, k := range state.Keys {
+		db.traverse(k.Key, false).setKeyFlags(k.Flags)
+	}
+	return &MemDBWithContext{MemDB: db}, nil
+}
@@ -35,16 +35,20 @@
 package unionstore
 
 import (
+	"bytes"
 	"context"
+	"slices"
 
+	"github.com/pkg/errors"
 	tikverr "github.com/tikv/client-go/v2/error"
 )
 
-// SnapshotGetter returns a Getter for a snapshot of MemBuffer.
-func (db *MemDB) SnapshotGetter() Getter {
+// SnapshotGetter returns a MemBufferSnapshot for a snapshot of MemBuffer.
+func (db *MemDB) SnapshotGetter() MemBufferSnapshot {
 	return &memdbSnapGetter{
-		db: db,
-		cp: db.getSnapshot(),
+		db:  db,
+		cp:  db.getSnapshot(),
+		seq: db.snapshotSeq,
 	}
 }
 
@@ -85,8 +89,9 @@ func (db *MemDB) getSnapshot() MemDBCheckpoint {
 }
 
 type memdbSnapGetter struct {
-	db *MemDB
-	cp MemDBCheckpoint
+	db  *MemDB
+	cp  MemDBCheckpoint
+	seq uint64
 }
 
 func (snap *memdbSnapGetter) Get(ctx context.Context, key []byte) ([]byte, error) {
@@ -105,6 +110,79 @@ func (snap *memdbSnapGetter) Get(ctx context.Context, key []byte) ([]byte, error
 	return v, nil
 }
 
+// BatchGet implements the MemBufferSnapshot interface. The keys are looked up in order while holding the read lock
+// of the MemDB once, so it can run with concurrent writes to the staging buffers.
+func (snap *memdbSnapGetter) BatchGet(ctx context.Context, keys [][]byte) (map[string][]byte, error) {
+	sorted := slices.Clone(keys)
+	slices.SortFunc(sorted, bytes.Compare)
+
+	snap.db.RLock()
+	defer snap.db.RUnlock()
+	if snap.seq != snap.db.snapshotSeq {
+		return nil, errors.WithStack(tikverr.ErrSnapshotInvalidated)
+	}
+	m := make(map[string][]byte, len(keys))
+	for _, k := range sorted {
+		if v, ok := snap.getValue(k); ok {
+			m[string(k)] = v
+		}
+	}
+	return m, nil
+}
+
+// Exists implements the MemBufferSnapshot interface.
+func (snap *memdbSnapGetter) Exists(k []byte) (bool, error) {
+	snap.db.RLock()
+	defer snap.db.RUnlock()
+	if snap.seq != snap.db.snapshotSeq {
+		return false, errors.WithStack(tikverr.ErrSnapshotInvalidated)
+	}
+	_, ok := snap.getValue(k)
+	return ok, nil
+}
+
+// CountInSnapshotRange implements the MemBufferSnapshot interface. It walks the keys in [lower, upper) while holding
+// the read lock of the MemDB like BatchGet, and only checks the lengths of the values.
+func (snap *memdbSnapGetter) CountInSnapshotRange(lower, upper []byte) (int, error) {
+	snap.db.RLock()
+	defer snap.db.RUnlock()
+	if snap.seq != snap.db.snapshotSeq {
+		return 0, errors.WithStack(tikverr.ErrSnapshotInvalidated)
+	}
+	it := &MemdbIterator{db: snap.db, start: lower, includeFlags: true}
+	if len(upper) > 0 {
+		it.end = upper
+	}
+	if len(lower) == 0 {
+		it.seekToFirst()
+	} else {
+		it.seek(lower)
+	}
+	count := 0
+	for ; it.Valid(); _ = it.Next() {
+		if it.curr.vptr.isNull() {
+			continue
+		}
+		if v, ok := snap.db.vlog.getSnapshotValue(it.curr.vptr, &snap.cp); ok && !IsTombstone(v) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// getValue returns the value of k in the snapshot, the deleted key is reported as not found.
+func (snap *memdbSnapGetter) getValue(k []byte) ([]byte, bool) {
+	x := snap.db.traverse(k, false)
+	if x.isNull() || x.vptr.isNull() {
+		return nil, false
+	}
+	v, ok := snap.db.vlog.getSnapshotValue(x.vptr, &snap.cp)
+	if !ok || IsTombstone(v) {
+		return nil, false
+	}
+	return v, true
+}
+
 type memdbSnapIter struct {
 	*MemdbIterator
 	value []byte
@@ -128,6 +206,18 @@ func (i *memdbSnapIter) Next() error {
 	return nil
 }
 
+// Seek implements the SeekableIterator interface, the keys without a value in the snapshot are skipped like Next.
+func (i *memdbSnapIter) Seek(key []byte) error {
+	i.value = nil
+	if err := i.MemdbIterator.Seek(key); err != nil {
+		return err
+	}
+	if !i.setValue() {
+		return i.Next()
+	}
+	return nil
+}
+
 func (i *memdbSnapIter) setValue() bool {
 	if !i.Valid() {
 		return false
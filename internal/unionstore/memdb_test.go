@@ -37,14 +37,23 @@
 package unionstore
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
+	"math/rand"
+	"sync"
 	"testing"
+	"time"
 
 	leveldb "github.com/pingcap/goleveldb/leveldb/memdb"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	tikverr "github.com/tikv/client-go/v2/error"
 	"github.com/tikv/client-go/v2/kv"
+	"github.com/tikv/client-go/v2/util/redact"
 )
 
 type KeyFlags = kv.KeyFlags
@@ -501,6 +510,31 @@ func TestDirty(t *testing.T) {
 	assert.False(db.Dirty())
 }
 
+func TestWriteAmplificationStats(t *testing.T) {
+	assert := assert.New(t)
+
+	db := newMemDB()
+	for i := 0; i < 3; i++ {
+		assert.Nil(db.Set([]byte{1}, []byte{byte(i + 1)}))
+	}
+	assert.Nil(db.Set([]byte{2}, []byte{2}))
+	assert.Nil(db.Delete([]byte{2}))
+	writes, keys := db.WriteAmplificationStats()
+	assert.Equal(uint64(5), writes)
+	assert.Equal(uint64(2), keys)
+
+	// rejected writes and flags-only updates are not counted.
+	assert.NotNil(db.Set([]byte{3}, nil))
+	db.UpdateFlags([]byte{1}, kv.SetKeyLocked)
+	writes, _ = db.WriteAmplificationStats()
+	assert.Equal(uint64(5), writes)
+
+	db.Reset()
+	writes, keys = db.WriteAmplificationStats()
+	assert.Zero(writes)
+	assert.Zero(keys)
+}
+
 func TestFlags(t *testing.T) {
 	assert := assert.New(t)
 
@@ -828,6 +862,43 @@ func TestMemDBStaging(t *testing.T) {
 	assert.Equal(len(v), 2)
 }
 
+func TestTxnTooLargeTopPrefixes(t *testing.T) {
+	require := require.New(t)
+	buffer := newMemDB()
+	buffer.bufferSizeLimit = 10000
+	small := []string{"t_small_table_01", "t_small_table_02"}
+	var err error
+	for i := 0; err == nil; i++ {
+		// 3 of every 4 entries are written to the dominant prefix.
+		prefix := "t_large_table_01"
+		if i%4 == 3 {
+			prefix = small[i/4%2]
+		}
+		err = buffer.Set([]byte(fmt.Sprintf("%s_%04d", prefix, i)), make([]byte, 32))
+	}
+	var txnTooLarge *tikverr.ErrTxnTooLarge
+	require.ErrorAs(err, &txnTooLarge)
+	require.Len(txnTooLarge.TopPrefixes, 3)
+	require.Equal([]byte("t_large_table_01"), txnTooLarge.TopPrefixes[0].Prefix)
+	require.Greater(txnTooLarge.TopPrefixes[0].Size, 6*txnTooLarge.TopPrefixes[1].Size/2)
+	total := 0
+	for _, p := range txnTooLarge.TopPrefixes {
+		total += p.Size
+	}
+	require.Equal(buffer.Size(), total)
+	require.Contains(err.Error(), "top prefixes")
+
+	// at most txnTooLargeTopPrefixes prefixes are reported.
+	buffer = newMemDB()
+	buffer.bufferSizeLimit = 1000
+	err = nil
+	for i := 0; err == nil; i++ {
+		err = buffer.Set([]byte(fmt.Sprintf("%016d", i)), make([]byte, 32))
+	}
+	require.ErrorAs(err, &txnTooLarge)
+	require.Len(txnTooLarge.TopPrefixes, txnTooLargeTopPrefixes)
+}
+
 func TestBufferLimit(t *testing.T) {
 	assert := assert.New(t)
 	buffer := newMemDB()
@@ -860,3 +931,960 @@ func TestUnsetTemporaryFlag(t *testing.T) {
 	require.Nil(err)
 	require.False(flags.HasNeedConstraintCheckInPrewrite())
 }
+
+func TestMemBufferIterWithFlags(t *testing.T) {
+	require := require.New(t)
+	var buffer MemBuffer = NewMemDBWithContext()
+
+	type entry struct {
+		key      string
+		value    string
+		hasValue bool
+		locked   bool
+	}
+	checkIter := func(it FlagsIterator, expected []entry) {
+		defer it.Close()
+		for _, e := range expected {
+			require.True(it.Valid())
+			require.Equal([]byte(e.key), it.Key())
+			require.Equal(e.hasValue, it.HasValue())
+			if e.hasValue {
+				require.Equal([]byte(e.value), it.Value())
+			}
+			require.Equal(e.locked, it.Flags().HasLocked())
+			require.Nil(it.Next())
+		}
+		require.False(it.Valid())
+	}
+
+	require.Nil(buffer.Set([]byte("a"), []byte("a")))
+	require.Nil(buffer.SetWithFlags([]byte("b"), []byte("b"), kv.SetKeyLocked))
+	// a locked-only key has flags but no value.
+	buffer.UpdateFlags([]byte("c"), kv.SetKeyLocked)
+	require.Nil(buffer.Delete([]byte("d")))
+
+	all := []entry{
+		{"a", "a", true, false},
+		{"b", "b", true, true},
+		{"c", "", false, true},
+		{"d", "", true, false},
+	}
+	checkIter(buffer.IterWithFlags(nil, nil), all)
+	checkIter(buffer.IterWithFlags([]byte("b"), []byte("d")), all[1:3])
+	checkIter(buffer.IterReverseWithFlags(nil, nil), []entry{all[3], all[2], all[1], all[0]})
+	checkIter(buffer.IterReverseWithFlags([]byte("d"), []byte("b")), []entry{all[2], all[1]})
+	checkIter(buffer.IterWithFlags([]byte("e"), nil), nil)
+
+	// the plain iterator skips the flag-only key.
+	it, err := buffer.Iter(nil, nil)
+	require.Nil(err)
+	checkIterator(t, it, [][]byte{[]byte("a"), []byte("b"), []byte("d")}, [][]byte{[]byte("a"), []byte("b"), {}})
+
+	// keys modified in a staging buffer.
+	h := buffer.Staging()
+	require.Nil(buffer.SetWithFlags([]byte("c"), []byte("c"), kv.SetPresumeKeyNotExists))
+	require.Nil(buffer.SetWithFlags([]byte("e"), []byte("e"), kv.SetKeyLocked))
+	it2 := buffer.IterWithFlags([]byte("c"), nil)
+	require.True(it2.Valid())
+	require.True(it2.HasValue())
+	require.True(it2.Flags().HasLocked())
+	require.True(it2.Flags().HasPresumeKeyNotExists())
+	it2.Close()
+	checkIter(buffer.IterWithFlags([]byte("c"), nil), []entry{
+		{"c", "c", true, true},
+		{"d", "", true, false},
+		{"e", "e", true, true},
+	})
+
+	// after the staging buffer is cleaned up, the key "c" becomes flag-only again and "e" keeps its persistent flag.
+	buffer.Cleanup(h)
+	checkIter(buffer.IterWithFlags([]byte("c"), nil), []entry{
+		{"c", "", false, true},
+		{"d", "", true, false},
+		{"e", "", false, true},
+	})
+
+	// iterators created after writes observe the new state.
+	require.Nil(buffer.Set([]byte("c"), []byte("cc")))
+	buffer.UpdateFlags([]byte("e"), kv.DelKeyLocked)
+	checkIter(buffer.IterWithFlags([]byte("c"), nil), []entry{
+		{"c", "cc", true, true},
+		{"d", "", true, false},
+		{"e", "", false, false},
+	})
+
+	// the pipelined memdb does not support it.
+	var pipelined MemBuffer = NewPipelinedMemDB(nil, nil)
+	require.NotNil(pipelined.IterWithFlags(nil, nil).Next())
+	require.NotNil(pipelined.IterReverseWithFlags(nil, nil).Next())
+}
+
+func TestMemBufferSnapshotBatchGet(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+	buffer := newMemDB()
+	require.Nil(buffer.Set([]byte("a"), []byte("a")))
+	require.Nil(buffer.Set([]byte("b"), []byte("b")))
+	require.Nil(buffer.Set([]byte("c"), []byte("c")))
+	require.Nil(buffer.Delete([]byte("c")))
+	buffer.UpdateFlags([]byte("d"), kv.SetPresumeKeyNotExists)
+	h := buffer.Staging()
+	snap := buffer.SnapshotGetter()
+
+	keys := [][]byte{[]byte("e"), []byte("c"), []byte("b"), []byte("d"), []byte("a")}
+	expected := map[string][]byte{"a": []byte("a"), "b": []byte("b")}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			_ = buffer.Set([]byte("a"), []byte(fmt.Sprintf("a%d", i)))
+			_ = buffer.Set([]byte(fmt.Sprintf("e%d", i)), []byte("e"))
+			_ = buffer.Set([]byte("e"), []byte("e"))
+			_ = buffer.Delete([]byte("b"))
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		m, err := snap.BatchGet(ctx, keys)
+		require.Nil(err)
+		require.Equal(expected, m)
+		for _, k := range []string{"a", "b"} {
+			exists, err := snap.Exists([]byte(k))
+			require.Nil(err)
+			require.True(exists)
+		}
+		for _, k := range []string{"c", "d", "e"} {
+			exists, err := snap.Exists([]byte(k))
+			require.Nil(err)
+			require.False(exists)
+		}
+	}
+	wg.Wait()
+	// the keys are not reordered.
+	require.Equal([]byte("e"), keys[0])
+
+	// cleaning up the staging buffer keeps the snapshot valid.
+	buffer.Cleanup(h)
+	m, err := snap.BatchGet(ctx, keys)
+	require.Nil(err)
+	require.Equal(expected, m)
+
+	// rolling back to a checkpoint invalidates the snapshot.
+	cp := buffer.Checkpoint()
+	require.Nil(buffer.Set([]byte("f"), []byte("f")))
+	buffer.RevertToCheckpoint(cp)
+	_, err = snap.BatchGet(ctx, keys)
+	require.ErrorIs(err, tikverr.ErrSnapshotInvalidated)
+	_, err = snap.Exists([]byte("a"))
+	require.ErrorIs(err, tikverr.ErrSnapshotInvalidated)
+
+	// so does resetting the MemDB.
+	snap = buffer.SnapshotGetter()
+	exists, err := snap.Exists([]byte("a"))
+	require.Nil(err)
+	require.True(exists)
+	buffer.Reset()
+	_, err = snap.BatchGet(ctx, keys)
+	require.ErrorIs(err, tikverr.ErrSnapshotInvalidated)
+}
+
+func TestMemBufferSnapshotCount(t *testing.T) {
+	require := require.New(t)
+	buffer := newMemDB()
+	for _, k := range []string{"a", "b", "c", "d"} {
+		require.Nil(buffer.Set([]byte(k), []byte(k)))
+	}
+	require.Nil(buffer.Delete([]byte("c")))
+	buffer.UpdateFlags([]byte("bb"), kv.SetPresumeKeyNotExists)
+	h := buffer.Staging()
+	snap := buffer.SnapshotGetter()
+
+	check := func(lower, upper string, expected int) {
+		count, err := snap.CountInSnapshotRange([]byte(lower), []byte(upper))
+		require.Nil(err)
+		require.Equal(expected, count, "[%s, %s)", lower, upper)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			_ = buffer.Set([]byte(fmt.Sprintf("b%d", i)), []byte("b"))
+			_ = buffer.Delete([]byte("a"))
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		check("", "", 3)
+		check("a", "d", 2)
+		check("b", "c", 1)
+		check("c", "", 1)
+		check("e", "", 0)
+	}
+	wg.Wait()
+	buffer.Cleanup(h)
+	check("", "", 3)
+
+	buffer.Reset()
+	_, err := snap.CountInSnapshotRange(nil, nil)
+	require.ErrorIs(err, tikverr.ErrSnapshotInvalidated)
+}
+
+func TestMemBufferSerialize(t *testing.T) {
+	require := require.New(t)
+	type entry struct {
+		key   string
+		flags kv.KeyFlags
+		value []byte
+	}
+	export := func(buffer MemBuffer) []entry {
+		var entries []entry
+		for it := buffer.IterWithFlags(nil, nil); it.Valid(); require.Nil(it.Next()) {
+			e := entry{key: string(it.Key()), flags: it.Flags()}
+			if it.HasValue() {
+				e.value = it.Value()
+			}
+			entries = append(entries, e)
+		}
+		return entries
+	}
+	inspect := func(buffer MemBuffer, h int) map[string][]byte {
+		m := make(map[string][]byte)
+		buffer.InspectStage(h, func(k []byte, _ kv.KeyFlags, v []byte) {
+			m[string(k)] = v
+		})
+		return m
+	}
+
+	buffer := NewMemDBWithContext()
+	require.Nil(buffer.Set([]byte("a"), []byte("a")))
+	require.Nil(buffer.SetWithFlags([]byte("b"), []byte("b"), kv.SetPresumeKeyNotExists))
+	require.Nil(buffer.Set([]byte("c"), []byte("c")))
+	buffer.UpdateFlags([]byte("d"), kv.SetKeyLocked)
+	h1 := buffer.Staging()
+	require.Nil(buffer.Set([]byte("a"), []byte("a1")))
+	require.Nil(buffer.Delete([]byte("c")))
+	require.Nil(buffer.Set([]byte("e"), []byte("e")))
+	h2 := buffer.Staging()
+	require.Nil(buffer.SetWithFlags([]byte("a"), []byte("a2"), kv.SetAssertExist))
+	require.Nil(buffer.Set([]byte("f"), []byte("f")))
+	buffer.UpdateFlags([]byte("b"), kv.SetKeyLocked)
+
+	data, err := buffer.Serialize()
+	require.Nil(err)
+	restored, err := DeserializeMemBuffer(data)
+	require.Nil(err)
+	require.Equal(export(buffer), export(restored))
+	require.Equal(buffer.Len(), restored.Len())
+	require.Equal(buffer.Size(), restored.Size())
+	for _, h := range []int{h1, h2} {
+		require.Equal(inspect(buffer, h), inspect(restored, h))
+	}
+
+	// the staging buffers are preserved.
+	require.Equal(h2+1, restored.Staging())
+	restored.Cleanup(h2 + 1)
+	buffer.Cleanup(h2)
+	restored.Cleanup(h2)
+	require.Equal(export(buffer), export(restored))
+	buffer.Release(h1)
+	restored.Release(h1)
+	require.Equal(export(buffer), export(restored))
+	v, err := restored.Get(context.Background(), []byte("a"))
+	require.Nil(err)
+	require.Equal([]byte("a1"), v)
+	// the deletion is kept.
+	v, err = restored.Get(context.Background(), []byte("c"))
+	require.Nil(err)
+	require.Empty(v)
+
+	_, err = DeserializeMemBuffer([]byte("invalid"))
+	require.NotNil(err)
+}
+
+func TestMemDBStats(t *testing.T) {
+	require := require.New(t)
+	db := newMemDB()
+	require.Equal(ArenaStats{}, db.Stats())
+	require.Zero(db.Stats().Fragmentation())
+
+	for i := 0; i < 100; i++ {
+		require.Nil(db.Set([]byte(fmt.Sprintf("key%03d", i)), []byte("v")))
+	}
+	stats := db.Stats()
+	require.Equal(100, stats.Keys)
+	require.Zero(stats.FreedNodeBytes)
+	require.GreaterOrEqual(stats.NodeBytes, uint64(100*memdbNodeSize(6)))
+	require.Equal(uint64(100*(memdbVlogHdrSize+1)), stats.VlogBytes)
+	require.Equal(db.Mem(), stats.NodeCapacity+stats.VlogCapacity)
+	require.Greater(stats.Fragmentation(), 0.0)
+
+	// the removed nodes are freed but stay in the arena.
+	for i := 0; i < 40; i++ {
+		db.RemoveFromBuffer([]byte(fmt.Sprintf("key%03d", i)))
+	}
+	removed := db.Stats()
+	require.Equal(60, removed.Keys)
+	require.Equal(uint64(40*memdbNodeSize(6)), removed.FreedNodeBytes)
+	require.Equal(stats.NodeBytes, removed.NodeBytes)
+	require.Equal(stats.VlogBytes, removed.VlogBytes)
+	require.InDelta(stats.Fragmentation()+float64(removed.FreedNodeBytes)/float64(db.Mem()), removed.Fragmentation(), 1e-9)
+
+	// the overwritten values are kept in the value log.
+	require.Nil(db.Set([]byte("key099"), []byte("vv")))
+	require.Equal(stats.VlogBytes+memdbVlogHdrSize+2, db.Stats().VlogBytes)
+
+	db.Reset()
+	require.Equal(ArenaStats{}, db.Stats())
+}
+
+func TestMemDBMemoryThresholds(t *testing.T) {
+	require := require.New(t)
+	db := newMemDB()
+
+	type fire struct {
+		limit uint64
+		mem   uint64
+		len   int
+	}
+	var fires []fire
+	limits := []uint64{64 << 10, 256 << 10, 1 << 20}
+	thresholds := make([]MemoryThreshold, 0, len(limits))
+	for _, limit := range limits {
+		limit := limit
+		thresholds = append(thresholds, MemoryThreshold{
+			Limit: limit,
+			Callback: func(mem uint64, len int) {
+				require.Equal(db.Mem(), mem)
+				require.Equal(db.Len(), len)
+				fires = append(fires, fire{limit, mem, len})
+			},
+		})
+	}
+	db.SetMemoryThresholds(thresholds)
+	// the footprint change hook works along with the thresholds.
+	var hookCalls int
+	db.SetMemoryFootprintChangeHook(func(uint64) { hookCalls++ })
+
+	value := make([]byte, 1024)
+	grow := func(n int) {
+		for i := 0; i < n; i++ {
+			require.Nil(db.Set([]byte(fmt.Sprintf("key%05d", db.Len())), value))
+		}
+	}
+	checkFires := func(expected []uint64) {
+		require.Len(fires, len(expected))
+		for i, f := range fires {
+			require.Equal(expected[i], f.limit)
+			require.GreaterOrEqual(f.mem, f.limit)
+		}
+	}
+
+	grow(8)
+	require.Less(db.Mem(), limits[0])
+	require.Empty(fires)
+
+	grow(2048)
+	require.GreaterOrEqual(db.Mem(), limits[2])
+	checkFires(limits)
+	// each threshold fires only once while the memory footprint keeps above it.
+	grow(1024)
+	checkFires(limits)
+	require.Greater(hookCalls, 3)
+
+	// the thresholds are re-armed when the memory footprint drops.
+	db.Reset()
+	fires = nil
+	grow(256)
+	require.GreaterOrEqual(db.Mem(), limits[1])
+	require.Less(db.Mem(), limits[2])
+	checkFires(limits[:2])
+
+	// the new thresholds replace the old ones.
+	db.SetMemoryThresholds(nil)
+	fires = nil
+	grow(2048)
+	require.Empty(fires)
+}
+
+func TestMemoryThresholdsRearm(t *testing.T) {
+	require := require.New(t)
+	var fired []int
+	thresholds := newMemoryThresholds([]MemoryThreshold{
+		{Limit: 100, Callback: func(mem uint64, len int) { fired = append(fired, len) }},
+		{Limit: 200, Callback: func(mem uint64, len int) { fired = append(fired, -len) }},
+	})
+	thresholds.check(99, 1)
+	require.Empty(fired)
+	thresholds.check(100, 2)
+	require.Equal([]int{2}, fired)
+	thresholds.check(150, 3)
+	require.Equal([]int{2}, fired)
+	// dropping to 80% of the limit doesn't re-arm it.
+	thresholds.check(80, 4)
+	thresholds.check(120, 5)
+	require.Equal([]int{2}, fired)
+	// dropping below 80% of the limit re-arms it.
+	thresholds.check(79, 6)
+	thresholds.check(250, 7)
+	require.Equal([]int{2, 7, -7}, fired)
+	thresholds.check(300, 8)
+	require.Equal([]int{2, 7, -7}, fired)
+
+	// nil thresholds are no-op.
+	require.Nil(newMemoryThresholds(nil))
+	newMemoryThresholds(nil).check(1000, 1)
+}
+
+func TestMemDBClearAllFlags(t *testing.T) {
+	require := require.New(t)
+	db := newMemDB()
+	require.Nil(db.SetWithFlags([]byte("a"), []byte("1"), kv.SetPresumeKeyNotExists))
+	require.Nil(db.SetWithFlags([]byte("b"), []byte("2"), kv.SetKeyLocked, kv.SetAssertExist))
+	require.Nil(db.Set([]byte("c"), []byte("3")))
+	db.UpdateFlags([]byte("d"), kv.SetKeyLocked)
+	flagsOf := func() map[string]kv.KeyFlags {
+		flags := make(map[string]kv.KeyFlags)
+		for it := db.IterWithFlags(nil, nil); it.Valid(); require.Nil(it.Next()) {
+			flags[string(it.Key())] = it.Flags()
+		}
+		return flags
+	}
+	origin := flagsOf()
+	require.True(origin["b"].HasLocked())
+	require.True(origin["b"].HasAssertExist())
+
+	// the flags cleared in a staging buffer are restored by Cleanup.
+	h := db.Staging()
+	db.ClearAllFlags()
+	for k, flags := range flagsOf() {
+		require.Zero(flags, k)
+	}
+	for k, v := range map[string]string{"a": "1", "b": "2", "c": "3"} {
+		val, err := db.Get([]byte(k))
+		require.Nil(err)
+		require.Equal(v, string(val))
+	}
+	db.Cleanup(h)
+	require.Equal(origin, flagsOf())
+
+	// the flags are cleared for good once the staging buffer is released.
+	h = db.Staging()
+	db.ClearAllFlags()
+	db.Release(h)
+	for _, flags := range flagsOf() {
+		require.Zero(flags)
+	}
+
+	// the flags cleared after the checkpoint are restored by RevertToCheckpoint, the earlier ones are not.
+	db.UpdateFlags([]byte("a"), kv.SetKeyLocked)
+	cp := db.Checkpoint()
+	db.ClearAllFlags()
+	require.Nil(db.SetWithFlags([]byte("e"), []byte("5"), kv.SetPresumeKeyNotExists))
+	db.ClearAllFlags()
+	db.RevertToCheckpoint(cp)
+	flags := flagsOf()
+	require.True(flags["a"].HasLocked())
+	require.Zero(flags["b"])
+	require.NotContains(flags, "e")
+	val, err := db.Get([]byte("a"))
+	require.Nil(err)
+	require.Equal("1", string(val))
+}
+
+func TestMemBufferExportMutations(t *testing.T) {
+	require := require.New(t)
+	type entry struct {
+		key      string
+		flags    kv.KeyFlags
+		hasValue bool
+		value    []byte
+	}
+	entries := func(buffer MemBuffer) []entry {
+		var res []entry
+		for it := buffer.IterWithFlags(nil, nil); it.Valid(); require.Nil(it.Next()) {
+			e := entry{key: string(it.Key()), flags: it.Flags(), hasValue: it.HasValue()}
+			if e.hasValue {
+				e.value = it.Value()
+			}
+			res = append(res, e)
+		}
+		return res
+	}
+	roundTrip := func(buffer MemBuffer) MemBuffer {
+		var buf bytes.Buffer
+		require.Nil(buffer.ExportMutations(&buf))
+		restored := NewMemDBWithContext()
+		require.Nil(restored.ImportMutations(&buf))
+		require.Equal(entries(buffer), entries(restored))
+		require.Equal(buffer.Len(), restored.Len())
+		require.Equal(buffer.Size(), restored.Size())
+		return restored
+	}
+
+	flagsOps := []kv.FlagsOp{kv.SetKeyLocked, kv.SetPresumeKeyNotExists, kv.SetAssertExist, kv.SetAssertNotExist,
+		kv.SetNeedConstraintCheckInPrewrite, kv.SetPreviousPresumeKNE, kv.SetNewlyInserted}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for round := 0; round < 100; round++ {
+		buffer := NewMemDBWithContext()
+		staged := false
+		for i, n := 0, rnd.Intn(200); i < n; i++ {
+			key := []byte(fmt.Sprintf("key%d", rnd.Intn(100)))
+			switch rnd.Intn(5) {
+			case 0:
+				require.Nil(buffer.Delete(key))
+			case 1:
+				buffer.UpdateFlags(key, flagsOps[rnd.Intn(len(flagsOps))])
+			case 2:
+				require.Nil(buffer.SetWithFlags(key, []byte{byte(i)}, flagsOps[rnd.Intn(len(flagsOps))]))
+			default:
+				value := make([]byte, rnd.Intn(64)+1)
+				rnd.Read(value)
+				require.Nil(buffer.Set(key, value))
+			}
+			// the staging buffers are merged into the exported state.
+			if rnd.Intn(50) == 0 {
+				buffer.Staging()
+				staged = true
+			}
+		}
+		restored := roundTrip(buffer)
+		// the writes in the staging buffers don't make the buffer dirty until they're released.
+		if !staged {
+			require.Equal(buffer.Dirty(), restored.Dirty())
+		}
+	}
+
+	buffer := NewMemDBWithContext()
+	buffer.UpdateFlags([]byte("locked"), kv.SetKeyLocked)
+	require.Nil(buffer.Delete([]byte("deleted")))
+	require.Nil(buffer.Set([]byte("k"), []byte("v")))
+	restored := roundTrip(buffer)
+	require.True(restored.Dirty())
+	v, err := restored.Get(context.Background(), []byte("deleted"))
+	require.Nil(err)
+	require.Empty(v)
+	flags, err := restored.GetFlags([]byte("locked"))
+	require.Nil(err)
+	require.True(flags.HasLocked())
+
+	var buf bytes.Buffer
+	require.Nil(buffer.ExportMutations(&buf))
+	data := buf.Bytes()
+	// import into a non-empty buffer.
+	require.NotNil(restored.ImportMutations(bytes.NewReader(data)))
+	staging := NewMemDBWithContext()
+	staging.Staging()
+	require.NotNil(staging.ImportMutations(bytes.NewReader(data)))
+	// corrupted or truncated data.
+	for i := range data {
+		corrupted := bytes.Clone(data)
+		corrupted[i] ^= 0x80
+		db := NewMemDBWithContext()
+		require.NotNil(db.ImportMutations(bytes.NewReader(corrupted)))
+		require.Zero(db.Len())
+		db = NewMemDBWithContext()
+		require.NotNil(db.ImportMutations(bytes.NewReader(data[:i])))
+		require.Zero(db.Len())
+	}
+	// size limits.
+	db := NewMemDBWithContext()
+	db.SetEntrySizeLimit(2, math.MaxUint64)
+	var entryTooLarge *tikverr.ErrEntryTooLarge
+	require.ErrorAs(db.ImportMutations(bytes.NewReader(data)), &entryTooLarge)
+	require.Zero(db.Len())
+	db = NewMemDBWithContext()
+	db.SetEntrySizeLimit(math.MaxUint64, uint64(buffer.Size()-1))
+	var txnTooLarge *tikverr.ErrTxnTooLarge
+	require.ErrorAs(db.ImportMutations(bytes.NewReader(data)), &txnTooLarge)
+	require.Zero(db.Len())
+}
+
+func TestMemBufferExport(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+	buffer := NewMemDBWithContext()
+	require.Nil(buffer.SetWithFlags([]byte("a"), []byte("a"), kv.SetPresumeKeyNotExists))
+	require.Nil(buffer.Set([]byte("b"), []byte("b")))
+	require.Nil(buffer.Delete([]byte("c")))
+	buffer.UpdateFlags([]byte("d"), kv.SetKeyLocked)
+	h := buffer.Staging()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			_ = buffer.Set([]byte("a"), []byte(fmt.Sprintf("a%d", i)))
+			_ = buffer.Set([]byte(fmt.Sprintf("e%d", i)), []byte("e"))
+			_ = buffer.Delete([]byte("b"))
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		var buf bytes.Buffer
+		require.Nil(buffer.Export(&buf))
+		restored := NewMemDBWithContext()
+		require.Nil(restored.Import(&buf))
+		require.Equal(4, restored.Len())
+		m, err := restored.SnapshotGetter().BatchGet(ctx, [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("e0")})
+		require.Nil(err)
+		require.Equal(map[string][]byte{"a": []byte("a"), "b": []byte("b")}, m)
+		v, err := restored.Get(ctx, []byte("c"))
+		require.Nil(err)
+		require.Empty(v)
+		flags, err := restored.GetFlags([]byte("a"))
+		require.Nil(err)
+		require.True(flags.HasPresumeKeyNotExists())
+		flags, err = restored.GetFlags([]byte("d"))
+		require.Nil(err)
+		require.True(flags.HasLocked())
+	}
+	wg.Wait()
+
+	// without staging buffers, Export is the same as ExportMutations.
+	buffer.Release(h)
+	var exported, mutations bytes.Buffer
+	require.Nil(buffer.Export(&exported))
+	require.Nil(buffer.ExportMutations(&mutations))
+	require.Equal(mutations.Bytes(), exported.Bytes())
+
+	// the imported MemBuffer must be empty.
+	require.NotNil(buffer.Import(&exported))
+}
+
+func TestMemDBChangesSince(t *testing.T) {
+	require := require.New(t)
+	type change struct {
+		key   string
+		value string
+		op    OpType
+	}
+	changesSince := func(db *MemDB, seq uint64) []change {
+		var changes []change
+		last := seq
+		require.Nil(db.ChangesSince(seq, func(key, value []byte, op OpType, seq uint64) (bool, error) {
+			require.Greater(seq, last)
+			last = seq
+			changes = append(changes, change{string(key), string(value), op})
+			return false, nil
+		}))
+		return changes
+	}
+
+	db := newMemDB()
+	require.Equal(uint64(0), db.CurrentSeq())
+	require.Nil(db.Set([]byte("a"), []byte("1")))
+	require.Nil(db.Set([]byte("b"), []byte("1")))
+	require.Equal([]change{{"a", "1", OpPut}, {"b", "1", OpPut}}, changesSince(db, 0))
+
+	seq := db.CurrentSeq()
+	require.Equal(uint64(2), seq)
+	require.Empty(changesSince(db, seq))
+	// the values aren't overwritten in place once the sequences are tracked.
+	require.Nil(db.Set([]byte("a"), []byte("2")))
+	require.Nil(db.Delete([]byte("c")))
+	require.Nil(db.Set([]byte("d"), []byte("1")))
+	require.Nil(db.Set([]byte("a"), []byte("3")))
+	h := db.Staging()
+	require.Nil(db.Set([]byte("e"), []byte("1")))
+	db.Cleanup(h)
+	db.RemoveFromBuffer([]byte("d"))
+	require.Equal([]change{{"a", "2", OpPut}, {"c", "", OpDelete}, {"a", "3", OpPut}}, changesSince(db, seq))
+	require.Len(changesSince(db, 0), 5)
+	require.Len(db.vlog.seqs, 6)
+
+	var keys []string
+	require.Nil(db.ChangesSince(0, func(key, value []byte, op OpType, seq uint64) (bool, error) {
+		keys = append(keys, string(key))
+		return len(keys) == 2, nil
+	}))
+	require.Equal([]string{"a", "b"}, keys)
+	err := errors.New("stop")
+	require.Equal(err, db.ChangesSince(0, func(key, value []byte, op OpType, seq uint64) (bool, error) {
+		return false, err
+	}))
+
+	// the sequence isn't reused after reset.
+	seq = db.CurrentSeq()
+	db.Reset()
+	require.Nil(db.Set([]byte("a"), []byte("4")))
+	require.Equal([]change{{"a", "4", OpPut}}, changesSince(db, seq))
+	require.Greater(db.CurrentSeq(), seq)
+}
+
+func TestMemDBAssertKeysInRange(t *testing.T) {
+	require := require.New(t)
+	db := NewMemDBWithContext()
+	require.Nil(db.AssertKeysInRange([]byte("t1"), []byte("t2")))
+
+	require.Nil(db.Set([]byte("t1_a"), []byte("v")))
+	require.Nil(db.Delete([]byte("t1_b")))
+	db.UpdateFlags([]byte("t1_c"), kv.SetPresumeKeyNotExists)
+	require.Nil(db.AssertKeysInRange([]byte("t1"), []byte("t2")))
+	require.Nil(db.AssertKeysInRange([]byte("t1_a"), []byte("t1_c\x00")))
+	require.Nil(db.AssertKeysInRange(nil, nil))
+
+	// the flags-only key is out of the range.
+	err := db.AssertKeysInRange([]byte("t1"), []byte("t1_c"))
+	require.ErrorContains(err, "key "+redact.Key([]byte("t1_c"))+" is out of range")
+
+	// the first key out of the range is named.
+	require.Nil(db.Set([]byte("t0_z"), []byte("v")))
+	require.Nil(db.Set([]byte("t2_a"), []byte("v")))
+	err = db.AssertKeysInRange([]byte("t1"), []byte("t2"))
+	require.ErrorContains(err, "key "+redact.Key([]byte("t0_z"))+" is out of range")
+	err = db.AssertKeysInRange([]byte("t0"), []byte("t2"))
+	require.ErrorContains(err, "key "+redact.Key([]byte("t2_a"))+" is out of range")
+	require.Nil(db.AssertKeysInRange([]byte("t0"), nil))
+}
+
+func TestMemDBNamedSavepoints(t *testing.T) {
+	require := require.New(t)
+	db := newMemDB()
+	scan := func() []string {
+		var kvs []string
+		it, err := db.Iter(nil, nil)
+		require.Nil(err)
+		for ; it.Valid(); require.Nil(it.Next()) {
+			kvs = append(kvs, string(it.Key())+"="+string(it.Value()))
+		}
+		return kvs
+	}
+	isInvalid := func(err error) bool {
+		var e *tikverr.ErrInvalidSavepoint
+		return errors.As(err, &e)
+	}
+
+	require.Nil(db.Set([]byte("a"), []byte("0")))
+	require.Nil(db.Savepoint("sp1"))
+	require.Nil(db.Set([]byte("a"), []byte("1")))
+	require.Nil(db.Set([]byte("b"), []byte("1")))
+	require.Nil(db.Savepoint("sp2"))
+	require.Nil(db.Set([]byte("b"), []byte("2")))
+	require.Nil(db.Set([]byte("c"), []byte("2")))
+	require.Nil(db.Savepoint("sp3"))
+	require.Nil(db.Set([]byte("c"), []byte("3")))
+	require.Nil(db.Delete([]byte("a")))
+	require.Equal([]string{"a=", "b=2", "c=3"}, scan())
+
+	// the snapshot reads the state before the first savepoint.
+	snap := db.SnapshotGetter()
+	v, err := snap.Get(context.Background(), []byte("a"))
+	require.Nil(err)
+	require.Equal([]byte("0"), v)
+
+	// rolling back to sp2 discards the changes after it and removes sp3.
+	require.Nil(db.RollbackToSavepoint("sp2"))
+	require.Equal([]string{"a=1", "b=1"}, scan())
+	require.True(isInvalid(db.RollbackToSavepoint("sp3")))
+	require.Nil(db.Set([]byte("d"), []byte("2")))
+	require.Nil(db.RollbackToSavepoint("sp2"))
+	require.Equal([]string{"a=1", "b=1"}, scan())
+
+	// replacing sp1 keeps the changes of the old one.
+	require.Nil(db.Set([]byte("e"), []byte("2")))
+	require.Nil(db.Savepoint("sp1"))
+	require.Nil(db.Set([]byte("e"), []byte("1")))
+	require.Nil(db.RollbackToSavepoint("sp1"))
+	require.Equal([]string{"a=1", "b=1", "e=2"}, scan())
+	require.Nil(db.RollbackToSavepoint("sp2"))
+	require.Equal([]string{"a=1", "b=1"}, scan())
+	require.True(isInvalid(db.RollbackToSavepoint("sp1")))
+
+	// releasing sp2 merges its changes into the enclosing scope.
+	require.Nil(db.Set([]byte("f"), []byte("2")))
+	require.Nil(db.ReleaseSavepoint("sp2"))
+	require.True(isInvalid(db.ReleaseSavepoint("sp2")))
+	require.Equal([]string{"a=1", "b=1", "f=2"}, scan())
+	v, err = snap.Get(context.Background(), []byte("a"))
+	require.Nil(err)
+	require.Equal([]byte("0"), v)
+
+	// reverting to a checkpoint before a savepoint invalidates it.
+	cp := db.Checkpoint()
+	require.Nil(db.Set([]byte("g"), []byte("3")))
+	require.Nil(db.Savepoint("sp4"))
+	require.Nil(db.Set([]byte("g"), []byte("4")))
+	db.RevertToCheckpoint(cp)
+	require.True(isInvalid(db.RollbackToSavepoint("sp4")))
+	require.Equal([]string{"a=1", "b=1", "f=2"}, scan())
+}
+
+func TestMemDBPurgeTombstonesBefore(t *testing.T) {
+	require := require.New(t)
+	db := newMemDB()
+	for i := 0; i < 100; i++ {
+		require.Nil(db.SetWithFlags([]byte(fmt.Sprintf("k%03d", i)), []byte("v"), kv.SetNewlyInserted))
+	}
+	for i := 0; i < 100; i += 2 {
+		require.Nil(db.Delete([]byte(fmt.Sprintf("k%03d", i))))
+	}
+	// the tombstones committed as mutations are kept.
+	require.Nil(db.Delete([]byte("deleted")))
+	require.Nil(db.SetWithFlags([]byte("locked"), []byte("v"), kv.SetNewlyInserted))
+	require.Nil(db.DeleteWithFlags([]byte("locked"), kv.SetKeyLocked))
+	require.Nil(db.SetWithFlags([]byte("presumed"), []byte("v"), kv.SetNewlyInserted, kv.SetPresumeKeyNotExists))
+	require.Nil(db.Delete([]byte("presumed")))
+	cp := db.Checkpoint()
+	// the tombstones after the checkpoint are kept.
+	require.Nil(db.Delete([]byte("k001")))
+	require.Nil(db.Set([]byte("k002"), []byte("v2")))
+	after := db.Checkpoint()
+	require.Nil(db.Delete([]byte("k003")))
+
+	// the checkpoint must be stable.
+	h := db.Staging()
+	require.Nil(db.Delete([]byte("x")))
+	_, err := db.PurgeTombstonesBefore(db.Checkpoint())
+	require.ErrorContains(err, "not stable")
+	db.Cleanup(h)
+	_, err = db.PurgeTombstonesBefore(nil)
+	require.Error(err)
+	_, err = db.PurgeTombstonesBefore(&MemDBCheckpoint{blocks: 100})
+	require.ErrorContains(err, "doesn't belong")
+
+	keys, size := db.Len(), db.Size()
+	stats := db.Stats()
+	n, err := db.PurgeTombstonesBefore(cp)
+	require.Nil(err)
+	// k002 is rewritten after the checkpoint.
+	require.Equal(49, n)
+	require.Equal(keys-n, db.Len())
+	require.Equal(size-n*4, db.Size())
+	require.Equal(stats.FreedNodeBytes+uint64(n*memdbNodeSize(4)), db.Stats().FreedNodeBytes)
+
+	check := func() {
+		for i := 0; i < 100; i++ {
+			key := []byte(fmt.Sprintf("k%03d", i))
+			v, err := db.Get(key)
+			switch {
+			case i == 1 || i == 3:
+				require.Nil(err)
+				require.True(IsTombstone(v))
+			case i == 2:
+				require.Equal([]byte("v2"), v)
+			case i%2 == 0:
+				require.True(tikverr.IsErrNotFound(err), "%s", key)
+			default:
+				require.Equal([]byte("v"), v)
+			}
+		}
+		for _, key := range []string{"deleted", "locked", "presumed"} {
+			v, err := db.Get([]byte(key))
+			require.Nil(err)
+			require.True(IsTombstone(v))
+		}
+	}
+	check()
+	n, err = db.PurgeTombstonesBefore(cp)
+	require.Nil(err)
+	require.Zero(n)
+
+	// the checkpoints after cp can still be reverted to.
+	db.RevertToCheckpoint(after)
+	v, err := db.Get([]byte("k003"))
+	require.Nil(err)
+	require.Equal([]byte("v"), v)
+	db.RevertToCheckpoint(cp)
+	v, err = db.Get([]byte("k002"))
+	require.Nil(err)
+	require.True(IsTombstone(v))
+	v, err = db.Get([]byte("k001"))
+	require.Nil(err)
+	require.Equal([]byte("v"), v)
+
+}
+
+func TestMemDBIterChangesSince(t *testing.T) {
+	require := require.New(t)
+	db := newMemDB()
+	collect := func(cp *MemDBCheckpoint) map[string]string {
+		it, err := db.IterChangesSince(cp)
+		require.Nil(err)
+		defer it.Close()
+		changes := make(map[string]string)
+		var prev []byte
+		for ; it.Valid(); require.Nil(it.Next()) {
+			require.Less(string(prev), string(it.Key()))
+			prev = append(prev[:0], it.Key()...)
+			if it.Deleted() {
+				require.Empty(it.Value())
+				changes[string(it.Key())] = "deleted"
+			} else {
+				changes[string(it.Key())] = string(it.Value())
+			}
+		}
+		return changes
+	}
+
+	for i := 0; i < 10; i++ {
+		require.Nil(db.Set([]byte(fmt.Sprintf("k%d", i)), []byte("v")))
+	}
+	cp := db.Checkpoint()
+	require.Empty(collect(cp))
+	// taking a checkpoint has no side effect, the values out of the staging buffers are still overwritten in place.
+	tail := db.vlog.checkpoint()
+	require.Nil(db.Set([]byte("k9"), []byte("w")))
+	require.True(tail.isSamePosition(db.Checkpoint()))
+	require.Empty(collect(cp))
+
+	h0 := db.Staging()
+	// k0 is overwritten with a value of the same length, which is not done in place in the staging buffer.
+	require.Nil(db.Set([]byte("k0"), []byte("x")))
+	require.Nil(db.Set([]byte("k1"), []byte("v1")))
+	require.Nil(db.Set([]byte("k1"), []byte("v11")))
+	require.Nil(db.Delete([]byte("k2")))
+	require.Nil(db.Set([]byte("k3"), []byte("v3")))
+	require.Nil(db.Delete([]byte("k3")))
+	require.Nil(db.Delete([]byte("n0")))
+	require.Nil(db.Set([]byte("n0"), []byte("n")))
+	db.UpdateFlags([]byte("k4"), kv.SetPresumeKeyNotExists)
+
+	h1 := db.Staging()
+	require.Nil(db.Set([]byte("k5"), []byte("v5")))
+	h2 := db.Staging()
+	require.Nil(db.Set([]byte("k1"), []byte("v12")))
+	require.Nil(db.Set([]byte("n1"), []byte("n")))
+	// the changes of a staging buffer are visible until it's cleaned up.
+	require.Equal("v12", collect(cp)["k1"])
+	db.Cleanup(h2)
+	require.Nil(db.Delete([]byte("k6")))
+	db.Release(h1)
+	db.Release(h0)
+
+	expected := map[string]string{
+		"k0": "x",
+		"k1": "v11",
+		"k2": "deleted",
+		"k3": "deleted",
+		"k5": "v5",
+		"k6": "deleted",
+		"n0": "n",
+	}
+	require.Equal(expected, collect(cp))
+	mid := db.Checkpoint()
+	h3 := db.Staging()
+	require.Nil(db.Set([]byte("k1"), []byte("v12")))
+	require.Equal(map[string]string{"k1": "v12"}, collect(mid))
+	db.Release(h3)
+	// all keys with values are changed since the beginning.
+	require.Len(collect(&MemDBCheckpoint{}), 11)
+
+	// the keys removed from the buffer are not yielded.
+	db.RemoveFromBuffer([]byte("k5"))
+	delete(expected, "k5")
+	expected["k1"] = "v12"
+	require.Equal(expected, collect(cp))
+
+	// the changes after a reverted checkpoint are gone.
+	db.RevertToCheckpoint(cp)
+	require.Empty(collect(cp))
+
+	_, err := db.IterChangesSince(nil)
+	require.Error(err)
+	_, err = db.IterChangesSince(&MemDBCheckpoint{blocks: 100})
+	require.ErrorContains(err, "doesn't belong")
+
+	var pipelined MemBuffer = NewPipelinedMemDB(nil, nil)
+	_, err = pipelined.IterChangesSince(cp)
+	require.ErrorContains(err, "not supported")
+}
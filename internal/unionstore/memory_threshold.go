@@ -0,0 +1,63 @@
+// Copyright 2024 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unionstore
+
+// memoryThresholdRearmRatio is the ratio of the limit under which the memory usage must drop before a fired
+// MemoryThreshold fires again.
+const memoryThresholdRearmRatio = 0.8
+
+// MemoryThreshold is a memory footprint limit of a MemBuffer, see MemBuffer.SetMemoryThresholds.
+type MemoryThreshold struct {
+	// Limit is the memory footprint in bytes at which Callback is called.
+	Limit uint64
+	// Callback is called with the current Mem() and Len() of the MemBuffer when the memory footprint reaches Limit.
+	Callback func(mem uint64, len int)
+}
+
+// memoryThresholds tracks which of the thresholds have been fired.
+type memoryThresholds struct {
+	thresholds []MemoryThreshold
+	fired      []bool
+}
+
+func newMemoryThresholds(thresholds []MemoryThreshold) *memoryThresholds {
+	if len(thresholds) == 0 {
+		return nil
+	}
+	return &memoryThresholds{
+		thresholds: append([]MemoryThreshold(nil), thresholds...),
+		fired:      make([]bool, len(thresholds)),
+	}
+}
+
+// check fires the thresholds crossed upward by mem, and re-arms the fired ones which mem has dropped below
+// memoryThresholdRearmRatio of.
+func (t *memoryThresholds) check(mem uint64, len int) {
+	if t == nil {
+		return
+	}
+	for i, threshold := range t.thresholds {
+		if t.fired[i] {
+			if float64(mem) < float64(threshold.Limit)*memoryThresholdRearmRatio {
+				t.fired[i] = false
+			}
+			continue
+		}
+		if mem >= threshold.Limit {
+			t.fired[i] = true
+			threshold.Callback(mem, len)
+		}
+	}
+}
@@ -15,8 +15,10 @@
 package unionstore
 
 import (
+	"bytes"
 	"context"
 	stderrors "errors"
+	"io"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -39,14 +41,18 @@ type PipelinedMemDB struct {
 	// concurrent memdb.Set, memdb.SetWithFlags, memdb.Delete and memdb.UpdateFlags.
 	sync.RWMutex
 	onFlushing              atomic.Bool
+	flushingBytes           atomic.Uint64 // flushingBytes is the size of the flushingMemDB, FlushPending reads it concurrently.
 	errCh                   chan error
 	flushFunc               FlushFunc
 	bufferBatchGetter       BufferBatchGetter
+	bufferScanner           BufferScanner
 	memDB                   *MemDB
 	flushingMemDB           *MemDB // the flushingMemDB is not wrapped by a mutex, because there is no data race in it.
 	len, size               int    // len and size records the total flushed and onflushing memdb.
+	writes                  uint64 // writes records the Set and Delete calls of the flushed and onflushing memdb.
 	generation              uint64
 	entryLimit, bufferLimit uint64
+	largeEntryHandler       LargeEntryHandler
 	flushOption             flushOption
 	// prefetchCache is used to cache the result of BatchGet, it's invalidated when Flush.
 	// the values are wrapped by util.Option.
@@ -54,10 +60,19 @@ type PipelinedMemDB struct {
 	//   Some([...]) -> put
 	//   Some([]) -> delete
 	batchGetCache map[string]util.Option[[]byte]
-	memChangeHook func(uint64)
+	// prefetchedRanges records the ranges whose keys are fully loaded into batchGetCache by PrefetchRange,
+	// keys inside these ranges but not in the cache are known to be absent. It's invalidated when Flush.
+	prefetchedRanges []kv.KeyRange
+	memChangeHook    func(uint64)
+	memThresholds    *memoryThresholds
+
+	// batchGetConcurrency is the max number of concurrent requests of BatchGet to read the flushed buffer.
+	batchGetConcurrency int
 
 	// metrics
 	flushWaitDuration time.Duration
+	batchGetHits      int
+	batchGetMisses    int
 }
 
 const (
@@ -69,6 +84,10 @@ const (
 	MinFlushMemSize uint64 = 16 * 1024 * 1024 // 16MB
 	// ForceFlushMemSizeThreshold is the threshold to force flush MemDB, which controls the max memory consumption of PipelinedMemDB.
 	ForceFlushMemSizeThreshold uint64 = 128 * 1024 * 1024 // 128MB
+	// DefBatchGetConcurrency is the default max number of concurrent requests of BatchGet to read the flushed buffer.
+	DefBatchGetConcurrency = 8
+	// minBatchGetKeysPerWorker avoids splitting a small BatchGet into tiny requests.
+	minBatchGetKeysPerWorker = 32
 )
 
 type flushOption struct {
@@ -98,6 +117,9 @@ func newFlushOption() flushOption {
 type FlushFunc func(uint64, *MemDB) error
 type BufferBatchGetter func(ctx context.Context, keys [][]byte) (map[string][]byte, error)
 
+// BufferScanner scans the flushed buffer in range [lower, upper) and returns all the keys and values in it.
+type BufferScanner func(ctx context.Context, lower, upper []byte) (map[string][]byte, error)
+
 func NewPipelinedMemDB(bufferBatchGetter BufferBatchGetter, flushFunc FlushFunc) *PipelinedMemDB {
 	memdb := newMemDB()
 	memdb.setSkipMutex(true)
@@ -112,6 +134,8 @@ func NewPipelinedMemDB(bufferBatchGetter BufferBatchGetter, flushFunc FlushFunc)
 		entryLimit:  memdb.entrySizeLimit,
 		bufferLimit: memdb.bufferSizeLimit,
 		flushOption: flushOpt,
+
+		batchGetConcurrency: DefBatchGetConcurrency,
 	}
 }
 
@@ -154,6 +178,9 @@ func (p *PipelinedMemDB) get(ctx context.Context, k []byte, skipRemoteBuffer boo
 			}
 			return *inner, nil
 		}
+		if p.inPrefetchedRange(k) {
+			return nil, tikverr.ErrNotExist
+		}
 	}
 	// read remote buffer
 	var (
@@ -195,6 +222,8 @@ func (p *PipelinedMemDB) GetFlags(k []byte) (kv.KeyFlags, error) {
 	return f, nil
 }
 
+// BatchGet gets the values of the given keys, the keys absent from both the local buffers and the cache are read
+// from the flushed buffer with at most batchGetConcurrency concurrent requests.
 func (p *PipelinedMemDB) BatchGet(ctx context.Context, keys [][]byte) (map[string][]byte, error) {
 	m := make(map[string][]byte, len(keys))
 	if p.batchGetCache == nil {
@@ -202,18 +231,34 @@ func (p *PipelinedMemDB) BatchGet(ctx context.Context, keys [][]byte) (map[strin
 	}
 	shrinkKeys := make([][]byte, 0, len(keys))
 	for _, k := range keys {
+		// the local buffers take precedence over the flushed buffer, so the keys being flushed are always read from
+		// flushingMemDB even if the flushFunc has already written part of them.
 		v, err := p.GetLocal(ctx, k)
-		if err != nil {
-			if tikverr.IsErrNotFound(err) {
-				shrinkKeys = append(shrinkKeys, k)
-				continue
-			}
+		if err == nil {
+			p.batchGetHits++
+			m[string(k)] = v
+			p.batchGetCache[string(k)] = util.Some(v)
+			continue
+		}
+		if !tikverr.IsErrNotFound(err) {
 			return nil, err
 		}
-		m[string(k)] = v
-		p.batchGetCache[string(k)] = util.Some(v)
+		p.batchGetMisses++
+		if cached, ok := p.batchGetCache[string(k)]; ok {
+			if inner := cached.Inner(); inner != nil {
+				m[string(k)] = *inner
+			}
+			continue
+		}
+		if p.inPrefetchedRange(k) {
+			continue
+		}
+		shrinkKeys = append(shrinkKeys, k)
+	}
+	if len(shrinkKeys) == 0 {
+		return m, nil
 	}
-	storageValues, err := p.bufferBatchGetter(ctx, shrinkKeys)
+	storageValues, err := p.batchGetRemote(ctx, shrinkKeys)
 	if err != nil {
 		return nil, err
 	}
@@ -233,6 +278,99 @@ func (p *PipelinedMemDB) BatchGet(ctx context.Context, keys [][]byte) (map[strin
 	return m, nil
 }
 
+// batchGetRemote reads the keys from the flushed buffer, the keys are split into batches of at least
+// minBatchGetKeysPerWorker keys which are sent concurrently.
+func (p *PipelinedMemDB) batchGetRemote(ctx context.Context, keys [][]byte) (map[string][]byte, error) {
+	concurrency := p.batchGetConcurrency
+	if n := (len(keys) + minBatchGetKeysPerWorker - 1) / minBatchGetKeysPerWorker; n < concurrency {
+		concurrency = n
+	}
+	if concurrency <= 1 {
+		return p.bufferBatchGetter(ctx, keys)
+	}
+	batchSize := (len(keys) + concurrency - 1) / concurrency
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	result := make(map[string][]byte, len(keys))
+	for start := 0; start < len(keys); start += batchSize {
+		end := start + batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		wg.Add(1)
+		go func(batch [][]byte) {
+			defer wg.Done()
+			values, err := p.bufferBatchGetter(ctx, batch)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			for k, v := range values {
+				result[k] = v
+			}
+		}(keys[start:end])
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return result, nil
+}
+
+// SetBatchGetConcurrency sets the max number of concurrent requests BatchGet sends to read the flushed buffer.
+func (p *PipelinedMemDB) SetBatchGetConcurrency(concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	p.batchGetConcurrency = concurrency
+}
+
+// SetBufferScanner sets the scanner used by PrefetchRange to read the flushed buffer by range.
+func (p *PipelinedMemDB) SetBufferScanner(scanner BufferScanner) {
+	p.bufferScanner = scanner
+}
+
+// PrefetchRange loads all the flushed keys in range [lower, upper) into the BatchGet cache with a single scan,
+// so the following point reads in the range can be served without RPC. It returns the number of keys cached.
+// It returns an error if no BufferScanner is set, since the flushed buffer can't be scanned then.
+func (p *PipelinedMemDB) PrefetchRange(ctx context.Context, lower, upper []byte) (int, error) {
+	if p.bufferScanner == nil {
+		return 0, errors.New("no buffer scanner is set for PipelinedMemDB")
+	}
+	storageValues, err := p.bufferScanner(ctx, lower, upper)
+	if err != nil {
+		return 0, err
+	}
+	if p.batchGetCache == nil {
+		p.batchGetCache = make(map[string]util.Option[[]byte], len(storageValues))
+	}
+	for k, v := range storageValues {
+		// the protobuf cast empty byte slice to nil, we need to cast it back when receiving values from storage.
+		if v == nil {
+			v = []byte{}
+		}
+		p.batchGetCache[k] = util.Some(v)
+	}
+	p.prefetchedRanges = append(p.prefetchedRanges, kv.KeyRange{StartKey: lower, EndKey: upper})
+	return len(storageValues), nil
+}
+
+func (p *PipelinedMemDB) inPrefetchedRange(k []byte) bool {
+	for _, r := range p.prefetchedRanges {
+		if bytes.Compare(k, r.StartKey) >= 0 && (len(r.EndKey) == 0 || bytes.Compare(k, r.EndKey) < 0) {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *PipelinedMemDB) UpdateFlags(k []byte, ops ...kv.FlagsOp) {
 	p.memDB.UpdateFlags(k, ops...)
 }
@@ -255,6 +393,16 @@ func (p *PipelinedMemDB) SetWithFlags(key, value []byte, ops ...kv.FlagsOp) erro
 	return err
 }
 
+// TrySet implements MemBuffer interface. Like Set, the buffer size limit is checked against the mutable memdb only,
+// since the flushed and flushing memdbs don't count.
+func (p *PipelinedMemDB) TrySet(key, value []byte) error {
+	p.Lock()
+	defer p.Unlock()
+	err := p.memDB.TrySet(key, value)
+	p.onMemChange()
+	return err
+}
+
 // Delete deletes the key k in the MemBuffer.
 func (p *PipelinedMemDB) Delete(key []byte) error {
 	p.Lock()
@@ -284,6 +432,7 @@ func (p *PipelinedMemDB) Flush(force bool) (bool, error) {
 
 	// invalidate the batch get cache whether the flush is really triggered.
 	p.batchGetCache = nil
+	p.prefetchedRanges = nil
 
 	if len(p.memDB.stages) > 0 {
 		return false, errors.New("there are stages unreleased when Flush is called")
@@ -301,12 +450,16 @@ func (p *PipelinedMemDB) Flush(force bool) (bool, error) {
 			return false, err
 		}
 	}
+	p.flushingBytes.Store(uint64(p.memDB.Size()))
 	p.onFlushing.Store(true)
 	p.flushingMemDB = p.memDB
+	observeArenaStats("pipelined", p.flushingMemDB.Stats())
 	p.len += p.flushingMemDB.Len()
 	p.size += p.flushingMemDB.Size()
+	p.writes += p.flushingMemDB.writes
 	p.memDB = newMemDB()
 	p.memDB.SetEntrySizeLimit(p.entryLimit, p.bufferLimit)
+	p.memDB.SetLargeEntryHandler(p.largeEntryHandler)
 	p.memDB.setSkipMutex(true)
 	p.generation++
 	go func(generation uint64) {
@@ -316,6 +469,7 @@ func (p *PipelinedMemDB) Flush(force bool) (bool, error) {
 		flushStart := time.Now()
 		err := p.flushFunc(generation, p.flushingMemDB)
 		metrics.TiKVPipelinedFlushDuration.Observe(time.Since(flushStart).Seconds())
+		p.flushingBytes.Store(0)
 		p.onFlushing.Store(false)
 		// Send the error to errCh after onFlushing status is set to false.
 		// this guarantees the onFlushing.Store(true) in another goroutine's Flush happens after onFlushing.Store(false) in this function.
@@ -409,6 +563,12 @@ func (p *PipelinedMemDB) SetEntrySizeLimit(entryLimit, bufferLimit uint64) {
 	p.memDB.SetEntrySizeLimit(entryLimit, bufferLimit)
 }
 
+// SetLargeEntryHandler sets the handler for the entries exceeding the entry size limit.
+func (p *PipelinedMemDB) SetLargeEntryHandler(h LargeEntryHandler) {
+	p.largeEntryHandler = h
+	p.memDB.SetLargeEntryHandler(h)
+}
+
 func (p *PipelinedMemDB) Len() int {
 	return p.memDB.Len() + p.len
 }
@@ -421,6 +581,13 @@ func (p *PipelinedMemDB) Size() int {
 	return size
 }
 
+// WriteAmplificationStats returns the count of Set and Delete calls and the count of distinct keys.
+// A key written in different flush generations is counted once in each generation.
+func (p *PipelinedMemDB) WriteAmplificationStats() (totalWrites, distinctKeys uint64) {
+	totalWrites, distinctKeys = p.memDB.WriteAmplificationStats()
+	return totalWrites + p.writes, distinctKeys + uint64(p.len)
+}
+
 func (p *PipelinedMemDB) OnFlushing() bool {
 	return p.onFlushing.Load()
 }
@@ -430,10 +597,17 @@ func (p *PipelinedMemDB) SetMemoryFootprintChangeHook(hook func(uint64)) {
 	p.memChangeHook = hook
 }
 
+// SetMemoryThresholds sets the thresholds of the memory footprint, see MemBuffer.SetMemoryThresholds.
+func (p *PipelinedMemDB) SetMemoryThresholds(thresholds []MemoryThreshold) {
+	p.memThresholds = newMemoryThresholds(thresholds)
+}
+
 func (p *PipelinedMemDB) onMemChange() {
+	mem := p.Mem()
 	if p.memChangeHook != nil {
-		p.memChangeHook(p.Mem())
+		p.memChangeHook(mem)
 	}
+	p.memThresholds.check(mem, p.Len())
 }
 
 // Mem returns the memory usage of MemBuffer.
@@ -452,11 +626,13 @@ type errIterator struct {
 	err error
 }
 
-func (e *errIterator) Valid() bool   { return true }
-func (e *errIterator) Next() error   { return e.err }
-func (e *errIterator) Key() []byte   { return nil }
-func (e *errIterator) Value() []byte { return nil }
-func (e *errIterator) Close()        {}
+func (e *errIterator) Valid() bool        { return true }
+func (e *errIterator) Next() error        { return e.err }
+func (e *errIterator) Key() []byte        { return nil }
+func (e *errIterator) Value() []byte      { return nil }
+func (e *errIterator) Close()             {}
+func (e *errIterator) Flags() kv.KeyFlags { return 0 }
+func (e *errIterator) HasValue() bool     { return false }
 
 // SnapshotIter implements MemBuffer interface, returns an iterator which outputs error.
 func (p *PipelinedMemDB) SnapshotIter(k, upperBound []byte) Iterator {
@@ -468,6 +644,16 @@ func (p *PipelinedMemDB) SnapshotIterReverse(k, lowerBound []byte) Iterator {
 	return &errIterator{err: errors.New("SnapshotIter is not supported for PipelinedMemDB")}
 }
 
+// IterWithFlags implements MemBuffer interface, returns an iterator which outputs error.
+func (p *PipelinedMemDB) IterWithFlags(lower, upper []byte) FlagsIterator {
+	return &errIterator{err: errors.New("IterWithFlags is not supported for PipelinedMemDB")}
+}
+
+// IterReverseWithFlags implements MemBuffer interface, returns an iterator which outputs error.
+func (p *PipelinedMemDB) IterReverseWithFlags(upper, lower []byte) FlagsIterator {
+	return &errIterator{err: errors.New("IterReverseWithFlags is not supported for PipelinedMemDB")}
+}
+
 // The following methods are not implemented for PipelinedMemDB and DOES NOT return error because of the interface limitation.
 // It panics when the following methods are called, the application should not use those methods when PipelinedMemDB is enabled.
 
@@ -482,7 +668,7 @@ func (p *PipelinedMemDB) InspectStage(int, func([]byte, kv.KeyFlags, []byte)) {
 }
 
 // SnapshotGetter implements MemBuffer interface.
-func (p *PipelinedMemDB) SnapshotGetter() Getter {
+func (p *PipelinedMemDB) SnapshotGetter() MemBufferSnapshot {
 	panic("SnapshotGetter is not supported for PipelinedMemDB")
 }
 
@@ -509,6 +695,11 @@ func (p *PipelinedMemDB) Release(h int) {
 	p.memDB.Release(h)
 }
 
+// ClearAllFlags implements MemBuffer interface, the flags of the flushed keys are not cleared.
+func (p *PipelinedMemDB) ClearAllFlags() {
+	p.memDB.ClearAllFlags()
+}
+
 // Checkpoint implements MemBuffer interface.
 func (p *PipelinedMemDB) Checkpoint() *MemDBCheckpoint {
 	panic("Checkpoint is not supported for PipelinedMemDB")
@@ -522,6 +713,98 @@ func (p *PipelinedMemDB) RevertToCheckpoint(*MemDBCheckpoint) {
 // GetFlushMetrics implements MemBuffer interface.
 func (p *PipelinedMemDB) GetFlushMetrics() FlushMetrics {
 	return FlushMetrics{
-		WaitDuration: p.flushWaitDuration,
+		WaitDuration:   p.flushWaitDuration,
+		MemDBHitCount:  p.batchGetHits,
+		MemDBMissCount: p.batchGetMisses,
+	}
+}
+
+// FlushPending implements MemBuffer interface. At most one flush is in progress at a time, the bytes queued are the
+// size of the flushingMemDB until its flushFunc returns. It only reads the atomics, so it's safe to be called
+// concurrently with Flush.
+func (p *PipelinedMemDB) FlushPending() (inflight int, bytesQueued uint64) {
+	if !p.onFlushing.Load() {
+		return 0, 0
+	}
+	return 1, p.flushingBytes.Load()
+}
+
+// Stats implements MemBuffer interface, it returns the stats of the MemDB which is not flushed yet.
+func (p *PipelinedMemDB) Stats() ArenaStats {
+	return p.memDB.Stats()
+}
+
+// Serialize implements MemBuffer interface, it's not supported since the flushed keys are not kept in memory.
+func (p *PipelinedMemDB) Serialize() ([]byte, error) {
+	return nil, errors.New("Serialize is not supported for PipelinedMemDB")
+}
+
+// ExportMutations implements MemBuffer interface, it's not supported since the flushed keys are not kept in memory.
+func (p *PipelinedMemDB) ExportMutations(io.Writer) error {
+	return errors.New("ExportMutations is not supported for PipelinedMemDB")
+}
+
+// Savepoint implements MemBuffer interface, it's not supported since the changes may be flushed at any time.
+func (p *PipelinedMemDB) Savepoint(string) error {
+	return errors.New("Savepoint is not supported for PipelinedMemDB")
+}
+
+// RollbackToSavepoint implements MemBuffer interface, it's not supported since the flushed changes can't be
+// rolled back.
+func (p *PipelinedMemDB) RollbackToSavepoint(string) error {
+	return errors.New("RollbackToSavepoint is not supported for PipelinedMemDB")
+}
+
+// ReleaseSavepoint implements MemBuffer interface, it's not supported like Savepoint.
+func (p *PipelinedMemDB) ReleaseSavepoint(string) error {
+	return errors.New("ReleaseSavepoint is not supported for PipelinedMemDB")
+}
+
+// AssertKeysInRange implements MemBuffer interface, it checks the keys in the current and the flushing MemDB, the
+// keys already flushed are not kept in memory and not checked.
+func (p *PipelinedMemDB) AssertKeysInRange(lower, upper []byte) error {
+	if err := p.memDB.AssertKeysInRange(lower, upper); err != nil {
+		return err
+	}
+	if p.flushingMemDB != nil {
+		return p.flushingMemDB.AssertKeysInRange(lower, upper)
 	}
+	return nil
+}
+
+// ImportMutations implements MemBuffer interface, it's not supported since the keys can't be flushed by the
+// transaction who exported them.
+func (p *PipelinedMemDB) ImportMutations(io.Reader) error {
+	return errors.New("ImportMutations is not supported for PipelinedMemDB")
+}
+
+// Export implements MemBuffer interface, it's not supported since the flushed keys are not kept in memory.
+func (p *PipelinedMemDB) Export(io.Writer) error {
+	return errors.New("Export is not supported for PipelinedMemDB")
+}
+
+// Import implements MemBuffer interface, it's not supported like ImportMutations.
+func (p *PipelinedMemDB) Import(io.Reader) error {
+	return errors.New("Import is not supported for PipelinedMemDB")
+}
+
+// PurgeTombstonesBefore implements MemBuffer interface. Checkpoint is not supported for PipelinedMemDB, so cp must
+// be nil, and the flushed generation works as the stable checkpoint: the tombstones in the flushingMemDB are
+// removed once its flush is done, since they are already written to TiKV and read from the flushed buffer
+// afterwards. The keys with flags are kept. It purges nothing while the flush is in progress.
+func (p *PipelinedMemDB) PurgeTombstonesBefore(cp *MemDBCheckpoint) (int, error) {
+	if cp != nil {
+		return 0, errors.New("PurgeTombstonesBefore of PipelinedMemDB only accepts a nil checkpoint")
+	}
+	if p.flushingMemDB == nil || p.onFlushing.Load() {
+		return 0, nil
+	}
+	p.Lock()
+	defer p.Unlock()
+	return p.flushingMemDB.purgeTombstones(nil, func(flags kv.KeyFlags) bool { return flags == 0 }), nil
+}
+
+// IterChangesSince implements MemBuffer interface, it's not supported since Checkpoint is not supported.
+func (p *PipelinedMemDB) IterChangesSince(*MemDBCheckpoint) (ChangesIterator, error) {
+	return nil, errors.New("IterChangesSince is not supported for PipelinedMemDB")
 }
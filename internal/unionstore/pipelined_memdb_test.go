@@ -16,6 +16,7 @@ package unionstore
 
 import (
 	"context"
+	"fmt"
 	"strconv"
 	"sync"
 	"testing"
@@ -24,7 +25,9 @@ import (
 	"github.com/pingcap/failpoint"
 	"github.com/stretchr/testify/require"
 	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/kv"
 	"github.com/tikv/client-go/v2/util"
+	"github.com/tikv/client-go/v2/util/redact"
 )
 
 func emptyBufferBatchGetter(ctx context.Context, keys [][]byte) (map[string][]byte, error) {
@@ -187,6 +190,75 @@ func TestPipelinedFlushBlock(t *testing.T) {
 	require.Nil(t, memdb.FlushWait())
 }
 
+func TestPipelinedFlushPending(t *testing.T) {
+	blockCh := make(chan struct{})
+	memdb := NewPipelinedMemDB(emptyBufferBatchGetter, func(_ uint64, db *MemDB) error {
+		<-blockCh
+		return nil
+	})
+	inflight, bytesQueued := memdb.FlushPending()
+	require.Zero(t, inflight)
+	require.Zero(t, bytesQueued)
+
+	require.Nil(t, memdb.Set([]byte("key"), []byte("value")))
+	size := memdb.Size()
+	flushed, err := memdb.Flush(true)
+	require.Nil(t, err)
+	require.True(t, flushed)
+	// the keys written after the flush are not queued.
+	require.Nil(t, memdb.Set([]byte("key2"), []byte("value2")))
+	inflight, bytesQueued = memdb.FlushPending()
+	require.Equal(t, 1, inflight)
+	require.Equal(t, uint64(size), bytesQueued)
+
+	close(blockCh)
+	require.Nil(t, memdb.FlushWait())
+	inflight, bytesQueued = memdb.FlushPending()
+	require.Zero(t, inflight)
+	require.Zero(t, bytesQueued)
+
+	inflight, bytesQueued = NewMemDBWithContext().FlushPending()
+	require.Zero(t, inflight)
+	require.Zero(t, bytesQueued)
+}
+
+func TestPipelinedFlushPendingConcurrently(t *testing.T) {
+	memdb := NewPipelinedMemDB(emptyBufferBatchGetter, func(_ uint64, db *MemDB) error {
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	// FlushPending is polled while the flushes are started and finished, run it with -race.
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			inflight, bytesQueued := memdb.FlushPending()
+			require.LessOrEqual(t, inflight, 1)
+			if inflight == 0 {
+				require.Zero(t, bytesQueued)
+			}
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		require.Nil(t, memdb.Set([]byte(strconv.Itoa(i)), []byte("value")))
+		_, err := memdb.Flush(true)
+		require.Nil(t, err)
+	}
+	require.Nil(t, memdb.FlushWait())
+	close(done)
+	wg.Wait()
+	inflight, bytesQueued := memdb.FlushPending()
+	require.Zero(t, inflight)
+	require.Zero(t, bytesQueued)
+}
+
 func TestPipelinedFlushGet(t *testing.T) {
 	blockCh := make(chan struct{})
 	memdb := NewPipelinedMemDB(emptyBufferBatchGetter, func(_ uint64, db *MemDB) error {
@@ -293,6 +365,67 @@ func TestPipelinedFlushGeneration(t *testing.T) {
 	require.Nil(t, memdb.FlushWait())
 }
 
+func TestPipelinedPurgeTombstones(t *testing.T) {
+	blockCh := make(chan struct{})
+	flushed := make(map[string][]byte)
+	memdb := NewPipelinedMemDB(func(_ context.Context, keys [][]byte) (map[string][]byte, error) {
+		m := make(map[string][]byte, len(keys))
+		for _, k := range keys {
+			if v, ok := flushed[string(k)]; ok {
+				m[string(k)] = v
+			}
+		}
+		return m, nil
+	}, func(_ uint64, db *MemDB) error {
+		<-blockCh
+		for it, _ := db.Iter(nil, nil); it.Valid(); it.Next() {
+			flushed[string(it.Key())] = it.Value()
+		}
+		return nil
+	})
+	_, err := memdb.PurgeTombstonesBefore(&MemDBCheckpoint{})
+	require.Error(t, err)
+	for i := 0; i < 10; i++ {
+		require.Nil(t, memdb.Set([]byte{byte(i)}, []byte("v")))
+	}
+	for i := 0; i < 10; i += 2 {
+		require.Nil(t, memdb.Delete([]byte{byte(i)}))
+	}
+	require.Nil(t, memdb.DeleteWithFlags([]byte("locked"), kv.SetKeyLocked))
+	_, err = memdb.Flush(true)
+	require.Nil(t, err)
+
+	// the flushing generation is not purged.
+	n, err := memdb.PurgeTombstonesBefore(nil)
+	require.Nil(t, err)
+	require.Zero(t, n)
+	blockCh <- struct{}{}
+	require.Eventually(t, func() bool { return !memdb.OnFlushing() }, 5*time.Second, 10*time.Millisecond)
+
+	n, err = memdb.PurgeTombstonesBefore(nil)
+	require.Nil(t, err)
+	require.Equal(t, 5, n)
+	// the purged deletions are flushed, and they are still visible by reading the flushed buffer.
+	for i := 0; i < 10; i++ {
+		require.Contains(t, flushed, string([]byte{byte(i)}))
+		v, err := memdb.Get(context.Background(), []byte{byte(i)})
+		require.Nil(t, err)
+		if i%2 == 0 {
+			require.True(t, IsTombstone(v))
+			_, err = memdb.GetLocal(context.Background(), []byte{byte(i)})
+			require.True(t, tikverr.IsErrNotFound(err))
+		} else {
+			require.Equal(t, []byte("v"), v)
+		}
+	}
+	flags, err := memdb.GetFlags([]byte("locked"))
+	require.Nil(t, err)
+	require.True(t, flags.HasLocked())
+	require.Equal(t, 11, memdb.Len())
+	close(blockCh)
+	require.Nil(t, memdb.FlushWait())
+}
+
 func TestErrorIterator(t *testing.T) {
 	iteratorToErr := func(iter Iterator) {
 		for iter.Valid() {
@@ -460,3 +593,248 @@ func TestMemBufferBatchGetCache(t *testing.T) {
 	require.True(t, tikverr.IsErrNotFound(err))
 	require.Nil(t, pipelinedMemdb.FlushWait())
 }
+
+func TestPipelinedBatchGetConcurrent(t *testing.T) {
+	var (
+		remoteMutex sync.Mutex
+		batchGets   int
+	)
+	remoteBuffer := make(map[string][]byte)
+	flushStarted := make(chan struct{}, 1)
+	continueFlush := make(chan struct{})
+	pipelinedMemdb := NewPipelinedMemDB(func(_ context.Context, keys [][]byte) (map[string][]byte, error) {
+		// simulate a slow remote buffer whose latency grows with the batch size.
+		time.Sleep(time.Duration(len(keys)) * 200 * time.Microsecond)
+		remoteMutex.Lock()
+		defer remoteMutex.Unlock()
+		batchGets++
+		m := make(map[string][]byte, len(keys))
+		for _, k := range keys {
+			if val, ok := remoteBuffer[string(k)]; ok {
+				m[string(k)] = val
+			}
+		}
+		return m, nil
+	}, func(generation uint64, db *MemDB) error {
+		i := 0
+		for it, _ := db.Iter(nil, nil); it.Valid(); it.Next() {
+			remoteMutex.Lock()
+			remoteBuffer[string(it.Key())] = append([]byte{}, it.Value()...)
+			remoteMutex.Unlock()
+			// the second generation is blocked after writing half of its keys.
+			if i++; generation == 2 && i == db.Len()/2 {
+				flushStarted <- struct{}{}
+				<-continueFlush
+			}
+		}
+		return nil
+	})
+
+	keys := make([][]byte, 0, 512)
+	for i := 0; i < 512; i++ {
+		k := []byte(fmt.Sprintf("k%03d", i))
+		keys = append(keys, k)
+		if i%2 == 0 {
+			require.Nil(t, pipelinedMemdb.Set(k, []byte("v1")))
+		}
+	}
+	_, err := pipelinedMemdb.Flush(true)
+	require.Nil(t, err)
+	require.Nil(t, pipelinedMemdb.FlushWait())
+
+	batchGet := func(concurrency int) (map[string][]byte, time.Duration) {
+		pipelinedMemdb.SetBatchGetConcurrency(concurrency)
+		pipelinedMemdb.batchGetCache = nil
+		start := time.Now()
+		m, err := pipelinedMemdb.BatchGet(context.Background(), keys)
+		require.Nil(t, err)
+		return m, time.Since(start)
+	}
+	m, serial := batchGet(1)
+	require.Len(t, m, 256)
+	require.Equal(t, 1, batchGets)
+	m, concurrent := batchGet(DefBatchGetConcurrency)
+	require.Len(t, m, 256)
+	require.Equal(t, 1+DefBatchGetConcurrency, batchGets)
+	require.Less(t, concurrent, serial/2)
+	metrics := pipelinedMemdb.GetFlushMetrics()
+	require.Equal(t, 0, metrics.MemDBHitCount)
+	require.Equal(t, 1024, metrics.MemDBMissCount)
+
+	// overwrite the first 100 keys and flush them, the BatchGet during the flush must read the local values.
+	for _, k := range keys[:100] {
+		require.Nil(t, pipelinedMemdb.Set(k, []byte("v2")))
+	}
+	_, err = pipelinedMemdb.Flush(true)
+	require.Nil(t, err)
+	<-flushStarted
+	m, _ = batchGet(DefBatchGetConcurrency)
+	close(continueFlush)
+	require.Nil(t, pipelinedMemdb.FlushWait())
+	for i, k := range keys {
+		v, ok := m[string(k)]
+		switch {
+		case i < 100:
+			require.True(t, ok)
+			require.Equal(t, []byte("v2"), v)
+		case i%2 == 0:
+			require.True(t, ok)
+			require.Equal(t, []byte("v1"), v)
+		default:
+			require.False(t, ok)
+		}
+	}
+	metrics = pipelinedMemdb.GetFlushMetrics()
+	require.Equal(t, 100, metrics.MemDBHitCount)
+	require.Equal(t, 1024+412, metrics.MemDBMissCount)
+}
+
+func TestPipelinedPrefetchRange(t *testing.T) {
+	remoteBuffer := map[string][]byte{
+		"k1": []byte("v1"),
+		"k2": {},
+		"k3": []byte("v3"),
+		"k5": []byte("v5"),
+	}
+	batchGets, scans := 0, 0
+	pipelinedMemdb := NewPipelinedMemDB(func(_ context.Context, keys [][]byte) (map[string][]byte, error) {
+		batchGets++
+		m := make(map[string][]byte, len(keys))
+		for _, k := range keys {
+			if val, ok := remoteBuffer[string(k)]; ok {
+				m[string(k)] = val
+			}
+		}
+		return m, nil
+	}, func(_ uint64, db *MemDB) error {
+		return nil
+	})
+	us := NewUnionStore(pipelinedMemdb, &mockSnapshot{newMemDB()})
+
+	// without a scanner, PrefetchRange fails and nothing is cached.
+	_, err := us.PrefetchRange(context.Background(), []byte("k1"), []byte("k4"))
+	require.ErrorContains(t, err, "no buffer scanner")
+	_, err = us.Get(context.Background(), []byte("k1"))
+	require.Nil(t, err)
+	require.Equal(t, 1, batchGets)
+	batchGets = 0
+
+	pipelinedMemdb.SetBufferScanner(func(_ context.Context, lower, upper []byte) (map[string][]byte, error) {
+		scans++
+		m := make(map[string][]byte)
+		for k, v := range remoteBuffer {
+			if k >= string(lower) && k < string(upper) {
+				m[k] = v
+			}
+		}
+		return m, nil
+	})
+	require.Nil(t, pipelinedMemdb.Set([]byte("k3"), []byte("v33")))
+	n, err := us.PrefetchRange(context.Background(), []byte("k1"), []byte("k4"))
+	require.Nil(t, err)
+	require.Equal(t, 3, n)
+	require.Equal(t, 1, scans)
+
+	v, err := us.Get(context.Background(), []byte("k1"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("v1"), v)
+	v, err = us.GetMemBuffer().Get(context.Background(), []byte("k2"))
+	require.Nil(t, err)
+	require.Len(t, v, 0)
+	// the local memdb has higher priority than the cache.
+	v, err = us.Get(context.Background(), []byte("k3"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("v33"), v)
+	// keys in the prefetched range but absent in the remote buffer are not fetched again.
+	_, err = us.GetMemBuffer().Get(context.Background(), []byte("k2x"))
+	require.True(t, tikverr.IsErrNotFound(err))
+	require.Equal(t, 0, batchGets)
+
+	// keys out of the prefetched range still go to the remote buffer.
+	v, err = us.Get(context.Background(), []byte("k5"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("v5"), v)
+	require.Equal(t, 1, batchGets)
+
+	// the cache is invalidated by flush.
+	_, err = pipelinedMemdb.Flush(true)
+	require.Nil(t, err)
+	require.Nil(t, pipelinedMemdb.FlushWait())
+	require.Nil(t, pipelinedMemdb.prefetchedRanges)
+	_, err = us.GetMemBuffer().Get(context.Background(), []byte("k2x"))
+	require.True(t, tikverr.IsErrNotFound(err))
+	require.Equal(t, 2, batchGets)
+}
+
+func TestPipelinedWriteAmplificationStats(t *testing.T) {
+	memdb := NewPipelinedMemDB(emptyBufferBatchGetter, func(_ uint64, db *MemDB) error {
+		return nil
+	})
+	for i := 0; i < 3; i++ {
+		require.Nil(t, memdb.Set([]byte("k1"), []byte(strconv.Itoa(i))))
+	}
+	require.Nil(t, memdb.Delete([]byte("k2")))
+	writes, keys := memdb.WriteAmplificationStats()
+	require.Equal(t, uint64(4), writes)
+	require.Equal(t, uint64(2), keys)
+
+	// the stats of flushed memdb are accumulated.
+	flushed, err := memdb.Flush(true)
+	require.True(t, flushed)
+	require.Nil(t, err)
+	require.Nil(t, memdb.Set([]byte("k1"), []byte("v")))
+	require.Nil(t, memdb.Set([]byte("k1"), []byte("v")))
+	writes, keys = memdb.WriteAmplificationStats()
+	require.Equal(t, uint64(6), writes)
+	require.Equal(t, uint64(3), keys)
+	require.Nil(t, memdb.FlushWait())
+}
+
+func TestPipelinedAssertKeysInRange(t *testing.T) {
+	flushCh := make(chan struct{})
+	memdb := NewPipelinedMemDB(emptyBufferBatchGetter, func(_ uint64, db *MemDB) error {
+		<-flushCh
+		return nil
+	})
+	require.Nil(t, memdb.Set([]byte("t1_a"), []byte("v")))
+	require.Nil(t, memdb.Set([]byte("t3_a"), []byte("v")))
+	require.Nil(t, memdb.AssertKeysInRange([]byte("t1"), []byte("t4")))
+
+	// the keys of the flushing memdb are checked too.
+	flushed, err := memdb.Flush(true)
+	require.True(t, flushed)
+	require.Nil(t, err)
+	require.Nil(t, memdb.Set([]byte("t2_a"), []byte("v")))
+	require.Nil(t, memdb.AssertKeysInRange([]byte("t1"), []byte("t4")))
+	require.ErrorContains(t, memdb.AssertKeysInRange([]byte("t2"), []byte("t4")), "key "+redact.Key([]byte("t1_a"))+" is out of range")
+	require.ErrorContains(t, memdb.AssertKeysInRange([]byte("t1"), []byte("t2")), "key "+redact.Key([]byte("t2_a"))+" is out of range")
+	close(flushCh)
+	require.Nil(t, memdb.FlushWait())
+
+	// the flushed keys are not kept.
+	require.Nil(t, memdb.AssertKeysInRange([]byte("t2"), []byte("t3")))
+}
+
+func TestPipelinedMemoryThresholds(t *testing.T) {
+	memdb := NewPipelinedMemDB(emptyBufferBatchGetter, func(_ uint64, db *MemDB) error {
+		return nil
+	})
+	var fires []int
+	memdb.SetMemoryThresholds([]MemoryThreshold{{
+		Limit: 64 << 10,
+		Callback: func(mem uint64, len int) {
+			require.GreaterOrEqual(t, mem, uint64(64<<10))
+			require.Equal(t, memdb.Len(), len)
+			fires = append(fires, len)
+		},
+	}})
+	var hookCalls int
+	memdb.SetMemoryFootprintChangeHook(func(uint64) { hookCalls++ })
+
+	value := make([]byte, 1024)
+	for i := 0; i < 128; i++ {
+		require.Nil(t, memdb.Set([]byte(strconv.Itoa(i)), value))
+	}
+	require.Len(t, fires, 1)
+	require.Equal(t, 128, hookCalls)
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unionstore
+
+import (
+	"container/list"
+	"sync"
+)
+
+// snapshotReadCache is the ReadThroughCache created by KVUnionStore.EnableSnapshotReadCache and
+// KVUnionStore.EnableSnapshotCache. It's an LRU cache bounded by the total bytes of the keys and values and by the
+// count of the entries, and it also caches the keys absent in the snapshot with nil values.
+type snapshotReadCache struct {
+	mu       sync.Mutex
+	maxBytes int
+	bytes    int
+	// maxEntries is the max count of the entries, zero means unlimited.
+	maxEntries int
+	entries    map[string]*list.Element
+	// lru orders the entries from the most recently used to the least recently used.
+	lru    *list.List
+	hits   uint64
+	misses uint64
+}
+
+type snapshotReadCacheEntry struct {
+	key   string
+	value []byte
+}
+
+func newSnapshotReadCache(maxBytes int) *snapshotReadCache {
+	return &snapshotReadCache{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Get implements ReadThroughCache.
+func (c *snapshotReadCache) Get(key []byte) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[string(key)]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.lru.MoveToFront(e)
+	return e.Value.(*snapshotReadCacheEntry).value, true
+}
+
+// Put implements ReadThroughCache.
+func (c *snapshotReadCache) Put(key, value []byte) {
+	size := len(key) + len(value)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeLocked(key)
+	if size > c.maxBytes {
+		return
+	}
+	c.entries[string(key)] = c.lru.PushFront(&snapshotReadCacheEntry{key: string(key), value: value})
+	c.bytes += size
+	for c.bytes > c.maxBytes || (c.maxEntries > 0 && c.lru.Len() > c.maxEntries) {
+		c.removeElementLocked(c.lru.Back())
+	}
+}
+
+// putNotExist caches that key doesn't exist in the snapshot.
+func (c *snapshotReadCache) putNotExist(key []byte) {
+	c.Put(key, nil)
+}
+
+// remove evicts key from the cache.
+func (c *snapshotReadCache) remove(key []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeLocked(key)
+}
+
+// clear evicts all entries, the hits and misses are kept.
+func (c *snapshotReadCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+	c.lru.Init()
+	c.bytes = 0
+}
+
+func (c *snapshotReadCache) removeLocked(key []byte) {
+	if e, ok := c.entries[string(key)]; ok {
+		c.removeElementLocked(e)
+	}
+}
+
+func (c *snapshotReadCache) removeElementLocked(e *list.Element) {
+	entry := c.lru.Remove(e).(*snapshotReadCacheEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= len(entry.key) + len(entry.value)
+}
+
+func (c *snapshotReadCache) stats() (hits, misses uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
@@ -35,6 +35,9 @@
 package unionstore
 
 import (
+	"context"
+
+	"github.com/pkg/errors"
 	"github.com/tikv/client-go/v2/internal/logutil"
 	"github.com/tikv/client-go/v2/kv"
 	"go.uber.org/zap"
@@ -51,16 +54,37 @@ type UnionIter struct {
 	curIsDirty bool
 	isValid    bool
 	reverse    bool
+	// compare compares two keys in the ascending order, it's kv.CmpKey unless set by NewUnionIterWithCompare.
+	compare func(a, b []byte) int
+
+	// newSnapshotIt recreates the snapshot iterator positioned on the given key, it's used by Seek when the
+	// snapshot iterator can't be moved there otherwise. It's nil if the union iterator isn't created by
+	// KVUnionStore.
+	newSnapshotIt func(key []byte) (Iterator, error)
+
+	// ctx is checked every unionIterCtxCheckInterval calls of Next, so that a long iteration stops once it's done.
+	// It's nil if the union iterator isn't created by KVUnionStore.IterCtx or KVUnionStore.IterReverseCtx.
+	ctx   context.Context
+	steps int
 }
 
+const unionIterCtxCheckInterval = 64
+
 // NewUnionIter returns a union iterator for BufferStore.
 func NewUnionIter(dirtyIt Iterator, snapshotIt Iterator, reverse bool) (*UnionIter, error) {
+	return NewUnionIterWithCompare(dirtyIt, snapshotIt, reverse, kv.CmpKey)
+}
+
+// NewUnionIterWithCompare is like NewUnionIter, but the keys of the two iterators are merged by cmp, which compares
+// two keys in the ascending order. It's used by tests to check the merge under custom orderings.
+func NewUnionIterWithCompare(dirtyIt Iterator, snapshotIt Iterator, reverse bool, cmp func(a, b []byte) int) (*UnionIter, error) {
 	it := &UnionIter{
 		dirtyIt:       dirtyIt,
 		snapshotIt:    snapshotIt,
 		dirtyValid:    dirtyIt.Valid(),
 		snapshotValid: snapshotIt.Valid(),
 		reverse:       reverse,
+		compare:       cmp,
 	}
 	err := it.updateCur()
 	if err != nil {
@@ -110,12 +134,7 @@ func (iter *UnionIter) updateCur() error {
 
 		// both valid
 		if iter.snapshotValid && iter.dirtyValid {
-			snapshotKey := iter.snapshotIt.Key()
-			dirtyKey := iter.dirtyIt.Key()
-			cmp := kv.CmpKey(dirtyKey, snapshotKey)
-			if iter.reverse {
-				cmp = -cmp
-			}
+			cmp := iter.cmp(iter.dirtyIt.Key(), iter.snapshotIt.Key())
 			// if equal, means both have value
 			if cmp == 0 {
 				if len(iter.dirtyIt.Value()) == 0 {
@@ -160,6 +179,15 @@ func (iter *UnionIter) updateCur() error {
 
 // Next implements the Iterator Next interface.
 func (iter *UnionIter) Next() error {
+	if iter.ctx != nil {
+		iter.steps++
+		if iter.steps%unionIterCtxCheckInterval == 0 {
+			if err := iter.ctx.Err(); err != nil {
+				iter.isValid = false
+				return errors.WithStack(err)
+			}
+		}
+	}
 	var err error
 	if !iter.curIsDirty {
 		err = iter.snapshotNext()
@@ -173,6 +201,65 @@ func (iter *UnionIter) Next() error {
 	return err
 }
 
+// Seek implements the SeekableIterator interface. It moves the iterator to the first entry whose key >= key, or
+// <= key for a reverse iterator, and skips the keys deleted in the MemBuffer as Next does. If key is not before the
+// current key, the snapshot iterator is reused when it's already at or beyond key, otherwise it's sought if it's a
+// SeekableIterator, or moved forward by Next. Seeking backward recreates a snapshot iterator which can't seek.
+func (iter *UnionIter) Seek(key []byte) error {
+	forward := iter.isValid && iter.cmp(key, iter.Key()) >= 0
+	dirtyIt, err := iter.seekIter(iter.dirtyIt, nil, forward, key)
+	if err != nil {
+		return err
+	}
+	iter.dirtyIt = dirtyIt
+	iter.dirtyValid = dirtyIt.Valid()
+	snapshotIt, err := iter.seekIter(iter.snapshotIt, iter.newSnapshotIt, forward, key)
+	if err != nil {
+		return err
+	}
+	iter.snapshotIt = snapshotIt
+	iter.snapshotValid = snapshotIt.Valid()
+	return iter.updateCur()
+}
+
+// seekIter moves it to the first entry at or beyond key in the iteration order. If forward is true, key is not before
+// the current key of the union iterator, so that it only needs to move forward. The keys before its position are
+// consumed then, which means it's already beyond key if it's invalid.
+func (iter *UnionIter) seekIter(it Iterator, newIt func(key []byte) (Iterator, error), forward bool, key []byte) (Iterator, error) {
+	if forward && (!it.Valid() || iter.cmp(it.Key(), key) >= 0) {
+		return it, nil
+	}
+	if seekable, ok := it.(SeekableIterator); ok {
+		return it, seekable.Seek(key)
+	}
+	if forward {
+		for it.Valid() && iter.cmp(it.Key(), key) < 0 {
+			if err := it.Next(); err != nil {
+				return nil, err
+			}
+		}
+		return it, nil
+	}
+	if newIt == nil {
+		return nil, errors.New("union iterator can't seek backward since the underlying iterator is not seekable")
+	}
+	newIter, err := newIt(key)
+	if err != nil {
+		return nil, err
+	}
+	it.Close()
+	return newIter, nil
+}
+
+// cmp compares two keys in the iteration order.
+func (iter *UnionIter) cmp(a, b []byte) int {
+	cmp := iter.compare(a, b)
+	if iter.reverse {
+		return -cmp
+	}
+	return cmp
+}
+
 // Value implements the Iterator Value interface.
 // Multi columns
 func (iter *UnionIter) Value() []byte {
@@ -35,10 +35,14 @@
 package unionstore
 
 import (
+	"bytes"
 	"context"
+	"io"
 	"math"
+	"sync/atomic"
 	"time"
 
+	"github.com/pingcap/errors"
 	tikverr "github.com/tikv/client-go/v2/error"
 	"github.com/tikv/client-go/v2/kv"
 )
@@ -52,6 +56,31 @@ type Iterator interface {
 	Close()
 }
 
+// SeekableIterator is an Iterator which can be repositioned without being recreated.
+type SeekableIterator interface {
+	Iterator
+	// Seek moves the iterator to the first entry whose key >= key, or <= key for a reverse iterator. The bounds of
+	// the iterator are kept, so it becomes invalid if there is no such entry within the bounds.
+	Seek(key []byte) error
+}
+
+// FlagsIterator is an Iterator which also yields the KeyFlags of the keys.
+// Unlike Iterator, it yields keys that only have flags but no value.
+type FlagsIterator interface {
+	Iterator
+	// Flags returns the KeyFlags of the current key.
+	Flags() kv.KeyFlags
+	// HasValue returns false if the current key only has flags.
+	HasValue() bool
+}
+
+// ChangesIterator is an Iterator over the keys changed since a checkpoint, see MemBuffer.IterChangesSince.
+type ChangesIterator interface {
+	Iterator
+	// Deleted returns true if the latest change of the current key is a deletion, Value returns an empty value then.
+	Deleted() bool
+}
+
 // Getter is the interface for the Get method.
 type Getter interface {
 	// Get gets the value for key k from kv store.
@@ -59,11 +88,27 @@ type Getter interface {
 	Get(ctx context.Context, k []byte) ([]byte, error)
 }
 
+// MemBufferSnapshot is a read-only view of the MemBuffer at the time it's taken, which excludes the changes in the
+// staging buffers. The reads return ErrSnapshotInvalidated once the MemBuffer is reset or rolled back to a
+// checkpoint, since the data of the view may have been discarded.
+type MemBufferSnapshot interface {
+	Getter
+	// BatchGet gets the values of keys in the snapshot, the keys not exist or deleted are absent in the result.
+	BatchGet(ctx context.Context, keys [][]byte) (map[string][]byte, error)
+	// Exists returns whether k has a value which is not deleted in the snapshot.
+	Exists(k []byte) (bool, error)
+	// CountInSnapshotRange returns the number of keys in [lower, upper) which have a value not deleted in the
+	// snapshot, without reading the values out. Empty bounds mean unbounded.
+	CountInSnapshotRange(lower, upper []byte) (int, error)
+}
+
 // uSnapshot defines the interface for the snapshot fetched from KV store.
 type uSnapshot interface {
 	// Get gets the value for key k from kv store.
 	// If corresponding kv pair does not exist, it returns nil and ErrNotExist.
 	Get(ctx context.Context, k []byte) ([]byte, error)
+	// BatchGet gets the values for keys from kv store, the keys not exist are absent in the result.
+	BatchGet(ctx context.Context, keys [][]byte) (map[string][]byte, error)
 	// Iter creates an Iterator positioned on the first entry that k <= entry's key.
 	// If such entry is not found, it returns an invalid Iterator with no error.
 	// It yields only keys that < upperBound. If upperBound is nil, it means the upperBound is unbounded.
@@ -77,11 +122,94 @@ type uSnapshot interface {
 	IterReverse(k, lowerBound []byte) (Iterator, error)
 }
 
+// ctxIterSnapshot is implemented by the snapshots which create iterators bound to a context, it's preferred by
+// KVUnionStore.IterCtx and KVUnionStore.IterReverseCtx.
+type ctxIterSnapshot interface {
+	// IterCtx creates an Iterator like Iter, the requests of the iterator are canceled once ctx is done.
+	IterCtx(ctx context.Context, k []byte, upperBound []byte) (Iterator, error)
+	// IterReverseCtx creates a reversed Iterator like IterReverse, the requests of the iterator are canceled once
+	// ctx is done.
+	IterReverseCtx(ctx context.Context, k, lowerBound []byte) (Iterator, error)
+}
+
+// Source indicates where a read of KVUnionStore is served from.
+type Source int
+
+const (
+	// SourceMemBuffer means the read is served by the MemBuffer.
+	SourceMemBuffer Source = iota
+	// SourceSnapshot means the read goes through to the snapshot.
+	SourceSnapshot
+	// SourceCache means the read is served by the read-through cache.
+	SourceCache
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourceMemBuffer:
+		return "membuffer"
+	case SourceSnapshot:
+		return "snapshot"
+	case SourceCache:
+		return "cache"
+	default:
+		return "unknown"
+	}
+}
+
+// KVSource indicates where the value of a key read by GetWithSource comes from, or why it's absent.
+type KVSource int
+
+const (
+	// KVSourceNotExist means the key is neither in the MemBuffer nor in the snapshot.
+	KVSourceNotExist KVSource = iota
+	// KVSourceFromBuffer means the value is written in the MemBuffer.
+	KVSourceFromBuffer
+	// KVSourceFromSnapshot means the value is read from the snapshot.
+	KVSourceFromSnapshot
+	// KVSourceDeletedInBuffer means the key is deleted in the MemBuffer, no matter it exists in the snapshot or not.
+	KVSourceDeletedInBuffer
+)
+
+func (s KVSource) String() string {
+	switch s {
+	case KVSourceNotExist:
+		return "not_exist"
+	case KVSourceFromBuffer:
+		return "from_buffer"
+	case KVSourceFromSnapshot:
+		return "from_snapshot"
+	case KVSourceDeletedInBuffer:
+		return "deleted_in_buffer"
+	default:
+		return "unknown"
+	}
+}
+
 // KVUnionStore is an in-memory Store which contains a buffer for write and a
 // snapshot for read.
 type KVUnionStore struct {
 	memBuffer MemBuffer
 	snapshot  uSnapshot
+	readHook  func(source Source, key []byte)
+	cache     ReadThroughCache
+	// readCache is the cache created by EnableSnapshotReadCache, it's also set as cache.
+	readCache *snapshotReadCache
+	// readCacheScoped is set by EnableSnapshotCache, then readCache only serves the reads in readCacheScope, see
+	// WithSnapshotCacheScope.
+	readCacheScoped bool
+	readCacheScope  uint64
+	// allowEmptyValues distinguishes empty values from nonexistent keys in the snapshot, see SetAllowEmptyValues.
+	allowEmptyValues bool
+}
+
+// ReadThroughCache is a value cache consulted by KVUnionStore before the snapshot.
+// It only sees keys missing in the MemBuffer, so it never serves keys written or deleted by the transaction.
+type ReadThroughCache interface {
+	// Get returns the cached value of key and whether it is found.
+	Get(key []byte) ([]byte, bool)
+	// Put caches the value of key which is read from the snapshot.
+	Put(key, value []byte)
 }
 
 // NewUnionStore builds a new unionStore.
@@ -97,12 +225,165 @@ func (us *KVUnionStore) GetMemBuffer() MemBuffer {
 	return us.memBuffer
 }
 
+// Savepoint returns a checkpoint of the MemBuffer, which can be rolled back to by RollbackTo.
+func (us *KVUnionStore) Savepoint() *MemDBCheckpoint {
+	return us.memBuffer.Checkpoint()
+}
+
+// RollbackTo reverts the MemBuffer to the savepoint. It returns ErrInvalidSavepoint if cp is nil or represents
+// a later state than the current MemBuffer. The outstanding iterators are invalidated after rollback.
+func (us *KVUnionStore) RollbackTo(cp *MemDBCheckpoint) error {
+	if cp == nil {
+		return errors.WithStack(&tikverr.ErrInvalidSavepoint{Reason: "savepoint is nil"})
+	}
+	if cp.isAfter(us.memBuffer.Checkpoint()) {
+		return errors.WithStack(&tikverr.ErrInvalidSavepoint{Reason: "savepoint is later than the current state"})
+	}
+	us.memBuffer.RevertToCheckpoint(cp)
+	return nil
+}
+
+// BufferLen returns the number of entries in the MemBuffer.
+func (us *KVUnionStore) BufferLen() int {
+	return us.memBuffer.Len()
+}
+
+// BufferSize returns the size of the MemBuffer.
+func (us *KVUnionStore) BufferSize() int {
+	return us.memBuffer.Size()
+}
+
+// Dirty returns whether the MemBuffer is mutated.
+func (us *KVUnionStore) Dirty() bool {
+	return us.memBuffer.Dirty()
+}
+
+// SetReadHook sets a hook which is called on every read of the union store with the source serving it.
+// Get reports exactly one source per call, while Iter and IterReverse report both sources with the seek key
+// since the union iterator reads from the MemBuffer and the snapshot at the same time.
+// Set it to nil to disable the hook.
+func (us *KVUnionStore) SetReadHook(hook func(source Source, key []byte)) {
+	us.readHook = hook
+}
+
+func (us *KVUnionStore) onRead(source Source, key []byte) {
+	if us.readHook != nil {
+		us.readHook(source, key)
+	}
+}
+
+// SetReadThroughCache sets the cache which is checked by Get before reading the snapshot on a MemBuffer miss.
+// Values read from the snapshot are put into the cache. Set it to nil to disable the cache.
+func (us *KVUnionStore) SetReadThroughCache(cache ReadThroughCache) {
+	us.cache = cache
+	us.readCache = nil
+	us.readCacheScoped = false
+}
+
+// EnableSnapshotReadCache sets a built-in LRU ReadThroughCache holding at most maxBytes of keys and values read by
+// Get and BatchGetWithSource from the snapshot, including the keys absent in the snapshot. A key read from the
+// MemBuffer is evicted from the cache, since the MemBuffer shadows the cached value as long as the write is there.
+// It replaces the cache set by SetReadThroughCache.
+func (us *KVUnionStore) EnableSnapshotReadCache(maxBytes int) {
+	us.readCache = newSnapshotReadCache(maxBytes)
+	us.readCacheScoped = false
+	us.cache = us.readCache
+}
+
+// EnableSnapshotCache sets a built-in LRU ReadThroughCache holding at most maxEntries results of the snapshot reads
+// like EnableSnapshotReadCache, but the cache is scoped: it only serves the reads whose contexts are derived from the
+// same WithSnapshotCacheScope, and it's cleared when a read comes from another scope, so it only memoizes the
+// repeated reads of a statement. The reads without a scope bypass the cache. Like EnableSnapshotReadCache, a key
+// written to the MemBuffer is evicted once it's read from the MemBuffer. It replaces the cache set by
+// SetReadThroughCache or EnableSnapshotReadCache.
+func (us *KVUnionStore) EnableSnapshotCache(maxEntries int) {
+	us.readCache = newSnapshotReadCache(math.MaxInt)
+	us.readCache.maxEntries = maxEntries
+	us.readCacheScoped = true
+	us.readCacheScope = 0
+	us.cache = us.readCache
+}
+
+type snapshotCacheScopeKey struct{}
+
+var snapshotCacheScopeID atomic.Uint64
+
+// WithSnapshotCacheScope returns a context carrying a new scope of the cache enabled by
+// KVUnionStore.EnableSnapshotCache, the reads with the contexts derived from it share the cached results.
+func WithSnapshotCacheScope(ctx context.Context) context.Context {
+	return context.WithValue(ctx, snapshotCacheScopeKey{}, snapshotCacheScopeID.Add(1))
+}
+
+// scopeReadCache clears the cache enabled by EnableSnapshotCache if it's read from another scope than ctx, and
+// returns the cache to serve the snapshot reads with ctx, which is nil if the reads can't be cached.
+func (us *KVUnionStore) scopeReadCache(ctx context.Context) ReadThroughCache {
+	if !us.readCacheScoped {
+		return us.cache
+	}
+	scope, _ := ctx.Value(snapshotCacheScopeKey{}).(uint64)
+	if scope == 0 {
+		return nil
+	}
+	if scope != us.readCacheScope {
+		us.readCache.clear()
+		us.readCacheScope = scope
+	}
+	return us.cache
+}
+
+// ReadCacheStats returns how many lookups of the cache enabled by EnableSnapshotReadCache hit and missed. It returns
+// zeros if the cache is not enabled.
+func (us *KVUnionStore) ReadCacheStats() (hits, misses uint64) {
+	if us.readCache == nil {
+		return 0, 0
+	}
+	return us.readCache.stats()
+}
+
+// GetSnapshotCacheMetrics returns the metrics of the cache enabled by EnableSnapshotReadCache or
+// EnableSnapshotCache, which are accumulated since it's enabled.
+func (us *KVUnionStore) GetSnapshotCacheMetrics() SnapshotCacheMetrics {
+	hits, misses := us.ReadCacheStats()
+	return SnapshotCacheMetrics{Hits: hits, Misses: misses}
+}
+
+// onBufferRead evicts the key read from the MemBuffer from the snapshot read cache.
+func (us *KVUnionStore) onBufferRead(k []byte) {
+	us.onRead(SourceMemBuffer, k)
+	if us.readCache != nil {
+		us.readCache.remove(k)
+	}
+}
+
+// SetAllowEmptyValues makes the union store distinguish zero-length values read from the snapshot from
+// nonexistent keys, the snapshot must be configured in the same way. An empty value in the MemBuffer is always
+// a delete. If a ReadThroughCache is set, it must keep nil and empty values apart too.
+func (us *KVUnionStore) SetAllowEmptyValues(b bool) {
+	us.allowEmptyValues = b
+}
+
+// isNotExistInSnapshot returns whether the value read from the snapshot or the cache stands for a nonexistent key.
+func (us *KVUnionStore) isNotExistInSnapshot(v []byte) bool {
+	if us.allowEmptyValues {
+		return v == nil
+	}
+	return len(v) == 0
+}
+
 // Get implements the Retriever interface.
 func (us *KVUnionStore) Get(ctx context.Context, k []byte) ([]byte, error) {
 	v, err := us.memBuffer.Get(ctx, k)
 	if tikverr.IsErrNotFound(err) {
-		v, err = us.snapshot.Get(ctx, k)
+		v, err = us.getFromSnapshot(ctx, k)
+		if err != nil {
+			return v, err
+		}
+		if us.isNotExistInSnapshot(v) {
+			return nil, tikverr.ErrNotExist
+		}
+		return v, nil
 	}
+	us.onBufferRead(k)
 	if err != nil {
 		return v, err
 	}
@@ -112,30 +393,335 @@ func (us *KVUnionStore) Get(ctx context.Context, k []byte) ([]byte, error) {
 	return v, nil
 }
 
+// GetWithSource is like Get, but it also returns where the value comes from. Unlike Get, an absent key is not
+// reported as an error, the source tells whether it's deleted in the MemBuffer or doesn't exist at all.
+func (us *KVUnionStore) GetWithSource(ctx context.Context, k []byte) ([]byte, KVSource, error) {
+	v, err := us.memBuffer.Get(ctx, k)
+	if err == nil {
+		us.onBufferRead(k)
+		if len(v) == 0 {
+			return nil, KVSourceDeletedInBuffer, nil
+		}
+		return v, KVSourceFromBuffer, nil
+	}
+	if !tikverr.IsErrNotFound(err) {
+		return nil, KVSourceNotExist, err
+	}
+	v, err = us.getFromSnapshot(ctx, k)
+	if tikverr.IsErrNotFound(err) || (err == nil && us.isNotExistInSnapshot(v)) {
+		return nil, KVSourceNotExist, nil
+	}
+	if err != nil {
+		return nil, KVSourceNotExist, err
+	}
+	return v, KVSourceFromSnapshot, nil
+}
+
+// BatchGetWithSource gets the values of keys like GetWithSource. The returned values only contain the existing keys,
+// while the sources contain all the keys.
+func (us *KVUnionStore) BatchGetWithSource(ctx context.Context, keys [][]byte) (map[string][]byte, map[string]KVSource, error) {
+	values := make(map[string][]byte, len(keys))
+	sources := make(map[string]KVSource, len(keys))
+	snapKeys := make([][]byte, 0, len(keys))
+	cache := us.scopeReadCache(ctx)
+	for _, k := range keys {
+		v, err := us.memBuffer.Get(ctx, k)
+		if err == nil {
+			us.onBufferRead(k)
+			if len(v) == 0 {
+				sources[string(k)] = KVSourceDeletedInBuffer
+			} else {
+				values[string(k)] = v
+				sources[string(k)] = KVSourceFromBuffer
+			}
+			continue
+		}
+		if !tikverr.IsErrNotFound(err) {
+			return nil, nil, err
+		}
+		if cache != nil {
+			if v, ok := cache.Get(k); ok {
+				us.onRead(SourceCache, k)
+				if us.isNotExistInSnapshot(v) {
+					sources[string(k)] = KVSourceNotExist
+				} else {
+					values[string(k)] = v
+					sources[string(k)] = KVSourceFromSnapshot
+				}
+				continue
+			}
+		}
+		us.onRead(SourceSnapshot, k)
+		snapKeys = append(snapKeys, k)
+	}
+	if len(snapKeys) == 0 {
+		return values, sources, nil
+	}
+	snapValues, err := us.snapshot.BatchGet(ctx, snapKeys)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, k := range snapKeys {
+		v, ok := snapValues[string(k)]
+		if ok && cache != nil {
+			cache.Put(k, v)
+		} else if !ok && cache != nil && us.readCache != nil {
+			us.readCache.putNotExist(k)
+		}
+		if !ok || us.isNotExistInSnapshot(v) {
+			sources[string(k)] = KVSourceNotExist
+			continue
+		}
+		values[string(k)] = v
+		sources[string(k)] = KVSourceFromSnapshot
+	}
+	return values, sources, nil
+}
+
+func (us *KVUnionStore) getFromSnapshot(ctx context.Context, k []byte) ([]byte, error) {
+	cache := us.scopeReadCache(ctx)
+	if cache == nil {
+		us.onRead(SourceSnapshot, k)
+		return us.snapshot.Get(ctx, k)
+	}
+	if v, ok := cache.Get(k); ok {
+		us.onRead(SourceCache, k)
+		return v, nil
+	}
+	us.onRead(SourceSnapshot, k)
+	v, err := us.snapshot.Get(ctx, k)
+	if err == nil {
+		cache.Put(k, v)
+	} else if tikverr.IsErrNotFound(err) && us.readCache != nil {
+		us.readCache.putNotExist(k)
+	}
+	return v, err
+}
+
 // Iter implements the Retriever interface.
 func (us *KVUnionStore) Iter(k, upperBound []byte) (Iterator, error) {
+	return us.iter(nil, k, upperBound)
+}
+
+// IterCtx creates an Iterator like Iter, but the snapshot iterator is created by IterCtx of the snapshot if it's
+// supported, so that creating and advancing it are canceled once ctx is done. Otherwise ctx is only checked before
+// the snapshot iterator is created. The returned iterator also checks ctx periodically in Next.
+func (us *KVUnionStore) IterCtx(ctx context.Context, k, upperBound []byte) (Iterator, error) {
+	return us.iter(ctx, k, upperBound)
+}
+
+// iter creates the union iterator of Iter and IterCtx, ctx is nil for Iter.
+func (us *KVUnionStore) iter(ctx context.Context, k, upperBound []byte) (Iterator, error) {
+	us.onRead(SourceMemBuffer, k)
+	us.onRead(SourceSnapshot, k)
 	bufferIt, err := us.memBuffer.Iter(k, upperBound)
 	if err != nil {
 		return nil, err
 	}
-	retrieverIt, err := us.snapshot.Iter(k, upperBound)
+	retrieverIt, err := us.snapshotIter(ctx, k, upperBound)
+	if err != nil {
+		return nil, err
+	}
+	it, err := NewUnionIter(bufferIt, retrieverIt, false)
+	if err != nil {
+		return nil, err
+	}
+	it.ctx = ctx
+	it.newSnapshotIt = func(key []byte) (Iterator, error) {
+		if bytes.Compare(key, k) < 0 {
+			key = k
+		}
+		return us.snapshotIter(ctx, key, upperBound)
+	}
+	return it, nil
+}
+
+func (us *KVUnionStore) snapshotIter(ctx context.Context, k, upperBound []byte) (Iterator, error) {
+	if ctx == nil {
+		return us.snapshot.Iter(k, upperBound)
+	}
+	if snapshot, ok := us.snapshot.(ctxIterSnapshot); ok {
+		return snapshot.IterCtx(ctx, k, upperBound)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return us.snapshot.Iter(k, upperBound)
+}
+
+func (us *KVUnionStore) snapshotIterReverse(ctx context.Context, k, lowerBound []byte) (Iterator, error) {
+	if ctx == nil {
+		return us.snapshot.IterReverse(k, lowerBound)
+	}
+	if snapshot, ok := us.snapshot.(ctxIterSnapshot); ok {
+		return snapshot.IterReverseCtx(ctx, k, lowerBound)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return us.snapshot.IterReverse(k, lowerBound)
+}
+
+// IterWithBounds creates an Iterator positioned on the first key >= lower, it yields only keys < upper.
+// A nil lower means the lowerBound is unbounded and a nil upper means the upperBound is unbounded.
+// If lower >= upper, it returns an invalid Iterator.
+func (us *KVUnionStore) IterWithBounds(lower, upper []byte) (Iterator, error) {
+	if upper != nil && bytes.Compare(lower, upper) >= 0 {
+		return emptyIterator{}, nil
+	}
+	return us.Iter(lower, upper)
+}
+
+// IterPrefix creates an Iterator which yields all keys with the given prefix.
+// If the prefix is empty, it iterates all keys.
+func (us *KVUnionStore) IterPrefix(prefix []byte) (Iterator, error) {
+	return us.Iter(prefix, prefixUpperBound(prefix))
+}
+
+// IterPaged creates an Iterator like Iter, but the snapshot iterator is recreated from the key after the last one
+// every pageSize keys, so that a long scan doesn't hold a single snapshot iterator. The MemBuffer is read by
+// SnapshotIter, so the writes made during the scan are not seen, and neither are the writes in the staging buffers.
+func (us *KVUnionStore) IterPaged(k, upperBound []byte, pageSize int) (Iterator, error) {
+	if pageSize <= 0 {
+		return nil, errors.Errorf("invalid page size %d", pageSize)
+	}
+	us.onRead(SourceMemBuffer, k)
+	us.onRead(SourceSnapshot, k)
+	bufferIt := us.memBuffer.SnapshotIter(k, upperBound)
+	if errIt, ok := bufferIt.(*errIterator); ok {
+		return nil, errIt.err
+	}
+	newSnapshotIt := func(key []byte) (Iterator, error) {
+		if bytes.Compare(key, k) < 0 {
+			key = k
+		}
+		return newPagedIter(key, pageSize, func(start []byte) (Iterator, error) {
+			return us.snapshot.Iter(start, upperBound)
+		})
+	}
+	retrieverIt, err := newSnapshotIt(k)
+	if err != nil {
+		bufferIt.Close()
+		return nil, err
+	}
+	it, err := NewUnionIter(bufferIt, retrieverIt, false)
 	if err != nil {
 		return nil, err
 	}
-	return NewUnionIter(bufferIt, retrieverIt, false)
+	it.newSnapshotIt = newSnapshotIt
+	return it, nil
+}
+
+// pagedIter iterates by a sequence of iterators, each of which yields at most pageSize keys, the next one starts
+// from the key after the last key of the previous one.
+type pagedIter struct {
+	it       Iterator
+	pageSize int
+	// remaining is the number of keys which can still be yielded by it.
+	remaining int
+	newIter   func(start []byte) (Iterator, error)
+}
+
+func newPagedIter(start []byte, pageSize int, newIter func(start []byte) (Iterator, error)) (*pagedIter, error) {
+	it, err := newIter(start)
+	if err != nil {
+		return nil, err
+	}
+	return &pagedIter{it: it, pageSize: pageSize, remaining: pageSize, newIter: newIter}, nil
+}
+
+func (i *pagedIter) Valid() bool   { return i.it.Valid() }
+func (i *pagedIter) Key() []byte   { return i.it.Key() }
+func (i *pagedIter) Value() []byte { return i.it.Value() }
+
+func (i *pagedIter) Next() error {
+	i.remaining--
+	if i.remaining > 0 {
+		return i.it.Next()
+	}
+	start := kv.NextKey(i.it.Key())
+	i.it.Close()
+	it, err := i.newIter(start)
+	if err != nil {
+		i.it = emptyIterator{}
+		return err
+	}
+	i.it, i.remaining = it, i.pageSize
+	return nil
+}
+
+func (i *pagedIter) Close() { i.it.Close() }
+
+// prefixUpperBound returns the smallest key which is greater than all keys with
+// the given prefix. It returns nil if there is no such key, i.e. the prefix is
+// empty or consists of 0xFF only.
+func prefixUpperBound(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			upperBound := make([]byte, i+1)
+			copy(upperBound, prefix)
+			upperBound[i]++
+			return upperBound
+		}
+	}
+	return nil
 }
 
 // IterReverse implements the Retriever interface.
 func (us *KVUnionStore) IterReverse(k, lowerBound []byte) (Iterator, error) {
+	return us.iterReverse(nil, k, lowerBound)
+}
+
+// IterReverseCtx creates a reversed Iterator like IterReverse, and it's bound to ctx like IterCtx.
+func (us *KVUnionStore) IterReverseCtx(ctx context.Context, k, lowerBound []byte) (Iterator, error) {
+	return us.iterReverse(ctx, k, lowerBound)
+}
+
+// iterReverse creates the union iterator of IterReverse and IterReverseCtx, ctx is nil for IterReverse.
+func (us *KVUnionStore) iterReverse(ctx context.Context, k, lowerBound []byte) (Iterator, error) {
+	us.onRead(SourceMemBuffer, k)
+	us.onRead(SourceSnapshot, k)
 	bufferIt, err := us.memBuffer.IterReverse(k, lowerBound)
 	if err != nil {
 		return nil, err
 	}
-	retrieverIt, err := us.snapshot.IterReverse(k, lowerBound)
+	retrieverIt, err := us.snapshotIterReverse(ctx, k, lowerBound)
+	if err != nil {
+		return nil, err
+	}
+	it, err := NewUnionIter(bufferIt, retrieverIt, true)
 	if err != nil {
 		return nil, err
 	}
-	return NewUnionIter(bufferIt, retrieverIt, true)
+	it.ctx = ctx
+	it.newSnapshotIt = func(key []byte) (Iterator, error) {
+		// The reverse iterator starts from the keys < the given key, and key itself is included by Seek.
+		end := kv.NextKey(key)
+		if len(k) > 0 && bytes.Compare(end, k) > 0 {
+			end = k
+		}
+		return us.snapshotIterReverse(ctx, end, lowerBound)
+	}
+	return it, nil
+}
+
+type emptyIterator struct{}
+
+func (emptyIterator) Valid() bool   { return false }
+func (emptyIterator) Key() []byte   { return nil }
+func (emptyIterator) Value() []byte { return nil }
+func (emptyIterator) Next() error   { return nil }
+func (emptyIterator) Close()        {}
+
+// PrefetchRange warms the BatchGet cache of the pipelined MemBuffer over range [lower, upper) with a single
+// scan, and returns the number of keys cached. It's a no-op if the MemBuffer is not a PipelinedMemDB, and it fails if
+// the PipelinedMemDB has no BufferScanner.
+func (us *KVUnionStore) PrefetchRange(ctx context.Context, lower, upper []byte) (int, error) {
+	pipelined, ok := us.memBuffer.(*PipelinedMemDB)
+	if !ok {
+		return 0, nil
+	}
+	return pipelined.PrefetchRange(ctx, lower, upper)
 }
 
 // HasPresumeKeyNotExists gets the key exist error info for the lazy check.
@@ -163,6 +749,20 @@ func (us *KVUnionStore) SetEntrySizeLimit(entryLimit, bufferLimit uint64) {
 	us.memBuffer.SetEntrySizeLimit(entryLimit, bufferLimit)
 }
 
+// TrySet sets the value of key like MemBuffer.Set, but it checks the projected size of the MemBuffer against the
+// buffer size limit first. If the limit would be exceeded, ErrTxnTooLarge carrying the projected size is returned
+// and the MemBuffer is left untouched.
+func (us *KVUnionStore) TrySet(key, value []byte) error {
+	return us.memBuffer.TrySet(key, value)
+}
+
+// SetLargeEntryHandler sets the handler for the entries exceeding the entry size limit. Instead of failing the write
+// with ErrEntryTooLarge, the value returned by the handler is buffered and counted against the buffer size limit.
+// Passing nil restores the default behavior.
+func (us *KVUnionStore) SetLargeEntryHandler(h LargeEntryHandler) {
+	us.memBuffer.SetLargeEntryHandler(h)
+}
+
 // MemBuffer is an interface that stores mutations that written during transaction execution.
 // It now unifies MemDB and PipelinedMemDB.
 // The implementations should follow the transaction guarantees:
@@ -184,10 +784,16 @@ type MemBuffer interface {
 	GetFlags([]byte) (kv.KeyFlags, error)
 	// Set sets the value for key k in the MemBuffer.
 	Set([]byte, []byte) error
+	// TrySet sets the value for key k like Set, but the MemBuffer is left untouched if the buffer size limit would be
+	// exceeded, and ErrTxnTooLarge carrying the projected size is returned.
+	TrySet([]byte, []byte) error
 	// SetWithFlags sets the value for key k in the MemBuffer with flags.
 	SetWithFlags([]byte, []byte, ...kv.FlagsOp) error
 	// UpdateFlags updates the flags for key k in the MemBuffer.
 	UpdateFlags([]byte, ...kv.FlagsOp)
+	// ClearAllFlags resets the flags of all keys in the MemBuffer while keeping the values, it's reverted by Cleanup
+	// and RevertToCheckpoint.
+	ClearAllFlags()
 	// RemoveFromBuffer removes the key k from the MemBuffer, only used for test.
 	RemoveFromBuffer(key []byte)
 	// Delete deletes the key k in the MemBuffer.
@@ -198,26 +804,41 @@ type MemBuffer interface {
 	Iter([]byte, []byte) (Iterator, error)
 	// IterReverse implements the Retriever interface.
 	IterReverse([]byte, []byte) (Iterator, error)
+	// IterWithFlags returns a FlagsIterator positioned on the first key >= lower, it yields only keys < upper.
+	IterWithFlags(lower, upper []byte) FlagsIterator
+	// IterReverseWithFlags returns a reversed FlagsIterator positioned on the last key < upper,
+	// it yields only keys >= lower.
+	IterReverseWithFlags(upper, lower []byte) FlagsIterator
 	// SnapshotIter returns an Iterator for a snapshot of MemBuffer.
 	SnapshotIter([]byte, []byte) Iterator
 	// SnapshotIterReverse returns a reversed Iterator for a snapshot of MemBuffer.
 	SnapshotIterReverse([]byte, []byte) Iterator
-	// SnapshotGetter returns a Getter for a snapshot of MemBuffer.
-	SnapshotGetter() Getter
+	// SnapshotGetter returns a MemBufferSnapshot for a snapshot of MemBuffer.
+	SnapshotGetter() MemBufferSnapshot
 	// InspectStage iterates all buffered keys and values in MemBuffer.
 	InspectStage(handle int, f func([]byte, kv.KeyFlags, []byte))
 	// SetEntrySizeLimit sets the size limit for each entry and total buffer.
 	SetEntrySizeLimit(uint64, uint64)
+	// SetLargeEntryHandler sets the handler for the entries exceeding the entry size limit.
+	SetLargeEntryHandler(LargeEntryHandler)
 	// Dirty returns true if the MemBuffer is NOT read only.
 	Dirty() bool
 	// SetMemoryFootprintChangeHook sets the hook for memory footprint change.
 	SetMemoryFootprintChangeHook(hook func(uint64))
+	// SetMemoryThresholds sets the thresholds of the memory footprint. The callback of each threshold is called once
+	// when the memory footprint crosses its limit upward, and may be called again after the memory footprint drops
+	// below 80% of the limit.
+	SetMemoryThresholds(thresholds []MemoryThreshold)
 	// Mem returns the memory usage of MemBuffer.
 	Mem() uint64
 	// Len returns the count of entries in the MemBuffer.
 	Len() int
 	// Size returns the size of the MemBuffer.
 	Size() int
+	// WriteAmplificationStats returns the count of Set and Delete calls and the count of distinct keys in the MemBuffer.
+	WriteAmplificationStats() (totalWrites, distinctKeys uint64)
+	// Stats returns the memory usage of the arenas of the MemBuffer.
+	Stats() ArenaStats
 	// Staging create a new staging buffer inside the MemBuffer.
 	Staging() int
 	// Cleanup the resources referenced by the StagingHandle.
@@ -239,10 +860,59 @@ type MemBuffer interface {
 	FlushWait() error
 	// GetFlushDetails returns the metrics related to flushing
 	GetFlushMetrics() FlushMetrics
+	// FlushPending returns the count of the flushes in progress and the bytes of the keys they are writing, so that
+	// the writer can slow down before calling Set when the flushes fall behind.
+	FlushPending() (inflight int, bytesQueued uint64)
+	// Serialize captures the state of the MemBuffer, which can be restored by DeserializeMemBuffer for tests.
+	Serialize() ([]byte, error)
+	// ExportMutations writes the final state of the keys, flags and values in the MemBuffer to w, so that a
+	// suspended transaction can be resumed by ImportMutations in another process.
+	ExportMutations(w io.Writer) error
+	// ImportMutations restores the mutations written by ExportMutations into the empty MemBuffer.
+	ImportMutations(r io.Reader) error
+	// Export writes the keys, flags and values in the snapshot of the MemBuffer to w in sorted order, the changes in
+	// the staging buffers are excluded. It can be restored by Import.
+	Export(w io.Writer) error
+	// Import restores the keys written by Export into the empty MemBuffer.
+	Import(r io.Reader) error
+	// Savepoint creates a named savepoint, an existing savepoint of the same name is replaced.
+	Savepoint(name string) error
+	// RollbackToSavepoint discards the changes after the named savepoint and the savepoints created after it.
+	RollbackToSavepoint(name string) error
+	// ReleaseSavepoint merges the changes after the named savepoint into the enclosing scope and removes it.
+	ReleaseSavepoint(name string) error
+	// AssertKeysInRange returns an error naming the first key in the MemBuffer that is out of [lower, upper).
+	AssertKeysInRange(lower, upper []byte) error
+	// PurgeTombstonesBefore removes the keys deleted before the stable checkpoint cp whose deletions don't need to be
+	// committed from the buffer any more, and returns how many are removed.
+	PurgeTombstonesBefore(cp *MemDBCheckpoint) (int, error)
+	// IterChangesSince returns an iterator over the keys whose values are written after cp, each key is yielded once
+	// with its current value. The changes should be made in a staging buffer created since cp.
+	IterChangesSince(cp *MemDBCheckpoint) (ChangesIterator, error)
 }
 
 type FlushMetrics struct {
 	WaitDuration time.Duration
+	// MemDBHitCount and MemDBMissCount count the keys of BatchGet found and not found in the local buffers.
+	MemDBHitCount  int
+	MemDBMissCount int
+}
+
+// SnapshotCacheMetrics is the metrics of the snapshot read cache of KVUnionStore, see
+// KVUnionStore.GetSnapshotCacheMetrics.
+type SnapshotCacheMetrics struct {
+	// Hits is the count of the lookups served by the cache.
+	Hits uint64
+	// Misses is the count of the lookups which go through to the snapshot.
+	Misses uint64
+}
+
+// HitRate returns the ratio of the lookups served by the cache, it's zero if there is no lookup.
+func (m SnapshotCacheMetrics) HitRate() float64 {
+	if m.Hits+m.Misses == 0 {
+		return 0
+	}
+	return float64(m.Hits) / float64(m.Hits+m.Misses)
 }
 
 var (
@@ -267,6 +937,16 @@ func (db *MemDBWithContext) GetLocal(_ context.Context, k []byte) ([]byte, error
 	return db.MemDB.Get(k)
 }
 
+// IterWithFlags implements the MemBuffer interface.
+func (db *MemDBWithContext) IterWithFlags(lower, upper []byte) FlagsIterator {
+	return db.MemDB.IterWithFlags(lower, upper)
+}
+
+// IterReverseWithFlags implements the MemBuffer interface.
+func (db *MemDBWithContext) IterReverseWithFlags(upper, lower []byte) FlagsIterator {
+	return db.MemDB.iterReverseWithFlags(upper, lower)
+}
+
 func (db *MemDBWithContext) Flush(bool) (bool, error) { return false, nil }
 
 func (db *MemDBWithContext) FlushWait() error { return nil }
@@ -297,3 +977,6 @@ func (db *MemDBWithContext) BatchGet(ctx context.Context, keys [][]byte) (map[st
 
 // GetFlushMetrisc implements the MemBuffer interface.
 func (db *MemDBWithContext) GetFlushMetrics() FlushMetrics { return FlushMetrics{} }
+
+// FlushPending implements the MemBuffer interface, there is never a flush in progress.
+func (db *MemDBWithContext) FlushPending() (int, uint64) { return 0, 0 }
@@ -35,11 +35,17 @@
 package unionstore
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/kv"
 )
 
 func TestUnionStoreGetSet(t *testing.T) {
@@ -113,6 +119,157 @@ func TestUnionStoreSeek(t *testing.T) {
 	checkIterator(t, iter, [][]byte{[]byte("2"), []byte("4")}, [][]byte{[]byte("2"), []byte("4")})
 }
 
+// unseekableSnapshot hides the Seek method of the iterators of mockSnapshot.
+type unseekableSnapshot struct {
+	*mockSnapshot
+	iters int
+}
+
+func (s *unseekableSnapshot) Iter(k []byte, upperBound []byte) (Iterator, error) {
+	s.iters++
+	it, err := s.mockSnapshot.Iter(k, upperBound)
+	return struct{ Iterator }{it}, err
+}
+
+func (s *unseekableSnapshot) IterReverse(k, lowerBound []byte) (Iterator, error) {
+	s.iters++
+	it, err := s.mockSnapshot.IterReverse(k, lowerBound)
+	return struct{ Iterator }{it}, err
+}
+
+func TestUnionIterSeek(t *testing.T) {
+	store := newMemDB()
+	for _, k := range []string{"1", "2", "3", "4", "5", "6"} {
+		assert.Nil(t, store.Set([]byte(k), []byte(k)))
+	}
+	for _, seekable := range []bool{true, false} {
+		var snapshot uSnapshot = &mockSnapshot{store}
+		unseekable := &unseekableSnapshot{mockSnapshot: &mockSnapshot{store}}
+		if !seekable {
+			snapshot = unseekable
+		}
+		us := NewUnionStore(NewMemDBWithContext(), snapshot)
+		assert.Nil(t, us.GetMemBuffer().Set([]byte("2a"), []byte("2a")))
+		assert.Nil(t, us.GetMemBuffer().Delete([]byte("4")))
+
+		check := func(iter SeekableIterator, key []byte, expected string) {
+			assert.Nil(t, iter.Seek(key))
+			if len(expected) == 0 {
+				assert.False(t, iter.Valid())
+				return
+			}
+			assert.True(t, iter.Valid())
+			assert.Equal(t, expected, string(iter.Key()))
+			assert.Equal(t, expected, string(iter.Value()))
+		}
+
+		it, err := us.Iter(nil, nil)
+		assert.Nil(t, err)
+		iter := it.(SeekableIterator)
+		check(iter, []byte("2a"), "2a")
+		assert.Nil(t, iter.Next())
+		assert.Equal(t, []byte("3"), iter.Key())
+		// the deleted key is skipped.
+		check(iter, []byte("4"), "5")
+		// the snapshot iterator is already beyond the key.
+		check(iter, []byte("5"), "5")
+		check(iter, []byte("1"), "1")
+		check(iter, []byte("2"), "2")
+		assert.Nil(t, iter.Next())
+		assert.Equal(t, []byte("2a"), iter.Key())
+		check(iter, []byte("7"), "")
+		check(iter, []byte("3"), "3")
+		iter.Close()
+		if !seekable {
+			// only seeking backward recreates the snapshot iterator.
+			assert.Equal(t, 3, unseekable.iters)
+		}
+
+		// the bounds are kept.
+		it, err = us.Iter([]byte("2"), []byte("5"))
+		assert.Nil(t, err)
+		iter = it.(SeekableIterator)
+		check(iter, []byte("3"), "3")
+		check(iter, []byte("1"), "2")
+		check(iter, []byte("4"), "")
+		iter.Close()
+
+		it, err = us.IterReverse(nil, nil)
+		assert.Nil(t, err)
+		iter = it.(SeekableIterator)
+		check(iter, []byte("4"), "3")
+		assert.Nil(t, iter.Next())
+		assert.Equal(t, []byte("2a"), iter.Key())
+		check(iter, []byte("2"), "2")
+		check(iter, []byte("6"), "6")
+		check(iter, []byte("2b"), "2a")
+		check(iter, []byte("0"), "")
+		check(iter, []byte("5"), "5")
+		iter.Close()
+
+		it, err = us.IterReverse([]byte("5"), []byte("2"))
+		assert.Nil(t, err)
+		iter = it.(SeekableIterator)
+		check(iter, []byte("3"), "3")
+		check(iter, []byte("6"), "3")
+		check(iter, []byte("1"), "")
+		iter.Close()
+	}
+}
+
+func TestUnionIterWithCompare(t *testing.T) {
+	require := require.New(t)
+	snapshot := newMemDB()
+	for _, k := range []string{"a", "b", "c"} {
+		require.Nil(snapshot.Set([]byte(k), []byte("snap-"+k)))
+	}
+	buffer := newMemDB()
+	require.Nil(buffer.Set([]byte("A"), []byte("buf-A")))
+	require.Nil(buffer.Delete([]byte("C")))
+	caseInsensitive := func(a, b []byte) int {
+		return bytes.Compare(bytes.ToLower(a), bytes.ToLower(b))
+	}
+
+	collect := func(it *UnionIter) []string {
+		var kvs []string
+		for ; it.Valid(); require.Nil(it.Next()) {
+			kvs = append(kvs, string(it.Key())+"="+string(it.Value()))
+		}
+		return kvs
+	}
+	for _, reverse := range []bool{false, true} {
+		var bufferIt, snapshotIt Iterator
+		var err error
+		if reverse {
+			bufferIt, err = buffer.IterReverse(nil, nil)
+			require.Nil(err)
+			snapshotIt, err = snapshot.IterReverse(nil, nil)
+		} else {
+			bufferIt, err = buffer.Iter(nil, nil)
+			require.Nil(err)
+			snapshotIt, err = snapshot.Iter(nil, nil)
+		}
+		require.Nil(err)
+		it, err := NewUnionIterWithCompare(bufferIt, snapshotIt, reverse, caseInsensitive)
+		require.Nil(err)
+		// the buffer wins the tie and its deletion hides the snapshot value.
+		expected := []string{"A=buf-A", "b=snap-b"}
+		if reverse {
+			expected = []string{"b=snap-b", "A=buf-A"}
+		}
+		require.Equal(expected, collect(it))
+	}
+
+	// the keys are distinct by the default comparator.
+	bufferIt, err := buffer.Iter(nil, nil)
+	require.Nil(err)
+	snapshotIt, err := snapshot.Iter(nil, nil)
+	require.Nil(err)
+	it, err := NewUnionIter(bufferIt, snapshotIt, false)
+	require.Nil(err)
+	require.Equal([]string{"A=buf-A", "a=snap-a", "b=snap-b", "c=snap-c"}, collect(it))
+}
+
 func TestUnionStoreIterReverse(t *testing.T) {
 	assert := assert.New(t)
 	store := newMemDB()
@@ -154,6 +311,509 @@ func TestUnionStoreIterReverse(t *testing.T) {
 	checkIterator(t, iter, [][]byte{[]byte("2")}, [][]byte{[]byte("2")})
 }
 
+func TestUnionStoreIterWithBounds(t *testing.T) {
+	assert := assert.New(t)
+	store := newMemDB()
+	us := NewUnionStore(NewMemDBWithContext(), &mockSnapshot{store})
+
+	assert.Nil(store.Set([]byte("1"), []byte("1")))
+	assert.Nil(store.Set([]byte("3"), []byte("3")))
+	assert.Nil(store.Set([]byte("5"), []byte("5")))
+	assert.Nil(us.GetMemBuffer().Set([]byte("2"), []byte("2")))
+	assert.Nil(us.GetMemBuffer().Set([]byte("4"), []byte("4")))
+	assert.Nil(us.GetMemBuffer().Delete([]byte("3")))
+
+	iter, err := us.IterWithBounds(nil, nil)
+	assert.Nil(err)
+	checkIterator(t, iter, [][]byte{[]byte("1"), []byte("2"), []byte("4"), []byte("5")}, [][]byte{[]byte("1"), []byte("2"), []byte("4"), []byte("5")})
+
+	iter, err = us.IterWithBounds([]byte("2"), []byte("5"))
+	assert.Nil(err)
+	checkIterator(t, iter, [][]byte{[]byte("2"), []byte("4")}, [][]byte{[]byte("2"), []byte("4")})
+
+	iter, err = us.IterWithBounds(nil, []byte("2"))
+	assert.Nil(err)
+	checkIterator(t, iter, [][]byte{[]byte("1")}, [][]byte{[]byte("1")})
+
+	iter, err = us.IterWithBounds([]byte("4"), nil)
+	assert.Nil(err)
+	checkIterator(t, iter, [][]byte{[]byte("4"), []byte("5")}, [][]byte{[]byte("4"), []byte("5")})
+
+	// empty ranges.
+	iter, err = us.IterWithBounds([]byte("3"), []byte("4"))
+	assert.Nil(err)
+	checkIterator(t, iter, nil, nil)
+	iter, err = us.IterWithBounds([]byte("2"), []byte("2"))
+	assert.Nil(err)
+	checkIterator(t, iter, nil, nil)
+	iter, err = us.IterWithBounds([]byte("4"), []byte("2"))
+	assert.Nil(err)
+	checkIterator(t, iter, nil, nil)
+	iter, err = us.IterWithBounds(nil, []byte{})
+	assert.Nil(err)
+	checkIterator(t, iter, nil, nil)
+}
+
+func TestUnionStoreGetWithSource(t *testing.T) {
+	assert := assert.New(t)
+	store := newMemDB()
+	us := NewUnionStore(NewMemDBWithContext(), &mockSnapshot{store})
+	ctx := context.Background()
+
+	// k1: set then deleted in buffer.
+	assert.Nil(us.GetMemBuffer().Set([]byte("k1"), []byte("v1")))
+	assert.Nil(us.GetMemBuffer().Delete([]byte("k1")))
+	// k2: only in snapshot.
+	assert.Nil(store.Set([]byte("k2"), []byte("v2")))
+	// k3: deleted in buffer but present in snapshot.
+	assert.Nil(store.Set([]byte("k3"), []byte("v3")))
+	assert.Nil(us.GetMemBuffer().Delete([]byte("k3")))
+	// k4: in neither.
+	// k5: in buffer, overwriting snapshot.
+	assert.Nil(store.Set([]byte("k5"), []byte("v5")))
+	assert.Nil(us.GetMemBuffer().Set([]byte("k5"), []byte("v55")))
+
+	cases := []struct {
+		key    string
+		value  []byte
+		source KVSource
+	}{
+		{"k1", nil, KVSourceDeletedInBuffer},
+		{"k2", []byte("v2"), KVSourceFromSnapshot},
+		{"k3", nil, KVSourceDeletedInBuffer},
+		{"k4", nil, KVSourceNotExist},
+		{"k5", []byte("v55"), KVSourceFromBuffer},
+	}
+	keys := make([][]byte, 0, len(cases))
+	for _, c := range cases {
+		v, source, err := us.GetWithSource(ctx, []byte(c.key))
+		assert.Nil(err)
+		assert.Equal(c.value, v, c.key)
+		assert.Equal(c.source, source, c.key)
+		// Get collapses the absent keys into ErrNotExist.
+		_, err = us.Get(ctx, []byte(c.key))
+		assert.Equal(c.value == nil, tikverr.IsErrNotFound(err), c.key)
+		keys = append(keys, []byte(c.key))
+	}
+
+	values, sources, err := us.BatchGetWithSource(ctx, keys)
+	assert.Nil(err)
+	assert.Equal(map[string][]byte{"k2": []byte("v2"), "k5": []byte("v55")}, values)
+	assert.Len(sources, len(cases))
+	for _, c := range cases {
+		assert.Equal(c.source, sources[c.key], c.key)
+	}
+}
+
+func TestUnionStoreSavepoint(t *testing.T) {
+	assert := assert.New(t)
+	store := newMemDB()
+	us := NewUnionStore(NewMemDBWithContext(), &mockSnapshot{store})
+	ctx := context.Background()
+	buffer := us.GetMemBuffer()
+
+	err := us.RollbackTo(nil)
+	assert.True(tikverr.IsErrInvalidSavepoint(err))
+
+	assert.Nil(buffer.Set([]byte("k1"), []byte("v1")))
+	sp1 := us.Savepoint()
+
+	// the writes of a released stage are rolled back.
+	h := buffer.Staging()
+	assert.Nil(buffer.Set([]byte("k2"), []byte("v2")))
+	assert.Nil(buffer.Set([]byte("k1"), []byte("v11")))
+	buffer.Release(h)
+	sp2 := us.Savepoint()
+	assert.Nil(buffer.Delete([]byte("k1")))
+
+	assert.Nil(us.RollbackTo(sp2))
+	v, err := us.Get(ctx, []byte("k1"))
+	assert.Nil(err)
+	assert.Equal([]byte("v11"), v)
+
+	assert.Nil(us.RollbackTo(sp1))
+	v, err = us.Get(ctx, []byte("k1"))
+	assert.Nil(err)
+	assert.Equal([]byte("v1"), v)
+	_, err = us.Get(ctx, []byte("k2"))
+	assert.True(tikverr.IsErrNotFound(err))
+	assert.Equal(1, us.BufferLen())
+
+	// sp2 is later than the current state after rolling back to sp1.
+	err = us.RollbackTo(sp2)
+	assert.True(tikverr.IsErrInvalidSavepoint(err))
+	v, err = us.Get(ctx, []byte("k1"))
+	assert.Nil(err)
+	assert.Equal([]byte("v1"), v)
+
+	// the writes in a cleaned up stage are discarded, and the savepoint before it is still valid.
+	h = buffer.Staging()
+	assert.Nil(buffer.Set([]byte("k3"), []byte("v3")))
+	buffer.Cleanup(h)
+	assert.Nil(buffer.Set([]byte("k4"), []byte("v4")))
+	assert.Nil(us.RollbackTo(sp1))
+	_, err = us.Get(ctx, []byte("k3"))
+	assert.True(tikverr.IsErrNotFound(err))
+	_, err = us.Get(ctx, []byte("k4"))
+	assert.True(tikverr.IsErrNotFound(err))
+}
+
+func TestUnionStoreBufferStats(t *testing.T) {
+	assert := assert.New(t)
+	store := newMemDB()
+	us := NewUnionStore(NewMemDBWithContext(), &mockSnapshot{store})
+	assert.Nil(store.Set([]byte("snap"), []byte("1")))
+
+	assert.False(us.Dirty())
+	assert.Equal(0, us.BufferLen())
+	assert.Equal(0, us.BufferSize())
+
+	assert.Nil(us.GetMemBuffer().Set([]byte("k1"), []byte("v1")))
+	assert.Nil(us.GetMemBuffer().Set([]byte("k2"), []byte("v2")))
+	assert.True(us.Dirty())
+	assert.Equal(2, us.BufferLen())
+	assert.Equal(us.GetMemBuffer().Size(), us.BufferSize())
+	assert.Equal(8, us.BufferSize())
+}
+
+func TestUnionStoreIterPrefix(t *testing.T) {
+	assert := assert.New(t)
+	store := newMemDB()
+	us := NewUnionStore(NewMemDBWithContext(), &mockSnapshot{store})
+
+	keys := [][]byte{
+		{0x01},
+		{0x01, 0x00},
+		{0x01, 0xff},
+		{0x01, 0xff, 0x01},
+		{0x02},
+		{0xff},
+		{0xff, 0xff},
+		{0xff, 0xff, 0x00},
+	}
+	for i, k := range keys {
+		if i%2 == 0 {
+			assert.Nil(store.Set(k, k))
+		} else {
+			assert.Nil(us.GetMemBuffer().Set(k, k))
+		}
+	}
+
+	iter, err := us.IterPrefix([]byte{0x01})
+	assert.Nil(err)
+	checkIterator(t, iter, keys[:4], keys[:4])
+
+	iter, err = us.IterPrefix([]byte{0x01, 0xff})
+	assert.Nil(err)
+	checkIterator(t, iter, keys[2:4], keys[2:4])
+
+	iter, err = us.IterPrefix([]byte{0xff})
+	assert.Nil(err)
+	checkIterator(t, iter, keys[5:], keys[5:])
+
+	iter, err = us.IterPrefix([]byte{0xff, 0xff})
+	assert.Nil(err)
+	checkIterator(t, iter, keys[6:], keys[6:])
+
+	iter, err = us.IterPrefix([]byte{0x03})
+	assert.Nil(err)
+	checkIterator(t, iter, nil, nil)
+
+	iter, err = us.IterPrefix(nil)
+	assert.Nil(err)
+	checkIterator(t, iter, keys, keys)
+
+	iter, err = us.IterPrefix([]byte{})
+	assert.Nil(err)
+	checkIterator(t, iter, keys, keys)
+}
+
+func TestUnionStoreReadHook(t *testing.T) {
+	assert := assert.New(t)
+	store := newMemDB()
+	us := NewUnionStore(NewMemDBWithContext(), &mockSnapshot{store})
+
+	assert.Nil(store.Set([]byte("1"), []byte("1")))
+	assert.Nil(us.GetMemBuffer().Set([]byte("2"), []byte("2")))
+
+	// no hook is set, reads should work as usual.
+	_, err := us.Get(context.TODO(), []byte("1"))
+	assert.Nil(err)
+
+	counts := make(map[Source]int)
+	us.SetReadHook(func(source Source, key []byte) {
+		counts[source]++
+	})
+	_, err = us.Get(context.TODO(), []byte("1"))
+	assert.Nil(err)
+	_, err = us.Get(context.TODO(), []byte("2"))
+	assert.Nil(err)
+	_, err = us.Get(context.TODO(), []byte("3"))
+	assert.True(tikverr.IsErrNotFound(err))
+	assert.Equal(1, counts[SourceMemBuffer])
+	assert.Equal(2, counts[SourceSnapshot])
+
+	iter, err := us.Iter(nil, nil)
+	assert.Nil(err)
+	iter.Close()
+	iter, err = us.IterReverse(nil, nil)
+	assert.Nil(err)
+	iter.Close()
+	assert.Equal(3, counts[SourceMemBuffer])
+	assert.Equal(4, counts[SourceSnapshot])
+
+	us.SetReadHook(nil)
+	_, err = us.Get(context.TODO(), []byte("1"))
+	assert.Nil(err)
+	assert.Equal(4, counts[SourceSnapshot])
+}
+
+type mockReadThroughCache struct {
+	m map[string][]byte
+}
+
+func (c *mockReadThroughCache) Get(key []byte) ([]byte, bool) {
+	v, ok := c.m[string(key)]
+	return v, ok
+}
+
+func (c *mockReadThroughCache) Put(key, value []byte) {
+	c.m[string(key)] = value
+}
+
+type countingSnapshot struct {
+	mockSnapshot
+	gets      int
+	batchGets int
+}
+
+func (s *countingSnapshot) Get(ctx context.Context, k []byte) ([]byte, error) {
+	s.gets++
+	return s.mockSnapshot.Get(ctx, k)
+}
+
+func (s *countingSnapshot) BatchGet(ctx context.Context, keys [][]byte) (map[string][]byte, error) {
+	s.batchGets++
+	return s.mockSnapshot.BatchGet(ctx, keys)
+}
+
+func TestUnionStoreReadThroughCache(t *testing.T) {
+	assert := assert.New(t)
+	store := newMemDB()
+	snap := &countingSnapshot{mockSnapshot: mockSnapshot{store}}
+	us := NewUnionStore(NewMemDBWithContext(), snap)
+	cache := &mockReadThroughCache{m: make(map[string][]byte)}
+	us.SetReadThroughCache(cache)
+
+	assert.Nil(store.Set([]byte("1"), []byte("1")))
+
+	// the first read goes to the snapshot and populates the cache.
+	v, err := us.Get(context.TODO(), []byte("1"))
+	assert.Nil(err)
+	assert.Equal([]byte("1"), v)
+	assert.Equal(1, snap.gets)
+	assert.Equal([]byte("1"), cache.m["1"])
+
+	// the cache hit bypasses the snapshot.
+	v, err = us.Get(context.TODO(), []byte("1"))
+	assert.Nil(err)
+	assert.Equal([]byte("1"), v)
+	assert.Equal(1, snap.gets)
+
+	cache.m["2"] = []byte("2")
+	v, err = us.Get(context.TODO(), []byte("2"))
+	assert.Nil(err)
+	assert.Equal([]byte("2"), v)
+	assert.Equal(1, snap.gets)
+
+	// keys not found in the snapshot are not cached.
+	_, err = us.Get(context.TODO(), []byte("3"))
+	assert.True(tikverr.IsErrNotFound(err))
+	assert.Equal(2, snap.gets)
+	_, ok := cache.m["3"]
+	assert.False(ok)
+
+	// the buffer deletes override the cache.
+	assert.Nil(us.GetMemBuffer().Delete([]byte("1")))
+	_, err = us.Get(context.TODO(), []byte("1"))
+	assert.True(tikverr.IsErrNotFound(err))
+
+	// the buffer writes override the cache.
+	assert.Nil(us.GetMemBuffer().Set([]byte("2"), []byte("22")))
+	v, err = us.Get(context.TODO(), []byte("2"))
+	assert.Nil(err)
+	assert.Equal([]byte("22"), v)
+	assert.Equal(2, snap.gets)
+}
+
+func TestUnionStoreSnapshotReadCache(t *testing.T) {
+	require := require.New(t)
+	store := newMemDB()
+	require.Nil(store.Set([]byte("k1"), []byte("v1")))
+	require.Nil(store.Set([]byte("k2"), []byte("v2")))
+	snap := &countingSnapshot{mockSnapshot: mockSnapshot{store}}
+	us := NewUnionStore(NewMemDBWithContext(), snap)
+	hits, misses := us.ReadCacheStats()
+	require.Zero(hits)
+	require.Zero(misses)
+	us.EnableSnapshotReadCache(1024)
+
+	// the repeated gets are served by the cache, including the nonexistent keys.
+	for i := 0; i < 3; i++ {
+		v, err := us.Get(context.TODO(), []byte("k1"))
+		require.Nil(err)
+		require.Equal([]byte("v1"), v)
+		_, err = us.Get(context.TODO(), []byte("k3"))
+		require.True(tikverr.IsErrNotFound(err))
+	}
+	require.Equal(2, snap.gets)
+	hits, misses = us.ReadCacheStats()
+	require.Equal(uint64(4), hits)
+	require.Equal(uint64(2), misses)
+
+	// BatchGetWithSource populates and consults the cache too.
+	values, sources, err := us.BatchGetWithSource(context.TODO(), [][]byte{[]byte("k1"), []byte("k2"), []byte("k4")})
+	require.Nil(err)
+	require.Equal(map[string][]byte{"k1": []byte("v1"), "k2": []byte("v2")}, values)
+	require.Equal(KVSourceNotExist, sources["k4"])
+	require.Equal(1, snap.batchGets)
+	values, _, err = us.BatchGetWithSource(context.TODO(), [][]byte{[]byte("k2"), []byte("k4")})
+	require.Nil(err)
+	require.Equal(map[string][]byte{"k2": []byte("v2")}, values)
+	require.Equal(1, snap.batchGets)
+	_, source, err := us.GetWithSource(context.TODO(), []byte("k4"))
+	require.Nil(err)
+	require.Equal(KVSourceNotExist, source)
+	require.Equal(2, snap.gets)
+
+	// the buffer writes are read through the MemBuffer and evict the cached values.
+	buffer := us.GetMemBuffer()
+	require.Nil(buffer.Set([]byte("k1"), []byte("v1x")))
+	require.Nil(buffer.Set([]byte("k3"), []byte("v3")))
+	require.Nil(buffer.Delete([]byte("k2")))
+	v, err := us.Get(context.TODO(), []byte("k1"))
+	require.Nil(err)
+	require.Equal([]byte("v1x"), v)
+	v, err = us.Get(context.TODO(), []byte("k3"))
+	require.Nil(err)
+	require.Equal([]byte("v3"), v)
+	_, err = us.Get(context.TODO(), []byte("k2"))
+	require.True(tikverr.IsErrNotFound(err))
+	for _, k := range []string{"k1", "k2", "k3"} {
+		_, ok := us.readCache.entries[k]
+		require.False(ok, k)
+	}
+	// the snapshot is read again once the writes are rolled back.
+	buffer.RevertToCheckpoint(&MemDBCheckpoint{})
+	v, err = us.Get(context.TODO(), []byte("k1"))
+	require.Nil(err)
+	require.Equal([]byte("v1"), v)
+	require.Equal(3, snap.gets)
+
+	// the least recently used entries are evicted when the cache is full.
+	us.EnableSnapshotReadCache(8)
+	for _, k := range []string{"k1", "k2", "k1", "k3"} {
+		_, _ = us.Get(context.TODO(), []byte(k))
+	}
+	require.Equal(6, snap.gets)
+	_, err = us.Get(context.TODO(), []byte("k1"))
+	require.Nil(err)
+	require.Equal(6, snap.gets)
+	_, err = us.Get(context.TODO(), []byte("k2"))
+	require.Nil(err)
+	require.Equal(7, snap.gets)
+	require.LessOrEqual(us.readCache.bytes, 8)
+
+	// a cache set by SetReadThroughCache replaces the built-in one.
+	us.SetReadThroughCache(nil)
+	hits, misses = us.ReadCacheStats()
+	require.Zero(hits)
+	require.Zero(misses)
+}
+
+type stmtCtxKey struct{}
+
+// uncomparableCtx is a context which panics when it's compared.
+type uncomparableCtx struct {
+	context.Context
+	_ []int
+}
+
+func TestUnionStoreScopedSnapshotCache(t *testing.T) {
+	require := require.New(t)
+	store := newMemDB()
+	for _, k := range []string{"k1", "k2", "k3"} {
+		require.Nil(store.Set([]byte(k), []byte("v")))
+	}
+	snap := &countingSnapshot{mockSnapshot: mockSnapshot{store}}
+	us := NewUnionStore(NewMemDBWithContext(), snap)
+	us.EnableSnapshotCache(2)
+	require.Equal(SnapshotCacheMetrics{}, us.GetSnapshotCacheMetrics())
+	require.Zero(us.GetSnapshotCacheMetrics().HitRate())
+
+	// the repeated reads in the same scope are served by the cache, even with the derived contexts.
+	ctx1 := WithSnapshotCacheScope(context.Background())
+	for i := 0; i < 3; i++ {
+		_, err := us.Get(ctx1, []byte("k1"))
+		require.Nil(err)
+		_, err = us.Get(context.WithValue(ctx1, stmtCtxKey{}, i), []byte("k4"))
+		require.True(tikverr.IsErrNotFound(err))
+	}
+	require.Equal(2, snap.gets)
+	require.Equal(SnapshotCacheMetrics{Hits: 4, Misses: 2}, us.GetSnapshotCacheMetrics())
+	require.InDelta(4.0/6.0, us.GetSnapshotCacheMetrics().HitRate(), 1e-9)
+
+	// the entries are bounded by maxEntries.
+	_, err := us.Get(ctx1, []byte("k2"))
+	require.Nil(err)
+	require.Equal(2, us.readCache.lru.Len())
+	_, err = us.Get(ctx1, []byte("k1"))
+	require.Nil(err)
+	require.Equal(4, snap.gets)
+
+	// a read in another scope doesn't see the entries cached by the previous one.
+	ctx2 := WithSnapshotCacheScope(context.Background())
+	_, err = us.Get(ctx2, []byte("k1"))
+	require.Nil(err)
+	require.Equal(5, snap.gets)
+	_, _, err = us.BatchGetWithSource(ctx2, [][]byte{[]byte("k1"), []byte("k3")})
+	require.Nil(err)
+	require.Equal(1, snap.batchGets)
+	_, err = us.Get(ctx2, []byte("k3"))
+	require.Nil(err)
+	require.Equal(5, snap.gets)
+
+	// a write to the MemBuffer only evicts the written key once it's read from the MemBuffer.
+	require.Nil(us.GetMemBuffer().Set([]byte("k9"), []byte("v")))
+	_, err = us.Get(ctx2, []byte("k3"))
+	require.Nil(err)
+	require.Equal(5, snap.gets)
+	require.Nil(us.GetMemBuffer().Set([]byte("k3"), []byte("v2")))
+	v, err := us.Get(ctx2, []byte("k3"))
+	require.Nil(err)
+	require.Equal([]byte("v2"), v)
+	us.GetMemBuffer().RemoveFromBuffer([]byte("k3"))
+	_, err = us.Get(ctx2, []byte("k3"))
+	require.Nil(err)
+	require.Equal(6, snap.gets)
+
+	// the reads without a scope bypass the cache, and the contexts needn't be comparable.
+	_, err = us.Get(uncomparableCtx{Context: context.Background()}, []byte("k3"))
+	require.Nil(err)
+	require.Equal(7, snap.gets)
+	_, err = us.Get(uncomparableCtx{Context: ctx2}, []byte("k3"))
+	require.Nil(err)
+	require.Equal(7, snap.gets)
+
+	// the byte bounded cache isn't scoped.
+	us.EnableSnapshotReadCache(1024)
+	_, err = us.Get(ctx1, []byte("k1"))
+	require.Nil(err)
+	_, err = us.Get(ctx2, []byte("k1"))
+	require.Nil(err)
+	require.Equal(8, snap.gets)
+}
+
 func checkIterator(t *testing.T, iter Iterator, keys [][]byte, values [][]byte) {
 	assert := assert.New(t)
 	defer iter.Close()
@@ -167,3 +827,292 @@ func checkIterator(t *testing.T, iter Iterator, keys [][]byte, values [][]byte)
 	}
 	assert.False(iter.Valid())
 }
+
+func TestUnionStoreIterPaged(t *testing.T) {
+	require := require.New(t)
+	store := newMemDB()
+	for _, k := range []string{"1", "2", "3", "4", "5", "6", "7"} {
+		require.Nil(store.Set([]byte(k), []byte(k)))
+	}
+	snapshot := &unseekableSnapshot{mockSnapshot: &mockSnapshot{store}}
+	us := NewUnionStore(NewMemDBWithContext(), snapshot)
+	buffer := us.GetMemBuffer()
+	require.Nil(buffer.Set([]byte("2a"), []byte("2a")))
+	require.Nil(buffer.Set([]byte("5"), []byte("5x")))
+	// the deleted keys fall on the page boundaries of both page sizes.
+	require.Nil(buffer.Delete([]byte("3")))
+	require.Nil(buffer.Delete([]byte("4")))
+	require.Nil(buffer.Delete([]byte("8")))
+
+	collect := func(it Iterator) []string {
+		defer it.Close()
+		var kvs []string
+		for ; it.Valid(); require.Nil(it.Next()) {
+			kvs = append(kvs, string(it.Key())+"="+string(it.Value()))
+		}
+		return kvs
+	}
+	for _, bounds := range [][2][]byte{{nil, nil}, {[]byte("2"), []byte("6")}, {[]byte("3"), nil}} {
+		it, err := us.Iter(bounds[0], bounds[1])
+		require.Nil(err)
+		expected := collect(it)
+		for _, pageSize := range []int{1, 2, 3} {
+			snapshot.iters = 0
+			it, err = us.IterPaged(bounds[0], bounds[1], pageSize)
+			require.Nil(err)
+			require.Equal(expected, collect(it), "bounds %q, page size %d", bounds, pageSize)
+			require.Greater(snapshot.iters, 1)
+		}
+	}
+
+	// the writes made during the scan are not seen.
+	it, err := us.IterPaged(nil, nil, 2)
+	require.Nil(err)
+	require.Equal("1", string(it.Key()))
+	require.Nil(buffer.Set([]byte("4"), []byte("4x")))
+	require.Nil(buffer.Set([]byte("6a"), []byte("6a")))
+	require.Nil(buffer.Delete([]byte("7")))
+	require.Equal([]string{"1=1", "2=2", "2a=2a", "5=5x", "6=6", "7=7"}, collect(it))
+
+	// seeking backward recreates the paged iterator.
+	it, err = us.IterPaged(nil, nil, 1)
+	require.Nil(err)
+	iter := it.(SeekableIterator)
+	require.Nil(iter.Seek([]byte("6")))
+	require.Equal("6", string(iter.Key()))
+	require.Nil(iter.Seek([]byte("3")))
+	require.Equal("4", string(iter.Key()))
+	require.Equal("4x", string(iter.Value()))
+	require.Nil(iter.Next())
+	require.Equal("5", string(iter.Key()))
+	iter.Close()
+
+	_, err = us.IterPaged(nil, nil, 0)
+	require.Error(err)
+	_, err = NewUnionStore(NewPipelinedMemDB(nil, nil), &mockSnapshot{store}).IterPaged(nil, nil, 1)
+	require.Error(err)
+}
+
+func TestUnionStoreLargeEntryHandler(t *testing.T) {
+	require := require.New(t)
+	us := NewUnionStore(NewMemDBWithContext(), &mockSnapshot{newMemDB()})
+	buffer := us.GetMemBuffer()
+	us.SetEntrySizeLimit(8, 20)
+
+	// without a handler, the error carries the key.
+	err := buffer.Set([]byte("k1"), []byte("0123456789"))
+	var entryTooLarge *tikverr.ErrEntryTooLarge
+	require.ErrorAs(err, &entryTooLarge)
+	require.Equal(&tikverr.ErrEntryTooLarge{Limit: 8, Size: 12, Key: []byte("k1")}, entryTooLarge)
+
+	var handled []string
+	spilled := map[string][]byte{}
+	us.SetLargeEntryHandler(func(key, value []byte) ([]byte, error) {
+		handled = append(handled, string(key))
+		switch string(key) {
+		case "bad":
+			return nil, errors.New("spill failed")
+		case "empty":
+			return nil, nil
+		case "huge":
+			return value, nil
+		}
+		spilled[string(key)] = append([]byte{}, value...)
+		return []byte("ref"), nil
+	})
+	// the buffer size limit is checked against the replacement, the original value would exceed it.
+	require.Nil(buffer.Set([]byte("k1"), []byte("0123456789")))
+	require.Nil(buffer.Set([]byte("k2"), []byte("0123456789")))
+	require.Nil(buffer.Set([]byte("k3"), []byte("v3")))
+	require.Equal([]string{"k1", "k2"}, handled)
+	require.Equal([]byte("0123456789"), spilled["k1"])
+	v, err := us.Get(context.TODO(), []byte("k1"))
+	require.Nil(err)
+	require.Equal([]byte("ref"), v)
+	v, err = us.Get(context.TODO(), []byte("k3"))
+	require.Nil(err)
+	require.Equal([]byte("v3"), v)
+
+	// the failed writes buffer nothing.
+	err = buffer.Set([]byte("bad"), []byte("0123456789"))
+	require.ErrorContains(err, "spill failed")
+	require.ErrorContains(err, "limit: 8")
+	err = buffer.Set([]byte("empty"), []byte("0123456789"))
+	require.ErrorContains(err, "empty value")
+	err = buffer.Set([]byte("huge"), []byte("0123456789"))
+	require.ErrorAs(err, &entryTooLarge)
+	require.Equal([]byte("huge"), entryTooLarge.Key)
+	for _, k := range []string{"bad", "empty", "huge"} {
+		_, err = buffer.Get(context.TODO(), []byte(k))
+		require.True(tikverr.IsErrNotFound(err))
+	}
+
+	// the limit changed in the middle of the transaction applies to the subsequent writes.
+	handled = nil
+	us.SetEntrySizeLimit(5, 64)
+	require.Nil(buffer.Set([]byte("k3"), []byte("v3v3")))
+	require.Equal([]string{"k3"}, handled)
+	us.SetEntrySizeLimit(64, 64)
+	require.Nil(buffer.Set([]byte("k4"), []byte("0123456789")))
+	require.Equal([]string{"k3"}, handled)
+	v, err = buffer.Get(context.TODO(), []byte("k4"))
+	require.Nil(err)
+	require.Equal([]byte("0123456789"), v)
+
+	// the handler can be removed.
+	us.SetEntrySizeLimit(5, 64)
+	us.SetLargeEntryHandler(nil)
+	require.ErrorAs(buffer.Set([]byte("k5"), []byte("0123456789")), &entryTooLarge)
+	require.Equal([]string{"k3"}, handled)
+}
+
+// blockingSnapshot blocks the creation of the iterators until ctx is done.
+type blockingSnapshot struct {
+	mockSnapshot
+	iterCtxCalls int
+}
+
+func (s *blockingSnapshot) IterCtx(ctx context.Context, k []byte, upperBound []byte) (Iterator, error) {
+	s.iterCtxCalls++
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (s *blockingSnapshot) IterReverseCtx(ctx context.Context, k, lowerBound []byte) (Iterator, error) {
+	s.iterCtxCalls++
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestUnionStoreIterCtx(t *testing.T) {
+	require := require.New(t)
+	store := newMemDB()
+	for i := 0; i < 200; i++ {
+		require.Nil(store.Set([]byte(fmt.Sprintf("k%03d", i)), []byte("v")))
+	}
+
+	// the ctx-aware snapshot is preferred, and the creation returns once ctx is done.
+	snapshot := &blockingSnapshot{mockSnapshot: mockSnapshot{store}}
+	us := NewUnionStore(NewMemDBWithContext(), snapshot)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := us.IterCtx(ctx, nil, nil)
+	require.ErrorIs(err, context.DeadlineExceeded)
+	_, err = us.IterReverseCtx(ctx, nil, nil)
+	require.ErrorIs(err, context.DeadlineExceeded)
+	require.Less(time.Since(start), 5*time.Second)
+	require.Equal(2, snapshot.iterCtxCalls)
+
+	// the iterators without ctx are created by the old methods.
+	it, err := us.Iter(nil, nil)
+	require.Nil(err)
+	require.True(it.Valid())
+	it.Close()
+
+	// other snapshots check ctx before creating the iterators.
+	us = NewUnionStore(NewMemDBWithContext(), &mockSnapshot{store})
+	_, err = us.IterCtx(ctx, nil, nil)
+	require.ErrorIs(err, context.DeadlineExceeded)
+
+	// Next stops once ctx is canceled.
+	for _, reverse := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		if reverse {
+			it, err = us.IterReverseCtx(ctx, nil, nil)
+		} else {
+			it, err = us.IterCtx(ctx, nil, nil)
+		}
+		require.Nil(err)
+		for i := 0; i < 10; i++ {
+			require.Nil(it.Next())
+		}
+		cancel()
+		steps := 0
+		for ; it.Valid(); steps++ {
+			if err = it.Next(); err != nil {
+				break
+			}
+		}
+		require.ErrorIs(err, context.Canceled)
+		require.False(it.Valid())
+		require.Less(steps, unionIterCtxCheckInterval)
+	}
+}
+
+func TestUnionStoreTrySet(t *testing.T) {
+	require := require.New(t)
+	us := NewUnionStore(NewMemDBWithContext(), &mockSnapshot{newMemDB()})
+	buffer := us.GetMemBuffer()
+
+	// without a limit, TrySet behaves like Set.
+	require.Nil(us.TrySet([]byte("k0"), []byte("v0")))
+	require.Nil(buffer.Delete([]byte("k0")))
+	require.Equal(2, us.BufferSize())
+
+	us.SetEntrySizeLimit(0, 10)
+	// exactly at the limit.
+	require.Nil(us.TrySet([]byte("k1"), []byte("123456")))
+	require.Equal(10, us.BufferSize())
+
+	// overwriting the value only counts the difference.
+	require.Nil(us.TrySet([]byte("k1"), []byte("abcdef")))
+	err := us.TrySet([]byte("k1"), []byte("abcdefg"))
+	var txnTooLarge *tikverr.ErrTxnTooLarge
+	require.ErrorAs(err, &txnTooLarge)
+	require.Equal(11, txnTooLarge.Size)
+
+	// two bytes over with a new key, the buffer is not mutated.
+	err = us.TrySet([]byte("k"), []byte("v"))
+	require.ErrorAs(err, &txnTooLarge)
+	require.Equal(12, txnTooLarge.Size)
+	require.Equal(10, us.BufferSize())
+	require.Equal(2, us.BufferLen())
+	val, err := us.Get(context.Background(), []byte("k1"))
+	require.Nil(err)
+	require.Equal([]byte("abcdef"), val)
+	_, err = buffer.Get(context.Background(), []byte("k"))
+	require.True(tikverr.IsErrNotFound(err))
+	require.ErrorIs(us.TrySet([]byte("k"), nil), tikverr.ErrCannotSetNilValue)
+
+	// the key of a flags-only entry is already counted.
+	us = NewUnionStore(NewMemDBWithContext(), &mockSnapshot{newMemDB()})
+	us.SetEntrySizeLimit(0, 10)
+	us.GetMemBuffer().UpdateFlags([]byte("k2"), kv.SetKeyLocked)
+	require.Equal(2, us.BufferSize())
+	err = us.TrySet([]byte("k2"), []byte("123456789"))
+	require.ErrorAs(err, &txnTooLarge)
+	require.Equal(11, txnTooLarge.Size)
+	require.Nil(us.TrySet([]byte("k2"), []byte("12345678")))
+	require.Equal(10, us.BufferSize())
+}
+
+func TestPipelinedUnionStoreTrySet(t *testing.T) {
+	require := require.New(t)
+	blockCh := make(chan struct{})
+	memdb := NewPipelinedMemDB(emptyBufferBatchGetter, func(_ uint64, db *MemDB) error {
+		<-blockCh
+		return nil
+	})
+	us := NewUnionStore(memdb, &mockSnapshot{newMemDB()})
+	us.SetEntrySizeLimit(0, 10)
+	require.Nil(us.TrySet([]byte("k1"), []byte("12345678")))
+	_, err := memdb.Flush(true)
+	require.Nil(err)
+
+	// only the mutable memdb counts, the flushing one doesn't.
+	require.Equal(10, us.BufferSize())
+	require.Nil(us.TrySet([]byte("k2"), []byte("12345678")))
+	require.Equal(20, us.BufferSize())
+	var txnTooLarge *tikverr.ErrTxnTooLarge
+	err = us.TrySet([]byte("k2"), []byte("123456789"))
+	require.ErrorAs(err, &txnTooLarge)
+	require.Equal(11, txnTooLarge.Size)
+	// the flushing value of a key is not replaced by the write to the mutable memdb.
+	err = us.TrySet([]byte("k1"), []byte("1"))
+	require.ErrorAs(err, &txnTooLarge)
+	require.Equal(13, txnTooLarge.Size)
+	require.Equal(20, us.BufferSize())
+	close(blockCh)
+	require.Nil(memdb.FlushWait())
+}
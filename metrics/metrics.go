@@ -75,6 +75,7 @@ var (
 	TiKVBatchRecvLatency                     *prometheus.HistogramVec
 	TiKVRangeTaskStats                       *prometheus.GaugeVec
 	TiKVRangeTaskPushDuration                *prometheus.HistogramVec
+	TiKVRangeTaskHandleDuration              *prometheus.HistogramVec
 	TiKVTokenWaitDuration                    prometheus.Histogram
 	TiKVTxnHeartBeatHistogram                *prometheus.HistogramVec
 	TiKVTTLManagerHistogram                  prometheus.Histogram
@@ -111,6 +112,10 @@ var (
 	TiKVPipelinedFlushLenHistogram           prometheus.Histogram
 	TiKVPipelinedFlushSizeHistogram          prometheus.Histogram
 	TiKVPipelinedFlushDuration               prometheus.Histogram
+	TiKVMemDBArenaBytes                      *prometheus.GaugeVec
+	TiKVMemDBArenaFragmentation              *prometheus.GaugeVec
+	TiKVCodecDecodeErrorCounter              *prometheus.CounterVec
+	TiKVTxnAssertionFailedCounter            *prometheus.CounterVec
 )
 
 // Label constants.
@@ -136,9 +141,12 @@ const (
 	LblGeneral         = "general"
 	LblDirection       = "direction"
 	LblReason          = "reason"
+	LblKind            = "kind"
 )
 
 func initMetrics(namespace, subsystem string, constLabels prometheus.Labels) {
+	initNamespace, initSubsystem, initConstLabels = namespace, subsystem, constLabels
+
 	TiKVTxnCmdHistogram = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace:   namespace,
@@ -466,6 +474,16 @@ func initMetrics(namespace, subsystem string, constLabels prometheus.Labels) {
 			ConstLabels: constLabels,
 		}, []string{LblType})
 
+	TiKVRangeTaskHandleDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "range_task_handle_duration",
+			Buckets:     prometheus.ExponentialBuckets(0.001, 2, 20), // 1ms ~ 524s
+			Help:        "duration of each handler invocation of range task workers",
+			ConstLabels: constLabels,
+		}, []string{LblType})
+
 	TiKVTokenWaitDuration = prometheus.NewHistogram(
 		prometheus.HistogramOpts{
 			Namespace:   namespace,
@@ -796,6 +814,45 @@ func initMetrics(namespace, subsystem string, constLabels prometheus.Labels) {
 			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 28), // 0.5ms ~ 18h
 		})
 
+	TiKVMemDBArenaBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "memdb_arena_bytes",
+			Help:        "Bytes of the memdb arenas by the type of the memdb.",
+			ConstLabels: constLabels,
+		}, []string{LblType, LblKind})
+
+	TiKVMemDBArenaFragmentation = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "memdb_arena_fragmentation",
+			Help:        "Estimated ratio of the memdb arena memory not used by live nodes by the type of the memdb.",
+			ConstLabels: constLabels,
+		}, []string{LblType})
+
+	TiKVCodecDecodeErrorCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "codec_decode_error_total",
+			Help:        "Counter of failures to decode the region keys by the API version of the codec.",
+			ConstLabels: constLabels,
+		}, []string{LblType})
+
+	TiKVTxnAssertionFailedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "txn_assertion_failed_total",
+			Help:        "Counter of assertion failures in transactions by the assertion.",
+			ConstLabels: constLabels,
+		}, []string{LblType})
+
+	if mode == ModeDisabled {
+		disableMetrics()
+	}
 	initShortcuts()
 }
 
@@ -815,7 +872,11 @@ func InitMetricsWithConstLabels(namespace, subsystem string, constLabels prometh
 
 // RegisterMetrics registers all metrics variables.
 // Note: to change default namespace and subsystem name, call `InitMetrics` before registering.
+// Nothing is registered if the metrics are disabled by SetMode.
 func RegisterMetrics() {
+	if mode == ModeDisabled {
+		return
+	}
 	prometheus.MustRegister(TiKVTxnCmdHistogram)
 	prometheus.MustRegister(TiKVBackoffHistogram)
 	prometheus.MustRegister(TiKVSendReqHistogram)
@@ -850,6 +911,7 @@ func RegisterMetrics() {
 	prometheus.MustRegister(TiKVBatchClientRecycle)
 	prometheus.MustRegister(TiKVRangeTaskStats)
 	prometheus.MustRegister(TiKVRangeTaskPushDuration)
+	prometheus.MustRegister(TiKVRangeTaskHandleDuration)
 	prometheus.MustRegister(TiKVTokenWaitDuration)
 	prometheus.MustRegister(TiKVTxnHeartBeatHistogram)
 	prometheus.MustRegister(TiKVTTLManagerHistogram)
@@ -886,6 +948,10 @@ func RegisterMetrics() {
 	prometheus.MustRegister(TiKVPipelinedFlushLenHistogram)
 	prometheus.MustRegister(TiKVPipelinedFlushSizeHistogram)
 	prometheus.MustRegister(TiKVPipelinedFlushDuration)
+	prometheus.MustRegister(TiKVMemDBArenaBytes)
+	prometheus.MustRegister(TiKVMemDBArenaFragmentation)
+	prometheus.MustRegister(TiKVCodecDecodeErrorCounter)
+	prometheus.MustRegister(TiKVTxnAssertionFailedCounter)
 }
 
 // readCounter reads the value of a prometheus.Counter.
@@ -0,0 +1,154 @@
+// Copyright 2024 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Mode decides how the metrics are collected.
+type Mode int
+
+const (
+	// ModeFull collects all metrics, the children of the shortcuts are resolved when the metrics are initialized.
+	// It's the default mode.
+	ModeFull Mode = iota
+	// ModeLazy collects all metrics, but the children of the shortcuts are resolved on their first use,
+	// so the series which are never used are not exported.
+	ModeLazy
+	// ModeDisabled replaces the shortcuts and the metrics without labels with no-op implementations, and
+	// RegisterMetrics registers nothing. The metric vectors keep their types, observations through them still
+	// work but are not exported.
+	ModeDisabled
+)
+
+var (
+	mode Mode
+
+	initNamespace   string
+	initSubsystem   string
+	initConstLabels prometheus.Labels
+)
+
+// SetMode sets the metrics mode and initializes the metrics variables again with the last used namespace,
+// subsystem name and const labels. It must be called before creating any client and before RegisterMetrics,
+// the mode is not toggleable afterward because the metrics held by the clients are not replaced.
+func SetMode(m Mode) {
+	mode = m
+	initMetrics(initNamespace, initSubsystem, initConstLabels)
+}
+
+// Enabled returns false if the metrics are disabled by ModeDisabled. Hot paths which observe the metric vectors
+// directly can check it to skip the observations.
+func Enabled() bool {
+	return mode != ModeDisabled
+}
+
+func observerWithLabels(vec *prometheus.HistogramVec, lvs ...string) prometheus.Observer {
+	switch mode {
+	case ModeLazy:
+		return &lazyObserver{vec: vec, lvs: lvs}
+	case ModeDisabled:
+		return noopMetric{}
+	default:
+		return vec.WithLabelValues(lvs...)
+	}
+}
+
+func counterWithLabels(vec *prometheus.CounterVec, lvs ...string) prometheus.Counter {
+	switch mode {
+	case ModeLazy:
+		return &lazyCounter{vec: vec, lvs: lvs}
+	case ModeDisabled:
+		return noopMetric{}
+	default:
+		return vec.WithLabelValues(lvs...)
+	}
+}
+
+// disableMetrics replaces the metrics without labels with no-op implementations.
+func disableMetrics() {
+	TiKVLocalLatchWaitTimeHistogram = noopMetric{}
+	TiKVBatchWaitDuration = noopMetric{}
+	TiKVBatchSendLatency = noopMetric{}
+	TiKVBatchWaitOverLoad = noopMetric{}
+	TiKVBatchClientUnavailable = noopMetric{}
+	TiKVBatchClientWaitEstablish = noopMetric{}
+	TiKVBatchClientRecycle = noopMetric{}
+	TiKVTokenWaitDuration = noopMetric{}
+	TiKVTTLManagerHistogram = noopMetric{}
+	TiKVPessimisticLockKeysDuration = noopMetric{}
+	TiKVTTLLifeTimeReachCounter = noopMetric{}
+	TiKVNoAvailableConnectionCounter = noopMetric{}
+	TiKVTSFutureWaitDuration = noopMetric{}
+	TiKVRequestRetryTimesHistogram = noopMetric{}
+	TiKVTxnCommitBackoffSeconds = noopMetric{}
+	TiKVTxnCommitBackoffCount = noopMetric{}
+	TiKVSmallReadDuration = noopMetric{}
+	TiKVReadThroughput = noopMetric{}
+	TiKVPipelinedFlushLenHistogram = noopMetric{}
+	TiKVPipelinedFlushSizeHistogram = noopMetric{}
+	TiKVPipelinedFlushDuration = noopMetric{}
+}
+
+var noopDesc = prometheus.NewInvalidDesc(errors.New("metrics are disabled"))
+
+// noopMetric implements prometheus.Counter and prometheus.Histogram, but does nothing.
+type noopMetric struct{}
+
+func (noopMetric) Desc() *prometheus.Desc           { return noopDesc }
+func (noopMetric) Write(*dto.Metric) error          { return nil }
+func (noopMetric) Describe(chan<- *prometheus.Desc) {}
+func (noopMetric) Collect(chan<- prometheus.Metric) {}
+func (noopMetric) Inc()                             {}
+func (noopMetric) Add(float64)                      {}
+func (noopMetric) Observe(float64)                  {}
+
+// lazyObserver resolves the child of the histogram vector on the first observation.
+type lazyObserver struct {
+	once sync.Once
+	vec  *prometheus.HistogramVec
+	lvs  []string
+	o    prometheus.Observer
+}
+
+func (l *lazyObserver) Observe(v float64) {
+	l.once.Do(func() { l.o = l.vec.WithLabelValues(l.lvs...) })
+	l.o.Observe(v)
+}
+
+// lazyCounter resolves the child of the counter vector on the first use.
+type lazyCounter struct {
+	once sync.Once
+	vec  *prometheus.CounterVec
+	lvs  []string
+	c    prometheus.Counter
+}
+
+func (l *lazyCounter) get() prometheus.Counter {
+	l.once.Do(func() { l.c = l.vec.WithLabelValues(l.lvs...) })
+	return l.c
+}
+
+func (l *lazyCounter) Desc() *prometheus.Desc              { return l.get().Desc() }
+func (l *lazyCounter) Write(m *dto.Metric) error           { return l.get().Write(m) }
+func (l *lazyCounter) Describe(ch chan<- *prometheus.Desc) { l.get().Describe(ch) }
+func (l *lazyCounter) Collect(ch chan<- prometheus.Metric) { l.get().Collect(ch) }
+func (l *lazyCounter) Inc()                                { l.get().Inc() }
+func (l *lazyCounter) Add(v float64)                       { l.get().Add(v) }
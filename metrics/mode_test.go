@@ -0,0 +1,76 @@
+// Copyright 2024 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetMode(t *testing.T) {
+	defer SetMode(ModeFull)
+
+	SetMode(ModeLazy)
+	require.True(t, Enabled())
+	require.IsType(t, &lazyCounter{}, TwoPCTxnCounterOk)
+	require.IsType(t, &lazyObserver{}, BackoffHistogramRPC)
+	// the lazy shortcut observes the child of the vector.
+	TwoPCTxnCounterOk.Add(2)
+	require.Equal(t, int64(2), readCounter(TwoPCTxnCounterOk))
+	require.Equal(t, int64(2), readCounter(TiKVTwoPCTxnCounter.WithLabelValues("ok")))
+
+	SetMode(ModeDisabled)
+	require.False(t, Enabled())
+	require.Equal(t, noopMetric{}, TwoPCTxnCounterOk)
+	require.Equal(t, noopMetric{}, TiKVBatchWaitDuration)
+	TwoPCTxnCounterOk.Inc()
+	require.Equal(t, TxnCommitCounter{}, GetTxnCommitCounter())
+	// nothing is registered.
+	defaultRegisterer := prometheus.DefaultRegisterer
+	defer func() { prometheus.DefaultRegisterer = defaultRegisterer }()
+	reg := prometheus.NewRegistry()
+	prometheus.DefaultRegisterer = reg
+	RegisterMetrics()
+	mfs, err := reg.Gather()
+	require.Nil(t, err)
+	require.Empty(t, mfs)
+
+	SetMode(ModeFull)
+	require.True(t, Enabled())
+	require.NotEqual(t, noopMetric{}, TiKVBatchWaitDuration)
+	TwoPCTxnCounterOk.Inc()
+	require.Equal(t, int64(1), readCounter(TwoPCTxnCounterOk))
+}
+
+func BenchmarkObserveHotPath(b *testing.B) {
+	defer SetMode(ModeFull)
+	for _, c := range []struct {
+		name string
+		mode Mode
+	}{{"full", ModeFull}, {"lazy", ModeLazy}, {"disabled", ModeDisabled}} {
+		SetMode(c.mode)
+		b.Run(c.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				TiKVBatchWaitDuration.Observe(0.001)
+				TiKVBatchSendLatency.Observe(0.001)
+				BackoffHistogramRPC.Observe(0.002)
+				TxnCmdHistogramWithGetGeneral.Observe(0.003)
+				LockResolverCountWithResolve.Inc()
+			}
+		})
+	}
+}
@@ -173,143 +173,143 @@ var (
 )
 
 func initShortcuts() {
-	TxnCmdHistogramWithCommitInternal = TiKVTxnCmdHistogram.WithLabelValues(LblCommit, LblInternal)
-	TxnCmdHistogramWithCommitGeneral = TiKVTxnCmdHistogram.WithLabelValues(LblCommit, LblGeneral)
-	TxnCmdHistogramWithRollbackInternal = TiKVTxnCmdHistogram.WithLabelValues(LblRollback, LblInternal)
-	TxnCmdHistogramWithRollbackGeneral = TiKVTxnCmdHistogram.WithLabelValues(LblRollback, LblGeneral)
-	TxnCmdHistogramWithBatchGetInternal = TiKVTxnCmdHistogram.WithLabelValues(LblBatchGet, LblInternal)
-	TxnCmdHistogramWithBatchGetGeneral = TiKVTxnCmdHistogram.WithLabelValues(LblBatchGet, LblGeneral)
-	TxnCmdHistogramWithGetInternal = TiKVTxnCmdHistogram.WithLabelValues(LblGet, LblInternal)
-	TxnCmdHistogramWithGetGeneral = TiKVTxnCmdHistogram.WithLabelValues(LblGet, LblGeneral)
-	TxnCmdHistogramWithLockKeysInternal = TiKVTxnCmdHistogram.WithLabelValues(LblLockKeys, LblInternal)
-	TxnCmdHistogramWithLockKeysGeneral = TiKVTxnCmdHistogram.WithLabelValues(LblLockKeys, LblGeneral)
-
-	RawkvCmdHistogramWithGet = TiKVRawkvCmdHistogram.WithLabelValues("get")
-	RawkvCmdHistogramWithBatchGet = TiKVRawkvCmdHistogram.WithLabelValues("batch_get")
-	RawkvCmdHistogramWithBatchPut = TiKVRawkvCmdHistogram.WithLabelValues("batch_put")
-	RawkvCmdHistogramWithDelete = TiKVRawkvCmdHistogram.WithLabelValues("delete")
-	RawkvCmdHistogramWithBatchDelete = TiKVRawkvCmdHistogram.WithLabelValues("batch_delete")
-	RawkvCmdHistogramWithRawScan = TiKVRawkvCmdHistogram.WithLabelValues("raw_scan")
-	RawkvCmdHistogramWithRawReversScan = TiKVRawkvCmdHistogram.WithLabelValues("raw_reverse_scan")
-	RawkvSizeHistogramWithKey = TiKVRawkvSizeHistogram.WithLabelValues("key")
-	RawkvSizeHistogramWithValue = TiKVRawkvSizeHistogram.WithLabelValues("value")
-	RawkvCmdHistogramWithRawChecksum = TiKVRawkvSizeHistogram.WithLabelValues("raw_checksum")
-
-	BackoffHistogramRPC = TiKVBackoffHistogram.WithLabelValues("tikvRPC")
-	BackoffHistogramLock = TiKVBackoffHistogram.WithLabelValues("txnLock")
-	BackoffHistogramLockFast = TiKVBackoffHistogram.WithLabelValues("tikvLockFast")
-	BackoffHistogramPD = TiKVBackoffHistogram.WithLabelValues("pdRPC")
-	BackoffHistogramRegionMiss = TiKVBackoffHistogram.WithLabelValues("regionMiss")
-	BackoffHistogramRegionScheduling = TiKVBackoffHistogram.WithLabelValues("regionScheduling")
-	BackoffHistogramServerBusy = TiKVBackoffHistogram.WithLabelValues("serverBusy")
-	BackoffHistogramTiKVDiskFull = TiKVBackoffHistogram.WithLabelValues("tikvDiskFull")
-	BackoffHistogramRegionRecoveryInProgress = TiKVBackoffHistogram.WithLabelValues("regionRecoveryInProgress")
-	BackoffHistogramStaleCmd = TiKVBackoffHistogram.WithLabelValues("staleCommand")
-	BackoffHistogramDataNotReady = TiKVBackoffHistogram.WithLabelValues("dataNotReady")
-	BackoffHistogramIsWitness = TiKVBackoffHistogram.WithLabelValues("isWitness")
-	BackoffHistogramEmpty = TiKVBackoffHistogram.WithLabelValues("")
-
-	TxnRegionsNumHistogramWithSnapshotInternal = TiKVTxnRegionsNumHistogram.WithLabelValues("snapshot", LblInternal)
-	TxnRegionsNumHistogramWithSnapshot = TiKVTxnRegionsNumHistogram.WithLabelValues("snapshot", LblGeneral)
-	TxnRegionsNumHistogramPrewriteInternal = TiKVTxnRegionsNumHistogram.WithLabelValues("2pc_prewrite", LblInternal)
-	TxnRegionsNumHistogramPrewrite = TiKVTxnRegionsNumHistogram.WithLabelValues("2pc_prewrite", LblGeneral)
-	TxnRegionsNumHistogramCommitInternal = TiKVTxnRegionsNumHistogram.WithLabelValues("2pc_commit", LblInternal)
-	TxnRegionsNumHistogramCommit = TiKVTxnRegionsNumHistogram.WithLabelValues("2pc_commit", LblGeneral)
-	TxnRegionsNumHistogramCleanupInternal = TiKVTxnRegionsNumHistogram.WithLabelValues("2pc_cleanup", LblInternal)
-	TxnRegionsNumHistogramCleanup = TiKVTxnRegionsNumHistogram.WithLabelValues("2pc_cleanup", LblGeneral)
-	TxnRegionsNumHistogramPessimisticLockInternal = TiKVTxnRegionsNumHistogram.WithLabelValues("2pc_pessimistic_lock", LblInternal)
-	TxnRegionsNumHistogramPessimisticLock = TiKVTxnRegionsNumHistogram.WithLabelValues("2pc_pessimistic_lock", LblGeneral)
-	TxnRegionsNumHistogramPessimisticRollbackInternal = TiKVTxnRegionsNumHistogram.WithLabelValues("2pc_pessimistic_rollback", LblInternal)
-	TxnRegionsNumHistogramPessimisticRollback = TiKVTxnRegionsNumHistogram.WithLabelValues("2pc_pessimistic_rollback", LblGeneral)
-	TxnRegionsNumHistogramWithCoprocessorInternal = TiKVTxnRegionsNumHistogram.WithLabelValues("coprocessor", LblInternal)
-	TxnRegionsNumHistogramWithCoprocessor = TiKVTxnRegionsNumHistogram.WithLabelValues("batch_coprocessor", LblGeneral)
-	TxnRegionsNumHistogramWithBatchCoprocessorInternal = TiKVTxnRegionsNumHistogram.WithLabelValues("coprocessor", LblInternal)
-	TxnRegionsNumHistogramWithBatchCoprocessor = TiKVTxnRegionsNumHistogram.WithLabelValues("batch_coprocessor", LblGeneral)
-	TxnWriteKVCountHistogramInternal = TiKVTxnWriteKVCountHistogram.WithLabelValues(LblInternal)
-	TxnWriteKVCountHistogramGeneral = TiKVTxnWriteKVCountHistogram.WithLabelValues(LblGeneral)
-	TxnWriteSizeHistogramInternal = TiKVTxnWriteSizeHistogram.WithLabelValues(LblInternal)
-	TxnWriteSizeHistogramGeneral = TiKVTxnWriteSizeHistogram.WithLabelValues(LblGeneral)
-
-	LockResolverCountWithBatchResolve = TiKVLockResolverCounter.WithLabelValues("batch_resolve")
-	LockResolverCountWithExpired = TiKVLockResolverCounter.WithLabelValues("expired")
-	LockResolverCountWithNotExpired = TiKVLockResolverCounter.WithLabelValues("not_expired")
-	LockResolverCountWithWaitExpired = TiKVLockResolverCounter.WithLabelValues("wait_expired")
-	LockResolverCountWithResolve = TiKVLockResolverCounter.WithLabelValues("resolve")
-	LockResolverCountWithResolveForWrite = TiKVLockResolverCounter.WithLabelValues("resolve_for_write")
-	LockResolverCountWithResolveAsync = TiKVLockResolverCounter.WithLabelValues("resolve_async_commit")
-	LockResolverCountWithWriteConflict = TiKVLockResolverCounter.WithLabelValues("write_conflict")
-	LockResolverCountWithQueryTxnStatus = TiKVLockResolverCounter.WithLabelValues("query_txn_status")
-	LockResolverCountWithQueryTxnStatusCommitted = TiKVLockResolverCounter.WithLabelValues("query_txn_status_committed")
-	LockResolverCountWithQueryTxnStatusRolledBack = TiKVLockResolverCounter.WithLabelValues("query_txn_status_rolled_back")
-	LockResolverCountWithQueryCheckSecondaryLocks = TiKVLockResolverCounter.WithLabelValues("query_check_secondary_locks")
-	LockResolverCountWithResolveLocks = TiKVLockResolverCounter.WithLabelValues("query_resolve_locks")
-	LockResolverCountWithResolveLockLite = TiKVLockResolverCounter.WithLabelValues("query_resolve_lock_lite")
-
-	RegionCacheCounterWithInvalidateRegionFromCacheOK = TiKVRegionCacheCounter.WithLabelValues("invalidate_region_from_cache", "ok")
-	RegionCacheCounterWithSendFail = TiKVRegionCacheCounter.WithLabelValues("send_fail", "ok")
-	RegionCacheCounterWithGetRegionByIDOK = TiKVRegionCacheCounter.WithLabelValues("get_region_by_id", "ok")
-	RegionCacheCounterWithGetRegionByIDError = TiKVRegionCacheCounter.WithLabelValues("get_region_by_id", "err")
-	RegionCacheCounterWithGetCacheMissOK = TiKVRegionCacheCounter.WithLabelValues("get_region_when_miss", "ok")
-	RegionCacheCounterWithGetCacheMissError = TiKVRegionCacheCounter.WithLabelValues("get_region_when_miss", "err")
-	RegionCacheCounterWithScanRegionsOK = TiKVRegionCacheCounter.WithLabelValues("scan_regions", "ok")
-	RegionCacheCounterWithScanRegionsError = TiKVRegionCacheCounter.WithLabelValues("scan_regions", "err")
-	RegionCacheCounterWithGetStoreOK = TiKVRegionCacheCounter.WithLabelValues("get_store", "ok")
-	RegionCacheCounterWithGetStoreError = TiKVRegionCacheCounter.WithLabelValues("get_store", "err")
-	RegionCacheCounterWithInvalidateStoreRegionsOK = TiKVRegionCacheCounter.WithLabelValues("invalidate_store_regions", "ok")
-
-	LoadRegionCacheHistogramWhenCacheMiss = TiKVLoadRegionCacheHistogram.WithLabelValues("get_region_when_miss")
-	LoadRegionCacheHistogramWithRegionByID = TiKVLoadRegionCacheHistogram.WithLabelValues("get_region_by_id")
-	LoadRegionCacheHistogramWithRegions = TiKVLoadRegionCacheHistogram.WithLabelValues("scan_regions")
-	LoadRegionCacheHistogramWithGetStore = TiKVLoadRegionCacheHistogram.WithLabelValues("get_store")
-
-	TxnHeartBeatHistogramOK = TiKVTxnHeartBeatHistogram.WithLabelValues("ok")
-	TxnHeartBeatHistogramError = TiKVTxnHeartBeatHistogram.WithLabelValues("err")
-
-	StatusCountWithOK = TiKVStatusCounter.WithLabelValues("ok")
-	StatusCountWithError = TiKVStatusCounter.WithLabelValues("err")
-
-	SecondaryLockCleanupFailureCounterCommit = TiKVSecondaryLockCleanupFailureCounter.WithLabelValues("commit")
-	SecondaryLockCleanupFailureCounterRollback = TiKVSecondaryLockCleanupFailureCounter.WithLabelValues("rollback")
-
-	TwoPCTxnCounterOk = TiKVTwoPCTxnCounter.WithLabelValues("ok")
-	TwoPCTxnCounterError = TiKVTwoPCTxnCounter.WithLabelValues("err")
-
-	AsyncCommitTxnCounterOk = TiKVAsyncCommitTxnCounter.WithLabelValues("ok")
-	AsyncCommitTxnCounterError = TiKVAsyncCommitTxnCounter.WithLabelValues("err")
-
-	OnePCTxnCounterOk = TiKVOnePCTxnCounter.WithLabelValues("ok")
-	OnePCTxnCounterError = TiKVOnePCTxnCounter.WithLabelValues("err")
-	OnePCTxnCounterFallback = TiKVOnePCTxnCounter.WithLabelValues("fallback")
-
-	BatchRecvHistogramOK = TiKVBatchRecvLatency.WithLabelValues("ok")
-	BatchRecvHistogramError = TiKVBatchRecvLatency.WithLabelValues("err")
-
-	PrewriteAssertionUsageCounterNone = TiKVPrewriteAssertionUsageCounter.WithLabelValues("none")
-	PrewriteAssertionUsageCounterExist = TiKVPrewriteAssertionUsageCounter.WithLabelValues("exist")
-	PrewriteAssertionUsageCounterNotExist = TiKVPrewriteAssertionUsageCounter.WithLabelValues("not-exist")
-	PrewriteAssertionUsageCounterUnknown = TiKVPrewriteAssertionUsageCounter.WithLabelValues("unknown")
+	TxnCmdHistogramWithCommitInternal = observerWithLabels(TiKVTxnCmdHistogram, LblCommit, LblInternal)
+	TxnCmdHistogramWithCommitGeneral = observerWithLabels(TiKVTxnCmdHistogram, LblCommit, LblGeneral)
+	TxnCmdHistogramWithRollbackInternal = observerWithLabels(TiKVTxnCmdHistogram, LblRollback, LblInternal)
+	TxnCmdHistogramWithRollbackGeneral = observerWithLabels(TiKVTxnCmdHistogram, LblRollback, LblGeneral)
+	TxnCmdHistogramWithBatchGetInternal = observerWithLabels(TiKVTxnCmdHistogram, LblBatchGet, LblInternal)
+	TxnCmdHistogramWithBatchGetGeneral = observerWithLabels(TiKVTxnCmdHistogram, LblBatchGet, LblGeneral)
+	TxnCmdHistogramWithGetInternal = observerWithLabels(TiKVTxnCmdHistogram, LblGet, LblInternal)
+	TxnCmdHistogramWithGetGeneral = observerWithLabels(TiKVTxnCmdHistogram, LblGet, LblGeneral)
+	TxnCmdHistogramWithLockKeysInternal = observerWithLabels(TiKVTxnCmdHistogram, LblLockKeys, LblInternal)
+	TxnCmdHistogramWithLockKeysGeneral = observerWithLabels(TiKVTxnCmdHistogram, LblLockKeys, LblGeneral)
+
+	RawkvCmdHistogramWithGet = observerWithLabels(TiKVRawkvCmdHistogram, "get")
+	RawkvCmdHistogramWithBatchGet = observerWithLabels(TiKVRawkvCmdHistogram, "batch_get")
+	RawkvCmdHistogramWithBatchPut = observerWithLabels(TiKVRawkvCmdHistogram, "batch_put")
+	RawkvCmdHistogramWithDelete = observerWithLabels(TiKVRawkvCmdHistogram, "delete")
+	RawkvCmdHistogramWithBatchDelete = observerWithLabels(TiKVRawkvCmdHistogram, "batch_delete")
+	RawkvCmdHistogramWithRawScan = observerWithLabels(TiKVRawkvCmdHistogram, "raw_scan")
+	RawkvCmdHistogramWithRawReversScan = observerWithLabels(TiKVRawkvCmdHistogram, "raw_reverse_scan")
+	RawkvSizeHistogramWithKey = observerWithLabels(TiKVRawkvSizeHistogram, "key")
+	RawkvSizeHistogramWithValue = observerWithLabels(TiKVRawkvSizeHistogram, "value")
+	RawkvCmdHistogramWithRawChecksum = observerWithLabels(TiKVRawkvSizeHistogram, "raw_checksum")
+
+	BackoffHistogramRPC = observerWithLabels(TiKVBackoffHistogram, "tikvRPC")
+	BackoffHistogramLock = observerWithLabels(TiKVBackoffHistogram, "txnLock")
+	BackoffHistogramLockFast = observerWithLabels(TiKVBackoffHistogram, "tikvLockFast")
+	BackoffHistogramPD = observerWithLabels(TiKVBackoffHistogram, "pdRPC")
+	BackoffHistogramRegionMiss = observerWithLabels(TiKVBackoffHistogram, "regionMiss")
+	BackoffHistogramRegionScheduling = observerWithLabels(TiKVBackoffHistogram, "regionScheduling")
+	BackoffHistogramServerBusy = observerWithLabels(TiKVBackoffHistogram, "serverBusy")
+	BackoffHistogramTiKVDiskFull = observerWithLabels(TiKVBackoffHistogram, "tikvDiskFull")
+	BackoffHistogramRegionRecoveryInProgress = observerWithLabels(TiKVBackoffHistogram, "regionRecoveryInProgress")
+	BackoffHistogramStaleCmd = observerWithLabels(TiKVBackoffHistogram, "staleCommand")
+	BackoffHistogramDataNotReady = observerWithLabels(TiKVBackoffHistogram, "dataNotReady")
+	BackoffHistogramIsWitness = observerWithLabels(TiKVBackoffHistogram, "isWitness")
+	BackoffHistogramEmpty = observerWithLabels(TiKVBackoffHistogram, "")
+
+	TxnRegionsNumHistogramWithSnapshotInternal = observerWithLabels(TiKVTxnRegionsNumHistogram, "snapshot", LblInternal)
+	TxnRegionsNumHistogramWithSnapshot = observerWithLabels(TiKVTxnRegionsNumHistogram, "snapshot", LblGeneral)
+	TxnRegionsNumHistogramPrewriteInternal = observerWithLabels(TiKVTxnRegionsNumHistogram, "2pc_prewrite", LblInternal)
+	TxnRegionsNumHistogramPrewrite = observerWithLabels(TiKVTxnRegionsNumHistogram, "2pc_prewrite", LblGeneral)
+	TxnRegionsNumHistogramCommitInternal = observerWithLabels(TiKVTxnRegionsNumHistogram, "2pc_commit", LblInternal)
+	TxnRegionsNumHistogramCommit = observerWithLabels(TiKVTxnRegionsNumHistogram, "2pc_commit", LblGeneral)
+	TxnRegionsNumHistogramCleanupInternal = observerWithLabels(TiKVTxnRegionsNumHistogram, "2pc_cleanup", LblInternal)
+	TxnRegionsNumHistogramCleanup = observerWithLabels(TiKVTxnRegionsNumHistogram, "2pc_cleanup", LblGeneral)
+	TxnRegionsNumHistogramPessimisticLockInternal = observerWithLabels(TiKVTxnRegionsNumHistogram, "2pc_pessimistic_lock", LblInternal)
+	TxnRegionsNumHistogramPessimisticLock = observerWithLabels(TiKVTxnRegionsNumHistogram, "2pc_pessimistic_lock", LblGeneral)
+	TxnRegionsNumHistogramPessimisticRollbackInternal = observerWithLabels(TiKVTxnRegionsNumHistogram, "2pc_pessimistic_rollback", LblInternal)
+	TxnRegionsNumHistogramPessimisticRollback = observerWithLabels(TiKVTxnRegionsNumHistogram, "2pc_pessimistic_rollback", LblGeneral)
+	TxnRegionsNumHistogramWithCoprocessorInternal = observerWithLabels(TiKVTxnRegionsNumHistogram, "coprocessor", LblInternal)
+	TxnRegionsNumHistogramWithCoprocessor = observerWithLabels(TiKVTxnRegionsNumHistogram, "batch_coprocessor", LblGeneral)
+	TxnRegionsNumHistogramWithBatchCoprocessorInternal = observerWithLabels(TiKVTxnRegionsNumHistogram, "coprocessor", LblInternal)
+	TxnRegionsNumHistogramWithBatchCoprocessor = observerWithLabels(TiKVTxnRegionsNumHistogram, "batch_coprocessor", LblGeneral)
+	TxnWriteKVCountHistogramInternal = observerWithLabels(TiKVTxnWriteKVCountHistogram, LblInternal)
+	TxnWriteKVCountHistogramGeneral = observerWithLabels(TiKVTxnWriteKVCountHistogram, LblGeneral)
+	TxnWriteSizeHistogramInternal = observerWithLabels(TiKVTxnWriteSizeHistogram, LblInternal)
+	TxnWriteSizeHistogramGeneral = observerWithLabels(TiKVTxnWriteSizeHistogram, LblGeneral)
+
+	LockResolverCountWithBatchResolve = counterWithLabels(TiKVLockResolverCounter, "batch_resolve")
+	LockResolverCountWithExpired = counterWithLabels(TiKVLockResolverCounter, "expired")
+	LockResolverCountWithNotExpired = counterWithLabels(TiKVLockResolverCounter, "not_expired")
+	LockResolverCountWithWaitExpired = counterWithLabels(TiKVLockResolverCounter, "wait_expired")
+	LockResolverCountWithResolve = counterWithLabels(TiKVLockResolverCounter, "resolve")
+	LockResolverCountWithResolveForWrite = counterWithLabels(TiKVLockResolverCounter, "resolve_for_write")
+	LockResolverCountWithResolveAsync = counterWithLabels(TiKVLockResolverCounter, "resolve_async_commit")
+	LockResolverCountWithWriteConflict = counterWithLabels(TiKVLockResolverCounter, "write_conflict")
+	LockResolverCountWithQueryTxnStatus = counterWithLabels(TiKVLockResolverCounter, "query_txn_status")
+	LockResolverCountWithQueryTxnStatusCommitted = counterWithLabels(TiKVLockResolverCounter, "query_txn_status_committed")
+	LockResolverCountWithQueryTxnStatusRolledBack = counterWithLabels(TiKVLockResolverCounter, "query_txn_status_rolled_back")
+	LockResolverCountWithQueryCheckSecondaryLocks = counterWithLabels(TiKVLockResolverCounter, "query_check_secondary_locks")
+	LockResolverCountWithResolveLocks = counterWithLabels(TiKVLockResolverCounter, "query_resolve_locks")
+	LockResolverCountWithResolveLockLite = counterWithLabels(TiKVLockResolverCounter, "query_resolve_lock_lite")
+
+	RegionCacheCounterWithInvalidateRegionFromCacheOK = counterWithLabels(TiKVRegionCacheCounter, "invalidate_region_from_cache", "ok")
+	RegionCacheCounterWithSendFail = counterWithLabels(TiKVRegionCacheCounter, "send_fail", "ok")
+	RegionCacheCounterWithGetRegionByIDOK = counterWithLabels(TiKVRegionCacheCounter, "get_region_by_id", "ok")
+	RegionCacheCounterWithGetRegionByIDError = counterWithLabels(TiKVRegionCacheCounter, "get_region_by_id", "err")
+	RegionCacheCounterWithGetCacheMissOK = counterWithLabels(TiKVRegionCacheCounter, "get_region_when_miss", "ok")
+	RegionCacheCounterWithGetCacheMissError = counterWithLabels(TiKVRegionCacheCounter, "get_region_when_miss", "err")
+	RegionCacheCounterWithScanRegionsOK = counterWithLabels(TiKVRegionCacheCounter, "scan_regions", "ok")
+	RegionCacheCounterWithScanRegionsError = counterWithLabels(TiKVRegionCacheCounter, "scan_regions", "err")
+	RegionCacheCounterWithGetStoreOK = counterWithLabels(TiKVRegionCacheCounter, "get_store", "ok")
+	RegionCacheCounterWithGetStoreError = counterWithLabels(TiKVRegionCacheCounter, "get_store", "err")
+	RegionCacheCounterWithInvalidateStoreRegionsOK = counterWithLabels(TiKVRegionCacheCounter, "invalidate_store_regions", "ok")
+
+	LoadRegionCacheHistogramWhenCacheMiss = observerWithLabels(TiKVLoadRegionCacheHistogram, "get_region_when_miss")
+	LoadRegionCacheHistogramWithRegionByID = observerWithLabels(TiKVLoadRegionCacheHistogram, "get_region_by_id")
+	LoadRegionCacheHistogramWithRegions = observerWithLabels(TiKVLoadRegionCacheHistogram, "scan_regions")
+	LoadRegionCacheHistogramWithGetStore = observerWithLabels(TiKVLoadRegionCacheHistogram, "get_store")
+
+	TxnHeartBeatHistogramOK = observerWithLabels(TiKVTxnHeartBeatHistogram, "ok")
+	TxnHeartBeatHistogramError = observerWithLabels(TiKVTxnHeartBeatHistogram, "err")
+
+	StatusCountWithOK = counterWithLabels(TiKVStatusCounter, "ok")
+	StatusCountWithError = counterWithLabels(TiKVStatusCounter, "err")
+
+	SecondaryLockCleanupFailureCounterCommit = counterWithLabels(TiKVSecondaryLockCleanupFailureCounter, "commit")
+	SecondaryLockCleanupFailureCounterRollback = counterWithLabels(TiKVSecondaryLockCleanupFailureCounter, "rollback")
+
+	TwoPCTxnCounterOk = counterWithLabels(TiKVTwoPCTxnCounter, "ok")
+	TwoPCTxnCounterError = counterWithLabels(TiKVTwoPCTxnCounter, "err")
+
+	AsyncCommitTxnCounterOk = counterWithLabels(TiKVAsyncCommitTxnCounter, "ok")
+	AsyncCommitTxnCounterError = counterWithLabels(TiKVAsyncCommitTxnCounter, "err")
+
+	OnePCTxnCounterOk = counterWithLabels(TiKVOnePCTxnCounter, "ok")
+	OnePCTxnCounterError = counterWithLabels(TiKVOnePCTxnCounter, "err")
+	OnePCTxnCounterFallback = counterWithLabels(TiKVOnePCTxnCounter, "fallback")
+
+	BatchRecvHistogramOK = observerWithLabels(TiKVBatchRecvLatency, "ok")
+	BatchRecvHistogramError = observerWithLabels(TiKVBatchRecvLatency, "err")
+
+	PrewriteAssertionUsageCounterNone = counterWithLabels(TiKVPrewriteAssertionUsageCounter, "none")
+	PrewriteAssertionUsageCounterExist = counterWithLabels(TiKVPrewriteAssertionUsageCounter, "exist")
+	PrewriteAssertionUsageCounterNotExist = counterWithLabels(TiKVPrewriteAssertionUsageCounter, "not-exist")
+	PrewriteAssertionUsageCounterUnknown = counterWithLabels(TiKVPrewriteAssertionUsageCounter, "unknown")
 
 	// Counts new locks trying to acquire inside an aggressive locking stage.
-	AggressiveLockedKeysNew = TiKVAggressiveLockedKeysCounter.WithLabelValues("new")
+	AggressiveLockedKeysNew = counterWithLabels(TiKVAggressiveLockedKeysCounter, "new")
 	// Counts locks trying to acquire inside an aggressive locking stage, but it's already locked in the previous
 	// aggressive locking stage (before the latest invocation to `RetryAggressiveLocking`), in which case the lock
 	// can be *derived* from the previous stage and no RPC is needed for the key.
-	AggressiveLockedKeysDerived = TiKVAggressiveLockedKeysCounter.WithLabelValues("derived")
+	AggressiveLockedKeysDerived = counterWithLabels(TiKVAggressiveLockedKeysCounter, "derived")
 	// Counts locks that's forced acquired ignoring the WriteConflict.
-	AggressiveLockedKeysLockedWithConflict = TiKVAggressiveLockedKeysCounter.WithLabelValues("locked_with_conflict")
+	AggressiveLockedKeysLockedWithConflict = counterWithLabels(TiKVAggressiveLockedKeysCounter, "locked_with_conflict")
 	// Counts locks that's acquired within an aggressive locking stage, but with force-lock disabled (by passing
 	// `WakeUpMode = PessimisticLockWakeUpMode_WakeUpModeNormal`, which will disable `allow_lock_with_conflict` in
 	// TiKV).
-	AggressiveLockedKeysNonForceLock = TiKVAggressiveLockedKeysCounter.WithLabelValues("non_force_lock")
+	AggressiveLockedKeysNonForceLock = counterWithLabels(TiKVAggressiveLockedKeysCounter, "non_force_lock")
 
-	StaleReadHitCounter = TiKVStaleReadCounter.WithLabelValues("hit")
-	StaleReadMissCounter = TiKVStaleReadCounter.WithLabelValues("miss")
+	StaleReadHitCounter = counterWithLabels(TiKVStaleReadCounter, "hit")
+	StaleReadMissCounter = counterWithLabels(TiKVStaleReadCounter, "miss")
 
-	StaleReadReqLocalCounter = TiKVStaleReadReqCounter.WithLabelValues("local")
-	StaleReadReqCrossZoneCounter = TiKVStaleReadReqCounter.WithLabelValues("cross-zone")
+	StaleReadReqLocalCounter = counterWithLabels(TiKVStaleReadReqCounter, "local")
+	StaleReadReqCrossZoneCounter = counterWithLabels(TiKVStaleReadReqCounter, "cross-zone")
 
-	StaleReadLocalInBytes = TiKVStaleReadBytes.WithLabelValues("local", "in")
-	StaleReadLocalOutBytes = TiKVStaleReadBytes.WithLabelValues("local", "out")
-	StaleReadRemoteInBytes = TiKVStaleReadBytes.WithLabelValues("cross-zone", "in")
-	StaleReadRemoteOutBytes = TiKVStaleReadBytes.WithLabelValues("cross-zone", "out")
+	StaleReadLocalInBytes = counterWithLabels(TiKVStaleReadBytes, "local", "in")
+	StaleReadLocalOutBytes = counterWithLabels(TiKVStaleReadBytes, "local", "out")
+	StaleReadRemoteInBytes = counterWithLabels(TiKVStaleReadBytes, "cross-zone", "in")
+	StaleReadRemoteOutBytes = counterWithLabels(TiKVStaleReadBytes, "cross-zone", "out")
 }
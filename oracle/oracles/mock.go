@@ -45,16 +45,37 @@ import (
 
 var errStopped = errors.New("stopped")
 
-// MockOracle is a mock oracle for test.
+// MockOracle is a mock oracle for test. It's a supported testing API.
+//
+// By default it follows the wall clock (with an adjustable offset). Calling SetTS switches it to a manual clock
+// which only moves by SetTS and AdvanceTS, so that the returned timestamps are deterministic. It's also able to
+// return fixed timestamps for given txn scopes, inject errors and latency into TSO requests, and fix the
+// low-resolution timestamp instead of deriving it from the current timestamp.
 type MockOracle struct {
 	sync.RWMutex
 	stop   bool
 	offset time.Duration
 	lastTS uint64
 
+	// manual is true if the clock is controlled by SetTS and AdvanceTS.
+	manual    bool
+	currentTS uint64
+	scopeTS   map[string]uint64
+	err       error
+	// errTimes is the number of the TSO requests left to fail with err, err never expires if it's zero.
+	errTimes int
+	latency  time.Duration
+	// lowResTS is returned as the low-resolution timestamp if it's not zero.
+	lowResTS uint64
+
 	localExternalTimestamp
 }
 
+// NewMockOracle creates a MockOracle which follows the wall clock until SetTS is called.
+func NewMockOracle() *MockOracle {
+	return &MockOracle{}
+}
+
 // Enable enables the Oracle
 func (o *MockOracle) Enable() {
 	o.Lock()
@@ -77,16 +98,147 @@ func (o *MockOracle) AddOffset(d time.Duration) {
 	o.offset += d
 }
 
+// SetTS switches the oracle to the manual clock and sets the current timestamp. The next GetTimestamp returns
+// ts, and the following ones return ascending timestamps with the same physical time until the clock is moved.
+func (o *MockOracle) SetTS(ts uint64) {
+	o.Lock()
+	defer o.Unlock()
+
+	o.manual = true
+	o.currentTS = ts
+	o.lastTS = 0
+}
+
+// AdvanceTS moves the clock forward by d. If the oracle is not using the manual clock, it's the same as AddOffset.
+func (o *MockOracle) AdvanceTS(d time.Duration) {
+	o.Lock()
+	defer o.Unlock()
+
+	if !o.manual {
+		o.offset += d
+		return
+	}
+	o.currentTS = oracle.ComposeTS(oracle.ExtractPhysical(o.currentTS)+d.Milliseconds(), 0)
+	o.lastTS = 0
+}
+
+// SetScopeTS makes GetTimestamp return ts for the given txn scope, and the following ones return ascending
+// timestamps from it.
+func (o *MockOracle) SetScopeTS(txnScope string, ts uint64) {
+	o.Lock()
+	defer o.Unlock()
+
+	if o.scopeTS == nil {
+		o.scopeTS = make(map[string]uint64)
+	}
+	o.scopeTS[txnScope] = ts
+}
+
+// InjectError makes the TSO requests return err until it's called again with nil.
+func (o *MockOracle) InjectError(err error) {
+	o.Lock()
+	defer o.Unlock()
+
+	o.err, o.errTimes = err, 0
+}
+
+// InjectErrorTimes makes the next n TSO requests return err, the following ones succeed again.
+func (o *MockOracle) InjectErrorTimes(err error, n int) {
+	o.Lock()
+	defer o.Unlock()
+
+	if n <= 0 {
+		err = nil
+	}
+	o.err, o.errTimes = err, n
+}
+
+// takeErr returns the injected error and counts it as returned, the caller should hold the lock.
+func (o *MockOracle) takeErr() error {
+	err := o.err
+	if err != nil && o.errTimes > 0 {
+		if o.errTimes--; o.errTimes == 0 {
+			o.err = nil
+		}
+	}
+	return err
+}
+
+// SetLatency sets the latency of the TSO requests.
+func (o *MockOracle) SetLatency(d time.Duration) {
+	o.Lock()
+	defer o.Unlock()
+
+	o.latency = d
+}
+
+// SetLowResolutionTimestamp fixes the low-resolution timestamp to ts. Setting it to 0 makes the low-resolution
+// timestamp follow GetTimestamp again.
+func (o *MockOracle) SetLowResolutionTimestamp(ts uint64) {
+	o.Lock()
+	defer o.Unlock()
+
+	o.lowResTS = ts
+}
+
+// UpdateLowResolutionTimestamp simulates a tick of the low-resolution timestamp updater, it fixes the
+// low-resolution timestamp to a newly allocated timestamp and returns it.
+func (o *MockOracle) UpdateLowResolutionTimestamp(ctx context.Context) (uint64, error) {
+	ts, err := o.GetTimestamp(ctx, &oracle.Option{})
+	if err != nil {
+		return 0, err
+	}
+	o.SetLowResolutionTimestamp(ts)
+	return ts, nil
+}
+
+// now returns the current time of the oracle, the caller should hold the lock.
+func (o *MockOracle) now() time.Time {
+	if o.manual {
+		return oracle.GetTimeFromTS(o.currentTS)
+	}
+	return time.Now().Add(o.offset)
+}
+
+// wait simulates the latency of a TSO request, and returns the injected error if any.
+func (o *MockOracle) wait(ctx context.Context) error {
+	o.RLock()
+	latency := o.latency
+	o.RUnlock()
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		}
+	}
+	o.Lock()
+	defer o.Unlock()
+	return o.takeErr()
+}
+
 // GetTimestamp implements oracle.Oracle interface.
-func (o *MockOracle) GetTimestamp(ctx context.Context, _ *oracle.Option) (uint64, error) {
+func (o *MockOracle) GetTimestamp(ctx context.Context, opt *oracle.Option) (uint64, error) {
+	if err := o.wait(ctx); err != nil {
+		return 0, err
+	}
 	o.Lock()
 	defer o.Unlock()
 
 	if o.stop {
 		return 0, errors.WithStack(errStopped)
 	}
-	ts := oracle.GoTimeToTS(time.Now().Add(o.offset))
-	if oracle.ExtractPhysical(o.lastTS) == oracle.ExtractPhysical(ts) {
+	if opt != nil {
+		if ts, ok := o.scopeTS[opt.TxnScope]; ok {
+			o.scopeTS[opt.TxnScope] = ts + 1
+			return ts, nil
+		}
+	}
+	ts := oracle.GoTimeToTS(o.now())
+	if o.manual {
+		ts = o.currentTS
+	}
+	if oracle.ExtractPhysical(o.lastTS) == oracle.ExtractPhysical(ts) && o.lastTS >= ts {
 		ts = o.lastTS + 1
 	}
 	o.lastTS = ts
@@ -95,44 +247,76 @@ func (o *MockOracle) GetTimestamp(ctx context.Context, _ *oracle.Option) (uint64
 
 // GetAllTSOKeyspaceGroupMinTS implements oracle.Oracle interface.
 func (o *MockOracle) GetAllTSOKeyspaceGroupMinTS(ctx context.Context) (uint64, error) {
+	if err := o.wait(ctx); err != nil {
+		return 0, err
+	}
 	o.RLock()
 	defer o.RUnlock()
 
 	if o.stop {
 		return 0, errors.WithStack(errStopped)
 	}
-	return oracle.GoTimeToTS(time.Now().Add(o.offset)), nil
+	return oracle.GoTimeToTS(o.now()), nil
 }
 
 // GetStaleTimestamp implements oracle.Oracle interface.
 func (o *MockOracle) GetStaleTimestamp(ctx context.Context, txnScope string, prevSecond uint64) (ts uint64, err error) {
-	return oracle.GoTimeToTS(time.Now().Add(-time.Second * time.Duration(prevSecond))), nil
+	o.Lock()
+	defer o.Unlock()
+
+	if err := o.takeErr(); err != nil {
+		return 0, err
+	}
+	return oracle.GoTimeToTS(o.now().Add(-time.Second * time.Duration(prevSecond))), nil
 }
 
 type mockOracleFuture struct {
 	o   *MockOracle
 	ctx context.Context
+	opt *oracle.Option
 }
 
 func (m *mockOracleFuture) Wait() (uint64, error) {
-	return m.o.GetTimestamp(m.ctx, &oracle.Option{})
+	return m.o.GetTimestamp(m.ctx, m.opt)
 }
 
 // GetTimestampAsync implements oracle.Oracle interface.
-func (o *MockOracle) GetTimestampAsync(ctx context.Context, _ *oracle.Option) oracle.Future {
-	return &mockOracleFuture{o, ctx}
+func (o *MockOracle) GetTimestampAsync(ctx context.Context, opt *oracle.Option) oracle.Future {
+	return &mockOracleFuture{o, ctx, opt}
 }
 
 // GetLowResolutionTimestamp implements oracle.Oracle interface.
 func (o *MockOracle) GetLowResolutionTimestamp(ctx context.Context, opt *oracle.Option) (uint64, error) {
+	o.Lock()
+	if lowResTS := o.lowResTS; lowResTS != 0 {
+		err := o.takeErr()
+		o.Unlock()
+		if err != nil {
+			return 0, err
+		}
+		return lowResTS, nil
+	}
+	o.Unlock()
 	return o.GetTimestamp(ctx, opt)
 }
 
+type mockOracleLowResFuture struct {
+	o   *MockOracle
+	ctx context.Context
+	opt *oracle.Option
+}
+
+func (m *mockOracleLowResFuture) Wait() (uint64, error) {
+	return m.o.GetLowResolutionTimestamp(m.ctx, m.opt)
+}
+
 // GetLowResolutionTimestampAsync implements oracle.Oracle interface.
 func (o *MockOracle) GetLowResolutionTimestampAsync(ctx context.Context, opt *oracle.Option) oracle.Future {
-	return o.GetTimestampAsync(ctx, opt)
+	return &mockOracleLowResFuture{o, ctx, opt}
 }
 
+// SetLowResolutionTimestampUpdateInterval implements oracle.Oracle interface. The low-resolution timestamp of
+// MockOracle is not updated in background, use UpdateLowResolutionTimestamp to update it instead.
 func (o *MockOracle) SetLowResolutionTimestampUpdateInterval(time.Duration) error {
 	return nil
 }
@@ -142,7 +326,7 @@ func (o *MockOracle) IsExpired(lockTimestamp, TTL uint64, _ *oracle.Option) bool
 	o.RLock()
 	defer o.RUnlock()
 	expire := oracle.GetTimeFromTS(lockTimestamp).Add(time.Duration(TTL) * time.Millisecond)
-	return !time.Now().Add(o.offset).Before(expire)
+	return !o.now().Before(expire)
 }
 
 // UntilExpired implement oracle.Oracle interface.
@@ -150,7 +334,7 @@ func (o *MockOracle) UntilExpired(lockTimeStamp, TTL uint64, _ *oracle.Option) i
 	o.RLock()
 	defer o.RUnlock()
 	expire := oracle.GetTimeFromTS(lockTimeStamp).Add(time.Duration(TTL) * time.Millisecond)
-	return expire.Sub(time.Now().Add(o.offset)).Milliseconds()
+	return expire.Sub(o.now()).Milliseconds()
 }
 
 // Close implements oracle.Oracle interface.
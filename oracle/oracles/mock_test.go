@@ -0,0 +1,159 @@
+// Copyright 2024 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oracles_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tikv/client-go/v2/oracle"
+	"github.com/tikv/client-go/v2/oracle/oracles"
+)
+
+func TestMockOracleManualClock(t *testing.T) {
+	ctx := context.Background()
+	o := oracles.NewMockOracle()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	startTS := oracle.GoTimeToTS(start)
+	o.SetTS(startTS)
+
+	ts, err := o.GetTimestamp(ctx, &oracle.Option{})
+	assert.Nil(t, err)
+	assert.Equal(t, startTS, ts)
+	ts, err = o.GetTimestamp(ctx, &oracle.Option{})
+	assert.Nil(t, err)
+	assert.Equal(t, startTS+1, ts)
+	ts, err = o.GetTimestampAsync(ctx, &oracle.Option{}).Wait()
+	assert.Nil(t, err)
+	assert.Equal(t, startTS+2, ts)
+
+	o.AdvanceTS(time.Second)
+	ts, err = o.GetTimestamp(ctx, &oracle.Option{})
+	assert.Nil(t, err)
+	assert.Equal(t, oracle.GoTimeToTS(start.Add(time.Second)), ts)
+
+	lockTS := oracle.GoTimeToTS(start)
+	assert.False(t, o.IsExpired(lockTS, 2000, &oracle.Option{}))
+	assert.Equal(t, int64(1000), o.UntilExpired(lockTS, 2000, &oracle.Option{}))
+	o.AdvanceTS(time.Second)
+	assert.True(t, o.IsExpired(lockTS, 2000, &oracle.Option{}))
+
+	o.SetScopeTS("dc-1", 100)
+	ts, err = o.GetTimestamp(ctx, &oracle.Option{TxnScope: "dc-1"})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), ts)
+	ts, err = o.GetTimestamp(ctx, &oracle.Option{TxnScope: "dc-1"})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(101), ts)
+	ts, err = o.GetTimestamp(ctx, &oracle.Option{TxnScope: oracle.GlobalTxnScope})
+	assert.Nil(t, err)
+	assert.Equal(t, oracle.GoTimeToTS(start.Add(2*time.Second)), ts)
+}
+
+func TestMockOracleStaleTimestamp(t *testing.T) {
+	ctx := context.Background()
+	o := oracles.NewMockOracle()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	o.SetTS(oracle.GoTimeToTS(now))
+
+	ts, err := o.GetStaleTimestamp(ctx, oracle.GlobalTxnScope, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, oracle.GoTimeToTS(now.Add(-10*time.Second)), ts)
+
+	o.AdvanceTS(5 * time.Second)
+	ts, err = o.GetStaleTimestamp(ctx, oracle.GlobalTxnScope, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, oracle.GoTimeToTS(now.Add(-5*time.Second)), ts)
+}
+
+func TestMockOracleLowResolutionTimestamp(t *testing.T) {
+	ctx := context.Background()
+	o := oracles.NewMockOracle()
+	o.SetTS(oracle.ComposeTS(1000, 0))
+
+	// the low-resolution timestamp follows GetTimestamp by default.
+	ts, err := o.GetLowResolutionTimestamp(ctx, &oracle.Option{})
+	assert.Nil(t, err)
+	assert.Equal(t, oracle.ComposeTS(1000, 0), ts)
+
+	ts, err = o.UpdateLowResolutionTimestamp(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, oracle.ComposeTS(1000, 1), ts)
+	o.AdvanceTS(time.Second)
+	for i := 0; i < 3; i++ {
+		ts, err = o.GetLowResolutionTimestampAsync(ctx, &oracle.Option{}).Wait()
+		assert.Nil(t, err)
+		assert.Equal(t, oracle.ComposeTS(1000, 1), ts)
+	}
+	ts, err = o.UpdateLowResolutionTimestamp(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, oracle.ComposeTS(2000, 0), ts)
+
+	o.SetLowResolutionTimestamp(oracle.ComposeTS(500, 0))
+	ts, err = o.GetLowResolutionTimestamp(ctx, &oracle.Option{})
+	assert.Nil(t, err)
+	assert.Equal(t, oracle.ComposeTS(500, 0), ts)
+	o.SetLowResolutionTimestamp(0)
+	ts, err = o.GetLowResolutionTimestamp(ctx, &oracle.Option{})
+	assert.Nil(t, err)
+	assert.Equal(t, oracle.ComposeTS(2000, 1), ts)
+}
+
+func TestMockOracleInjection(t *testing.T) {
+	ctx := context.Background()
+	o := oracles.NewMockOracle()
+	o.SetTS(oracle.ComposeTS(1000, 0))
+
+	injected := errors.New("injected")
+	o.InjectError(injected)
+	_, err := o.GetTimestamp(ctx, &oracle.Option{})
+	assert.Equal(t, injected, err)
+	_, err = o.GetLowResolutionTimestamp(ctx, &oracle.Option{})
+	assert.Equal(t, injected, err)
+	_, err = o.GetStaleTimestamp(ctx, oracle.GlobalTxnScope, 1)
+	assert.Equal(t, injected, err)
+	o.InjectError(nil)
+	ts, err := o.GetTimestamp(ctx, &oracle.Option{})
+	assert.Nil(t, err)
+	assert.Equal(t, oracle.ComposeTS(1000, 0), ts)
+
+	// the error injected by InjectErrorTimes expires after n requests.
+	o.InjectErrorTimes(injected, 2)
+	_, err = o.GetTimestamp(ctx, &oracle.Option{})
+	assert.Equal(t, injected, err)
+	_, err = o.GetTimestampAsync(ctx, &oracle.Option{}).Wait()
+	assert.Equal(t, injected, err)
+	ts, err = o.GetTimestamp(ctx, &oracle.Option{})
+	assert.Nil(t, err)
+	assert.Equal(t, oracle.ComposeTS(1000, 1), ts)
+	o.InjectErrorTimes(injected, 0)
+	_, err = o.GetTimestamp(ctx, &oracle.Option{})
+	assert.Nil(t, err)
+
+	o.SetLatency(50 * time.Millisecond)
+	start := time.Now()
+	_, err = o.GetTimestamp(ctx, &oracle.Option{})
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	o.SetLatency(time.Hour)
+	cancelCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = o.GetTimestamp(cancelCtx, &oracle.Option{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
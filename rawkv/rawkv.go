@@ -37,6 +37,7 @@ package rawkv
 import (
 	"bytes"
 	"context"
+	"sync/atomic"
 	"time"
 
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
@@ -76,6 +77,42 @@ type rawOptions struct {
 	KeyOnly bool
 }
 
+// GetOptions is the options for GetOpt.
+type GetOptions struct {
+	// ColumnFamily is the column family to read from.
+	// If it is empty, the column family of the client is used.
+	ColumnFamily string
+}
+
+// PutOptions is the options for PutOpt.
+type PutOptions struct {
+	// ColumnFamily is the column family to write to.
+	// If it is empty, the column family of the client is used.
+	ColumnFamily string
+	// TTL is the time-to-live of the key in seconds, 0 means the key never expires.
+	TTL uint64
+}
+
+// DeleteOptions is the options for DeleteOpt.
+type DeleteOptions struct {
+	// ColumnFamily is the column family to delete from.
+	// If it is empty, the column family of the client is used.
+	ColumnFamily string
+}
+
+// ScanOptions is the options for ScanOpt.
+type ScanOptions struct {
+	// ColumnFamily is the column family to scan.
+	// If it is empty, the column family of the client is used.
+	ColumnFamily string
+	// Limit is the max number of pairs to return, it must not exceed MaxRawKVScanLimit.
+	Limit int
+	// KeyOnly tells the scanner to only return keys and omit the values.
+	KeyOnly bool
+	// Reverse scans from the upper bound to the lower bound, see ReverseScan for the meaning of the keys.
+	Reverse bool
+}
+
 // RawChecksum represents the checksum result of raw kv pairs in TiKV cluster.
 type RawChecksum struct {
 	// Crc64Xor is the checksum result with crc64 algorithm
@@ -128,6 +165,7 @@ type Client struct {
 	rpcClient   client.Client
 	cf          string
 	atomic      bool
+	closed      atomic.Bool
 }
 
 type option struct {
@@ -242,8 +280,9 @@ func NewClientWithOpts(ctx context.Context, pdAddrs []string, opts ...ClientOpt)
 	}, nil
 }
 
-// Close closes the client.
+// Close closes the client. The requests sent after it, or in flight when it's closed, fail with ErrClientClosed.
 func (c *Client) Close() error {
+	c.closed.Store(true)
 	if c.pdClient != nil {
 		c.pdClient.Close()
 	}
@@ -263,15 +302,20 @@ func (c *Client) ClusterID() uint64 {
 
 // Get queries value with the key. When the key does not exist, it returns `nil, nil`.
 func (c *Client) Get(ctx context.Context, key []byte, options ...RawOption) ([]byte, error) {
+	opts := c.getRawKVOptions(options...)
+	return c.GetOpt(ctx, key, GetOptions{ColumnFamily: opts.ColumnFamily})
+}
+
+// GetOpt queries value with the key and the given options. When the key does not exist, it returns `nil, nil`.
+func (c *Client) GetOpt(ctx context.Context, key []byte, opts GetOptions) ([]byte, error) {
 	start := time.Now()
 	defer func() { metrics.RawkvCmdHistogramWithGet.Observe(time.Since(start).Seconds()) }()
 
-	opts := c.getRawKVOptions(options...)
 	req := tikvrpc.NewRequest(
 		tikvrpc.CmdRawGet,
 		&kvrpcpb.RawGetRequest{
 			Key: key,
-			Cf:  c.getColumnFamily(opts),
+			Cf:  c.resolveColumnFamily(opts.ColumnFamily),
 		})
 	resp, _, err := c.sendReq(ctx, key, req, false)
 	if err != nil {
@@ -329,17 +373,22 @@ func (c *Client) BatchGet(ctx context.Context, keys [][]byte, options ...RawOpti
 
 // PutWithTTL stores a key-value pair to TiKV with a time-to-live duration.
 func (c *Client) PutWithTTL(ctx context.Context, key, value []byte, ttl uint64, options ...RawOption) error {
+	opts := c.getRawKVOptions(options...)
+	return c.PutOpt(ctx, key, value, PutOptions{ColumnFamily: opts.ColumnFamily, TTL: ttl})
+}
+
+// PutOpt stores a key-value pair to TiKV with the given options.
+func (c *Client) PutOpt(ctx context.Context, key, value []byte, opts PutOptions) error {
 	start := time.Now()
 	defer func() { metrics.RawkvCmdHistogramWithBatchPut.Observe(time.Since(start).Seconds()) }()
 	metrics.RawkvSizeHistogramWithKey.Observe(float64(len(key)))
 	metrics.RawkvSizeHistogramWithValue.Observe(float64(len(value)))
 
-	opts := c.getRawKVOptions(options...)
 	req := tikvrpc.NewRequest(tikvrpc.CmdRawPut, &kvrpcpb.RawPutRequest{
 		Key:    key,
 		Value:  value,
-		Ttl:    ttl,
-		Cf:     c.getColumnFamily(opts),
+		Ttl:    opts.TTL,
+		Cf:     c.resolveColumnFamily(opts.ColumnFamily),
 		ForCas: c.atomic,
 	})
 	resp, _, err := c.sendReq(ctx, key, req, false)
@@ -424,13 +473,18 @@ func (c *Client) BatchPutWithTTL(ctx context.Context, keys, values [][]byte, ttl
 
 // Delete deletes a key-value pair from TiKV.
 func (c *Client) Delete(ctx context.Context, key []byte, options ...RawOption) error {
+	opts := c.getRawKVOptions(options...)
+	return c.DeleteOpt(ctx, key, DeleteOptions{ColumnFamily: opts.ColumnFamily})
+}
+
+// DeleteOpt deletes a key-value pair from TiKV with the given options.
+func (c *Client) DeleteOpt(ctx context.Context, key []byte, opts DeleteOptions) error {
 	start := time.Now()
 	defer func() { metrics.RawkvCmdHistogramWithDelete.Observe(time.Since(start).Seconds()) }()
 
-	opts := c.getRawKVOptions(options...)
 	req := tikvrpc.NewRequest(tikvrpc.CmdRawDelete, &kvrpcpb.RawDeleteRequest{
 		Key:    key,
-		Cf:     c.getColumnFamily(opts),
+		Cf:     c.resolveColumnFamily(opts.ColumnFamily),
 		ForCas: c.atomic,
 	})
 	req.MaxExecutionDurationMs = uint64(client.MaxWriteExecutionTime.Milliseconds())
@@ -513,22 +567,57 @@ func (c *Client) DeleteRange(ctx context.Context, startKey []byte, endKey []byte
 // `Scan(ctx, push(startKey, '\0'), push(endKey, '\0'), limit)`.
 func (c *Client) Scan(ctx context.Context, startKey, endKey []byte, limit int, options ...RawOption,
 ) (keys [][]byte, values [][]byte, err error) {
+	opts := c.getRawKVOptions(options...)
+	return c.ScanOpt(ctx, startKey, endKey, ScanOptions{
+		ColumnFamily: opts.ColumnFamily,
+		Limit:        limit,
+		KeyOnly:      opts.KeyOnly,
+	})
+}
+
+// ReverseScan queries continuous kv pairs in range [endKey, startKey),
+// from startKey(upperBound) to endKey(lowerBound), up to limit pairs.
+// The returned keys are in reversed lexicographical order.
+// If endKey is empty, it means unbounded.
+// If you want to include the startKey or exclude the endKey, push a '\0' to the key. For example, to scan
+// (endKey, startKey], you can write:
+// `ReverseScan(ctx, push(startKey, '\0'), push(endKey, '\0'), limit)`.
+// It doesn't support Scanning from "", because locating the last Region is not yet implemented.
+func (c *Client) ReverseScan(ctx context.Context, startKey, endKey []byte, limit int, options ...RawOption) (keys [][]byte, values [][]byte, err error) {
+	opts := c.getRawKVOptions(options...)
+	return c.ScanOpt(ctx, startKey, endKey, ScanOptions{
+		ColumnFamily: opts.ColumnFamily,
+		Limit:        limit,
+		KeyOnly:      opts.KeyOnly,
+		Reverse:      true,
+	})
+}
+
+// ScanOpt queries continuous kv pairs with the given options.
+// If opts.Reverse is false, it behaves like Scan, otherwise it behaves like ReverseScan.
+func (c *Client) ScanOpt(ctx context.Context, startKey, endKey []byte, opts ScanOptions) (keys [][]byte, values [][]byte, err error) {
+	if opts.Reverse {
+		return c.reverseScan(ctx, startKey, endKey, opts)
+	}
+	return c.scan(ctx, startKey, endKey, opts)
+}
+
+func (c *Client) scan(ctx context.Context, startKey, endKey []byte, opts ScanOptions) (keys [][]byte, values [][]byte, err error) {
 	start := time.Now()
 	defer func() { metrics.RawkvCmdHistogramWithRawScan.Observe(time.Since(start).Seconds()) }()
 
+	limit := opts.Limit
 	if limit > MaxRawKVScanLimit {
 		return nil, nil, errors.WithStack(ErrMaxScanLimitExceeded)
 	}
 
-	opts := c.getRawKVOptions(options...)
-
 	for len(keys) < limit && (len(endKey) == 0 || bytes.Compare(startKey, endKey) < 0) {
 		req := tikvrpc.NewRequest(tikvrpc.CmdRawScan, &kvrpcpb.RawScanRequest{
 			StartKey: startKey,
 			EndKey:   endKey,
 			Limit:    uint32(limit - len(keys)),
 			KeyOnly:  opts.KeyOnly,
-			Cf:       c.getColumnFamily(opts),
+			Cf:       c.resolveColumnFamily(opts.ColumnFamily),
 		})
 		resp, loc, err := c.sendReq(ctx, startKey, req, false)
 		if err != nil {
@@ -550,26 +639,17 @@ func (c *Client) Scan(ctx context.Context, startKey, endKey []byte, limit int, o
 	return
 }
 
-// ReverseScan queries continuous kv pairs in range [endKey, startKey),
-// from startKey(upperBound) to endKey(lowerBound), up to limit pairs.
-// The returned keys are in reversed lexicographical order.
-// If endKey is empty, it means unbounded.
-// If you want to include the startKey or exclude the endKey, push a '\0' to the key. For example, to scan
-// (endKey, startKey], you can write:
-// `ReverseScan(ctx, push(startKey, '\0'), push(endKey, '\0'), limit)`.
-// It doesn't support Scanning from "", because locating the last Region is not yet implemented.
-func (c *Client) ReverseScan(ctx context.Context, startKey, endKey []byte, limit int, options ...RawOption) (keys [][]byte, values [][]byte, err error) {
+func (c *Client) reverseScan(ctx context.Context, startKey, endKey []byte, opts ScanOptions) (keys [][]byte, values [][]byte, err error) {
 	start := time.Now()
 	defer func() {
 		metrics.RawkvCmdHistogramWithRawReversScan.Observe(time.Since(start).Seconds())
 	}()
 
+	limit := opts.Limit
 	if limit > MaxRawKVScanLimit {
 		return nil, nil, errors.WithStack(ErrMaxScanLimitExceeded)
 	}
 
-	opts := c.getRawKVOptions(options...)
-
 	for len(keys) < limit && bytes.Compare(startKey, endKey) > 0 {
 		req := tikvrpc.NewRequest(tikvrpc.CmdRawScan, &kvrpcpb.RawScanRequest{
 			StartKey: startKey,
@@ -577,7 +657,7 @@ func (c *Client) ReverseScan(ctx context.Context, startKey, endKey []byte, limit
 			Limit:    uint32(limit - len(keys)),
 			Reverse:  true,
 			KeyOnly:  opts.KeyOnly,
-			Cf:       c.getColumnFamily(opts),
+			Cf:       c.resolveColumnFamily(opts.ColumnFamily),
 		})
 		resp, loc, err := c.sendReq(ctx, startKey, req, true)
 		if err != nil {
@@ -685,7 +765,24 @@ func (c *Client) CompareAndSwap(ctx context.Context, key, previousValue, newValu
 	return convertNilToEmptySlice(cmdResp.PreviousValue), cmdResp.Succeed, nil
 }
 
-func (c *Client) sendReq(ctx context.Context, key []byte, req *tikvrpc.Request, reverse bool) (*tikvrpc.Response, *locate.KeyLocation, error) {
+// closedErr returns ErrClientClosed if the client is closed, so that the requests in flight when the client is
+// closed fail with the same error as the ones sent after it. Otherwise err is returned as is.
+func (c *Client) closedErr(err error) error {
+	if c.closed.Load() {
+		return errors.WithStack(tikverr.ErrClientClosed)
+	}
+	return err
+}
+
+func (c *Client) sendReq(ctx context.Context, key []byte, req *tikvrpc.Request, reverse bool) (resp *tikvrpc.Response, loc *locate.KeyLocation, err error) {
+	if err := c.closedErr(nil); err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			resp, loc, err = nil, nil, c.closedErr(err)
+		}
+	}()
 	bo := retry.NewBackofferWithVars(ctx, rawkvMaxBackoff, nil)
 	sender := locate.NewRegionRequestSender(c.regionCache, c.rpcClient)
 	for {
@@ -719,9 +816,12 @@ func (c *Client) sendReq(ctx context.Context, key []byte, req *tikvrpc.Request,
 }
 
 func (c *Client) sendBatchReq(bo *retry.Backoffer, keys [][]byte, options *rawOptions, cmdType tikvrpc.CmdType) (*tikvrpc.Response, error) { // split the keys
+	if err := c.closedErr(nil); err != nil {
+		return nil, err
+	}
 	groups, _, err := c.regionCache.GroupKeysByRegion(bo, keys, nil)
 	if err != nil {
-		return nil, err
+		return nil, c.closedErr(err)
 	}
 
 	var batches []kvrpc.Batch
@@ -764,8 +864,9 @@ func (c *Client) sendBatchReq(bo *retry.Backoffer, keys [][]byte, options *rawOp
 
 	if firstError == nil {
 		cancel()
+		return resp, nil
 	}
-	return resp, firstError
+	return resp, c.closedErr(firstError)
 }
 
 func (c *Client) doBatchReq(bo *retry.Backoffer, batch kvrpc.Batch, options *rawOptions, cmdType tikvrpc.CmdType) kvrpc.BatchResult {
@@ -832,7 +933,15 @@ func (c *Client) doBatchReq(bo *retry.Backoffer, batch kvrpc.Batch, options *raw
 // If the given range spans over more than one regions, the actual endKey is the end of the first region.
 // We can't use sendReq directly, because we need to know the end of the region before we send the request
 // TODO: Is there any better way to avoid duplicating code with func `sendReq` ?
-func (c *Client) sendDeleteRangeReq(ctx context.Context, startKey []byte, endKey []byte, opts *rawOptions) (*tikvrpc.Response, []byte, error) {
+func (c *Client) sendDeleteRangeReq(ctx context.Context, startKey []byte, endKey []byte, opts *rawOptions) (resp *tikvrpc.Response, actualEndKey []byte, err error) {
+	if err := c.closedErr(nil); err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			resp, actualEndKey, err = nil, nil, c.closedErr(err)
+		}
+	}()
 	bo := retry.NewBackofferWithVars(ctx, rawkvMaxBackoff, nil)
 	sender := locate.NewRegionRequestSender(c.regionCache, c.rpcClient)
 	for {
@@ -873,6 +982,9 @@ func (c *Client) sendDeleteRangeReq(ctx context.Context, startKey []byte, endKey
 }
 
 func (c *Client) sendBatchPut(bo *retry.Backoffer, keys, values [][]byte, ttls []uint64, opts *rawOptions) error {
+	if err := c.closedErr(nil); err != nil {
+		return err
+	}
 	keyToValue := make(map[string][]byte, len(keys))
 	keyToTTL := make(map[string]uint64, len(keys))
 	for i, key := range keys {
@@ -883,7 +995,7 @@ func (c *Client) sendBatchPut(bo *retry.Backoffer, keys, values [][]byte, ttls [
 	}
 	groups, _, err := c.regionCache.GroupKeysByRegion(bo, keys, nil)
 	if err != nil {
-		return err
+		return c.closedErr(err)
 	}
 	var batches []kvrpc.Batch
 	// split the keys by size and RegionVerID
@@ -913,8 +1025,9 @@ func (c *Client) sendBatchPut(bo *retry.Backoffer, keys, values [][]byte, ttls [
 
 	if err == nil {
 		cancel()
+		return nil
 	}
-	return err
+	return c.closedErr(err)
 }
 
 func (c *Client) doBatchPut(bo *retry.Backoffer, batch kvrpc.Batch, opts *rawOptions) error {
@@ -967,10 +1080,14 @@ func (c *Client) doBatchPut(bo *retry.Backoffer, batch kvrpc.Batch, opts *rawOpt
 }
 
 func (c *Client) getColumnFamily(options *rawOptions) string {
-	if options.ColumnFamily == "" {
+	return c.resolveColumnFamily(options.ColumnFamily)
+}
+
+func (c *Client) resolveColumnFamily(cf string) string {
+	if cf == "" {
 		return c.cf
 	}
-	return options.ColumnFamily
+	return cf
 }
 
 func (c *Client) getRawKVOptions(options ...RawOption) *rawOptions {
@@ -39,11 +39,14 @@ import (
 	"context"
 	"fmt"
 	"hash/crc64"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/pingcap/failpoint"
 	"github.com/stretchr/testify/suite"
 	"github.com/tikv/client-go/v2/config/retry"
+	tikverr "github.com/tikv/client-go/v2/error"
 	"github.com/tikv/client-go/v2/internal/locate"
 	"github.com/tikv/client-go/v2/internal/mockstore/mocktikv"
 	"github.com/tikv/client-go/v2/kv"
@@ -485,6 +488,90 @@ func (s *testRawkvSuite) TestScan() {
 	s.True(bytes.Equal(returnKeys[2], []byte("db")))
 }
 
+func (s *testRawkvSuite) TestOptionsStructs() {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	defer mvccStore.Close()
+
+	client := &Client{
+		clusterID:   0,
+		regionCache: locate.NewRegionCache(mocktikv.NewPDClient(s.cluster)),
+		rpcClient:   mocktikv.NewRPCClient(s.cluster, mvccStore, nil),
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	for _, clientCf := range []string{"", "client_cf"} {
+		for _, optCf := range []string{"", "opt_cf"} {
+			client.SetColumnFamily(clientCf)
+			var rawOpts []RawOption
+			if optCf != "" {
+				rawOpts = append(rawOpts, SetColumnFamily(optCf))
+			}
+			otherCf := "other_cf"
+			keys := [][]byte{[]byte("opt_a"), []byte("opt_b"), []byte("opt_c")}
+
+			// put by options struct, read by both variants.
+			for _, k := range keys {
+				s.Nil(client.PutOpt(ctx, k, k, PutOptions{ColumnFamily: optCf}))
+			}
+			for _, k := range keys {
+				v, err := client.GetOpt(ctx, k, GetOptions{ColumnFamily: optCf})
+				s.Nil(err)
+				s.Equal(k, v)
+				v, err = client.Get(ctx, k, rawOpts...)
+				s.Nil(err)
+				s.Equal(k, v)
+				v, err = client.GetOpt(ctx, k, GetOptions{ColumnFamily: otherCf})
+				s.Nil(err)
+				s.Nil(v)
+			}
+
+			// scan and reverse scan should be same as the variadic variants.
+			for _, keyOnly := range []bool{false, true} {
+				scanOpts := rawOpts
+				if keyOnly {
+					scanOpts = append(append([]RawOption{}, rawOpts...), ScanKeyOnly())
+				}
+				keys1, values1, err := client.ScanOpt(ctx, []byte("opt_"), []byte("opt_z"), ScanOptions{ColumnFamily: optCf, Limit: 2, KeyOnly: keyOnly})
+				s.Nil(err)
+				keys2, values2, err := client.Scan(ctx, []byte("opt_"), []byte("opt_z"), 2, scanOpts...)
+				s.Nil(err)
+				s.Equal(keys[:2], keys1)
+				s.Equal(keys1, keys2)
+				s.Equal(values1, values2)
+				if !keyOnly {
+					s.Equal(keys[:2], values1)
+				}
+
+				keys1, values1, err = client.ScanOpt(ctx, []byte("opt_z"), []byte("opt_"), ScanOptions{ColumnFamily: optCf, Limit: 10, KeyOnly: keyOnly, Reverse: true})
+				s.Nil(err)
+				keys2, values2, err = client.ReverseScan(ctx, []byte("opt_z"), []byte("opt_"), 10, scanOpts...)
+				s.Nil(err)
+				s.Equal([][]byte{keys[2], keys[1], keys[0]}, keys1)
+				s.Equal(keys1, keys2)
+				s.Equal(values1, values2)
+			}
+			_, _, err := client.ScanOpt(ctx, []byte("opt_"), nil, ScanOptions{ColumnFamily: optCf, Limit: MaxRawKVScanLimit + 1})
+			s.NotNil(err)
+
+			// delete by both variants.
+			s.Nil(client.DeleteOpt(ctx, keys[0], DeleteOptions{ColumnFamily: optCf}))
+			s.Nil(client.Delete(ctx, keys[1], rawOpts...))
+			s.Nil(client.DeleteOpt(ctx, keys[2], DeleteOptions{ColumnFamily: otherCf}))
+			for i, k := range keys {
+				v, err := client.GetOpt(ctx, k, GetOptions{ColumnFamily: optCf})
+				s.Nil(err)
+				if i < 2 {
+					s.Nil(v)
+				} else {
+					s.Equal(k, v)
+				}
+			}
+			s.Nil(client.DeleteOpt(ctx, keys[2], DeleteOptions{ColumnFamily: optCf}))
+		}
+	}
+}
+
 func (s *testRawkvSuite) TestDeleteRange() {
 	mvccStore := mocktikv.MustNewMVCCStore()
 	defer mvccStore.Close()
@@ -657,3 +744,52 @@ func (s *testRawkvSuite) TestRawChecksum() {
 	s.Equal(expectTotalKvs, check.TotalKvs)
 	s.Equal(expectTotalBytes, check.TotalBytes)
 }
+
+func (s *testRawkvSuite) TestClosedClient() {
+	mvccStore := mocktikv.MustNewMVCCStore()
+	defer mvccStore.Close()
+
+	client := &Client{
+		clusterID:   0,
+		regionCache: locate.NewRegionCache(mocktikv.NewPDClient(s.cluster)),
+		rpcClient:   mocktikv.NewRPCClient(s.cluster, mvccStore, nil),
+	}
+	ctx := context.Background()
+	s.Nil(client.Put(ctx, []byte("k"), []byte("v")))
+
+	// the requests in flight when the client is closed either complete or fail with ErrClientClosed.
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			for err == nil {
+				if i%2 == 0 {
+					_, err = client.Get(ctx, []byte("k"))
+				} else {
+					err = client.BatchPut(ctx, [][]byte{{'k', byte(i)}}, [][]byte{[]byte("v")})
+				}
+			}
+			errs[i] = err
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	s.Nil(client.Close())
+	wg.Wait()
+	for _, err := range errs {
+		s.ErrorIs(err, tikverr.ErrClientClosed)
+	}
+
+	// the requests after the client is closed fail with ErrClientClosed.
+	_, err := client.Get(ctx, []byte("k"))
+	s.ErrorIs(err, tikverr.ErrClientClosed)
+	_, err = client.BatchGet(ctx, [][]byte{[]byte("k")})
+	s.ErrorIs(err, tikverr.ErrClientClosed)
+	s.ErrorIs(client.Put(ctx, []byte("k"), []byte("v")), tikverr.ErrClientClosed)
+	s.ErrorIs(client.BatchDelete(ctx, [][]byte{[]byte("k")}), tikverr.ErrClientClosed)
+	s.ErrorIs(client.DeleteRange(ctx, []byte("a"), []byte("z")), tikverr.ErrClientClosed)
+	_, _, err = client.Scan(ctx, []byte("a"), []byte("z"), 10)
+	s.ErrorIs(err, tikverr.ErrClientClosed)
+}
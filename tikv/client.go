@@ -35,6 +35,8 @@
 package tikv
 
 import (
+	"crypto/tls"
+
 	"github.com/tikv/client-go/v2/config"
 	"github.com/tikv/client-go/v2/internal/apicodec"
 	"github.com/tikv/client-go/v2/internal/client"
@@ -55,6 +57,11 @@ func WithSecurity(security config.Security) ClientOpt {
 	return client.WithSecurity(security)
 }
 
+// WithTLSConfig is used to set the TLS config, which overrides the one derived from the security config.
+func WithTLSConfig(tlsConfig *tls.Config) ClientOpt {
+	return client.WithTLSConfig(tlsConfig)
+}
+
 // WithCodec is used to set client codec.
 func WithCodec(codec apicodec.Codec) ClientOpt {
 	return client.WithCodec(codec)
@@ -119,6 +119,11 @@ type KVStore struct {
 	lockResolver *txnlock.LockResolver
 	txnLatches   *latch.LatchesScheduler
 
+	// oracleUpdateInterval is set by WithUpdateInterval, zero means the default interval.
+	oracleUpdateInterval time.Duration
+	// allowEmptyValues is set by WithAllowEmptyValues.
+	allowEmptyValues bool
+
 	mock bool
 
 	kv        SafePointKV
@@ -197,10 +202,33 @@ func WithPool(gp Pool) Option {
 // PD and less staleness on reads, and vice versa.
 func WithUpdateInterval(updateInterval time.Duration) Option {
 	return func(o *KVStore) {
-		err := o.oracle.SetLowResolutionTimestampUpdateInterval(updateInterval)
-		if err != nil {
-			panic(err)
-		}
+		o.oracleUpdateInterval = updateInterval
+	}
+}
+
+// WithOracle makes the store use the given oracle instead of the PD-backed one, which is useful to control the
+// timestamps in tests, e.g. with oracles.MockOracle, or to use an external TSO service. The PD-backed oracle is
+// not created then, and the given oracle is closed when the store is closed.
+func WithOracle(o oracle.Oracle) Option {
+	return func(store *KVStore) {
+		store.oracle = o
+	}
+}
+
+// WithAllowEmptyValues makes the transactions and snapshots of the store distinguish zero-length values from
+// nonexistent keys, see KVSnapshot.SetAllowEmptyValues. By default an empty value is read as a nonexistent key.
+func WithAllowEmptyValues(allow bool) Option {
+	return func(store *KVStore) {
+		store.allowEmptyValues = allow
+	}
+}
+
+// WithClientName sets the identity of the store returned by UUID, which replaces the one given to NewKVStore.
+// A meaningful name, e.g. "orders-service-pod-7", lets operators attribute the requests in TiKV logs to the
+// client instance, see UUID.
+func WithClientName(name string) Option {
+	return func(store *KVStore) {
+		store.uuid = name
 	}
 }
 
@@ -259,10 +287,6 @@ func requestHealthFeedbackFromKVClient(ctx context.Context, addr string, tikvCli
 
 // NewKVStore creates a new TiKV store instance.
 func NewKVStore(uuid string, pdClient pd.Client, spkv SafePointKV, tikvclient Client, opt ...Option) (*KVStore, error) {
-	o, err := oracles.NewPdOracle(pdClient, defaultOracleUpdateInterval)
-	if err != nil {
-		return nil, err
-	}
 	ctx, cancel := context.WithCancel(context.Background())
 	regionCache := locate.NewRegionCache(pdClient, locate.WithRequestHealthFeedbackCallback(func(ctx context.Context, addr string) error {
 		return requestHealthFeedbackFromKVClient(ctx, addr, tikvclient)
@@ -270,7 +294,6 @@ func NewKVStore(uuid string, pdClient pd.Client, spkv SafePointKV, tikvclient Cl
 	store := &KVStore{
 		clusterID:       pdClient.GetClusterID(context.TODO()),
 		uuid:            uuid,
-		oracle:          o,
 		pdClient:        pdClient,
 		regionCache:     regionCache,
 		kv:              spkv,
@@ -281,12 +304,31 @@ func NewKVStore(uuid string, pdClient pd.Client, spkv SafePointKV, tikvclient Cl
 		cancel:          cancel,
 		gP:              NewSpool(128, 10*time.Second),
 	}
-	store.clientMu.client = client.NewReqCollapse(client.NewInterceptedClient(tikvclient))
-	store.clientMu.client.SetEventListener(regionCache.GetClientEventListener())
-
 	store.lockResolver = txnlock.NewLockResolver(store)
 	loadOption(store, opt...)
 
+	store.clientMu.client = client.NewReqCollapse(client.NewInterceptedClient(client.NewIdentityClient(tikvclient, store.uuid)))
+	store.clientMu.client.SetEventListener(regionCache.GetClientEventListener())
+	// The logs of the store's background jobs carry the identity.
+	store.ctx = context.WithValue(store.ctx, logutil.CtxLogKey, logutil.BgLogger().With(zap.String("client", store.uuid)))
+
+	if store.oracle == nil {
+		updateInterval := defaultOracleUpdateInterval
+		if store.oracleUpdateInterval > 0 {
+			updateInterval = store.oracleUpdateInterval
+		}
+		o, err := oracles.NewPdOracle(pdClient, updateInterval)
+		if err != nil {
+			store.closeOnCreateFailure()
+			return nil, err
+		}
+		store.oracle = o
+	} else if store.oracleUpdateInterval > 0 {
+		if err := store.oracle.SetLowResolutionTimestampUpdateInterval(store.oracleUpdateInterval); err != nil {
+			panic(err)
+		}
+	}
+
 	store.wg.Add(2)
 	go store.runSafePointChecker()
 	go store.safeTSUpdater()
@@ -294,6 +336,18 @@ func NewKVStore(uuid string, pdClient pd.Client, spkv SafePointKV, tikvclient Cl
 	return store, nil
 }
 
+// closeOnCreateFailure releases the resources created by NewKVStore before it fails. The pd client, the safe
+// point kv and the tikv client are owned by the caller then.
+func (s *KVStore) closeOnCreateFailure() {
+	s.cancel()
+	s.gP.Close()
+	if s.pdHttpClient != nil {
+		s.pdHttpClient.Close()
+	}
+	s.lockResolver.Close()
+	s.regionCache.Close()
+}
+
 // NewPDClient returns an unwrapped pd client.
 func NewPDClient(pdAddrs []string) (pd.Client, error) {
 	cfg := config.GetGlobalConfig()
@@ -345,7 +399,7 @@ func (s *KVStore) runSafePointChecker() {
 				d = gcSafePointUpdateInterval
 			} else {
 				metrics.TiKVLoadSafepointCounter.WithLabelValues("fail").Inc()
-				logutil.BgLogger().Error("fail to load safepoint from pd", zap.Error(err))
+				logutil.Logger(s.ctx).Error("fail to load safepoint from pd", zap.Error(err))
 				d = gcSafePointQuickRepeatInterval
 			}
 		case <-s.ctx.Done():
@@ -355,7 +409,11 @@ func (s *KVStore) runSafePointChecker() {
 }
 
 // Begin a global transaction.
+// It returns ErrClientClosed if the store is closed.
 func (s *KVStore) Begin(opts ...TxnOption) (txn *transaction.KVTxn, err error) {
+	if s.IsClose() {
+		return nil, errors.WithStack(tikverr.ErrClientClosed)
+	}
 	options := &transaction.TxnOptions{}
 	// Inject the options
 	for _, opt := range opts {
@@ -379,6 +437,7 @@ func (s *KVStore) Begin(opts ...TxnOption) (txn *transaction.KVTxn, err error) {
 	}
 
 	snapshot := txnsnapshot.NewTiKVSnapshot(s, startTS, s.nextReplicaReadSeed())
+	snapshot.SetAllowEmptyValues(s.allowEmptyValues)
 	return transaction.NewTiKVTxn(s, snapshot, startTS, options)
 }
 
@@ -400,8 +459,10 @@ func (s *KVStore) DeleteRange(
 // If the given ts is greater than the current TSO timestamp, the snapshot is not guaranteed
 // to be consistent.
 // Specially, it is useful to set ts to math.MaxUint64 to point get the latest committed data.
+// The reads of the snapshot fail with ErrClientClosed once the store is closed.
 func (s *KVStore) GetSnapshot(ts uint64) *txnsnapshot.KVSnapshot {
 	snapshot := txnsnapshot.NewTiKVSnapshot(s, ts, s.nextReplicaReadSeed())
+	snapshot.SetAllowEmptyValues(s.allowEmptyValues)
 	return snapshot
 }
 
@@ -434,7 +495,9 @@ func (s *KVStore) Close() error {
 	return nil
 }
 
-// UUID return a unique ID which represents a Storage.
+// UUID return a unique ID which represents a Storage. It's the uuid given to NewKVStore, or the name set by
+// WithClientName. It's attached to all requests sent to TiKV as the session alias of kvrpcpb.SourceStmt unless
+// the request has its own alias, and the logs of the store carry it in the "client" field.
 func (s *KVStore) UUID() string {
 	return s.uuid
 }
@@ -541,11 +604,18 @@ func (s *KVStore) SupportDeleteRange() (supported bool) {
 }
 
 // SendReq sends a request to locate.
+// It returns ErrClientClosed if the store is closed, including when it's closed while the request is in flight.
 func (s *KVStore) SendReq(
 	bo *Backoffer, req *tikvrpc.Request, regionID locate.RegionVerID, timeout time.Duration,
 ) (*tikvrpc.Response, error) {
+	if s.IsClose() {
+		return nil, errors.WithStack(tikverr.ErrClientClosed)
+	}
 	sender := locate.NewRegionRequestSender(s.regionCache, s.GetTiKVClient())
 	resp, _, err := sender.SendReq(bo, req, regionID, timeout)
+	if err != nil && s.IsClose() {
+		return nil, errors.WithStack(tikverr.ErrClientClosed)
+	}
 	return resp, err
 }
 
@@ -599,7 +669,7 @@ func (s *KVStore) GetMinSafeTS(txnScope string) uint64 {
 func (s *KVStore) setMinSafeTS(txnScope string, safeTS uint64) {
 	// ensure safeTS is not set to max uint64
 	if safeTS == math.MaxUint64 {
-		logutil.AssertWarn(logutil.BgLogger(), "skip setting min-safe-ts to max uint64", zap.String("txnScope", txnScope), zap.Stack("stack"))
+		logutil.AssertWarn(logutil.Logger(s.ctx), "skip setting min-safe-ts to max uint64", zap.String("txnScope", txnScope), zap.Stack("stack"))
 		return
 	}
 	s.minSafeTS.Store(txnScope, safeTS)
@@ -642,7 +712,7 @@ func (s *KVStore) getSafeTS(storeID uint64) (bool, uint64) {
 func (s *KVStore) setSafeTS(storeID, safeTS uint64) {
 	// ensure safeTS is not set to max uint64
 	if safeTS == math.MaxUint64 {
-		logutil.AssertWarn(logutil.BgLogger(), "skip setting safe-ts to max uint64", zap.Uint64("storeID", storeID), zap.Stack("stack"))
+		logutil.AssertWarn(logutil.Logger(s.ctx), "skip setting safe-ts to max uint64", zap.Uint64("storeID", storeID), zap.Stack("stack"))
 		return
 	}
 	s.safeTSMap.Store(storeID, safeTS)
@@ -719,7 +789,7 @@ func (s *KVStore) updateSafeTS(ctx context.Context) {
 		_, storeMinResolvedTSs, err = s.getMinResolvedTSByStoresIDs(ctx, storeIDs)
 		if err != nil {
 			// If getting the minimum resolved timestamp from PD failed, log the error and need to get it from TiKV.
-			logutil.BgLogger().Debug("get resolved TS from PD failed", zap.Error(err), zap.Any("stores", storeIDs))
+			logutil.Logger(s.ctx).Debug("get resolved TS from PD failed", zap.Error(err), zap.Any("stores", storeIDs))
 		}
 	}
 
@@ -752,7 +822,7 @@ func (s *KVStore) updateSafeTS(ctx context.Context) {
 				)
 				if err != nil {
 					metrics.TiKVSafeTSUpdateCounter.WithLabelValues("fail", storeIDStr).Inc()
-					logutil.BgLogger().Debug("update safeTS failed", zap.Error(err), zap.Uint64("store-id", storeID))
+					logutil.Logger(s.ctx).Debug("update safeTS failed", zap.Error(err), zap.Uint64("store-id", storeID))
 					return
 				}
 				safeTS = resp.Resp.(*kvrpcpb.StoreSafeTSResponse).GetSafeTs()
@@ -804,12 +874,12 @@ func (s *KVStore) getMinResolvedTSByStoresIDs(ctx context.Context, storeIDs []ui
 			return minResolvedTS, storeMinResolvedTSs, err
 		}
 		minResolvedTS = uint64(injectedTS)
-		logutil.BgLogger().Info("inject min resolved ts", zap.Uint64("ts", uint64(injectedTS)))
+		logutil.Logger(s.ctx).Info("inject min resolved ts", zap.Uint64("ts", uint64(injectedTS)))
 		// Currently we only have a store 1 in the test, so it's OK to inject the same min resolved TS for all stores here.
 		for storeID, v := range storeMinResolvedTSs {
 			if v != 0 && v != math.MaxUint64 {
 				storeMinResolvedTSs[storeID] = uint64(injectedTS)
-				logutil.BgLogger().Info("inject store min resolved ts", zap.Uint64("storeID", storeID), zap.Uint64("ts", uint64(injectedTS)))
+				logutil.Logger(s.ctx).Info("inject store min resolved ts", zap.Uint64("storeID", storeID), zap.Uint64("ts", uint64(injectedTS)))
 			}
 		}
 	}
@@ -830,7 +900,7 @@ func (s *KVStore) updateGlobalTxnScopeTSFromPD(ctx context.Context) bool {
 	if s.pdHttpClient != nil && isGlobal {
 		clusterMinSafeTS, _, err := s.getMinResolvedTSByStoresIDs(ctx, nil)
 		if err != nil {
-			logutil.BgLogger().Debug("get resolved TS from PD failed", zap.Error(err))
+			logutil.Logger(s.ctx).Debug("get resolved TS from PD failed", zap.Error(err))
 		} else if isValidSafeTS(clusterMinSafeTS) {
 			// Update ts and metrics.
 			preClusterMinSafeTS := s.GetMinSafeTS(oracle.GlobalTxnScope)
@@ -949,6 +1019,21 @@ func WithPipelinedMemDB() TxnOption {
 	}
 }
 
+// WithPipelinedBatchGetConcurrency sets the max number of concurrent requests the pipelined memdb sends to
+// read the flushed buffer. It only takes effect with WithPipelinedMemDB.
+func WithPipelinedBatchGetConcurrency(concurrency int) TxnOption {
+	return func(st *transaction.TxnOptions) {
+		st.PipelinedBatchGetConcurrency = concurrency
+	}
+}
+
+// WithOnClose sets the function called once the transaction is committed or rolled back.
+func WithOnClose(f func()) TxnOption {
+	return func(st *transaction.TxnOptions) {
+		st.OnClose = f
+	}
+}
+
 // TODO: remove once tidb and br are ready
 
 // KVTxn contains methods to interact with a TiKV transaction.
@@ -98,18 +98,34 @@ var NewCodecPDClient = locate.NewCodecPDClient
 // NewCodecPDClientWithKeyspace creates a CodecPDClient in API v2 with keyspace name.
 var NewCodecPDClientWithKeyspace = locate.NewCodecPDClientWithKeyspace
 
+// NewCodecPDClientWithKeyspaceID creates a CodecPDClient in API v2 with keyspace ID, without loading the keyspace
+// meta from PD.
+var NewCodecPDClientWithKeyspaceID = locate.NewCodecPDClientWithKeyspaceID
+
 // NewCodecV1 is a constructor for v1 Codec.
 var NewCodecV1 = apicodec.NewCodecV1
 
 // NewCodecV2 is a constructor for v2 Codec.
 var NewCodecV2 = apicodec.NewCodecV2
 
+// NewCodecIdentity is a constructor for the Codec that leaves everything untouched, which is only for tests.
+var NewCodecIdentity = apicodec.NewCodecIdentity
+
 // Codec is responsible for encode/decode requests.
 type Codec = apicodec.Codec
 
+// CodecOption configures the Codec created by NewCodecV1 or NewCodecV2.
+type CodecOption = apicodec.CodecOption
+
+// OnDecodeError sets the callback invoked when a Codec fails to decode region keys, the error is still returned.
+var OnDecodeError = apicodec.OnDecodeError
+
 // DecodeKey is used to split a given key to it's APIv2 prefix and actual key.
 var DecodeKey = apicodec.DecodeKey
 
+// DescribeRegion returns a compact description of the region range in user keys for logging.
+var DescribeRegion = apicodec.DescribeRegion
+
 // DefaultKeyspaceID is the keyspaceID of the default keyspace.
 var DefaultKeyspaceID = apicodec.DefaultKeyspaceID
 
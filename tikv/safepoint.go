@@ -37,6 +37,9 @@ package tikv
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -56,6 +59,9 @@ const (
 	// save this to pd instead of tikv, because we can't use interface of table
 	// if the safepoint on tidb is expired.
 	GcSavedSafePoint = "/tidb/store/gcworker/saved_safe_point"
+	// GcSafePointPrefix is the prefix of the safe points saved by the services, which are listed by ListSafePoints.
+	// GcSavedSafePoint shares the prefix but it's not a service safe point.
+	GcSafePointPrefix = "/tidb/store/gcworker/"
 
 	GcSafePointCacheInterval       = time.Second * 100
 	gcCPUTimeInaccuracyBound       = time.Second
@@ -84,6 +90,12 @@ func NewMockSafePointKV(opts ...SafePointKVOpt) *MockSafePointKV {
 	}
 }
 
+// NewMemSafePointKV creates a SafePointKV that keeps the safe points in memory, for the deployments without etcd
+// and the tests. The safe points are not shared with other processes.
+func NewMemSafePointKV() SafePointKV {
+	return NewMockSafePointKV()
+}
+
 // Put implements the Put method for SafePointKV
 func (w *MockSafePointKV) Put(k string, v string) error {
 	w.mockLock.Lock()
@@ -113,6 +125,14 @@ func (w *MockSafePointKV) GetWithPrefix(prefix string) ([]*mvccpb.KeyValue, erro
 	return kvs, nil
 }
 
+// Delete deletes the key, see RemoveSafePoint.
+func (w *MockSafePointKV) Delete(k string) error {
+	w.mockLock.Lock()
+	defer w.mockLock.Unlock()
+	delete(w.store, k)
+	return nil
+}
+
 // Close implements the Close method for SafePointKV
 func (w *MockSafePointKV) Close() error {
 	return nil
@@ -190,11 +210,141 @@ func (w *EtcdSafePointKV) GetWithPrefix(k string) ([]*mvccpb.KeyValue, error) {
 	return resp.Kvs, nil
 }
 
+// Delete deletes the key, see RemoveSafePoint.
+func (w *EtcdSafePointKV) Delete(k string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	_, err := w.cli.Delete(ctx, k)
+	cancel()
+	return errors.WithStack(err)
+}
+
 // Close implements the Close for SafePointKV
 func (w *EtcdSafePointKV) Close() error {
 	return errors.WithStack(w.cli.Close())
 }
 
+// pdGCSafePointAPI is the PD HTTP API that returns the GC safe point and the service safe points.
+const pdGCSafePointAPI = "/pd/api/v1/gc/safepoint"
+
+// PDHTTPSafePointKV implements SafePointKV by reading the safe points from the PD HTTP API, for the deployments
+// whose PD endpoints don't serve etcd. It's read-only: the GC safe point is read as the value of GcSavedSafePoint,
+// and the service safe points are listed under GcSafePointPrefix by their service ids.
+type PDHTTPSafePointKV struct {
+	addrs []string
+	cli   *http.Client
+}
+
+// NewPDHTTPSafePointKV creates an instance of PDHTTPSafePointKV. The addresses are tried in order, the ones without
+// a scheme use https if tlsConfig is not nil, or http otherwise.
+func NewPDHTTPSafePointKV(addrs []string, tlsConfig *tls.Config) (*PDHTTPSafePointKV, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("no PD address for the safe point kv")
+	}
+	scheme := "http://"
+	if tlsConfig != nil {
+		scheme = "https://"
+	}
+	urls := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if !strings.Contains(addr, "://") {
+			addr = scheme + addr
+		}
+		urls = append(urls, strings.TrimSuffix(addr, "/")+pdGCSafePointAPI)
+	}
+	return &PDHTTPSafePointKV{
+		addrs: urls,
+		cli:   &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+	}, nil
+}
+
+// pdGCSafePoints is the response of pdGCSafePointAPI.
+type pdGCSafePoints struct {
+	ServiceSafePoints []serviceSafePoint `json:"service_gc_safe_points"`
+	GCSafePoint       uint64             `json:"gc_safe_point"`
+}
+
+// load reads the safe points from the first PD that responds.
+func (w *PDHTTPSafePointKV) load() (*pdGCSafePoints, error) {
+	var lastErr error
+	for _, url := range w.addrs {
+		sp, err := w.loadFrom(url)
+		if err == nil {
+			return sp, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (w *PDHTTPSafePointKV) loadFrom(url string) (*pdGCSafePoints, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	resp, err := w.cli.Do(req)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("failed to get the safe points from %s: %s", url, resp.Status)
+	}
+	var sp pdGCSafePoints
+	if err := json.NewDecoder(resp.Body).Decode(&sp); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &sp, nil
+}
+
+// Put implements the Put method for SafePointKV, it always fails since PDHTTPSafePointKV is read-only.
+func (w *PDHTTPSafePointKV) Put(k string, v string) error {
+	return errors.New("PDHTTPSafePointKV is read-only")
+}
+
+// Get implements the Get method for SafePointKV, only GcSavedSafePoint has a value.
+func (w *PDHTTPSafePointKV) Get(k string) (string, error) {
+	if k != GcSavedSafePoint {
+		return "", nil
+	}
+	sp, err := w.load()
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(sp.GCSafePoint, 10), nil
+}
+
+// GetWithPrefix implements the GetWithPrefix for SafePointKV
+func (w *PDHTTPSafePointKV) GetWithPrefix(k string) ([]*mvccpb.KeyValue, error) {
+	sp, err := w.load()
+	if err != nil {
+		return nil, err
+	}
+	kvs := make([]*mvccpb.KeyValue, 0, len(sp.ServiceSafePoints)+1)
+	if strings.HasPrefix(GcSavedSafePoint, k) {
+		kvs = append(kvs, &mvccpb.KeyValue{Key: []byte(GcSavedSafePoint), Value: []byte(strconv.FormatUint(sp.GCSafePoint, 10))})
+	}
+	for _, ssp := range sp.ServiceSafePoints {
+		key := GcSafePointPrefix + ssp.ServiceID
+		if !strings.HasPrefix(key, k) {
+			continue
+		}
+		value, err := json.Marshal(ssp)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		kvs = append(kvs, &mvccpb.KeyValue{Key: []byte(key), Value: value})
+	}
+	return kvs, nil
+}
+
+// Close implements the Close for SafePointKV
+func (w *PDHTTPSafePointKV) Close() error {
+	w.cli.CloseIdleConnections()
+	return nil
+}
+
 func saveSafePoint(kv SafePointKV, t uint64) error {
 	s := strconv.FormatUint(t, 10)
 	err := kv.Put(GcSavedSafePoint, s)
@@ -222,3 +372,122 @@ func loadSafePoint(kv SafePointKV) (uint64, error) {
 	}
 	return t, nil
 }
+
+// SafePointEntry is a safe point saved in SafePointKV under GcSafePointPrefix.
+type SafePointEntry struct {
+	// Key is the full key of the entry, which is used to remove it by RemoveSafePoint.
+	Key string
+	// ServiceID is the service that saved the safe point. It's the key without GcSafePointPrefix unless the value
+	// carries a service id.
+	ServiceID string
+	// SafePoint is the saved ts, it's zero if the value can't be decoded.
+	SafePoint uint64
+	// ExpiredAt is when the safe point expires, it's zero if the value doesn't carry an expiry.
+	ExpiredAt time.Time
+	// Value is the raw value of the entry.
+	Value string
+}
+
+// serviceSafePoint is the JSON form of a service safe point with expiry, which is the same as the one in PD.
+type serviceSafePoint struct {
+	ServiceID string `json:"service_id"`
+	ExpiredAt int64  `json:"expired_at"`
+	SafePoint uint64 `json:"safe_point"`
+}
+
+// safePointDeleter is implemented by the SafePointKV which can delete keys.
+type safePointDeleter interface {
+	Delete(k string) error
+}
+
+// ListSafePoints lists the safe points saved in spkv under GcSafePointPrefix, sorted by key. GcSavedSafePoint is
+// skipped since it's the safe point of the GC worker itself. The value of an entry is either a ts in decimal, or a
+// JSON service safe point with the service id and expiry.
+// An entry whose value can't be decoded is still listed with a zero SafePoint, so that it can be removed.
+func ListSafePoints(ctx context.Context, spkv SafePointKV) ([]SafePointEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	kvs, err := spkv.GetWithPrefix(GcSafePointPrefix)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]SafePointEntry, 0, len(kvs))
+	for _, kv := range kvs {
+		if string(kv.Key) == GcSavedSafePoint {
+			continue
+		}
+		entry := SafePointEntry{
+			Key:       string(kv.Key),
+			ServiceID: strings.TrimPrefix(string(kv.Key), GcSafePointPrefix),
+			Value:     string(kv.Value),
+		}
+		if !decodeSafePoint(&entry) {
+			logutil.Logger(ctx).Warn("failed to decode safe point",
+				zap.String("key", entry.Key), zap.String("value", entry.Value))
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries, nil
+}
+
+// decodeSafePoint decodes the value of entry, it returns false if the value is neither a ts nor a JSON service safe
+// point.
+func decodeSafePoint(entry *SafePointEntry) bool {
+	if ts, err := strconv.ParseUint(entry.Value, 10, 64); err == nil {
+		entry.SafePoint = ts
+		return true
+	}
+	var sp serviceSafePoint
+	if err := json.Unmarshal([]byte(entry.Value), &sp); err != nil {
+		return false
+	}
+	if sp.ServiceID != "" {
+		entry.ServiceID = sp.ServiceID
+	}
+	entry.SafePoint = sp.SafePoint
+	if sp.ExpiredAt > 0 {
+		entry.ExpiredAt = time.Unix(sp.ExpiredAt, 0)
+	}
+	return true
+}
+
+// RemoveSafePoint removes a stale safe point listed by ListSafePoints from spkv, spkv must have a Delete method
+// like MockSafePointKV and EtcdSafePointKV. Removing the minimum safe point may let GC advance past the data the
+// service still needs, so it's refused unless force is true. GcSavedSafePoint is never removed.
+func RemoveSafePoint(ctx context.Context, spkv SafePointKV, key string, force bool) error {
+	if key == GcSavedSafePoint {
+		return errors.Errorf("safe point %s is saved by the GC worker and can't be removed", key)
+	}
+	deleter, ok := spkv.(safePointDeleter)
+	if !ok {
+		return errors.Errorf("safe point kv %T does not support deletion", spkv)
+	}
+	entries, err := ListSafePoints(ctx, spkv)
+	if err != nil {
+		return err
+	}
+	idx := -1
+	var minSafePoint uint64
+	for i, entry := range entries {
+		if entry.Key == key {
+			idx = i
+		}
+		if entry.SafePoint > 0 && (minSafePoint == 0 || entry.SafePoint < minSafePoint) {
+			minSafePoint = entry.SafePoint
+		}
+	}
+	if idx < 0 {
+		return errors.Errorf("safe point %s not found", key)
+	}
+	if !force && entries[idx].SafePoint > 0 && entries[idx].SafePoint == minSafePoint {
+		return errors.Errorf("safe point %s is the minimum safe point %d, use force to remove it", key, minSafePoint)
+	}
+	if err := deleter.Delete(key); err != nil {
+		return err
+	}
+	logutil.Logger(ctx).Info("safe point removed",
+		zap.String("key", key), zap.Uint64("safePoint", entries[idx].SafePoint), zap.Bool("force", force))
+	return nil
+}
@@ -55,7 +55,7 @@ type CodecClient struct {
 
 // SendRequest uses codec to encode request before send, and decode response before return.
 func (c *CodecClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
-	req, err := c.codec.EncodeRequest(req)
+	req, err := apicodec.EncodeRequest(ctx, c.codec, req)
 	if err != nil {
 		return nil, err
 	}
@@ -42,6 +42,15 @@ type Getter = unionstore.Getter
 // Iterator is the interface for a iterator on KV store.
 type Iterator = unionstore.Iterator
 
+// SeekableIterator is an Iterator which can be repositioned without being recreated.
+type SeekableIterator = unionstore.SeekableIterator
+
+// FlagsIterator is an Iterator which also yields the KeyFlags of the keys.
+type FlagsIterator = unionstore.FlagsIterator
+
+// ChangesIterator is an Iterator over the keys changed since a checkpoint, see MemBuffer.IterChangesSince.
+type ChangesIterator = unionstore.ChangesIterator
+
 // MemDB is rollbackable Red-Black Tree optimized for transaction states buffer use scenario.
 // You can think MemDB is a combination of two separate tree map, one for key => value and another for key => keyFlags.
 //
@@ -55,5 +64,68 @@ type MemDB = unionstore.MemDB
 // MemBuffer is the interface for the MemDB buffer.
 type MemBuffer = unionstore.MemBuffer
 
+// LargeEntryHandler handles an entry whose size exceeds the entry size limit of the MemBuffer, see
+// MemBuffer.SetLargeEntryHandler.
+type LargeEntryHandler = unionstore.LargeEntryHandler
+
+// MemoryThreshold is a memory footprint limit of a MemBuffer, see MemBuffer.SetMemoryThresholds.
+type MemoryThreshold = unionstore.MemoryThreshold
+
+// MemBufferSnapshot is a read-only view of the MemBuffer, see MemBuffer.SnapshotGetter.
+type MemBufferSnapshot = unionstore.MemBufferSnapshot
+
+// DeserializeMemBuffer restores a MemBuffer from the data returned by MemBuffer.Serialize.
+var DeserializeMemBuffer = unionstore.DeserializeMemBuffer
+
+// ArenaStats describes how much of the memory allocated by the arenas of a MemDB is live, see MemBuffer.Stats.
+type ArenaStats = unionstore.ArenaStats
+
 // MemDBCheckpoint is the checkpoint of memory DB.
 type MemDBCheckpoint = unionstore.MemDBCheckpoint
+
+// UnionStoreReadSource indicates where a read of the union store is served from.
+type UnionStoreReadSource = unionstore.Source
+
+const (
+	// UnionStoreReadSourceMemBuffer means the read is served by the MemBuffer.
+	UnionStoreReadSourceMemBuffer = unionstore.SourceMemBuffer
+	// UnionStoreReadSourceSnapshot means the read goes through to the snapshot.
+	UnionStoreReadSourceSnapshot = unionstore.SourceSnapshot
+	// UnionStoreReadSourceCache means the read is served by the read-through cache.
+	UnionStoreReadSourceCache = unionstore.SourceCache
+)
+
+// ReadThroughCache is a value cache consulted by the union store before the snapshot.
+type ReadThroughCache = unionstore.ReadThroughCache
+
+// SnapshotCacheMetrics is the metrics of the snapshot read cache of the union store, see
+// KVUnionStore.GetSnapshotCacheMetrics.
+type SnapshotCacheMetrics = unionstore.SnapshotCacheMetrics
+
+// WithSnapshotCacheScope returns a context carrying a new scope of the snapshot cache of the union store, see
+// KVUnionStore.EnableSnapshotCache.
+var WithSnapshotCacheScope = unionstore.WithSnapshotCacheScope
+
+// UnionStoreKVSource indicates where the value of a key comes from, or why it's absent.
+type UnionStoreKVSource = unionstore.KVSource
+
+const (
+	// UnionStoreKVSourceNotExist means the key is neither in the MemBuffer nor in the snapshot.
+	UnionStoreKVSourceNotExist = unionstore.KVSourceNotExist
+	// UnionStoreKVSourceFromBuffer means the value is written in the MemBuffer.
+	UnionStoreKVSourceFromBuffer = unionstore.KVSourceFromBuffer
+	// UnionStoreKVSourceFromSnapshot means the value is read from the snapshot.
+	UnionStoreKVSourceFromSnapshot = unionstore.KVSourceFromSnapshot
+	// UnionStoreKVSourceDeletedInBuffer means the key is deleted in the MemBuffer.
+	UnionStoreKVSourceDeletedInBuffer = unionstore.KVSourceDeletedInBuffer
+)
+
+// MemDBOpType is the type of a mutation yielded by MemDB.ChangesSince.
+type MemDBOpType = unionstore.OpType
+
+const (
+	// MemDBOpPut sets the value of a key.
+	MemDBOpPut = unionstore.OpPut
+	// MemDBOpDelete deletes a key.
+	MemDBOpDelete = unionstore.OpDelete
+)
@@ -657,6 +657,84 @@ func (req *Request) ToBatchCommandsRequest() *tikvpb.BatchCommandsRequest_Reques
 	return nil
 }
 
+// FromBatchCommandsRequest converts a request entry of BatchCommands to Request, the context of the embedded request
+// is copied to Request.Context. The embedded request is shared with the entry, it's copied rather than modified when
+// a context is attached to the returned Request.
+func FromBatchCommandsRequest(entry *tikvpb.BatchCommandsRequest_Request) (*Request, error) {
+	var req *Request
+	switch cmd := entry.GetCmd().(type) {
+	case *tikvpb.BatchCommandsRequest_Request_Get:
+		req = NewRequest(CmdGet, cmd.Get)
+	case *tikvpb.BatchCommandsRequest_Request_Scan:
+		req = NewRequest(CmdScan, cmd.Scan)
+	case *tikvpb.BatchCommandsRequest_Request_Prewrite:
+		req = NewRequest(CmdPrewrite, cmd.Prewrite)
+	case *tikvpb.BatchCommandsRequest_Request_Commit:
+		req = NewRequest(CmdCommit, cmd.Commit)
+	case *tikvpb.BatchCommandsRequest_Request_Cleanup:
+		req = NewRequest(CmdCleanup, cmd.Cleanup)
+	case *tikvpb.BatchCommandsRequest_Request_BatchGet:
+		req = NewRequest(CmdBatchGet, cmd.BatchGet)
+	case *tikvpb.BatchCommandsRequest_Request_BatchRollback:
+		req = NewRequest(CmdBatchRollback, cmd.BatchRollback)
+	case *tikvpb.BatchCommandsRequest_Request_ScanLock:
+		req = NewRequest(CmdScanLock, cmd.ScanLock)
+	case *tikvpb.BatchCommandsRequest_Request_ResolveLock:
+		req = NewRequest(CmdResolveLock, cmd.ResolveLock)
+	case *tikvpb.BatchCommandsRequest_Request_GC:
+		req = NewRequest(CmdGC, cmd.GC)
+	case *tikvpb.BatchCommandsRequest_Request_DeleteRange:
+		req = NewRequest(CmdDeleteRange, cmd.DeleteRange)
+	case *tikvpb.BatchCommandsRequest_Request_RawGet:
+		req = NewRequest(CmdRawGet, cmd.RawGet)
+	case *tikvpb.BatchCommandsRequest_Request_RawBatchGet:
+		req = NewRequest(CmdRawBatchGet, cmd.RawBatchGet)
+	case *tikvpb.BatchCommandsRequest_Request_RawPut:
+		req = NewRequest(CmdRawPut, cmd.RawPut)
+	case *tikvpb.BatchCommandsRequest_Request_RawBatchPut:
+		req = NewRequest(CmdRawBatchPut, cmd.RawBatchPut)
+	case *tikvpb.BatchCommandsRequest_Request_RawDelete:
+		req = NewRequest(CmdRawDelete, cmd.RawDelete)
+	case *tikvpb.BatchCommandsRequest_Request_RawBatchDelete:
+		req = NewRequest(CmdRawBatchDelete, cmd.RawBatchDelete)
+	case *tikvpb.BatchCommandsRequest_Request_RawDeleteRange:
+		req = NewRequest(CmdRawDeleteRange, cmd.RawDeleteRange)
+	case *tikvpb.BatchCommandsRequest_Request_RawScan:
+		req = NewRequest(CmdRawScan, cmd.RawScan)
+	case *tikvpb.BatchCommandsRequest_Request_Coprocessor:
+		req = NewRequest(CmdCop, cmd.Coprocessor)
+	case *tikvpb.BatchCommandsRequest_Request_PessimisticLock:
+		req = NewRequest(CmdPessimisticLock, cmd.PessimisticLock)
+	case *tikvpb.BatchCommandsRequest_Request_PessimisticRollback:
+		req = NewRequest(CmdPessimisticRollback, cmd.PessimisticRollback)
+	case *tikvpb.BatchCommandsRequest_Request_Empty:
+		req = NewRequest(CmdEmpty, cmd.Empty)
+	case *tikvpb.BatchCommandsRequest_Request_CheckTxnStatus:
+		req = NewRequest(CmdCheckTxnStatus, cmd.CheckTxnStatus)
+	case *tikvpb.BatchCommandsRequest_Request_CheckSecondaryLocks:
+		req = NewRequest(CmdCheckSecondaryLocks, cmd.CheckSecondaryLocks)
+	case *tikvpb.BatchCommandsRequest_Request_TxnHeartBeat:
+		req = NewRequest(CmdTxnHeartBeat, cmd.TxnHeartBeat)
+	case *tikvpb.BatchCommandsRequest_Request_FlashbackToVersion:
+		req = NewRequest(CmdFlashbackToVersion, cmd.FlashbackToVersion)
+	case *tikvpb.BatchCommandsRequest_Request_PrepareFlashbackToVersion:
+		req = NewRequest(CmdPrepareFlashbackToVersion, cmd.PrepareFlashbackToVersion)
+	case *tikvpb.BatchCommandsRequest_Request_Flush:
+		req = NewRequest(CmdFlush, cmd.Flush)
+	case *tikvpb.BatchCommandsRequest_Request_BufferBatchGet:
+		req = NewRequest(CmdBufferBatchGet, cmd.BufferBatchGet)
+	case *tikvpb.BatchCommandsRequest_Request_GetHealthFeedback:
+		req = NewRequest(CmdGetHealthFeedback, cmd.GetHealthFeedback)
+	default:
+		return nil, errors.Errorf("unknown command request %T", entry.GetCmd())
+	}
+	if r, ok := req.Req.(interface{ GetContext() *kvrpcpb.Context }); ok && r.GetContext() != nil {
+		req.Context = *r.GetContext()
+	}
+	req.rev = 1
+	return req, nil
+}
+
 // Response wraps all kv/coprocessor responses.
 type Response struct {
 	Resp interface{}
@@ -16,27 +16,126 @@ package txnkv
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/gogo/protobuf/proto"
+	"github.com/pingcap/kvproto/pkg/keyspacepb"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	"github.com/pkg/errors"
 	"github.com/tikv/client-go/v2/config"
 	"github.com/tikv/client-go/v2/config/retry"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/internal/apicodec"
+	"github.com/tikv/client-go/v2/internal/logutil"
 	"github.com/tikv/client-go/v2/oracle"
 	"github.com/tikv/client-go/v2/tikv"
 	"github.com/tikv/client-go/v2/txnkv/transaction"
 	"github.com/tikv/client-go/v2/util"
+	pd "github.com/tikv/pd/client"
+	pderr "github.com/tikv/pd/client/errs"
+	"go.uber.org/zap"
 )
 
 // Client is a txn client.
 type Client struct {
 	*tikv.KVStore
+
+	// codec is the codec of the keyspace the client is bound to.
+	codec tikv.Codec
+	// customOracle is set if the timestamps are allocated by the oracle given by WithOracle rather than PD.
+	customOracle bool
+
+	// txns tracks the transactions begun by Begin, which are drained by CloseGracefully.
+	txns struct {
+		sync.Mutex
+		closing bool
+		active  int
+		// drained is closed once no transaction is active after CloseGracefully is called.
+		drained chan struct{}
+	}
 }
 
 type option struct {
-	apiVersion   kvrpcpb.APIVersion
-	keyspaceName string
-	spKVPrefix   string
+	apiVersion    kvrpcpb.APIVersion
+	keyspaceName  string
+	keyspaceID    uint32
+	withKeyspace  bool
+	spKVPrefix    string
+	spkv          tikv.SafePointKV
+	spkvFactory   func() (tikv.SafePointKV, error)
+	ownedPDClient bool
+	oracle        oracle.Oracle
+	withOracle    bool
+	allowEmpty    bool
+	clientName    string
+	tlsConfig     *tls.Config
+	pdClient      pd.Client
+	withPDClient  bool
+	tso           tsoOptions
+}
+
+// maxTSOBatchWaitInterval is the max TSO batch wait interval accepted by the PD client.
+const maxTSOBatchWaitInterval = 10 * time.Millisecond
+
+// tsoOptions are the TSO options of the PD client, which are set by the PD client's UpdateOption.
+type tsoOptions struct {
+	followerProxy            bool
+	withFollowerProxy        bool
+	maxBatchWaitInterval     time.Duration
+	withMaxBatchWaitInterval bool
+}
+
+// TSOOption is factory to set the TSO options of the PD client, see Client.UpdateTSOOptions.
+type TSOOption func(*tsoOptions)
+
+// TSOFollowerProxy enables or disables the TSO follower proxy of the PD client, with which the TSO requests can be
+// served by the PD followers.
+func TSOFollowerProxy(enable bool) TSOOption {
+	return func(opt *tsoOptions) {
+		opt.followerProxy = enable
+		opt.withFollowerProxy = true
+	}
+}
+
+// TSOMaxBatchWaitInterval sets how long the PD client waits at most to batch more TSO requests, which must be within
+// [0, 10ms].
+func TSOMaxBatchWaitInterval(d time.Duration) TSOOption {
+	return func(opt *tsoOptions) {
+		opt.maxBatchWaitInterval = d
+		opt.withMaxBatchWaitInterval = true
+	}
+}
+
+func (opt *tsoOptions) validate() error {
+	if opt.withMaxBatchWaitInterval && (opt.maxBatchWaitInterval < 0 || opt.maxBatchWaitInterval > maxTSOBatchWaitInterval) {
+		return errors.Errorf("invalid max TSO batch wait interval %v, it should be within [0, %v]",
+			opt.maxBatchWaitInterval, maxTSOBatchWaitInterval)
+	}
+	return nil
+}
+
+func (opt *tsoOptions) isEmpty() bool {
+	return !opt.withFollowerProxy && !opt.withMaxBatchWaitInterval
+}
+
+// apply sets the options to pdClient, the options set before an error are kept.
+func (opt *tsoOptions) apply(pdClient pd.Client) error {
+	if opt.withMaxBatchWaitInterval {
+		if err := pdClient.UpdateOption(pd.MaxTSOBatchWaitInterval, opt.maxBatchWaitInterval); err != nil {
+			return errors.WithMessage(err, "failed to set the max TSO batch wait interval")
+		}
+	}
+	if opt.withFollowerProxy {
+		if err := pdClient.UpdateOption(pd.EnableTSOFollowerProxy, opt.followerProxy); err != nil {
+			return errors.WithMessage(err, "failed to set the TSO follower proxy")
+		}
+	}
+	return nil
 }
 
 // ClientOpt is factory to set the client options.
@@ -49,6 +148,16 @@ func WithKeyspace(keyspaceName string) ClientOpt {
 	}
 }
 
+// WithKeyspaceID is used to set client's keyspace by ID instead of name, which skips loading the keyspace meta from
+// PD, so the keyspace name of the client is empty. It only takes effect with APIv2 and can't be used along with
+// WithKeyspace.
+func WithKeyspaceID(keyspaceID uint32) ClientOpt {
+	return func(opt *option) {
+		opt.keyspaceID = keyspaceID
+		opt.withKeyspace = true
+	}
+}
+
 // WithAPIVersion is used to set client's apiVersion.
 func WithAPIVersion(apiVersion kvrpcpb.APIVersion) ClientOpt {
 	return func(opt *option) {
@@ -63,19 +172,200 @@ func WithSafePointKVPrefix(prefix string) ClientOpt {
 	}
 }
 
-// NewClient creates a txn client with pdAddrs.
-func NewClient(pdAddrs []string, opts ...ClientOpt) (*Client, error) {
-	// Apply options.
+// WithSafePointKV is used to set the safe point kv used by the client instead of dialing etcd.
+func WithSafePointKV(spkv tikv.SafePointKV) ClientOpt {
+	return func(opt *option) {
+		opt.spkv = spkv
+	}
+}
+
+// WithSafePointKVFactory is used to set the function creating the safe point kv used by the client, e.g.
+// tikv.NewMemSafePointKV or tikv.NewPDHTTPSafePointKV for the deployments whose PD endpoints don't serve etcd. It's
+// called when the client is created, and ignored if WithSafePointKV is given.
+func WithSafePointKVFactory(factory func() (tikv.SafePointKV, error)) ClientOpt {
+	return func(opt *option) {
+		opt.spkvFactory = factory
+	}
+}
+
+// WithOwnedPDClient makes the client created by NewClientWithPD, or by NewClient with WithPDClient, take the
+// ownership of the given pd.Client, which is closed when the client is closed.
+func WithOwnedPDClient() ClientOpt {
+	return func(opt *option) {
+		opt.ownedPDClient = true
+	}
+}
+
+// WithOracle is used to set the oracle used by the client instead of the PD-backed one, e.g. an external TSO
+// service. The oracle must not be nil, and it's closed when the client is closed.
+func WithOracle(o oracle.Oracle) ClientOpt {
+	return func(opt *option) {
+		opt.oracle = o
+		opt.withOracle = true
+	}
+}
+
+// WithAllowEmptyValues makes the transactions and snapshots of the client read zero-length values as existing
+// empty values rather than nonexistent keys. It's disabled by default for compatibility.
+func WithAllowEmptyValues(allow bool) ClientOpt {
+	return func(opt *option) {
+		opt.allowEmpty = allow
+	}
+}
+
+// WithClientName sets the identity of the client, which is attached to the requests and logs of the client so
+// that they can be attributed to it, see tikv.KVStore.UUID. It's derived from the cluster id by default.
+func WithClientName(name string) ClientOpt {
+	return func(opt *option) {
+		opt.clientName = name
+	}
+}
+
+// WithTLSConfig sets the TLS config used to connect to the safe point etcd and TiKV, which overrides the one derived
+// from the global security config, so that the clients of different clusters can use their own certificates in one
+// process. The PD client created by NewClient still uses the global security config.
+func WithTLSConfig(tlsConfig *tls.Config) ClientOpt {
+	return func(opt *option) {
+		opt.tlsConfig = tlsConfig
+	}
+}
+
+// WithPDClient makes NewClient use the given pd.Client instead of creating one, e.g. to share the PD client managed by
+// the caller or to mock PD in tests. The pd.Client must be connected to the cluster served by the PD addresses given
+// to NewClient. It's not closed when the client is closed unless WithOwnedPDClient is given. NewClientWithPD ignores
+// it.
+func WithPDClient(pdClient pd.Client) ClientOpt {
+	return func(opt *option) {
+		opt.pdClient = pdClient
+		opt.withPDClient = true
+	}
+}
+
+// WithTSOFollowerProxy enables or disables the TSO follower proxy of the PD client when the client is created, see
+// TSOFollowerProxy. It can't be used along with WithOracle.
+func WithTSOFollowerProxy(enable bool) ClientOpt {
+	return func(opt *option) {
+		TSOFollowerProxy(enable)(&opt.tso)
+	}
+}
+
+// WithMaxTSOBatchWaitInterval sets the max TSO batch wait interval of the PD client when the client is created, see
+// TSOMaxBatchWaitInterval. It can't be used along with WithOracle.
+func WithMaxTSOBatchWaitInterval(d time.Duration) ClientOpt {
+	return func(opt *option) {
+		TSOMaxBatchWaitInterval(d)(&opt.tso)
+	}
+}
+
+func applyOptions(opts []ClientOpt) (*option, error) {
 	opt := &option{}
 	for _, o := range opts {
 		o(opt)
 	}
+	if opt.withOracle && opt.oracle == nil {
+		return nil, errors.New("oracle is nil")
+	}
+	if opt.withPDClient && opt.pdClient == nil {
+		return nil, errors.New("pd client is nil")
+	}
+	if opt.withKeyspace && len(opt.keyspaceName) > 0 {
+		return nil, errors.New("WithKeyspaceID can't be used along with WithKeyspace")
+	}
+	if err := opt.tso.validate(); err != nil {
+		return nil, err
+	}
+	if opt.withOracle && !opt.tso.isEmpty() {
+		return nil, errors.New("the TSO options can't be used along with the oracle given by WithOracle")
+	}
+	return opt, nil
+}
+
+// NewClient creates a txn client with pdAddrs.
+// The safe point kv is stored in the etcd served by PD unless WithSafePointKV or WithSafePointKVFactory is given.
+func NewClient(pdAddrs []string, opts ...ClientOpt) (*Client, error) {
+	opt, err := applyOptions(opts)
+	if err != nil {
+		return nil, err
+	}
 	// Use an unwrapped PDClient to obtain keyspace meta.
-	pdClient, err := tikv.NewPDClient(pdAddrs)
+	pdClient := opt.pdClient
+	if pdClient == nil {
+		if pdClient, err = tikv.NewPDClient(pdAddrs); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	} else {
+		if err = checkPDClient(pdClient, pdAddrs); err != nil {
+			return nil, err
+		}
+		if !opt.ownedPDClient {
+			pdClient = unownedPDClient{Client: pdClient}
+		}
+	}
+	return newClient(pdClient, opt, "etcd", func() (tikv.SafePointKV, error) {
+		tlsConfig := opt.tlsConfig
+		if tlsConfig == nil {
+			var err error
+			if tlsConfig, err = config.GetGlobalConfig().Security.ToTLSConfig(); err != nil {
+				return nil, err
+			}
+		}
+		return tikv.NewEtcdSafePointKV(pdAddrs, tlsConfig, tikv.WithPrefix(opt.spKVPrefix))
+	})
+}
+
+// checkPDClient checks that the pd.Client given by WithPDClient is connected to the cluster served by pdAddrs, so that
+// the safe point kv dialing pdAddrs belongs to the same cluster.
+func checkPDClient(pdClient pd.Client, pdAddrs []string) error {
+	if pdClient.GetClusterID(context.Background()) == 0 {
+		return errors.New("the pd client is not connected to any cluster")
+	}
+	sd := pdClient.GetServiceDiscovery()
+	if sd == nil {
+		return nil
+	}
+	urls := sd.GetServiceURLs()
+	if len(urls) == 0 {
+		return nil
+	}
+	for _, url := range urls {
+		for _, addr := range pdAddrs {
+			if trimURLScheme(url) == trimURLScheme(addr) {
+				return nil
+			}
+		}
+	}
+	return errors.Errorf("the pd client is connected to %v, which doesn't match the pd addresses %v", urls, pdAddrs)
+}
+
+func trimURLScheme(url string) string {
+	url = strings.TrimPrefix(url, "http://")
+	return strings.TrimPrefix(url, "https://")
+}
+
+// NewClientWithPD creates a txn client with an existing pd.Client.
+// The safe point kv is kept in memory unless WithSafePointKV or WithSafePointKVFactory is given, since there is no
+// etcd endpoint to dial.
+// The pd.Client is not closed when the client is closed unless WithOwnedPDClient is given.
+func NewClientWithPD(pdClient pd.Client, opts ...ClientOpt) (*Client, error) {
+	opt, err := applyOptions(opts)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, err
+	}
+	if !opt.ownedPDClient {
+		pdClient = unownedPDClient{Client: pdClient}
 	}
+	return newClient(pdClient, opt, "in-memory", func() (tikv.SafePointKV, error) {
+		return tikv.NewMockSafePointKV(tikv.WithPrefix(opt.spKVPrefix)), nil
+	})
+}
 
+// newClient creates a txn client, the safe point kv is created by newSafePointKV unless it's given by the options,
+// spkvKind names the default safe point kv in the error.
+func newClient(pdClient pd.Client, opt *option, spkvKind string, newSafePointKV func() (tikv.SafePointKV, error)) (*Client, error) {
+	if err := opt.tso.apply(pdClient); err != nil {
+		return nil, err
+	}
+	var err error
 	pdClient = util.InterceptedPDClient{Client: pdClient}
 
 	// Construct codec from options.
@@ -84,7 +374,11 @@ func NewClient(pdAddrs []string, opts ...ClientOpt) (*Client, error) {
 	case kvrpcpb.APIVersion_V1:
 		codecCli = tikv.NewCodecPDClient(tikv.ModeTxn, pdClient)
 	case kvrpcpb.APIVersion_V2:
-		codecCli, err = tikv.NewCodecPDClientWithKeyspace(tikv.ModeTxn, pdClient, opt.keyspaceName)
+		if opt.withKeyspace {
+			codecCli, err = tikv.NewCodecPDClientWithKeyspaceID(tikv.ModeTxn, pdClient, opt.keyspaceID)
+		} else {
+			codecCli, err = tikv.NewCodecPDClientWithKeyspace(tikv.ModeTxn, pdClient, opt.keyspaceName)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -97,28 +391,153 @@ func NewClient(pdAddrs []string, opts ...ClientOpt) (*Client, error) {
 	cfg := config.GetGlobalConfig()
 	// init uuid
 	uuid := fmt.Sprintf("tikv-%v", pdClient.GetClusterID(context.TODO()))
-	tlsConfig, err := cfg.Security.ToTLSConfig()
-	if err != nil {
-		return nil, err
+
+	spkv := opt.spkv
+	if spkv == nil {
+		if opt.spkvFactory != nil {
+			spkvKind, newSafePointKV = "custom", opt.spkvFactory
+		}
+		spkv, err = newSafePointKV()
+		if err != nil {
+			return nil, errors.WithMessagef(err, "failed to create the %s safe point kv", spkvKind)
+		}
+	}
+
+	rpcOpts := []tikv.ClientOpt{tikv.WithSecurity(cfg.Security), tikv.WithCodec(codecCli.GetCodec())}
+	if opt.tlsConfig != nil {
+		rpcOpts = append(rpcOpts, tikv.WithTLSConfig(opt.tlsConfig))
 	}
+	rpcClient := tikv.NewRPCClient(rpcOpts...)
 
-	spkv, err := tikv.NewEtcdSafePointKV(pdAddrs, tlsConfig, tikv.WithPrefix(opt.spKVPrefix))
+	var storeOpts []tikv.Option
+	if opt.oracle != nil {
+		storeOpts = append(storeOpts, tikv.WithOracle(opt.oracle))
+	}
+	if opt.allowEmpty {
+		storeOpts = append(storeOpts, tikv.WithAllowEmptyValues(true))
+	}
+	if len(opt.clientName) > 0 {
+		storeOpts = append(storeOpts, tikv.WithClientName(opt.clientName))
+	}
+	s, err := tikv.NewKVStore(uuid, pdClient, spkv, rpcClient, storeOpts...)
 	if err != nil {
 		return nil, err
 	}
+	if cfg.TxnLocalLatches.Enabled {
+		s.EnableTxnLocalLatches(cfg.TxnLocalLatches.Capacity)
+	}
+	return &Client{
+		KVStore:      s,
+		codec:        codecCli.GetCodec(),
+		customOracle: opt.withOracle,
+	}, nil
+}
+
+// UpdateTSOOptions updates the TSO options of the PD client at runtime. It returns an error if the options are
+// invalid, the client uses the oracle given by WithOracle, or the PD client fails to update them, e.g. the TSO
+// follower proxy is only supported in the PD service mode. The options updated before the error are kept.
+func (c *Client) UpdateTSOOptions(opts ...TSOOption) error {
+	var opt tsoOptions
+	for _, o := range opts {
+		o(&opt)
+	}
+	if err := opt.validate(); err != nil {
+		return err
+	}
+	if c.customOracle && !opt.isEmpty() {
+		return errors.New("the TSO options can't be updated for the oracle given by WithOracle")
+	}
+	return opt.apply(c.GetPDClient())
+}
+
+// ErrTxnsNotDrained is returned by CloseGracefully if some transactions are still active when the context is done.
+type ErrTxnsNotDrained struct {
+	ActiveTxns int
+}
 
-	rpcClient := tikv.NewRPCClient(tikv.WithSecurity(cfg.Security), tikv.WithCodec(codecCli.GetCodec()))
+func (e *ErrTxnsNotDrained) Error() string {
+	return fmt.Sprintf("%d transactions are not drained before close", e.ActiveTxns)
+}
+
+// Begin begins a transaction like KVStore.Begin. The transaction is tracked until it's committed or rolled back,
+// so that CloseGracefully can wait for it. It returns ErrTiDBShuttingDown once CloseGracefully is called.
+func (c *Client) Begin(opts ...tikv.TxnOption) (*transaction.KVTxn, error) {
+	c.txns.Lock()
+	if c.txns.closing {
+		c.txns.Unlock()
+		return nil, errors.WithStack(tikverr.ErrTiDBShuttingDown)
+	}
+	c.txns.active++
+	c.txns.Unlock()
 
-	s, err := tikv.NewKVStore(uuid, pdClient, spkv, rpcClient)
+	opts = append(opts, func(o *transaction.TxnOptions) {
+		onClose := o.OnClose
+		o.OnClose = func() {
+			if onClose != nil {
+				onClose()
+			}
+			c.txnDone()
+		}
+	})
+	txn, err := c.KVStore.Begin(opts...)
 	if err != nil {
+		c.txnDone()
 		return nil, err
 	}
-	if cfg.TxnLocalLatches.Enabled {
-		s.EnableTxnLocalLatches(cfg.TxnLocalLatches.Capacity)
+	return txn, nil
+}
+
+func (c *Client) txnDone() {
+	c.txns.Lock()
+	defer c.txns.Unlock()
+	c.txns.active--
+	if c.txns.active == 0 && c.txns.drained != nil {
+		close(c.txns.drained)
 	}
-	return &Client{KVStore: s}, nil
 }
 
+// CloseGracefully stops beginning new transactions, waits for the active ones to be committed or rolled back
+// until ctx is done, and then closes the client, which closes the KVStore, the safe point kv and the RPC client.
+// If some transactions are still active when ctx is done, the client is closed anyway and ErrTxnsNotDrained with
+// the count of them is returned.
+func (c *Client) CloseGracefully(ctx context.Context) error {
+	c.txns.Lock()
+	c.txns.closing = true
+	if c.txns.drained == nil {
+		c.txns.drained = make(chan struct{})
+		if c.txns.active == 0 {
+			close(c.txns.drained)
+		}
+	}
+	drained := c.txns.drained
+	c.txns.Unlock()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+	}
+	c.txns.Lock()
+	active := c.txns.active
+	c.txns.Unlock()
+
+	if err := c.Close(); err != nil {
+		return err
+	}
+	if active > 0 {
+		logutil.Logger(c.Ctx()).Warn("close client with active transactions", zap.Int("activeTxns", active))
+		return errors.WithStack(&ErrTxnsNotDrained{ActiveTxns: active})
+	}
+	return nil
+}
+
+// unownedPDClient wraps a pd.Client owned by the caller, it's not closed along with the client.
+type unownedPDClient struct {
+	pd.Client
+}
+
+// Close implements the pd.Client interface, it does nothing.
+func (c unownedPDClient) Close() {}
+
 // GetTimestamp returns the current global timestamp.
 func (c *Client) GetTimestamp(ctx context.Context) (uint64, error) {
 	bo := retry.NewBackofferWithVars(ctx, transaction.TsoMaxBackoff, nil)
@@ -128,3 +547,160 @@ func (c *Client) GetTimestamp(ctx context.Context) (uint64, error) {
 	}
 	return startTS, nil
 }
+
+// TSOptions bounds the retries of GetTimestampWithOptions.
+type TSOptions struct {
+	// MaxAttempts is the max number of requests sent to the oracle, 0 means no limit.
+	MaxAttempts int
+	// MaxBackoff is the max total time to back off between the requests, 0 means the default of GetTimestamp.
+	MaxBackoff time.Duration
+	// FailFastOnNoLeader makes it return ErrPDNoLeader at once if the oracle reports that PD has no leader,
+	// instead of waiting for the leader switch to finish.
+	FailFastOnNoLeader bool
+}
+
+// TSODetails describes how a timestamp is got by GetTimestampWithOptions.
+type TSODetails struct {
+	// Attempts is the number of requests sent to the oracle.
+	Attempts int
+	// TotalBackoff is the total time backed off between the requests.
+	TotalBackoff time.Duration
+	// ServedBy is the URL of the PD leader when the timestamp is got, it's empty if no timestamp is got.
+	ServedBy string
+}
+
+// GetTimestampWithOptions returns the current global timestamp like GetTimestamp, but the retries on transient
+// errors such as PD leader switch are bounded by opts and reported in the returned TSODetails.
+func (c *Client) GetTimestampWithOptions(ctx context.Context, opts TSOptions) (uint64, TSODetails, error) {
+	maxBackoff := transaction.TsoMaxBackoff
+	if opts.MaxBackoff > 0 {
+		maxBackoff = int(opts.MaxBackoff.Milliseconds())
+	}
+	bo := retry.NewBackofferWithVars(ctx, maxBackoff, nil)
+	var details TSODetails
+	for {
+		details.Attempts++
+		ts, err := c.GetOracle().GetTimestamp(bo.GetCtx(), &oracle.Option{TxnScope: oracle.GlobalTxnScope})
+		if err == nil {
+			details.ServedBy = c.GetPDClient().GetLeaderURL()
+			return ts, details, nil
+		}
+		if opts.FailFastOnNoLeader && pderr.IsLeaderChange(err) {
+			return 0, details, errors.WithStack(&tikverr.ErrPDNoLeader{Reason: err.Error()})
+		}
+		if opts.MaxAttempts > 0 && details.Attempts >= opts.MaxAttempts {
+			return 0, details, errors.Errorf("get timestamp failed after %d attempts: %v", details.Attempts, err)
+		}
+		err = bo.Backoff(retry.BoPDRPC, errors.Errorf("get timestamp failed: %v", err))
+		details.TotalBackoff = time.Duration(bo.GetTotalSleep()) * time.Millisecond
+		if err != nil {
+			return 0, details, err
+		}
+	}
+}
+
+// pingMaxBackoff bounds the backoff of Ping, it's much shorter than TsoMaxBackoff so that an unreachable cluster is
+// reported soon.
+const pingMaxBackoff = 2 * time.Second
+
+// maxTimestampBatch is the max count of timestamps got by GetTimestampBatch, which is the count of the logical
+// timestamps in one physical tick.
+const maxTimestampBatch = 1 << 18
+
+// GetTimestampBatch returns n distinct global timestamps in ascending order. The requests are sent to the oracle
+// together so that the PD client batches them into a few TSO requests, which saves the round trips of calling
+// GetTimestamp n times. The failed requests are retried like GetTimestamp. n must be in (0, 262144].
+func (c *Client) GetTimestampBatch(ctx context.Context, n int) ([]uint64, error) {
+	if n <= 0 || n > maxTimestampBatch {
+		return nil, errors.Errorf("invalid timestamp batch size %d, it should be in (0, %d]", n, maxTimestampBatch)
+	}
+	bo := retry.NewBackofferWithVars(ctx, transaction.TsoMaxBackoff, nil)
+	o := c.GetOracle()
+	opt := &oracle.Option{TxnScope: oracle.GlobalTxnScope}
+	tss := make([]uint64, 0, n)
+	futures := make([]oracle.Future, 0, n)
+	for {
+		futures = futures[:0]
+		for i := len(tss); i < n; i++ {
+			futures = append(futures, o.GetTimestampAsync(bo.GetCtx(), opt))
+		}
+		var lastErr error
+		for _, f := range futures {
+			ts, err := f.Wait()
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			tss = append(tss, ts)
+		}
+		if lastErr == nil {
+			break
+		}
+		if err := bo.Backoff(retry.BoPDRPC, errors.Errorf("get timestamp batch failed: %v", lastErr)); err != nil {
+			return nil, err
+		}
+	}
+	slices.Sort(tss)
+	return tss, nil
+}
+
+// Ping checks whether the cluster is reachable by getting a timestamp from the oracle, which is lightweight enough for
+// the readiness probes. The retries back off for at most 2s, and they stop once ctx is done or the client is closed.
+// It returns ErrClientClosed if the client is closed.
+func (c *Client) Ping(ctx context.Context) error {
+	if c.IsClose() {
+		return errors.WithStack(tikverr.ErrClientClosed)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(c.Ctx(), cancel)
+	defer stop()
+	if _, _, err := c.GetTimestampWithOptions(ctx, TSOptions{MaxBackoff: pingMaxBackoff}); err != nil {
+		if c.IsClose() {
+			return errors.WithStack(tikverr.ErrClientClosed)
+		}
+		return errors.WithMessage(err, "failed to ping the cluster")
+	}
+	return nil
+}
+
+// KeyspaceID returns the ID of the keyspace the client is bound to by WithKeyspace or WithKeyspaceID with APIv2,
+// which is the one of the default keyspace if neither is given. The second returned value is false with APIv1, which
+// binds no keyspace.
+func (c *Client) KeyspaceID() (uint32, bool) {
+	if c.codec.GetAPIVersion() != kvrpcpb.APIVersion_V2 {
+		return 0, false
+	}
+	return uint32(c.codec.GetKeyspaceID()), true
+}
+
+// KeyspaceName returns the name of the keyspace the client is bound to with APIv2, see KeyspaceID. It's empty with
+// APIv1 or WithKeyspaceID.
+func (c *Client) KeyspaceName() string {
+	return c.codec.GetKeyspaceMeta().GetName()
+}
+
+// KeyspaceMeta returns a copy of the keyspace meta loaded when the client is created, see KeyspaceID. It's nil with
+// APIv1, and only the ID is set with WithKeyspaceID.
+func (c *Client) KeyspaceMeta() *keyspacepb.KeyspaceMeta {
+	meta := c.codec.GetKeyspaceMeta()
+	if meta == nil {
+		return nil
+	}
+	return proto.Clone(meta).(*keyspacepb.KeyspaceMeta)
+}
+
+// ContainsKey returns whether the key encoded by the codec of the client, i.e. the key stored in TiKV, is in the
+// range of the keyspace the client is bound to. It's always true with APIv1.
+func (c *Client) ContainsKey(encodedKey []byte) bool {
+	return apicodec.ContainsKey(c.codec, encodedKey)
+}
+
+// GetRegionCache returns the region cache of the client, e.g. to locate the keys or to load the regions by
+// BatchLoadRegionsFromKey. The regions are loaded through the codec of the client, so the keys to locate and the
+// region boundaries returned are in the key space of the transactions, not the encoded keys stored in TiKV. The
+// keys must be encoded by the codec, which is returned by GetCodec of the CodecPDClient returned by GetPDClient,
+// before they're sent to TiKV directly.
+func (c *Client) GetRegionCache() *tikv.RegionCache {
+	return c.KVStore.GetRegionCache()
+}
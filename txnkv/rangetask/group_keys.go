@@ -0,0 +1,33 @@
+// Copyright 2024 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rangetask
+
+import "context"
+
+// GroupKeysByRegion groups the keys by the ID of the region they belong to, which is convenient to build
+// per-region requests for a batch operation. The keys are located with the store's region cache, regions that
+// are not cached yet are loaded from PD. Keys in the same group keep their relative order in keys.
+func GroupKeysByRegion(ctx context.Context, store storage, keys [][]byte) (map[uint64][][]byte, error) {
+	bo := NewLocateRegionBackoffer(ctx)
+	groups, _, err := store.GetRegionCache().GroupKeysByRegion(bo, keys, nil)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[uint64][][]byte, len(groups))
+	for id, g := range groups {
+		res[id.GetID()] = g
+	}
+	return res, nil
+}
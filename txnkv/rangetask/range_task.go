@@ -37,24 +37,35 @@ package rangetask
 import (
 	"bytes"
 	"context"
+	"fmt"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pkg/errors"
 	"github.com/tikv/client-go/v2/config/retry"
 	"github.com/tikv/client-go/v2/internal/logutil"
 	"github.com/tikv/client-go/v2/kv"
 	"github.com/tikv/client-go/v2/metrics"
+	"github.com/tikv/client-go/v2/util"
+	"github.com/tikv/client-go/v2/util/redact"
 	"go.uber.org/zap"
+	"golang.org/x/time/rate"
 )
 
 const (
 	rangeTaskDefaultStatLogInterval = time.Minute * 10
 	defaultRegionsPerTask           = 128
+	defaultTaskRetryBaseBackoff     = 100 * time.Millisecond
+	defaultTaskRetryMaxBackoff      = 10 * time.Second
+	adaptiveBatchFastDuration       = 100 * time.Millisecond
+	adaptiveBatchSlowDuration       = time.Second
 
 	lblCompletedRegions = "completed-regions"
 	lblFailedRegions    = "failed-regions"
+	lblSkippedRegions   = "skipped-regions"
 )
 
 // Runner splits a range into many ranges to process concurrently, and convenient to send requests to all
@@ -66,24 +77,64 @@ type Runner struct {
 	// identifier can be a unique identifier for each runner, which is used for logging
 	identifier      string
 	store           storage
-	concurrency     int
 	handler         TaskHandler
 	statLogInterval time.Duration
 	regionsPerTask  int
+	// adaptiveMinRegions and adaptiveMaxRegions bound the regions of a task when it's adapted to the handler latency
+	// by SetAdaptiveBatching, zero means regionsPerTask is static.
+	adaptiveMinRegions int
+	adaptiveMaxRegions int
+	// regionLimiter limits how many regions the workers handle per second, see SetMaxRegionsPerSecond.
+	regionLimiter regionLimiter
+
+	// taskMaxRetry is the max times to retry a task whose error is retryable.
+	taskMaxRetry int
+	// retryBackoffer is set by SetTaskRetryBackoffer, each task backs off with a clone of it by retryBoType instead
+	// of the backoff set by SetTaskRetryBackoff.
+	retryBackoffer   *retry.Backoffer
+	retryBoType      *retry.Config
+	retryBaseBackoff time.Duration
+	retryMaxBackoff  time.Duration
+	isRetryable      func(error) bool
+	progressCallback func(stat TaskStat, lastKey []byte)
+	// slowTaskThreshold is how long a handler invocation takes to be logged as slow, zero means no slow log.
+	slowTaskThreshold time.Duration
+
+	// quiesceCh is closed by Quiesce to stop feeding new tasks.
+	quiesceCh   chan struct{}
+	quiesceOnce sync.Once
+
+	// mu protects concurrency and run, which are changed by SetConcurrency while a run is in progress.
+	mu          sync.Mutex
+	concurrency int
+	run         *rangeTaskRun
 
 	completedRegions int32
 	failedRegions    int32
+	skippedRegions   int32
+	// resumeKey tracks the end of the completed prefix of the current or the last run, see ResumeKey.
+	resumeKey resumeKeyTracker
+	// adaptiveRegions is how many regions are loaded for the next task when the batching is adaptive.
+	adaptiveRegions int32
+	// handleDurations collects the durations of the handler invocations of the current run.
+	handleDurations handleDurations
 }
 
 // TaskStat is used to count Regions that completed or failed to do the task.
 type TaskStat struct {
 	CompletedRegions int
 	FailedRegions    int
+	// SkippedRegions counts the Regions that have nothing to do, e.g. empty Regions of a sparse keyspace, which are
+	// counted as neither completed nor failed.
+	SkippedRegions int
 }
 
 // TaskHandler is the type of functions that processes a task of a key range.
 // The function should calculate Regions that succeeded or failed to the task.
 // Returning error from the handler means the error caused the whole task should be stopped.
+// The epoch of the region containing r.StartKey can be read from ctx by util.RegionEpochFromCtx without another
+// region cache lookup. It reflects the region when the regions are loaded, which may be stale by the time the
+// handler runs.
 type TaskHandler = func(ctx context.Context, r kv.KeyRange) (TaskStat, error)
 
 // NewRangeTaskRunner creates a RangeTaskRunner.
@@ -119,13 +170,16 @@ func NewRangeTaskRunnerWithID(
 		id = name
 	}
 	return &Runner{
-		name:            name,
-		identifier:      id,
-		store:           store,
-		concurrency:     concurrency,
-		handler:         handler,
-		statLogInterval: rangeTaskDefaultStatLogInterval,
-		regionsPerTask:  defaultRegionsPerTask,
+		name:             name,
+		identifier:       id,
+		store:            store,
+		concurrency:      concurrency,
+		handler:          handler,
+		statLogInterval:  rangeTaskDefaultStatLogInterval,
+		regionsPerTask:   defaultRegionsPerTask,
+		retryBaseBackoff: defaultTaskRetryBaseBackoff,
+		retryMaxBackoff:  defaultTaskRetryMaxBackoff,
+		quiesceCh:        make(chan struct{}),
 	}
 }
 
@@ -143,6 +197,118 @@ func (s *Runner) SetRegionsPerTask(regionsPerTask int) {
 	s.regionsPerTask = regionsPerTask
 }
 
+// SetAdaptiveBatching makes the regions of a task adapt to how long the handler takes, within [minRegions,
+// maxRegions]. Each run starts from minRegions regions per task, a task handled faster than 100ms doubles the regions
+// of the following tasks, and a task handled slower than 1s halves them. It overrides SetRegionsPerTask, which keeps
+// the regions of a task static by default.
+func (s *Runner) SetAdaptiveBatching(minRegions, maxRegions int) {
+	if minRegions < 1 || maxRegions < minRegions {
+		panic("RangeTaskRunner: adaptive batching requires 1 <= minRegions <= maxRegions")
+	}
+	s.adaptiveMinRegions = minRegions
+	s.adaptiveMaxRegions = maxRegions
+}
+
+// SetConcurrency sets how many workers process the tasks concurrently. It can be called while RunOnRange is running,
+// then more workers are spawned at once if it's increased, or the excess workers exit after finishing their current
+// tasks if it's decreased.
+func (s *Runner) SetConcurrency(concurrency int) {
+	if concurrency < 1 {
+		panic("RangeTaskRunner: concurrency should be at least 1")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.concurrency = concurrency
+	if s.run != nil {
+		s.adjustWorkers()
+	}
+}
+
+func (s *Runner) getConcurrency() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.concurrency
+}
+
+// SetTaskMaxRetry sets how many times a task is retried if the handler returns a retryable error, which is
+// checked by the checker set by SetRetryableChecker. The whole job is canceled only after the retries are exhausted.
+func (s *Runner) SetTaskMaxRetry(n int) {
+	s.taskMaxRetry = n
+}
+
+// SetTaskRetryBackoff sets the backoff between retries of a task, it grows exponentially from base to max.
+func (s *Runner) SetTaskRetryBackoff(base, max time.Duration) {
+	s.retryBaseBackoff = base
+	s.retryMaxBackoff = max
+}
+
+// SetTaskRetryBackoffer makes the retries of a task back off by boType with backoff instead of the backoff set by
+// SetTaskRetryBackoff. Each task backs off with its own clone of backoff, so the retries of a task also stop once
+// the max sleep of backoff is exceeded, before SetTaskMaxRetry is reached. A nil backoff restores the backoff set by
+// SetTaskRetryBackoff. It doesn't change which errors are retryable.
+func (s *Runner) SetTaskRetryBackoffer(backoff *retry.Backoffer, boType *retry.Config) {
+	if backoff != nil && boType == nil {
+		panic("RangeTaskRunner: the backoff type of the retry backoffer is required")
+	}
+	s.retryBackoffer = backoff
+	s.retryBoType = boType
+}
+
+// SetRetryableChecker sets the function to check whether an error returned by the handler is retryable.
+// By default no error is retryable.
+func (s *Runner) SetRetryableChecker(isRetryable func(error) bool) {
+	s.isRetryable = isRetryable
+}
+
+// SetProgressCallback sets the function called after the handler processes each task successfully. It's called with
+// the stat returned by the handler and the end key of the task, which can be used to report the progress of the whole
+// job. A task whose handler fails after the retries is not reported, so the failed ranges never look like progress.
+// The callback is called concurrently by the workers without holding any lock, a nil callback disables it.
+func (s *Runner) SetProgressCallback(cb func(stat TaskStat, lastKey []byte)) {
+	s.progressCallback = cb
+}
+
+// SetSlowTaskThreshold makes a handler invocation taking longer than d log a warning with its range and stat, each
+// retry of a task is an invocation. Zero or negative disables the slow log, which is the default.
+func (s *Runner) SetSlowTaskThreshold(d time.Duration) {
+	s.slowTaskThreshold = d
+}
+
+// SetRateLimit sets the max number of regions processed per second. It's the same as SetMaxRegionsPerSecond, both
+// set the only limiter of the runner, so the later call overrides the earlier one instead of adding another limit.
+// Zero or negative means unlimited, which is the default.
+func (s *Runner) SetRateLimit(regionsPerSecond float64) {
+	s.SetMaxRegionsPerSecond(regionsPerSecond)
+}
+
+// SetMaxRegionsPerSecond limits how many regions are handled per second by all the workers. Before invoking the
+// handler, a worker waits for the tokens of the regions loaded for the task, and then the difference to the regions
+// completed by the handler is reconciled, so that the throughput converges to the limit in the long run. It can be
+// called while RunOnRange is running to adjust the limit, zero or negative means unlimited, which is the default.
+// It shares the limiter with SetRateLimit.
+func (s *Runner) SetMaxRegionsPerSecond(n float64) {
+	s.regionLimiter.setLimit(n, max(s.regionsPerTask, s.adaptiveMaxRegions))
+}
+
+// Quiesce stops the runner from loading new regions and sending new tasks to the workers, while the tasks already
+// sent are still processed. RunOnRange then returns nil after the workers finish them, unless a task fails. Unlike
+// canceling the context, no in-flight work is abandoned. It's safe to be called concurrently with RunOnRange, and
+// the runner stays quiesced, so later calls to RunOnRange return without running any task.
+func (s *Runner) Quiesce() {
+	s.quiesceOnce.Do(func() {
+		close(s.quiesceCh)
+	})
+}
+
+func (s *Runner) isQuiesced() bool {
+	select {
+	case <-s.quiesceCh:
+		return true
+	default:
+		return false
+	}
+}
+
 const locateRegionMaxBackoff = 20000
 
 // NewLocateRegionBackoffer creates the backoofer for LocateRegion request.
@@ -153,38 +319,148 @@ func NewLocateRegionBackoffer(ctx context.Context) *retry.Backoffer {
 // RunOnRange runs the task on the given range.
 // Empty startKey or endKey means unbounded.
 func (s *Runner) RunOnRange(ctx context.Context, startKey, endKey []byte) error {
-	s.completedRegions = 0
+	_, err := s.runOnRange(ctx, startKey, startKey, endKey, false)
+	return err
+}
+
+// RunOnRangeBestEffort runs the task on the given range like RunOnRange, but a task whose handler fails doesn't cancel
+// the whole job, the iteration continues with the following tasks instead. It's for best-effort sweeps that process as
+// much as possible. The failures of the handler are returned as *TaskError ordered by their ranges, along with the
+// regions counted by the handler like RunOnRangeWithStat. Failing to load regions or canceling ctx still stops the
+// job, then the error is appended to the returned errors.
+func (s *Runner) RunOnRangeBestEffort(ctx context.Context, startKey, endKey []byte) (TaskStat, []error) {
+	taskErrs, err := s.runOnRange(ctx, startKey, startKey, endKey, true)
+	if err != nil {
+		taskErrs = append(taskErrs, err)
+	}
+	return TaskStat{
+		CompletedRegions: s.CompletedRegions(),
+		FailedRegions:    s.FailedRegions(),
+		SkippedRegions:   s.SkippedRegions(),
+	}, taskErrs
+}
+
+// TaskError is the error of a task which failed in RunOnRangeBestEffort, it wraps the error returned by the handler.
+type TaskError struct {
+	Range kv.KeyRange
+	Err   error
+}
+
+func (e *TaskError) Error() string {
+	return fmt.Sprintf("range task failed on [%s, %s): %v", redact.Key(e.Range.StartKey), redact.Key(e.Range.EndKey), e.Err)
+}
+
+// Unwrap returns the error returned by the handler.
+func (e *TaskError) Unwrap() error {
+	return e.Err
+}
+
+// RunOnRangeFrom runs the task on the given range like RunOnRange, but the regions are iterated from resumeKey,
+// so that an interrupted job can be resumed from the key returned by ResumeKey without redoing the completed part.
+// The key reported by the progress callback must not be used to resume, since the tasks are completed out of order
+// by the concurrent workers, and the tasks before it may still be running or have failed. resumeKey must be within
+// [startKey, endKey), and the logs still report the whole range.
+func (s *Runner) RunOnRangeFrom(ctx context.Context, resumeKey, startKey, endKey []byte) error {
+	if bytes.Compare(resumeKey, startKey) < 0 || (len(endKey) != 0 && bytes.Compare(resumeKey, endKey) >= 0) {
+		return errors.Errorf("resume key %s is out of range [%s, %s)",
+			redact.Key(resumeKey), redact.Key(startKey), redact.Key(endKey))
+	}
+	_, err := s.runOnRange(ctx, resumeKey, startKey, endKey, false)
+	return err
+}
+
+// ResumeKey returns the key to resume the current or the last run on a single range by RunOnRangeFrom. It's the end
+// key of the longest prefix of the range whose tasks are all completed successfully, or the key the run starts from
+// if there is no such task. It's updated before the progress callback of the task is called, so it can be read in
+// the callback to save a checkpoint. If the run returns nil, the whole range is completed and there is nothing to
+// resume. It returns nil if no run on a single range has started, or the last run is started by RunOnRanges.
+func (s *Runner) ResumeKey() []byte {
+	return s.resumeKey.get()
+}
+
+// RunOnRangeWithStat runs the task on the given range like RunOnRange, and returns the regions counted by the
+// handler during this run. The stat is taken after all workers exit, so it's returned even if the task fails, and
+// it isn't affected by later runs.
+func (s *Runner) RunOnRangeWithStat(ctx context.Context, startKey, endKey []byte) (TaskStat, error) {
+	_, err := s.runOnRange(ctx, startKey, startKey, endKey, false)
+	return TaskStat{
+		CompletedRegions: s.CompletedRegions(),
+		FailedRegions:    s.FailedRegions(),
+		SkippedRegions:   s.SkippedRegions(),
+	}, err
+}
+
+// RunOnRanges runs the task on multiple key ranges in one run, sharing the workers, the progress log, the counters
+// and the cancellation of the whole run. Empty startKey or endKey means unbounded, and each range must not be empty.
+// The ranges may overlap, then the overlapped part is processed once for each range. The tasks are drawn from the
+// ranges in turn, so that a huge range doesn't delay the small ones.
+func (s *Runner) RunOnRanges(ctx context.Context, ranges []kv.KeyRange) error {
+	cursors := make([]*rangeCursor, 0, len(ranges))
+	for _, r := range ranges {
+		if len(r.EndKey) != 0 && bytes.Compare(r.StartKey, r.EndKey) >= 0 {
+			return errors.Errorf("invalid range [%s, %s)", redact.Key(r.StartKey), redact.Key(r.EndKey))
+		}
+		cursors = append(cursors, &rangeCursor{key: r.StartKey, endKey: r.EndKey})
+	}
+	s.resumeKey.reset(nil, false)
+	_, err := s.runOnRanges(ctx, cursors, false, zap.Int("ranges", len(ranges)))
+	return err
+}
+
+// runOnRange runs the task on [resumeKey, endKey), startKey is only used for logging.
+func (s *Runner) runOnRange(ctx context.Context, resumeKey, startKey, endKey []byte, bestEffort bool) ([]error, error) {
+	var cursors []*rangeCursor
+	if len(endKey) == 0 || bytes.Compare(resumeKey, endKey) < 0 {
+		cursors = []*rangeCursor{{key: resumeKey, endKey: endKey}}
+	}
+	// The tasks of a single range are sent in the order of their keys, so the completed prefix can be tracked.
+	s.resumeKey.reset(resumeKey, true)
+	return s.runOnRanges(ctx, cursors, bestEffort, zap.String("startKey", redact.Key(startKey)), zap.String("endKey", redact.Key(endKey)))
+}
+
+// rangeCursor is where the next task of a range starts.
+type rangeCursor struct {
+	key    []byte
+	endKey []byte
+}
+
+// runOnRanges runs the task on the ranges of cursors, rangeFields describe the ranges in the logs. If bestEffort is
+// set, the failed tasks are returned as taskErrs instead of canceling the run, and err is what stops the run.
+func (s *Runner) runOnRanges(ctx context.Context, cursors []*rangeCursor, bestEffort bool, rangeFields ...zap.Field) (taskErrs []error, err error) {
+	// The counters are reset, so that they only count the regions of this run.
+	atomic.StoreInt32(&s.completedRegions, 0)
+	atomic.StoreInt32(&s.failedRegions, 0)
+	atomic.StoreInt32(&s.skippedRegions, 0)
+	atomic.StoreInt32(&s.adaptiveRegions, int32(s.adaptiveMinRegions))
+	s.handleDurations.reset()
 	metrics.TiKVRangeTaskStats.WithLabelValues(s.name, lblCompletedRegions).Set(0)
 
-	if len(endKey) != 0 && bytes.Compare(startKey, endKey) >= 0 {
-		logutil.Logger(ctx).Info("empty range task executed. ignored",
-			zap.String("name", s.identifier),
-			zap.String("startKey", kv.StrKey(startKey)),
-			zap.String("endKey", kv.StrKey(endKey)))
-		return nil
+	logger := logutil.Logger(ctx).With(zap.String("name", s.identifier)).With(rangeFields...)
+	if len(cursors) == 0 {
+		logger.Info("empty range task executed. ignored")
+		return nil, nil
 	}
 
-	logutil.Logger(ctx).Info("range task started",
-		zap.String("name", s.identifier),
-		zap.String("startKey", kv.StrKey(startKey)),
-		zap.String("endKey", kv.StrKey(endKey)),
-		zap.Int("concurrency", s.concurrency))
+	if len(cursors) == 1 {
+		logger.Info("range task started",
+			zap.String("resumeKey", redact.Key(cursors[0].key)),
+			zap.Int("concurrency", s.getConcurrency()))
+	} else {
+		logger.Info("range task started", zap.Int("concurrency", s.getConcurrency()))
+	}
 
 	// Periodically log the progress
 	statLogTicker := time.NewTicker(s.statLogInterval)
 
 	ctx, cancel := context.WithCancel(ctx)
-	taskCh := make(chan *kv.KeyRange, s.concurrency)
-	var wg sync.WaitGroup
+	run := &rangeTaskRun{ctx: ctx, cancel: cancel, bestEffort: bestEffort}
 
 	// Create workers that concurrently process the whole range.
-	workers := make([]*rangeTaskWorker, 0, s.concurrency)
-	for i := 0; i < s.concurrency; i++ {
-		w := s.createWorker(taskCh, &wg)
-		workers = append(workers, w)
-		wg.Add(1)
-		go w.run(ctx, cancel)
-	}
+	s.mu.Lock()
+	run.taskCh = make(chan *rangeTaskItem, s.concurrency)
+	s.run = run
+	s.adjustWorkers()
+	s.mu.Unlock()
 
 	startTime := time.Now()
 
@@ -192,109 +468,304 @@ func (s *Runner) RunOnRange(ctx context.Context, startKey, endKey []byte) error
 	isClosed := false
 	defer func() {
 		if !isClosed {
-			close(taskCh)
-			wg.Wait()
+			s.finishRun(run)
 		}
 		statLogTicker.Stop()
 		cancel()
 		metrics.TiKVRangeTaskStats.WithLabelValues(s.name, lblCompletedRegions).Set(0)
 	}()
 
-	// Iterate all regions and send each region's range as a task to the workers.
-	key := startKey
+	// feedCtx is canceled by Quiesce to interrupt feeding tasks, the workers keep using ctx.
+	feedCtx, feedCancel := context.WithCancel(ctx)
+	defer feedCancel()
+	go func() {
+		select {
+		case <-s.quiesceCh:
+			feedCancel()
+		case <-feedCtx.Done():
+		}
+	}()
+
+	// Iterate all regions and send each region's range as a task to the workers, taking the ranges in turn.
+	next := 0
+	var seq uint64
 Loop:
-	for {
+	for len(cursors) > 0 {
+		if s.isQuiesced() {
+			break
+		}
+
 		select {
 		case <-statLogTicker.C:
-			logutil.Logger(ctx).Info("range task in progress",
-				zap.String("name", s.identifier),
-				zap.String("startKey", kv.StrKey(startKey)),
-				zap.String("endKey", kv.StrKey(endKey)),
-				zap.Int("concurrency", s.concurrency),
-				zap.Duration("cost time", time.Since(startTime)),
-				zap.Int("completed regions", s.CompletedRegions()))
+			costTime := time.Since(startTime)
+			logger.Info("range task in progress",
+				zap.Int("concurrency", s.getConcurrency()),
+				zap.Duration("cost time", costTime),
+				zap.Int("completed regions", s.CompletedRegions()),
+				zap.Float64("regions per second", float64(s.CompletedRegions())/costTime.Seconds()),
+				zap.Float64("max regions per second", s.regionLimiter.limit()))
 		default:
 		}
 
-		bo := NewLocateRegionBackoffer(ctx)
+		bo := NewLocateRegionBackoffer(feedCtx)
 
-		rangeEndKey, err := s.store.GetRegionCache().BatchLoadRegionsFromKey(bo, key, s.regionsPerTask)
+		regionsPerTask := s.nextRegionsPerTask()
+		cursor := cursors[next]
+		task, regions, isLast, err := s.nextTaskRange(bo, cursor.key, cursor.endKey, regionsPerTask)
 		if err != nil {
-			logutil.Logger(ctx).Info("range task try to get range end key failure",
-				zap.String("name", s.identifier),
-				zap.String("startKey", kv.StrKey(startKey)),
-				zap.String("endKey", kv.StrKey(endKey)),
-				zap.String("loadRegionKey", kv.StrKey(key)),
+			if s.isQuiesced() {
+				break Loop
+			}
+			logger.Info("range task try to get range end key failure",
+				zap.String("loadRegionKey", redact.Key(cursor.key)),
 				zap.Duration("cost time", time.Since(startTime)),
 				zap.Error(err))
-			return err
-		}
-		task := &kv.KeyRange{
-			StartKey: key,
-			EndKey:   rangeEndKey,
-		}
-
-		isLast := len(task.EndKey) == 0 || (len(endKey) > 0 && bytes.Compare(task.EndKey, endKey) >= 0)
-		// Let task.EndKey = min(endKey, loc.EndKey)
-		if isLast {
-			task.EndKey = endKey
+			return s.finishTaskErrs(run, &isClosed), err
 		}
 
 		pushTaskStartTime := time.Now()
 
+		if s.isQuiesced() {
+			break
+		}
+		item := &rangeTaskItem{KeyRange: task, regions: regions, seq: seq}
+		seq++
+		if loc := s.store.GetRegionCache().TryLocateKey(task.StartKey); loc != nil {
+			item.epoch = &metapb.RegionEpoch{ConfVer: loc.Region.GetConfVer(), Version: loc.Region.GetVer()}
+		}
 		select {
-		case taskCh <- task:
-		case <-ctx.Done():
+		case run.taskCh <- item:
+		case <-feedCtx.Done():
 			break Loop
 		}
 		metrics.TiKVRangeTaskPushDuration.WithLabelValues(s.name).Observe(time.Since(pushTaskStartTime).Seconds())
 
 		if isLast {
-			break
+			cursors = slices.Delete(cursors, next, next+1)
+		} else {
+			cursor.key = task.EndKey
+			next++
+		}
+		if next >= len(cursors) {
+			next = 0
 		}
-
-		key = task.EndKey
 	}
 
 	isClosed = true
-	close(taskCh)
-	wg.Wait()
+	workers := s.finishRun(run)
+	taskErrs = collectTaskErrs(workers)
+	if err := workersErr(workers); err != nil {
+		logger.Info("range task failed",
+			zap.Duration("cost time", time.Since(startTime)),
+			zap.Int("completed regions", s.CompletedRegions()),
+			zap.Int("failed regions", s.FailedRegions()),
+			zap.Int("skipped regions", s.SkippedRegions()),
+			zap.Error(err))
+		return taskErrs, errors.WithStack(err)
+	}
+	// The feeding stops silently once ctx is done, which must not be reported as a completed best-effort sweep.
+	if bestEffort && !s.isQuiesced() && ctx.Err() != nil {
+		return taskErrs, errors.WithStack(ctx.Err())
+	}
+
+	msg := "range task finished"
+	if s.isQuiesced() {
+		msg = "range task quiesced"
+	}
+	maxHandleTime, p99HandleTime := s.handleDurations.summary()
+	logger.Info(msg,
+		zap.Duration("cost time", time.Since(startTime)),
+		zap.Int("completed regions", s.CompletedRegions()),
+		zap.Int("failed regions", s.FailedRegions()),
+		zap.Int("skipped regions", s.SkippedRegions()),
+		zap.Int("failed tasks", len(taskErrs)),
+		zap.Duration("max handle time", maxHandleTime),
+		zap.Duration("p99 handle time", p99HandleTime))
+
+	return taskErrs, nil
+}
+
+// finishTaskErrs finishes the run when it's stopped by the feeding loop, and returns the failed tasks of a best-effort
+// run so far.
+func (s *Runner) finishTaskErrs(run *rangeTaskRun, isClosed *bool) []error {
+	if !run.bestEffort {
+		return nil
+	}
+	*isClosed = true
+	return collectTaskErrs(s.finishRun(run))
+}
+
+// collectTaskErrs returns the failed tasks recorded by the workers in the order of their ranges.
+func collectTaskErrs(workers []*rangeTaskWorker) []error {
+	var taskErrs []*TaskError
 	for _, w := range workers {
-		if w.err != nil {
-			logutil.Logger(ctx).Info("range task failed",
-				zap.String("name", s.identifier),
-				zap.String("startKey", kv.StrKey(startKey)),
-				zap.String("endKey", kv.StrKey(endKey)),
-				zap.Duration("cost time", time.Since(startTime)),
-				zap.Int("completed regions", s.CompletedRegions()),
-				zap.Int("failed regions", s.FailedRegions()),
-				zap.Error(w.err))
-			return errors.WithStack(w.err)
+		taskErrs = append(taskErrs, w.taskErrs...)
+	}
+	slices.SortFunc(taskErrs, func(a, b *TaskError) int {
+		return bytes.Compare(a.Range.StartKey, b.Range.StartKey)
+	})
+	errs := make([]error, 0, len(taskErrs))
+	for _, e := range taskErrs {
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// nextRegionsPerTask returns how many regions should be loaded for the next task.
+func (s *Runner) nextRegionsPerTask() int {
+	if s.adaptiveMaxRegions == 0 {
+		return s.regionsPerTask
+	}
+	return int(atomic.LoadInt32(&s.adaptiveRegions))
+}
+
+// EnumerateRanges returns the ranges of the tasks that RunOnRange would send to the handler, without running any
+// task. The regions are loaded the same way as RunOnRange with the regions per task set by SetRegionsPerTask, the
+// adaptive batching isn't applied. Since regions may split and merge, a later run may divide the range differently.
+func (s *Runner) EnumerateRanges(ctx context.Context, startKey, endKey []byte) ([]kv.KeyRange, error) {
+	plan, err := s.Plan(ctx, startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	return plan.Ranges, nil
+}
+
+// TaskPlan describes the tasks of a range that RunOnRange would send to the handler, see Plan.
+type TaskPlan struct {
+	// Ranges are the ranges of the tasks, which are truncated by the range.
+	Ranges []kv.KeyRange
+	// Regions is the count of the regions overlapping the range.
+	Regions int
+}
+
+// Plan is a dry run of RunOnRange, it returns the tasks that RunOnRange would send to the handler and the count of
+// the regions they cover, e.g. to preview a destructive job. The regions are loaded like EnumerateRanges, and neither
+// the handler nor the counters of the runner are touched.
+func (s *Runner) Plan(ctx context.Context, startKey, endKey []byte) (TaskPlan, error) {
+	plan := TaskPlan{Ranges: make([]kv.KeyRange, 0)}
+	if len(endKey) != 0 && bytes.Compare(startKey, endKey) >= 0 {
+		return plan, nil
+	}
+	key := startKey
+	for {
+		task, regions, isLast, err := s.nextTaskRange(NewLocateRegionBackoffer(ctx), key, endKey, s.regionsPerTask)
+		if err != nil {
+			return TaskPlan{}, err
 		}
+		plan.Ranges = append(plan.Ranges, *task)
+		plan.Regions += regions
+		if isLast {
+			return plan, nil
+		}
+		key = task.EndKey
 	}
+}
 
-	logutil.Logger(ctx).Info("range task finished",
-		zap.String("name", s.identifier),
-		zap.String("startKey", kv.StrKey(startKey)),
-		zap.String("endKey", kv.StrKey(endKey)),
-		zap.Duration("cost time", time.Since(startTime)),
-		zap.Int("completed regions", s.CompletedRegions()))
+// nextTaskRange loads regionsPerTask regions from key and returns the range of the next task, which is truncated
+// by endKey, and the count of the regions overlapping it. isLast reports whether it's the last task of the range.
+func (s *Runner) nextTaskRange(bo *retry.Backoffer, key, endKey []byte, regionsPerTask int) (task *kv.KeyRange, regions int, isLast bool, err error) {
+	loaded, err := s.store.GetRegionCache().BatchLoadRegionsWithKeyRange(bo, key, nil, regionsPerTask)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	task = &kv.KeyRange{
+		StartKey: key,
+		EndKey:   loaded[len(loaded)-1].EndKey(),
+	}
 
-	return nil
+	isLast = len(task.EndKey) == 0 || (len(endKey) > 0 && bytes.Compare(task.EndKey, endKey) >= 0)
+	// Let task.EndKey = min(endKey, loc.EndKey)
+	if isLast {
+		task.EndKey = endKey
+	}
+	for _, r := range loaded {
+		if len(task.EndKey) != 0 && bytes.Compare(r.StartKey(), task.EndKey) >= 0 {
+			break
+		}
+		regions++
+	}
+	return task, regions, isLast, nil
+}
+
+// rangeTaskRun is the state of a running RunOnRange, which is used by SetConcurrency to spawn or stop workers.
+type rangeTaskRun struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	taskCh chan *rangeTaskItem
+	wg     sync.WaitGroup
+	// workers contains all workers spawned during the run, including the stopped ones, so that their errors are
+	// still checked after the run.
+	workers []*rangeTaskWorker
+	// active is how many workers are not asked to stop.
+	active int
+	// bestEffort makes the workers record the failed tasks and go on, instead of canceling the run.
+	bestEffort bool
+}
+
+// adjustWorkers spawns or stops workers of the current run to match the concurrency. The caller must hold s.mu.
+func (s *Runner) adjustWorkers() {
+	run := s.run
+	for run.active < s.concurrency {
+		w := s.createWorker(run)
+		run.workers = append(run.workers, w)
+		run.active++
+		run.wg.Add(1)
+		go w.run(run.ctx, run.cancel)
+	}
+	// Stop the latest spawned workers first.
+	for i := len(run.workers) - 1; i >= 0 && run.active > s.concurrency; i-- {
+		if w := run.workers[i]; !w.stopped {
+			w.stopped = true
+			close(w.stopCh)
+			run.active--
+		}
+	}
 }
 
-// createWorker creates a worker that can process tasks from the given channel.
-func (s *Runner) createWorker(taskCh chan *kv.KeyRange, wg *sync.WaitGroup) *rangeTaskWorker {
+// finishRun detaches the run from the runner so that SetConcurrency no longer changes its workers, then closes the
+// task channel and waits for all workers to exit. It returns all workers spawned during the run.
+func (s *Runner) finishRun(run *rangeTaskRun) []*rangeTaskWorker {
+	s.mu.Lock()
+	s.run = nil
+	workers := run.workers
+	s.mu.Unlock()
+
+	close(run.taskCh)
+	run.wg.Wait()
+	return workers
+}
+
+// createWorker creates a worker that can process tasks of the given run.
+func (s *Runner) createWorker(run *rangeTaskRun) *rangeTaskWorker {
 	return &rangeTaskWorker{
 		name:       s.name,
 		identifier: s.identifier,
 		store:      s.store,
 		handler:    s.handler,
-		taskCh:     taskCh,
-		wg:         wg,
+		taskCh:     run.taskCh,
+		stopCh:     make(chan struct{}),
+		wg:         &run.wg,
+		bestEffort: run.bestEffort,
+
+		regionsPerTask:     s.regionsPerTask,
+		adaptiveMinRegions: s.adaptiveMinRegions,
+		adaptiveMaxRegions: s.adaptiveMaxRegions,
+		taskMaxRetry:       s.taskMaxRetry,
+		retryBackoffer:     s.retryBackoffer,
+		retryBoType:        s.retryBoType,
+		retryBaseBackoff:   s.retryBaseBackoff,
+		retryMaxBackoff:    s.retryMaxBackoff,
+		isRetryable:        s.isRetryable,
+		progressCallback:   s.progressCallback,
+		slowTaskThreshold:  s.slowTaskThreshold,
+		regionLimiter:      &s.regionLimiter,
+		resumeKey:          &s.resumeKey,
 
 		completedRegions: &s.completedRegions,
 		failedRegions:    &s.failedRegions,
+		skippedRegions:   &s.skippedRegions,
+		adaptiveRegions:  &s.adaptiveRegions,
+		handleDurations:  &s.handleDurations,
 	}
 }
 
@@ -308,6 +779,23 @@ func (s *Runner) FailedRegions() int {
 	return int(atomic.LoadInt32(&s.failedRegions))
 }
 
+// SkippedRegions returns how many regions has nothing to do for the task.
+func (s *Runner) SkippedRegions() int {
+	return int(atomic.LoadInt32(&s.skippedRegions))
+}
+
+// rangeTaskItem is a task fed to the workers.
+type rangeTaskItem struct {
+	*kv.KeyRange
+	// epoch is the epoch of the region containing the start key when the regions are loaded, it's nil if the
+	// region is not in the region cache.
+	epoch *metapb.RegionEpoch
+	// regions is the count of the regions loaded for the task.
+	regions int
+	// seq is the order the task is sent in the run.
+	seq uint64
+}
+
 // rangeTaskWorker is used by RangeTaskRunner to process tasks concurrently.
 type rangeTaskWorker struct {
 	// name is consistent across all runners of the same type, which is used for metrics
@@ -316,19 +804,58 @@ type rangeTaskWorker struct {
 	identifier string
 	store      storage
 	handler    TaskHandler
-	taskCh     chan *kv.KeyRange
-	wg         *sync.WaitGroup
+	taskCh     chan *rangeTaskItem
+	// stopCh is closed by SetConcurrency to make the worker exit after finishing its current task.
+	stopCh chan struct{}
+	// stopped is protected by Runner.mu.
+	stopped bool
+	wg      *sync.WaitGroup
+	// bestEffort makes the worker record the failed tasks in taskErrs and go on, see RunOnRangeBestEffort.
+	bestEffort bool
 
-	err error
+	regionsPerTask     int
+	adaptiveMinRegions int
+	adaptiveMaxRegions int
+	taskMaxRetry       int
+	retryBackoffer     *retry.Backoffer
+	retryBoType        *retry.Config
+	retryBaseBackoff   time.Duration
+	retryMaxBackoff    time.Duration
+	isRetryable        func(error) bool
+	progressCallback   func(stat TaskStat, lastKey []byte)
+	slowTaskThreshold  time.Duration
+	regionLimiter      *regionLimiter
+	resumeKey          *resumeKeyTracker
+
+	err      error
+	taskErrs []*TaskError
 
 	completedRegions *int32
 	failedRegions    *int32
+	skippedRegions   *int32
+	adaptiveRegions  *int32
+	handleDurations  *handleDurations
 }
 
 // run starts the worker. It collects all objects from `w.taskCh` and process them one by one.
 func (w *rangeTaskWorker) run(ctx context.Context, cancel context.CancelFunc) {
 	defer w.wg.Done()
-	for r := range w.taskCh {
+	for {
+		select {
+		case <-w.stopCh:
+			return
+		default:
+		}
+		var item *rangeTaskItem
+		select {
+		case item = <-w.taskCh:
+		case <-w.stopCh:
+			return
+		}
+		if item == nil {
+			return
+		}
+		r := item.KeyRange
 		select {
 		case <-ctx.Done():
 			w.err = ctx.Err()
@@ -336,18 +863,50 @@ func (w *rangeTaskWorker) run(ctx context.Context, cancel context.CancelFunc) {
 		default:
 		}
 
-		stat, err := w.handler(ctx, *r)
+		// the regions of the task are unknown before the handler runs, estimate them by the loaded ones.
+		estimatedRegions := item.regions
+		if estimatedRegions == 0 {
+			estimatedRegions = w.regionsPerTask
+		}
+		if err := w.regionLimiter.wait(ctx, estimatedRegions); err != nil {
+			w.err = err
+			return
+		}
+
+		handleStartTime := time.Now()
+		stat, err := w.handleWithRetry(util.WithRegionEpoch(ctx, item.epoch), r)
+		w.regionLimiter.reconcile(estimatedRegions, stat.CompletedRegions)
+		if err == nil {
+			w.adaptRegionsPerTask(time.Since(handleStartTime))
+		}
 
 		atomic.AddInt32(w.completedRegions, int32(stat.CompletedRegions))
 		atomic.AddInt32(w.failedRegions, int32(stat.FailedRegions))
 		metrics.TiKVRangeTaskStats.WithLabelValues(w.name, lblCompletedRegions).Add(float64(stat.CompletedRegions))
+		atomic.AddInt32(w.skippedRegions, int32(stat.SkippedRegions))
 		metrics.TiKVRangeTaskStats.WithLabelValues(w.name, lblFailedRegions).Add(float64(stat.FailedRegions))
+		metrics.TiKVRangeTaskStats.WithLabelValues(w.name, lblSkippedRegions).Add(float64(stat.SkippedRegions))
+		if err == nil {
+			w.resumeKey.complete(item.seq, r.EndKey)
+			if w.progressCallback != nil {
+				w.progressCallback(stat, r.EndKey)
+			}
+		}
 
+		if err != nil && w.bestEffort {
+			logutil.Logger(ctx).Info("range task failed, continuing with the next task",
+				zap.String("name", w.identifier),
+				zap.String("startKey", redact.Key(r.StartKey)),
+				zap.String("endKey", redact.Key(r.EndKey)),
+				zap.Error(err))
+			w.taskErrs = append(w.taskErrs, &TaskError{Range: *r, Err: err})
+			continue
+		}
 		if err != nil {
 			logutil.Logger(ctx).Info("canceling range task because of error",
 				zap.String("name", w.identifier),
-				zap.String("startKey", kv.StrKey(r.StartKey)),
-				zap.String("endKey", kv.StrKey(r.EndKey)),
+				zap.String("startKey", redact.Key(r.StartKey)),
+				zap.String("endKey", redact.Key(r.EndKey)),
 				zap.Error(err))
 			w.err = err
 			cancel()
@@ -355,3 +914,316 @@ func (w *rangeTaskWorker) run(ctx context.Context, cancel context.CancelFunc) {
 		}
 	}
 }
+
+// workersErr returns the error that stops the workers. A failed worker cancels the others, so the error of the
+// failed worker is preferred to the context errors of the canceled ones.
+func workersErr(workers []*rangeTaskWorker) error {
+	var err error
+	for _, w := range workers {
+		if w.err == nil {
+			continue
+		}
+		if err == nil || (isContextErr(err) && !isContextErr(w.err)) {
+			err = w.err
+		}
+	}
+	return err
+}
+
+func isContextErr(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// adaptRegionsPerTask adjusts the regions of the following tasks by the duration of handling a task, if the
+// batching is adaptive.
+func (w *rangeTaskWorker) adaptRegionsPerTask(d time.Duration) {
+	if w.adaptiveMaxRegions == 0 {
+		return
+	}
+	for {
+		cur := atomic.LoadInt32(w.adaptiveRegions)
+		next := cur
+		if d < adaptiveBatchFastDuration {
+			next = min(cur*2, int32(w.adaptiveMaxRegions))
+		} else if d > adaptiveBatchSlowDuration {
+			next = max(cur/2, int32(w.adaptiveMinRegions))
+		}
+		if next == cur || atomic.CompareAndSwapInt32(w.adaptiveRegions, cur, next) {
+			return
+		}
+	}
+}
+
+// retryable returns whether the task should be retried on err.
+func (w *rangeTaskWorker) retryable(err error) bool {
+	return w.isRetryable != nil && w.isRetryable(err)
+}
+
+// handleWithRetry runs the handler on the task, and retries it with backoff if the error is retryable.
+// Only the stat of the last attempt is returned, so that failed regions are counted once.
+func (w *rangeTaskWorker) handleWithRetry(ctx context.Context, r *kv.KeyRange) (TaskStat, error) {
+	stat, err := w.handle(ctx, r)
+	backoff := w.retryBaseBackoff
+	var bo *retry.Backoffer
+	if w.retryBackoffer != nil {
+		bo = w.retryBackoffer.Clone()
+		bo.SetCtx(ctx)
+	}
+	for attempt := 1; err != nil && attempt <= w.taskMaxRetry && w.retryable(err); attempt++ {
+		logutil.Logger(ctx).Info("range task failed, retrying",
+			zap.String("name", w.identifier),
+			zap.String("startKey", redact.Key(r.StartKey)),
+			zap.String("endKey", redact.Key(r.EndKey)),
+			zap.Int("attempt", attempt),
+			zap.Error(err))
+		if bo != nil {
+			if boErr := bo.Backoff(w.retryBoType, err); boErr != nil {
+				return stat, err
+			}
+		} else {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return stat, err
+			}
+			backoff *= 2
+			if backoff > w.retryMaxBackoff {
+				backoff = w.retryMaxBackoff
+			}
+		}
+		// The regions may have split or merged, reload them so that the handler can locate the new ones.
+		if _, loadErr := w.store.GetRegionCache().BatchLoadRegionsFromKey(NewLocateRegionBackoffer(ctx), r.StartKey, w.regionsPerTask); loadErr != nil {
+			logutil.Logger(ctx).Info("range task failed to reload regions before retry",
+				zap.String("name", w.identifier),
+				zap.String("startKey", redact.Key(r.StartKey)),
+				zap.Error(loadErr))
+		}
+		stat, err = w.handle(ctx, r)
+	}
+	return stat, err
+}
+
+// handle runs the handler on the task once, and records how long it takes.
+func (w *rangeTaskWorker) handle(ctx context.Context, r *kv.KeyRange) (TaskStat, error) {
+	start := time.Now()
+	stat, err := w.handler(ctx, *r)
+	d := time.Since(start)
+	metrics.TiKVRangeTaskHandleDuration.WithLabelValues(w.name).Observe(d.Seconds())
+	w.handleDurations.add(d)
+	if w.slowTaskThreshold > 0 && d > w.slowTaskThreshold {
+		logutil.Logger(ctx).Warn("range task handler is slow",
+			zap.String("name", w.identifier),
+			zap.String("startKey", redact.Key(r.StartKey)),
+			zap.String("endKey", redact.Key(r.EndKey)),
+			zap.Duration("cost time", d),
+			zap.Int("completed regions", stat.CompletedRegions),
+			zap.Int("failed regions", stat.FailedRegions),
+			zap.Error(err))
+	}
+	return stat, err
+}
+
+// regionLimiter is a token bucket of the regions shared by the workers, it's unlimited if limiter is nil.
+type regionLimiter struct {
+	mu      sync.Mutex
+	limiter *rate.Limiter
+	// changed is closed when the limit is changed, so that the waits for the previous limit start over.
+	changed chan struct{}
+	// credit is the tokens taken for the estimated regions that are not completed, which are deducted from the
+	// following waits. It's at most the burst of limiter.
+	credit int
+}
+
+func (l *regionLimiter) setLimit(regionsPerSecond float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if regionsPerSecond <= 0 {
+		l.limiter = nil
+		l.credit = 0
+	} else if l.limiter == nil {
+		l.limiter = rate.NewLimiter(rate.Limit(regionsPerSecond), burst)
+	} else {
+		l.limiter.SetLimit(rate.Limit(regionsPerSecond))
+	}
+	if l.changed != nil {
+		close(l.changed)
+	}
+	l.changed = make(chan struct{})
+}
+
+// limit returns the max regions per second, zero means unlimited.
+func (l *regionLimiter) limit() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limiter == nil {
+		return 0
+	}
+	return float64(l.limiter.Limit())
+}
+
+// wait blocks until n regions are allowed, it returns an error if ctx is done before that. If the limit is changed
+// while waiting, the remaining regions wait for the new limit.
+func (l *regionLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	credit := min(n, l.credit)
+	l.credit -= credit
+	l.mu.Unlock()
+
+	for n -= credit; n > 0; {
+		l.mu.Lock()
+		limiter, changed := l.limiter, l.changed
+		l.mu.Unlock()
+		if limiter == nil {
+			return nil
+		}
+		// a reservation can't exceed the burst, so the tokens are taken in batches.
+		batch := min(n, limiter.Burst())
+		r := limiter.ReserveN(time.Now(), batch)
+		if delay := r.Delay(); delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				r.Cancel()
+				return errors.WithStack(ctx.Err())
+			case <-changed:
+				timer.Stop()
+				r.Cancel()
+				continue
+			}
+		}
+		n -= batch
+	}
+	return nil
+}
+
+// reconcile corrects the tokens taken by wait for the estimated regions with the regions actually completed. The
+// excess regions are reserved without waiting, which delays the following waits instead.
+func (l *regionLimiter) reconcile(estimated, actual int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limiter == nil {
+		return
+	}
+	if actual < estimated {
+		l.credit = min(l.credit+estimated-actual, l.limiter.Burst())
+		return
+	}
+	now := time.Now()
+	for n := actual - estimated; n > 0; {
+		batch := min(n, l.limiter.Burst())
+		l.limiter.ReserveN(now, batch)
+		n -= batch
+	}
+}
+
+// resumeKeyTracker tracks the end key of the longest prefix of the completed tasks of a single range, whose tasks are
+// sent in the order of their keys but may be completed out of order.
+type resumeKeyTracker struct {
+	mu sync.Mutex
+	// enabled is false if the run isn't on a single range.
+	enabled bool
+	key     []byte
+	// next is the seq of the first task not completed.
+	next uint64
+	// completed are the end keys of the completed tasks after next, indexed by their seqs.
+	completed map[uint64][]byte
+}
+
+func (t *resumeKeyTracker) reset(startKey []byte, enabled bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.enabled = enabled
+	t.key = startKey
+	t.next = 0
+	t.completed = nil
+}
+
+func (t *resumeKeyTracker) complete(seq uint64, endKey []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.enabled {
+		return
+	}
+	if seq != t.next {
+		if t.completed == nil {
+			t.completed = make(map[uint64][]byte)
+		}
+		t.completed[seq] = endKey
+		return
+	}
+	t.key = endKey
+	for t.next++; ; t.next++ {
+		key, ok := t.completed[t.next]
+		if !ok {
+			return
+		}
+		t.key = key
+		delete(t.completed, t.next)
+	}
+}
+
+func (t *resumeKeyTracker) get() []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.enabled {
+		return nil
+	}
+	return t.key
+}
+
+// handleDurationBuckets is the number of the buckets of handleDurations, the upper bound of the i-th bucket is
+// 1ms<<i like the buckets of metrics.TiKVRangeTaskHandleDuration, and the durations beyond them are in the last one.
+const handleDurationBuckets = 20
+
+// handleDurations collects the durations of the handler invocations in fixed buckets, which are summarized when a
+// run finishes.
+type handleDurations struct {
+	maxDuration atomic.Int64
+	counts      [handleDurationBuckets + 1]atomic.Uint64
+}
+
+func (h *handleDurations) reset() {
+	h.maxDuration.Store(0)
+	for i := range h.counts {
+		h.counts[i].Store(0)
+	}
+}
+
+func (h *handleDurations) add(d time.Duration) {
+	for {
+		maxDuration := h.maxDuration.Load()
+		if int64(d) <= maxDuration || h.maxDuration.CompareAndSwap(maxDuration, int64(d)) {
+			break
+		}
+	}
+	i := 0
+	for i < handleDurationBuckets && d > time.Millisecond<<i {
+		i++
+	}
+	h.counts[i].Add(1)
+}
+
+// summary returns the max and the 99th percentile of the durations, or zeros if there is none. The percentile is the
+// upper bound of the bucket it falls in, which is capped by the max.
+func (h *handleDurations) summary() (maxDuration, p99Duration time.Duration) {
+	var counts [handleDurationBuckets + 1]uint64
+	var total uint64
+	for i := range h.counts {
+		counts[i] = h.counts[i].Load()
+		total += counts[i]
+	}
+	if total == 0 {
+		return 0, 0
+	}
+	maxDuration = time.Duration(h.maxDuration.Load())
+	rank := (total*99 + 99) / 100
+	for i, count := range counts[:handleDurationBuckets] {
+		if rank <= count {
+			return maxDuration, min(time.Millisecond<<i, maxDuration)
+		}
+		rank -= count
+	}
+	return maxDuration, maxDuration
+}
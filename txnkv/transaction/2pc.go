@@ -499,25 +499,21 @@ type KVFilter interface {
 func (c *twoPhaseCommitter) checkAssertionByPessimisticLockResults(ctx context.Context, key []byte, flags kv.KeyFlags, mustExist, mustNotExist bool) error {
 	var assertionFailed *tikverr.ErrAssertionFailed
 	if flags.HasLockedValueExists() && mustNotExist {
-		assertionFailed = &tikverr.ErrAssertionFailed{
-			AssertionFailed: &kvrpcpb.AssertionFailed{
-				StartTs:          c.startTS,
-				Key:              key,
-				Assertion:        kvrpcpb.Assertion_NotExist,
-				ExistingStartTs:  0,
-				ExistingCommitTs: 0,
-			},
-		}
+		assertionFailed = tikverr.NewErrAssertionFailed(&kvrpcpb.AssertionFailed{
+			StartTs:          c.startTS,
+			Key:              key,
+			Assertion:        kvrpcpb.Assertion_NotExist,
+			ExistingStartTs:  0,
+			ExistingCommitTs: 0,
+		})
 	} else if !flags.HasLockedValueExists() && mustExist {
-		assertionFailed = &tikverr.ErrAssertionFailed{
-			AssertionFailed: &kvrpcpb.AssertionFailed{
-				StartTs:          c.startTS,
-				Key:              key,
-				Assertion:        kvrpcpb.Assertion_Exist,
-				ExistingStartTs:  0,
-				ExistingCommitTs: 0,
-			},
-		}
+		assertionFailed = tikverr.NewErrAssertionFailed(&kvrpcpb.AssertionFailed{
+			StartTs:          c.startTS,
+			Key:              key,
+			Assertion:        kvrpcpb.Assertion_Exist,
+			ExistingStartTs:  0,
+			ExistingCommitTs: 0,
+		})
 	}
 
 	if assertionFailed != nil {
@@ -98,6 +98,19 @@ type diagnosticContext struct {
 	resolvingRecordToken *int
 	sender               *locate.RegionRequestSender
 	reqDuration          time.Duration
+	// resolveAttempts is the number of times the locks blocking the batch have been tried to be resolved.
+	resolveAttempts int
+}
+
+// lockWaitTimeoutErr returns the error telling that waiting for lock has timed out.
+func (d *diagnosticContext) lockWaitTimeoutErr(lock *txnlock.Lock, waitStartTime time.Time) error {
+	return errors.WithStack(&tikverr.ErrLockWaitTimeoutDetail{
+		Key:             lock.Key,
+		Primary:         lock.Primary,
+		LockTxnID:       lock.TxnID,
+		WaitDuration:    time.Since(waitStartTime),
+		ResolveAttempts: d.resolveAttempts,
+	})
 }
 
 func (action actionPessimisticLock) handleSingleBatch(
@@ -363,6 +376,7 @@ func (action actionPessimisticLock) handlePessimisticLockResponseNormalMode(
 	if action.LockCtx.Stats != nil {
 		resolveLockOpts.Detail = &action.LockCtx.Stats.ResolveLock
 	}
+	diagCtx.resolveAttempts++
 	resolveLockRes, err := c.store.GetLockResolver().ResolveLocksWithOpts(bo, resolveLockOpts)
 	if err != nil {
 		return true, err
@@ -378,7 +392,7 @@ func (action actionPessimisticLock) handlePessimisticLockResponseNormalMode(
 		} else {
 			// the lockWaitTime is set, we should return wait timeout if we are still blocked by a lock
 			if time.Since(action.WaitStartTime).Milliseconds() >= action.LockWaitTime() {
-				return true, errors.WithStack(tikverr.ErrLockWaitTimeout)
+				return true, diagCtx.lockWaitTimeoutErr(locks[0], action.WaitStartTime)
 			}
 		}
 		if action.LockCtx.PessimisticLockWaited != nil {
@@ -492,6 +506,7 @@ func (action actionPessimisticLock) handlePessimisticLockResponseForceLockMode(
 			if action.LockCtx.Stats != nil {
 				resolveLockOpts.Detail = &action.LockCtx.Stats.ResolveLock
 			}
+			diagCtx.resolveAttempts++
 			resolveLockRes, err := c.store.GetLockResolver().ResolveLocksWithOpts(bo, resolveLockOpts)
 			if err != nil {
 				return true, err
@@ -507,7 +522,7 @@ func (action actionPessimisticLock) handlePessimisticLockResponseForceLockMode(
 				} else {
 					// the lockWaitTime is set, we should return wait timeout if we are still blocked by a lock
 					if time.Since(action.WaitStartTime).Milliseconds() >= action.LockWaitTime() {
-						return true, errors.WithStack(tikverr.ErrLockWaitTimeout)
+						return true, diagCtx.lockWaitTimeoutErr(locks[0], action.WaitStartTime)
 					}
 				}
 				if action.LockCtx.PessimisticLockWaited != nil {
@@ -115,6 +115,11 @@ type TxnOptions struct {
 	TxnScope       string
 	StartTS        *uint64
 	PipelinedMemDB bool
+	// PipelinedBatchGetConcurrency is the max number of concurrent requests the pipelined memdb sends
+	// to read the flushed buffer, zero means the default.
+	PipelinedBatchGetConcurrency int
+	// OnClose is called once the transaction is committed or rolled back.
+	OnClose func()
 }
 
 // KVTxn contains methods to interact with a TiKV transaction.
@@ -137,6 +142,10 @@ type KVTxn struct {
 	schemaVer SchemaVer
 	// commitCallback is called after current transaction gets committed
 	commitCallback func(info string, err error)
+	// onClose is called once the transaction is committed or rolled back.
+	onClose func()
+	// pipelinedBatchGetConcurrency is passed to the pipelined memdb, zero means the default.
+	pipelinedBatchGetConcurrency int
 
 	binlog                  BinlogExecutor
 	schemaLeaseChecker      SchemaLeaseChecker
@@ -167,6 +176,9 @@ type KVTxn struct {
 
 	isPipelined     bool
 	pipelinedCancel context.CancelFunc
+
+	// allowReadBeyondSafePoint makes the transaction a best-effort read-only transaction.
+	allowReadBeyondSafePoint bool
 }
 
 // NewTiKVTxn creates a new KVTxn.
@@ -184,9 +196,13 @@ func NewTiKVTxn(store kvstore, snapshot *txnsnapshot.KVSnapshot, startTS uint64,
 		enable1PC:         cfg.Enable1PC,
 		diskFullOpt:       kvrpcpb.DiskFullOpt_NotAllowedOnFull,
 		RequestSource:     snapshot.RequestSource,
+		onClose:           options.OnClose,
+
+		pipelinedBatchGetConcurrency: options.PipelinedBatchGetConcurrency,
 	}
 	if !options.PipelinedMemDB {
 		newTiKVTxn.us = unionstore.NewUnionStore(unionstore.NewMemDBWithContext(), snapshot)
+		newTiKVTxn.us.SetAllowEmptyValues(snapshot.IsAllowEmptyValues())
 		return newTiKVTxn, nil
 	}
 	if err := newTiKVTxn.InitPipelinedMemDB(); err != nil {
@@ -237,10 +253,26 @@ func (txn *KVTxn) BatchGet(ctx context.Context, keys [][]byte) (map[string][]byt
 // Set sets the value for key k as v into kv store.
 // v must NOT be nil or empty, otherwise it returns ErrCannotSetNilValue.
 func (txn *KVTxn) Set(k []byte, v []byte) error {
+	if txn.allowReadBeyondSafePoint {
+		return errors.WithStack(tikverr.ErrWriteInBestEffortTxn)
+	}
 	txn.setCnt++
 	return txn.GetMemBuffer().Set(k, v)
 }
 
+// SetWithTTL sets the value for key k with a TTL in seconds. A zero ttl means the key never expires, which is the
+// same as Set. Otherwise it returns ErrKeyTTLUnsupported without touching the MemBuffer, because the prewrite
+// mutations of the transactional protocol cannot carry a TTL for the key.
+func (txn *KVTxn) SetWithTTL(k []byte, v []byte, ttl uint64) error {
+	if ttl == 0 {
+		return txn.Set(k, v)
+	}
+	if txn.allowReadBeyondSafePoint {
+		return errors.WithStack(tikverr.ErrWriteInBestEffortTxn)
+	}
+	return errors.WithStack(&tikverr.ErrKeyTTLUnsupported{Reason: "prewrite mutations do not carry key ttl"})
+}
+
 // String implements fmt.Stringer interface.
 func (txn *KVTxn) String() string {
 	res := fmt.Sprintf("%d", txn.StartTS())
@@ -258,16 +290,46 @@ func (txn *KVTxn) Iter(k []byte, upperBound []byte) (unionstore.Iterator, error)
 	return txn.us.Iter(k, upperBound)
 }
 
+// IterCtx creates an Iterator like Iter, the scan requests of the iterator are canceled once ctx is done.
+func (txn *KVTxn) IterCtx(ctx context.Context, k []byte, upperBound []byte) (unionstore.Iterator, error) {
+	return txn.us.IterCtx(ctx, k, upperBound)
+}
+
 // IterReverse creates a reversed Iterator positioned on the first entry which key is less than k.
 func (txn *KVTxn) IterReverse(k, lowerBound []byte) (unionstore.Iterator, error) {
 	return txn.us.IterReverse(k, lowerBound)
 }
 
+// IterReverseCtx creates a reversed Iterator like IterReverse, the scan requests of the iterator are canceled once
+// ctx is done.
+func (txn *KVTxn) IterReverseCtx(ctx context.Context, k, lowerBound []byte) (unionstore.Iterator, error) {
+	return txn.us.IterReverseCtx(ctx, k, lowerBound)
+}
+
 // Delete removes the entry for key k from kv store.
 func (txn *KVTxn) Delete(k []byte) error {
+	if txn.allowReadBeyondSafePoint {
+		return errors.WithStack(tikverr.ErrWriteInBestEffortTxn)
+	}
 	return txn.GetMemBuffer().Delete(k)
 }
 
+// SetAllowReadBeyondSafePoint makes the transaction a best-effort read-only transaction whose reads skip the
+// client-side GC safe point validation, see KVSnapshot.SetAllowReadBeyondSafePoint for details.
+// Writing, locking keys and committing mutations are forbidden in such a transaction.
+func (txn *KVTxn) SetAllowReadBeyondSafePoint(b bool) {
+	txn.allowReadBeyondSafePoint = b
+	txn.snapshot.SetAllowReadBeyondSafePoint(b)
+}
+
+// SetAllowEmptyValues makes the reads of the transaction distinguish zero-length values in the store from
+// nonexistent keys, see KVSnapshot.SetAllowEmptyValues for details. Writing an empty value is still rejected with
+// ErrCannotSetNilValue, because an empty value in the MemBuffer stands for a delete.
+func (txn *KVTxn) SetAllowEmptyValues(b bool) {
+	txn.snapshot.SetAllowEmptyValues(b)
+	txn.us.SetAllowEmptyValues(b)
+}
+
 // SetSchemaLeaseChecker sets a hook to check schema version.
 func (txn *KVTxn) SetSchemaLeaseChecker(checker SchemaLeaseChecker) {
 	txn.schemaLeaseChecker = checker
@@ -588,7 +650,11 @@ func (txn *KVTxn) InitPipelinedMemDB() error {
 	txn.committer.resourceGroupTag = txn.resourceGroupTag
 	txn.committer.resourceGroupTagger = txn.resourceGroupTagger
 	txn.committer.resourceGroupName = txn.resourceGroupName
+	if txn.pipelinedBatchGetConcurrency > 0 {
+		pipelinedMemDB.SetBatchGetConcurrency(txn.pipelinedBatchGetConcurrency)
+	}
 	txn.us = unionstore.NewUnionStore(pipelinedMemDB, txn.snapshot)
+	txn.us.SetAllowEmptyValues(txn.snapshot.IsAllowEmptyValues())
 	return nil
 }
 
@@ -617,6 +683,14 @@ func (txn *KVTxn) Commit(ctx context.Context) error {
 	}
 	defer txn.close()
 
+	if txn.store.IsClose() {
+		return errors.WithStack(tikverr.ErrClientClosed)
+	}
+
+	if txn.allowReadBeyondSafePoint && txn.GetMemBuffer().Dirty() {
+		return errors.WithStack(tikverr.ErrWriteInBestEffortTxn)
+	}
+
 	ctx = context.WithValue(ctx, util.RequestSourceKey, *txn.RequestSource)
 
 	if txn.IsInAggressiveLockingMode() {
@@ -711,7 +785,7 @@ func (txn *KVTxn) Commit(ctx context.Context) error {
 	// pessimistic transaction should also bypass latch.
 	// transaction with pipelined memdb should also bypass latch.
 	if txn.store.TxnLatches() == nil || txn.IsPessimistic() || txn.IsPipelined() {
-		err = committer.execute(ctx)
+		err = txn.convertCommitErr(committer.execute(ctx))
 		if val == nil || sessionID > 0 {
 			txn.onCommitted(err)
 		}
@@ -732,7 +806,7 @@ func (txn *KVTxn) Commit(ctx context.Context) error {
 	if lock.IsStale() {
 		return &tikverr.ErrWriteConflictInLatch{StartTS: txn.startTS}
 	}
-	err = committer.execute(ctx)
+	err = txn.convertCommitErr(committer.execute(ctx))
 	if val == nil || sessionID > 0 {
 		txn.onCommitted(err)
 	}
@@ -743,9 +817,22 @@ func (txn *KVTxn) Commit(ctx context.Context) error {
 	return err
 }
 
+// convertCommitErr converts the commit error into ErrClientClosed if the store is closed while committing, unless
+// the result of the commit is undetermined, which must be reported as is.
+func (txn *KVTxn) convertCommitErr(err error) error {
+	if err == nil || !txn.store.IsClose() || tikverr.IsErrorUndetermined(err) {
+		return err
+	}
+	return errors.WithStack(tikverr.ErrClientClosed)
+}
+
 func (txn *KVTxn) close() {
+	wasValid := txn.valid
 	txn.valid = false
 	txn.ClearDiskFullOpt()
+	if wasValid && txn.onClose != nil {
+		txn.onClose()
+	}
 }
 
 // Rollback undoes the transaction operations to KV store.
@@ -1162,6 +1249,9 @@ func (txn *KVTxn) LockKeysFunc(ctx context.Context, lockCtx *tikv.LockCtx, fn fu
 }
 
 func (txn *KVTxn) lockKeys(ctx context.Context, lockCtx *tikv.LockCtx, fn func(), keysInput ...[]byte) error {
+	if txn.allowReadBeyondSafePoint {
+		return errors.WithStack(tikverr.ErrWriteInBestEffortTxn)
+	}
 	if txn.interceptor != nil {
 		// User has called txn.SetRPCInterceptor() to explicitly set an interceptor, we
 		// need to bind it to ctx so that the internal client can perceive and execute
@@ -1684,6 +1774,11 @@ func (txn *KVTxn) SetMemoryFootprintChangeHook(hook func(uint64)) {
 	txn.us.GetMemBuffer().SetMemoryFootprintChangeHook(hook)
 }
 
+// SetMemoryThresholds sets the thresholds of the memory footprint of the memdb, see MemBuffer.SetMemoryThresholds.
+func (txn *KVTxn) SetMemoryThresholds(thresholds []unionstore.MemoryThreshold) {
+	txn.us.GetMemBuffer().SetMemoryThresholds(thresholds)
+}
+
 // Mem returns the current memory footprint
 func (txn *KVTxn) Mem() uint64 {
 	return txn.us.GetMemBuffer().Mem()
@@ -54,6 +54,8 @@ import (
 
 // Scanner support tikv scan
 type Scanner struct {
+	// ctx bounds the requests sent by the scanner.
+	ctx          context.Context
 	snapshot     *KVSnapshot
 	batchSize    int
 	cache        []*kvrpcpb.KvPair
@@ -69,12 +71,13 @@ type Scanner struct {
 	eof   bool
 }
 
-func newScanner(snapshot *KVSnapshot, startKey []byte, endKey []byte, batchSize int, reverse bool) (*Scanner, error) {
+func newScanner(ctx context.Context, snapshot *KVSnapshot, startKey []byte, endKey []byte, batchSize int, reverse bool) (*Scanner, error) {
 	// It must be > 1. Otherwise scanner won't skipFirst.
 	if batchSize <= 1 {
 		batchSize = DefaultScanBatchSize
 	}
 	scanner := &Scanner{
+		ctx:          ctx,
 		snapshot:     snapshot,
 		batchSize:    batchSize,
 		valid:        true,
@@ -115,7 +118,7 @@ const scannerNextMaxBackoff = 20000
 
 // Next return next element.
 func (s *Scanner) Next() error {
-	bo := retry.NewBackofferWithVars(context.WithValue(context.Background(), retry.TxnStartKey, s.snapshot.version), scannerNextMaxBackoff, s.snapshot.vars)
+	bo := retry.NewBackofferWithVars(context.WithValue(s.ctx, retry.TxnStartKey, s.snapshot.version), scannerNextMaxBackoff, s.snapshot.vars)
 	if !s.valid {
 		return errors.New("scanner iterator is invalid")
 	}
@@ -135,10 +138,12 @@ func (s *Scanner) Next() error {
 				s.Close()
 				return nil
 			}
-			err = s.getData(bo)
+			if err = s.snapshot.checkClosed(); err == nil {
+				err = s.getData(bo)
+			}
 			if err != nil {
 				s.Close()
-				return err
+				return s.snapshot.convertReadErr(err)
 			}
 			if s.idx >= len(s.cache) {
 				continue
@@ -163,7 +168,7 @@ func (s *Scanner) Next() error {
 			// The check here does not violate the KeyOnly semantic, because current's value
 			// is filled by resolveCurrentLock which fetches the value by snapshot.get, so an empty
 			// value stands for NotExist
-			if len(current.Value) == 0 {
+			if s.snapshot.isNotExist(current.Value) {
 				continue
 			}
 		}
@@ -181,8 +186,7 @@ func (s *Scanner) startTS() uint64 {
 }
 
 func (s *Scanner) resolveCurrentLock(bo *retry.Backoffer, current *kvrpcpb.KvPair) error {
-	ctx := context.Background()
-	val, err := s.snapshot.get(ctx, bo, current.Key)
+	val, err := s.snapshot.get(s.ctx, bo, current.Key)
 	if err != nil {
 		return err
 	}
@@ -289,7 +293,7 @@ func (s *Scanner) getData(bo *retry.Backoffer) error {
 		}
 		cmdScanResp := resp.Resp.(*kvrpcpb.ScanResponse)
 
-		err = s.snapshot.store.CheckVisibility(s.startTS())
+		err = s.snapshot.checkVisibility()
 		if err != nil {
 			return err
 		}
@@ -39,6 +39,7 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -99,6 +100,8 @@ type kvstore interface {
 	SendReq(bo *retry.Backoffer, req *tikvrpc.Request, regionID locate.RegionVerID, timeout time.Duration) (*tikvrpc.Response, error)
 	// GetOracle gets a timestamp oracle client.
 	GetOracle() oracle.Oracle
+	// IsClose checks whether the store is closed.
+	IsClose() bool
 }
 
 // ReplicaReadAdjuster is a function that adjust the StoreSelectorOption and ReplicaReadType
@@ -126,7 +129,8 @@ type KVSnapshot struct {
 	// NOTE: This representation here is different from the Get and BatchGet API.
 	// cached use len(value)=0 to represent a key-value entry doesn't exist (a reliable truth from TiKV).
 	// In the BatchGet API, it use no key-value entry to represent non-exist.
-	// It's OK as long as there are no zero-byte values in the protocol.
+	// It's OK as long as there are no zero-byte values in the protocol. If allowEmptyValues is set, cached
+	// uses a nil value to represent non-exist instead, and a non-nil empty value is an existing empty value.
 	mu struct {
 		sync.RWMutex
 		hitCnt           int64
@@ -154,6 +158,10 @@ type KVSnapshot struct {
 	sampleStep uint32
 	*util.RequestSource
 	isPipelined bool
+	// allowReadBeyondSafePoint makes the snapshot best-effort, see SetAllowReadBeyondSafePoint.
+	allowReadBeyondSafePoint bool
+	// allowEmptyValues distinguishes empty values from nonexistent keys, see SetAllowEmptyValues.
+	allowEmptyValues bool
 }
 
 // NewTiKVSnapshot creates a snapshot of an TiKV store.
@@ -224,7 +232,7 @@ func (s *KVSnapshot) BatchGetWithTier(ctx context.Context, keys [][]byte, readTi
 		for _, key := range keys {
 			if val, ok := s.mu.cached[string(key)]; ok {
 				atomic.AddInt64(&s.mu.hitCnt, 1)
-				if len(val) > 0 {
+				if !s.isNotExist(val) {
 					m[string(key)] = val
 				}
 			} else {
@@ -252,12 +260,19 @@ func (s *KVSnapshot) BatchGetWithTier(ctx context.Context, keys [][]byte, readTi
 		bo.SetCtx(interceptor.WithRPCInterceptor(bo.GetCtx(), s.mu.interceptor))
 	}
 	s.mu.RUnlock()
+	if err := s.checkClosed(); err != nil {
+		return nil, err
+	}
 	// Create a map to collect key-values from region servers.
 	var mu sync.Mutex
 	err := s.batchGetKeysByRegions(bo, keys, readTier, func(k, v []byte) {
 		// when read buffer tier, empty value means a delete record, should also collect it.
 		if len(v) == 0 && readTier != BatchGetBufferTier {
-			return
+			if !s.allowEmptyValues {
+				return
+			}
+			// TiKV only returns the pairs of existing keys, the empty value is decoded as nil.
+			v = []byte{}
 		}
 
 		mu.Lock()
@@ -266,10 +281,10 @@ func (s *KVSnapshot) BatchGetWithTier(ctx context.Context, keys [][]byte, readTi
 	})
 	s.recordBackoffInfo(bo)
 	if err != nil {
-		return nil, err
+		return nil, s.convertReadErr(err)
 	}
 
-	err = s.store.CheckVisibility(s.version)
+	err = s.checkVisibility()
 	if err != nil {
 		return nil, err
 	}
@@ -606,7 +621,7 @@ func (s *KVSnapshot) Get(ctx context.Context, k []byte) ([]byte, error) {
 		if value, ok := s.mu.cached[string(k)]; ok {
 			atomic.AddInt64(&s.mu.hitCnt, 1)
 			s.mu.RUnlock()
-			if len(value) == 0 {
+			if s.isNotExist(value) {
 				return nil, tikverr.ErrNotExist
 			}
 			return value, nil
@@ -630,23 +645,34 @@ func (s *KVSnapshot) Get(ctx context.Context, k []byte) ([]byte, error) {
 		bo.SetCtx(interceptor.WithRPCInterceptor(bo.GetCtx(), s.mu.interceptor))
 	}
 	s.mu.RUnlock()
+	if err := s.checkClosed(); err != nil {
+		return nil, err
+	}
 	val, err := s.get(ctx, bo, k)
 	s.recordBackoffInfo(bo)
 	if err != nil {
-		return nil, err
+		return nil, s.convertReadErr(err)
 	}
-	err = s.store.CheckVisibility(s.version)
+	err = s.checkVisibility()
 	if err != nil {
 		return nil, err
 	}
 	// Update the cache.
 	s.UpdateSnapshotCache([][]byte{k}, map[string][]byte{string(k): val})
-	if len(val) == 0 {
+	if s.isNotExist(val) {
 		return nil, tikverr.ErrNotExist
 	}
 	return val, nil
 }
 
+// isNotExist returns whether the value read by the snapshot stands for a nonexistent key.
+func (s *KVSnapshot) isNotExist(val []byte) bool {
+	if s.allowEmptyValues {
+		return val == nil
+	}
+	return len(val) == 0
+}
+
 func (s *KVSnapshot) get(ctx context.Context, bo *retry.Backoffer, k []byte) ([]byte, error) {
 	if span := opentracing.SpanFromContext(ctx); span != nil && span.Tracer() != nil {
 		span1 := span.Tracer().StartSpan("tikvSnapshot.get", opentracing.ChildOf(span.Context()))
@@ -755,6 +781,9 @@ func (s *KVSnapshot) get(ctx context.Context, bo *retry.Backoffer, k []byte) ([]
 			s.mergeExecDetail(cmdGetResp.ExecDetailsV2)
 		}
 		val := cmdGetResp.GetValue()
+		if val == nil && s.allowEmptyValues && !cmdGetResp.GetNotFound() && cmdGetResp.GetError() == nil {
+			val = []byte{}
+		}
 		if keyErr := cmdGetResp.GetError(); keyErr != nil {
 			lock, err := txnlock.ExtractLockFromKeyErr(keyErr)
 			if err != nil {
@@ -827,13 +856,24 @@ func (s *KVSnapshot) mergeExecDetail(detail *kvrpcpb.ExecDetailsV2) {
 
 // Iter return a list of key-value pair after `k`.
 func (s *KVSnapshot) Iter(k []byte, upperBound []byte) (unionstore.Iterator, error) {
-	scanner, err := newScanner(s, k, upperBound, s.scanBatchSize, false)
+	return s.IterCtx(context.Background(), k, upperBound)
+}
+
+// IterCtx creates an Iterator like Iter, the scan requests of the iterator are canceled once ctx is done.
+func (s *KVSnapshot) IterCtx(ctx context.Context, k []byte, upperBound []byte) (unionstore.Iterator, error) {
+	scanner, err := newScanner(ctx, s, k, upperBound, s.scanBatchSize, false)
 	return scanner, err
 }
 
 // IterReverse creates a reversed Iterator positioned on the first entry which key is less than k.
 func (s *KVSnapshot) IterReverse(k, lowerBound []byte) (unionstore.Iterator, error) {
-	scanner, err := newScanner(s, lowerBound, k, s.scanBatchSize, true)
+	return s.IterReverseCtx(context.Background(), k, lowerBound)
+}
+
+// IterReverseCtx creates a reversed Iterator like IterReverse, the scan requests of the iterator are canceled once
+// ctx is done.
+func (s *KVSnapshot) IterReverseCtx(ctx context.Context, k, lowerBound []byte) (unionstore.Iterator, error) {
+	scanner, err := newScanner(ctx, s, lowerBound, k, s.scanBatchSize, true)
 	return scanner, err
 }
 
@@ -1123,6 +1163,90 @@ func (s *KVSnapshot) SetPipelined(ts uint64) {
 	s.resolvedLocks.Put(ts)
 }
 
+// SetAllowReadBeyondSafePoint makes the snapshot best-effort. A best-effort snapshot skips the client-side
+// GC safe point validation of reads, so the data it reads may be incomplete if the needed versions have been
+// garbage collected. Reads that fail while the snapshot is behind the safe point return ErrSnapshotLostToGC.
+func (s *KVSnapshot) SetAllowReadBeyondSafePoint(b bool) {
+	s.allowReadBeyondSafePoint = b
+}
+
+// IsBestEffort returns whether the snapshot is allowed to read beyond the GC safe point.
+func (s *KVSnapshot) IsBestEffort() bool {
+	return s.allowReadBeyondSafePoint
+}
+
+// SetAllowEmptyValues makes the snapshot distinguish zero-length values from nonexistent keys. By default a key
+// with an empty value is reported as nonexistent. If it's set, Get returns an empty non-nil value for such a key
+// and BatchGet includes it in the result, by the existence information in the responses rather than the value
+// length. It should be set before any read, since the cached results are not converted.
+func (s *KVSnapshot) SetAllowEmptyValues(b bool) {
+	s.allowEmptyValues = b
+}
+
+// IsAllowEmptyValues returns whether the snapshot distinguishes empty values from nonexistent keys.
+func (s *KVSnapshot) IsAllowEmptyValues() bool {
+	return s.allowEmptyValues
+}
+
+// checkVisibility checks whether the snapshot is still visible after a read.
+// For a best-effort snapshot, falling behind the GC safe point is tolerated and recorded in the runtime stats.
+func (s *KVSnapshot) checkVisibility() error {
+	err := s.store.CheckVisibility(s.version)
+	if err == nil || !s.allowReadBeyondSafePoint {
+		return err
+	}
+	var gcErr *tikverr.ErrGCTooEarly
+	if !errors.As(err, &gcErr) {
+		return err
+	}
+	s.mu.Lock()
+	if s.mu.stats != nil {
+		s.mu.stats.readBeyondSafePoint = true
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// checkClosed returns ErrClientClosed if the store is closed, it's checked before sending requests.
+func (s *KVSnapshot) checkClosed() error {
+	if s.store.IsClose() {
+		return errors.WithStack(tikverr.ErrClientClosed)
+	}
+	return nil
+}
+
+// convertReadErr converts the read error into ErrClientClosed if the store is closed while reading, and converts
+// the read error of a best-effort snapshot into ErrSnapshotLostToGC if the error means the data is gone and the
+// snapshot has fallen behind the GC safe point. Other errors, e.g. context cancellation, network and region
+// errors, are returned unchanged.
+func (s *KVSnapshot) convertReadErr(err error) error {
+	if err != nil && s.store.IsClose() {
+		return errors.WithStack(tikverr.ErrClientClosed)
+	}
+	if err == nil || !s.allowReadBeyondSafePoint || !isDataLostErr(err) {
+		return err
+	}
+	var gcErr *tikverr.ErrGCTooEarly
+	if visibilityErr := s.store.CheckVisibility(s.version); errors.As(visibilityErr, &gcErr) {
+		return errors.WithStack(&tikverr.ErrSnapshotLostToGC{StartTS: s.version, Cause: err})
+	}
+	return err
+}
+
+// isDataLostErr returns true if the read error means the versions being read may have been garbage collected,
+// i.e. the GC safe point has been exceeded, or TiKV fails to find the value of a committed version.
+func isDataLostErr(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var gcErr *tikverr.ErrGCTooEarly
+	if errors.As(err, &gcErr) || errors.Is(err, tikverr.ErrNotExist) {
+		return true
+	}
+	// TiKV reports an MVCC DefaultNotFound error if the write record is found but the value has been removed.
+	return strings.Contains(err.Error(), "default not found")
+}
+
 // SnapshotRuntimeStats records the runtime stats of snapshot.
 type SnapshotRuntimeStats struct {
 	rpcStats          *locate.RegionRequestRuntimeStats
@@ -1131,6 +1255,8 @@ type SnapshotRuntimeStats struct {
 	scanDetail        *util.ScanDetail
 	timeDetail        *util.TimeDetail
 	resolveLockDetail *util.ResolveLockDetail
+	// readBeyondSafePoint is true if a best-effort snapshot has read data beyond the GC safe point.
+	readBeyondSafePoint bool
 }
 
 // Clone implements the RuntimeStats interface.
@@ -1151,16 +1277,19 @@ func (rs *SnapshotRuntimeStats) Clone() *SnapshotRuntimeStats {
 	}
 
 	if rs.scanDetail != nil {
-		newRs.scanDetail = rs.scanDetail
+		scanDetail := *rs.scanDetail
+		newRs.scanDetail = &scanDetail
 	}
 
 	if rs.timeDetail != nil {
-		newRs.timeDetail = rs.timeDetail
+		timeDetail := *rs.timeDetail
+		newRs.timeDetail = &timeDetail
 	}
 
 	if rs.resolveLockDetail != nil {
 		newRs.resolveLockDetail = rs.resolveLockDetail
 	}
+	newRs.readBeyondSafePoint = rs.readBeyondSafePoint
 
 	return &newRs
 }
@@ -1187,6 +1316,19 @@ func (rs *SnapshotRuntimeStats) Merge(other *SnapshotRuntimeStats) {
 			rs.backoffTimes[k] += v
 		}
 	}
+	if other.scanDetail != nil {
+		if rs.scanDetail == nil {
+			rs.scanDetail = &util.ScanDetail{}
+		}
+		rs.scanDetail.Merge(other.scanDetail)
+	}
+	if other.timeDetail != nil {
+		if rs.timeDetail == nil {
+			rs.timeDetail = &util.TimeDetail{}
+		}
+		rs.timeDetail.Merge(other.timeDetail)
+	}
+	rs.readBeyondSafePoint = rs.readBeyondSafePoint || other.readBeyondSafePoint
 }
 
 // String implements fmt.Stringer interface.
@@ -1213,9 +1355,28 @@ func (rs *SnapshotRuntimeStats) String() string {
 		buf.WriteString(", ")
 		buf.WriteString(scanDetail)
 	}
+	if rs.readBeyondSafePoint {
+		if buf.Len() > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString("best_effort: read beyond gc safe point")
+	}
 	return buf.String()
 }
 
+// GetExecDetails returns the TiKV execution details aggregated over the read requests of the snapshot.
+func (rs *SnapshotRuntimeStats) GetExecDetails() util.TiKVExecDetails {
+	return (&util.TiKVExecDetails{
+		TimeDetail: rs.timeDetail,
+		ScanDetail: rs.scanDetail,
+	}).Clone()
+}
+
+// IsReadBeyondSafePoint returns true if a best-effort snapshot has read data beyond the GC safe point.
+func (rs *SnapshotRuntimeStats) IsReadBeyondSafePoint() bool {
+	return rs.readBeyondSafePoint
+}
+
 // GetCmdRPCCount returns the count of the corresponding kind of rpc requests
 func (rs *SnapshotRuntimeStats) GetCmdRPCCount(cmd tikvrpc.CmdType) int64 {
 	if rs.rpcStats == nil || len(rs.rpcStats.RPCStats) == 0 {
@@ -15,6 +15,8 @@
 package txnsnapshot
 
 import (
+	"context"
+
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	"github.com/tikv/client-go/v2/config/retry"
 	"github.com/tikv/client-go/v2/internal/locate"
@@ -54,7 +56,7 @@ func (s SnapshotProbe) BatchGetSingleRegion(bo *retry.Backoffer, region locate.R
 
 // NewScanner returns a scanner to iterate given key range.
 func (s SnapshotProbe) NewScanner(start, end []byte, batchSize int, reverse bool) (*Scanner, error) {
-	return newScanner(s.KVSnapshot, start, end, batchSize, reverse)
+	return newScanner(context.Background(), s.KVSnapshot, start, end, batchSize, reverse)
 }
 
 // ConfigProbe exposes configurations and global variables for testing purpose.
@@ -107,6 +107,47 @@ func NewTiKVExecDetails(pb *kvrpcpb.ExecDetailsV2) TiKVExecDetails {
 	}
 }
 
+// Merge merges the other TiKVExecDetails into itself.
+func (ed *TiKVExecDetails) Merge(other *TiKVExecDetails) {
+	if other == nil {
+		return
+	}
+	if other.TimeDetail != nil {
+		if ed.TimeDetail == nil {
+			ed.TimeDetail = &TimeDetail{}
+		}
+		ed.TimeDetail.Merge(other.TimeDetail)
+	}
+	if other.ScanDetail != nil {
+		if ed.ScanDetail == nil {
+			ed.ScanDetail = &ScanDetail{}
+		}
+		ed.ScanDetail.Merge(other.ScanDetail)
+	}
+	if other.WriteDetail != nil {
+		if ed.WriteDetail == nil {
+			ed.WriteDetail = &WriteDetail{}
+		}
+		ed.WriteDetail.Merge(other.WriteDetail)
+	}
+}
+
+// MergeFromExecDetailsV2 merges the detail from pb into itself.
+func (ed *TiKVExecDetails) MergeFromExecDetailsV2(pb *kvrpcpb.ExecDetailsV2) {
+	if pb == nil {
+		return
+	}
+	details := NewTiKVExecDetails(pb)
+	ed.Merge(&details)
+}
+
+// Clone returns a deep copy of itself.
+func (ed *TiKVExecDetails) Clone() TiKVExecDetails {
+	var details TiKVExecDetails
+	details.Merge(ed)
+	return details
+}
+
 func (ed *TiKVExecDetails) String() string {
 	if ed == nil {
 		return ""
@@ -157,6 +198,10 @@ type CommitDetails struct {
 		// It's recorded only when the commit mode is 2pc.
 		CommitPrimary ReqDetailInfo
 	}
+	// PrewriteExecDetails aggregates the TiKV execution details of all prewrite requests, it's protected by Mu.
+	PrewriteExecDetails TiKVExecDetails
+	// CommitExecDetails aggregates the TiKV execution details of all commit requests, it's protected by Mu.
+	CommitExecDetails TiKVExecDetails
 	WriteKeys         int
 	WriteSize         int
 	PrewriteRegionNum int32
@@ -189,6 +234,8 @@ func (cd *CommitDetails) Merge(other *CommitDetails) {
 	if cd.Mu.CommitPrimary.ReqTotalTime < other.Mu.CommitPrimary.ReqTotalTime {
 		cd.Mu.CommitPrimary = other.Mu.CommitPrimary
 	}
+	cd.PrewriteExecDetails.Merge(&other.PrewriteExecDetails)
+	cd.CommitExecDetails.Merge(&other.CommitExecDetails)
 }
 
 // MergePrewriteReqDetails merges prewrite related ExecDetailsV2 into the current CommitDetails.
@@ -198,6 +245,7 @@ func (cd *CommitDetails) MergePrewriteReqDetails(reqDuration time.Duration, regi
 	}
 	cd.Mu.Lock()
 	defer cd.Mu.Unlock()
+	cd.PrewriteExecDetails.MergeFromExecDetailsV2(execDetails)
 	if reqDuration > cd.Mu.SlowestPrewrite.ReqTotalTime {
 		cd.Mu.SlowestPrewrite.ReqTotalTime = reqDuration
 		cd.Mu.SlowestPrewrite.Region = regionID
@@ -213,6 +261,7 @@ func (cd *CommitDetails) MergeCommitReqDetails(reqDuration time.Duration, region
 	}
 	cd.Mu.Lock()
 	defer cd.Mu.Unlock()
+	cd.CommitExecDetails.MergeFromExecDetailsV2(execDetails)
 	if reqDuration > cd.Mu.CommitPrimary.ReqTotalTime {
 		cd.Mu.CommitPrimary.ReqTotalTime = reqDuration
 		cd.Mu.CommitPrimary.Region = regionID
@@ -245,9 +294,25 @@ func (cd *CommitDetails) Clone() *CommitDetails {
 	commit.Mu.CommitBackoffTypes = append([]string{}, cd.Mu.CommitBackoffTypes...)
 	commit.Mu.SlowestPrewrite = cd.Mu.SlowestPrewrite
 	commit.Mu.CommitPrimary = cd.Mu.CommitPrimary
+	commit.PrewriteExecDetails = cd.PrewriteExecDetails.Clone()
+	commit.CommitExecDetails = cd.CommitExecDetails.Clone()
 	return commit
 }
 
+// GetPrewriteExecDetails returns the aggregated TiKV execution details of the prewrite requests.
+func (cd *CommitDetails) GetPrewriteExecDetails() TiKVExecDetails {
+	cd.Mu.Lock()
+	defer cd.Mu.Unlock()
+	return cd.PrewriteExecDetails.Clone()
+}
+
+// GetCommitExecDetails returns the aggregated TiKV execution details of the commit requests.
+func (cd *CommitDetails) GetCommitExecDetails() TiKVExecDetails {
+	cd.Mu.Lock()
+	defer cd.Mu.Unlock()
+	return cd.CommitExecDetails.Clone()
+}
+
 // LockKeysDetails contains pessimistic lock keys detail information.
 type LockKeysDetails struct {
 	TotalTime                  time.Duration
@@ -404,6 +469,12 @@ type ScanDetail struct {
 	RocksdbBlockReadDuration time.Duration
 	// GetSnapshotDuration is the time spent getting an engine snapshot.
 	GetSnapshotDuration time.Duration
+	// ReadIndexProposeWaitDuration is the time used for proposing read index from read pool to store pool.
+	ReadIndexProposeWaitDuration time.Duration
+	// ReadIndexConfirmWaitDuration is the time used for leader confirmation.
+	ReadIndexConfirmWaitDuration time.Duration
+	// ReadPoolScheduleWaitDuration is the time used for read pool scheduling.
+	ReadPoolScheduleWaitDuration time.Duration
 
 	ResolveLock *ResolveLockDetail
 }
@@ -420,6 +491,9 @@ func (sd *ScanDetail) Merge(scanDetail *ScanDetail) {
 	atomic.AddUint64(&sd.RocksdbBlockReadByte, scanDetail.RocksdbBlockReadByte)
 	atomic.AddInt64((*int64)(&sd.RocksdbBlockReadDuration), int64(scanDetail.RocksdbBlockReadDuration))
 	atomic.AddInt64((*int64)(&sd.GetSnapshotDuration), int64(scanDetail.GetSnapshotDuration))
+	atomic.AddInt64((*int64)(&sd.ReadIndexProposeWaitDuration), int64(scanDetail.ReadIndexProposeWaitDuration))
+	atomic.AddInt64((*int64)(&sd.ReadIndexConfirmWaitDuration), int64(scanDetail.ReadIndexConfirmWaitDuration))
+	atomic.AddInt64((*int64)(&sd.ReadPoolScheduleWaitDuration), int64(scanDetail.ReadPoolScheduleWaitDuration))
 }
 
 var zeroScanDetail = ScanDetail{}
@@ -451,6 +525,21 @@ func (sd *ScanDetail) String() string {
 		buf.WriteString(FormatDuration(sd.GetSnapshotDuration))
 		buf.WriteString(", ")
 	}
+	if sd.ReadIndexProposeWaitDuration > 0 {
+		buf.WriteString("read_index_propose_wait_time: ")
+		buf.WriteString(FormatDuration(sd.ReadIndexProposeWaitDuration))
+		buf.WriteString(", ")
+	}
+	if sd.ReadIndexConfirmWaitDuration > 0 {
+		buf.WriteString("read_index_confirm_wait_time: ")
+		buf.WriteString(FormatDuration(sd.ReadIndexConfirmWaitDuration))
+		buf.WriteString(", ")
+	}
+	if sd.ReadPoolScheduleWaitDuration > 0 {
+		buf.WriteString("read_pool_wait_time: ")
+		buf.WriteString(FormatDuration(sd.ReadPoolScheduleWaitDuration))
+		buf.WriteString(", ")
+	}
 	buf.WriteString("rocksdb: {")
 	if sd.RocksdbDeleteSkippedCount > 0 {
 		buf.WriteString("delete_skipped_count: ")
@@ -502,6 +591,9 @@ func (sd *ScanDetail) MergeFromScanDetailV2(scanDetail *kvrpcpb.ScanDetailV2) {
 		sd.RocksdbBlockReadByte += scanDetail.RocksdbBlockReadByte
 		sd.RocksdbBlockReadDuration += time.Duration(scanDetail.RocksdbBlockReadNanos) * time.Nanosecond
 		sd.GetSnapshotDuration += time.Duration(scanDetail.GetSnapshotNanos) * time.Nanosecond
+		sd.ReadIndexProposeWaitDuration += time.Duration(scanDetail.ReadIndexProposeWaitNanos) * time.Nanosecond
+		sd.ReadIndexConfirmWaitDuration += time.Duration(scanDetail.ReadIndexConfirmWaitNanos) * time.Nanosecond
+		sd.ReadPoolScheduleWaitDuration += time.Duration(scanDetail.ReadPoolScheduleWaitNanos) * time.Nanosecond
 	}
 }
 
@@ -627,6 +719,10 @@ type TimeDetail struct {
 	KvReadWallTime time.Duration
 	// TotalRPCWallTime is Total wall clock time spent on this RPC in TiKV.
 	TotalRPCWallTime time.Duration
+	// KvGrpcProcessTime is the time spent on the gRPC layer.
+	KvGrpcProcessTime time.Duration
+	// KvGrpcWaitTime is the time spent on waiting for run again in grpc pool from other executor pool.
+	KvGrpcWaitTime time.Duration
 }
 
 // String implements the fmt.Stringer interface.
@@ -667,6 +763,20 @@ func (td *TimeDetail) String() string {
 		buf.WriteString("tikv_wall_time: ")
 		buf.WriteString(FormatDuration(td.TotalRPCWallTime))
 	}
+	if td.KvGrpcProcessTime > 0 {
+		if buf.Len() > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString("tikv_grpc_process_time: ")
+		buf.WriteString(FormatDuration(td.KvGrpcProcessTime))
+	}
+	if td.KvGrpcWaitTime > 0 {
+		if buf.Len() > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString("tikv_grpc_wait_time: ")
+		buf.WriteString(FormatDuration(td.KvGrpcWaitTime))
+	}
 	if buf.Len() == 0 {
 		return ""
 	}
@@ -682,6 +792,8 @@ func (td *TimeDetail) Merge(detail *TimeDetail) {
 		atomic.AddInt64((*int64)(&td.WaitTime), int64(detail.WaitTime))
 		atomic.AddInt64((*int64)(&td.KvReadWallTime), int64(detail.KvReadWallTime))
 		atomic.AddInt64((*int64)(&td.TotalRPCWallTime), int64(detail.TotalRPCWallTime))
+		atomic.AddInt64((*int64)(&td.KvGrpcProcessTime), int64(detail.KvGrpcProcessTime))
+		atomic.AddInt64((*int64)(&td.KvGrpcWaitTime), int64(detail.KvGrpcWaitTime))
 	}
 }
 
@@ -693,6 +805,8 @@ func (td *TimeDetail) MergeFromTimeDetail(timeDetailV2 *kvrpcpb.TimeDetailV2, ti
 		td.SuspendTime += time.Duration(timeDetailV2.ProcessSuspendWallTimeNs) * time.Nanosecond
 		td.KvReadWallTime += time.Duration(timeDetailV2.KvReadWallTimeNs) * time.Nanosecond
 		td.TotalRPCWallTime += time.Duration(timeDetailV2.TotalRpcWallTimeNs) * time.Nanosecond
+		td.KvGrpcProcessTime += time.Duration(timeDetailV2.KvGrpcProcessTimeNs) * time.Nanosecond
+		td.KvGrpcWaitTime += time.Duration(timeDetailV2.KvGrpcWaitTimeNs) * time.Nanosecond
 	} else if timeDetail != nil {
 		td.WaitTime += time.Duration(timeDetail.WaitWallTimeMs) * time.Millisecond
 		td.ProcessTime += time.Duration(timeDetail.ProcessWallTimeMs) * time.Millisecond
@@ -0,0 +1,130 @@
+// Copyright 2024 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestExecDetailsV2() *kvrpcpb.ExecDetailsV2 {
+	return &kvrpcpb.ExecDetailsV2{
+		TimeDetailV2: &kvrpcpb.TimeDetailV2{
+			WaitWallTimeNs:           uint64(time.Millisecond),
+			ProcessWallTimeNs:        uint64(2 * time.Millisecond),
+			ProcessSuspendWallTimeNs: uint64(3 * time.Millisecond),
+			KvReadWallTimeNs:         uint64(4 * time.Millisecond),
+			TotalRpcWallTimeNs:       uint64(10 * time.Millisecond),
+			KvGrpcProcessTimeNs:      uint64(5 * time.Millisecond),
+			KvGrpcWaitTimeNs:         uint64(6 * time.Millisecond),
+		},
+		ScanDetailV2: &kvrpcpb.ScanDetailV2{
+			ProcessedVersions:         10,
+			ProcessedVersionsSize:     100,
+			TotalVersions:             15,
+			RocksdbBlockCacheHitCount: 7,
+			RocksdbBlockReadCount:     3,
+			GetSnapshotNanos:          500,
+			ReadIndexProposeWaitNanos: 600,
+			ReadIndexConfirmWaitNanos: 700,
+			ReadPoolScheduleWaitNanos: 800,
+		},
+		WriteDetail: &kvrpcpb.WriteDetail{
+			PersistLogNanos: 1000,
+		},
+	}
+}
+
+func TestNewTiKVExecDetails(t *testing.T) {
+	assert := assert.New(t)
+
+	details := NewTiKVExecDetails(newTestExecDetailsV2())
+	assert.Equal(time.Millisecond, details.TimeDetail.WaitTime)
+	assert.Equal(2*time.Millisecond, details.TimeDetail.ProcessTime)
+	assert.Equal(3*time.Millisecond, details.TimeDetail.SuspendTime)
+	assert.Equal(4*time.Millisecond, details.TimeDetail.KvReadWallTime)
+	assert.Equal(10*time.Millisecond, details.TimeDetail.TotalRPCWallTime)
+	assert.Equal(5*time.Millisecond, details.TimeDetail.KvGrpcProcessTime)
+	assert.Equal(6*time.Millisecond, details.TimeDetail.KvGrpcWaitTime)
+	assert.Equal(int64(10), details.ScanDetail.ProcessedKeys)
+	assert.Equal(int64(100), details.ScanDetail.ProcessedKeysSize)
+	assert.Equal(int64(15), details.ScanDetail.TotalKeys)
+	assert.Equal(uint64(7), details.ScanDetail.RocksdbBlockCacheHitCount)
+	assert.Equal(uint64(3), details.ScanDetail.RocksdbBlockReadCount)
+	assert.Equal(500*time.Nanosecond, details.ScanDetail.GetSnapshotDuration)
+	assert.Equal(600*time.Nanosecond, details.ScanDetail.ReadIndexProposeWaitDuration)
+	assert.Equal(700*time.Nanosecond, details.ScanDetail.ReadIndexConfirmWaitDuration)
+	assert.Equal(800*time.Nanosecond, details.ScanDetail.ReadPoolScheduleWaitDuration)
+	assert.Equal(1000*time.Nanosecond, details.WriteDetail.PersistLogDuration)
+
+	assert.Equal("time_detail: {total_process_time: 2ms, total_suspend_time: 3ms, total_wait_time: 1ms, "+
+		"total_kv_read_wall_time: 4ms, tikv_wall_time: 10ms, tikv_grpc_process_time: 5ms, tikv_grpc_wait_time: 6ms}",
+		details.TimeDetail.String())
+	assert.Equal("scan_detail: {total_process_keys: 10, total_process_keys_size: 100, total_keys: 15, "+
+		"get_snapshot_time: 500ns, read_index_propose_wait_time: 600ns, read_index_confirm_wait_time: 700ns, "+
+		"read_pool_wait_time: 800ns, rocksdb: {block: {cache_hit_count: 7, read_count: 3}}}",
+		details.ScanDetail.String())
+
+	empty := NewTiKVExecDetails(nil)
+	assert.Nil(empty.TimeDetail)
+	assert.Nil(empty.ScanDetail)
+	assert.Nil(empty.WriteDetail)
+}
+
+func TestTiKVExecDetailsMerge(t *testing.T) {
+	assert := assert.New(t)
+
+	var details TiKVExecDetails
+	details.MergeFromExecDetailsV2(nil)
+	assert.Nil(details.ScanDetail)
+	details.MergeFromExecDetailsV2(newTestExecDetailsV2())
+	details.MergeFromExecDetailsV2(newTestExecDetailsV2())
+	assert.Equal(int64(20), details.ScanDetail.ProcessedKeys)
+	assert.Equal(uint64(14), details.ScanDetail.RocksdbBlockCacheHitCount)
+	assert.Equal(1600*time.Nanosecond, details.ScanDetail.ReadPoolScheduleWaitDuration)
+	assert.Equal(6*time.Millisecond, details.TimeDetail.SuspendTime)
+	assert.Equal(12*time.Millisecond, details.TimeDetail.KvGrpcWaitTime)
+	assert.Equal(2000*time.Nanosecond, details.WriteDetail.PersistLogDuration)
+
+	cloned := details.Clone()
+	cloned.MergeFromExecDetailsV2(newTestExecDetailsV2())
+	assert.Equal(int64(30), cloned.ScanDetail.ProcessedKeys)
+	assert.Equal(int64(20), details.ScanDetail.ProcessedKeys)
+}
+
+func TestCommitDetailsExecDetails(t *testing.T) {
+	assert := assert.New(t)
+
+	cd := &CommitDetails{}
+	cd.MergePrewriteReqDetails(time.Millisecond, 1, "store1", newTestExecDetailsV2())
+	cd.MergePrewriteReqDetails(2*time.Millisecond, 2, "store2", newTestExecDetailsV2())
+	cd.MergeCommitReqDetails(time.Millisecond, 1, "store1", newTestExecDetailsV2())
+
+	prewrite := cd.GetPrewriteExecDetails()
+	assert.Equal(int64(20), prewrite.ScanDetail.ProcessedKeys)
+	assert.Equal(2000*time.Nanosecond, prewrite.WriteDetail.PersistLogDuration)
+	commit := cd.GetCommitExecDetails()
+	assert.Equal(int64(10), commit.ScanDetail.ProcessedKeys)
+	assert.Equal(uint64(2), cd.Mu.SlowestPrewrite.Region)
+
+	other := cd.Clone()
+	cd.Merge(other)
+	assert.Equal(int64(40), cd.GetPrewriteExecDetails().ScanDetail.ProcessedKeys)
+	assert.Equal(int64(20), cd.GetCommitExecDetails().ScanDetail.ProcessedKeys)
+	assert.Equal(int64(20), other.GetPrewriteExecDetails().ScanDetail.ProcessedKeys)
+}
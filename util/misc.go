@@ -43,6 +43,7 @@ import (
 	"time"
 	"unsafe"
 
+	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pkg/errors"
 	"github.com/tikv/client-go/v2/internal/logutil"
 	"go.uber.org/zap"
@@ -105,6 +106,21 @@ func SetSessionID(ctx context.Context, sessionID uint64) context.Context {
 	return context.WithValue(ctx, SessionID, sessionID)
 }
 
+type regionEpochCtxKey struct{}
+
+// WithRegionEpoch returns a copy of ctx carrying the epoch of the region that a task is run on.
+func WithRegionEpoch(ctx context.Context, epoch *metapb.RegionEpoch) context.Context {
+	return context.WithValue(ctx, regionEpochCtxKey{}, epoch)
+}
+
+// RegionEpochFromCtx returns the region epoch set by WithRegionEpoch, nil is returned if it's not set.
+func RegionEpochFromCtx(ctx context.Context) *metapb.RegionEpoch {
+	if val, ok := ctx.Value(regionEpochCtxKey{}).(*metapb.RegionEpoch); ok {
+		return val
+	}
+	return nil
+}
+
 const (
 	byteSizeGB = int64(1 << 30)
 	byteSizeMB = int64(1 << 20)
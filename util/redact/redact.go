@@ -0,0 +1,80 @@
+// Copyright 2024 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package redact decides how user keys are printed in logs and error messages.
+package redact
+
+import (
+	"encoding/hex"
+	"sync/atomic"
+)
+
+// Mode is the strategy to print keys.
+type Mode int32
+
+const (
+	// ModeOff prints keys in hex, which is the default.
+	ModeOff Mode = iota
+	// ModeMarker replaces keys with the "?" marker.
+	ModeMarker
+	// ModeCustom prints keys by the redactor set by SetKeyRedactor, e.g. a hash of the key so the same key
+	// can still be correlated. The marker is used if no redactor is set.
+	ModeCustom
+)
+
+// Marker is what a key is replaced with in ModeMarker.
+const Marker = "?"
+
+var (
+	mode        atomic.Int32
+	keyRedactor atomic.Pointer[func(key []byte) string]
+)
+
+// SetMode sets the strategy to print keys, it's safe to be called concurrently with the printing.
+func SetMode(m Mode) {
+	mode.Store(int32(m))
+}
+
+// GetMode returns the strategy to print keys.
+func GetMode() Mode {
+	return Mode(mode.Load())
+}
+
+// SetKeyRedactor sets the function used to print keys in ModeCustom, a nil function unsets it.
+// It's safe to be called concurrently with the printing, and the function must be safe for concurrent use.
+func SetKeyRedactor(f func(key []byte) string) {
+	if f == nil {
+		keyRedactor.Store(nil)
+		return
+	}
+	keyRedactor.Store(&f)
+}
+
+// NeedRedact returns whether keys are hidden from logs and error messages.
+func NeedRedact() bool {
+	return GetMode() != ModeOff
+}
+
+// Key returns the printable form of key under the current mode.
+func Key(key []byte) string {
+	switch GetMode() {
+	case ModeOff:
+		return hex.EncodeToString(key)
+	case ModeCustom:
+		if f := keyRedactor.Load(); f != nil {
+			return (*f)(key)
+		}
+	}
+	return Marker
+}
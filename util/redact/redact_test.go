@@ -0,0 +1,94 @@
+// Copyright 2024 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func hashKey(key []byte) string {
+	sum := sha256.Sum256(key)
+	return "h:" + hex.EncodeToString(sum[:4])
+}
+
+func TestKey(t *testing.T) {
+	defer func() {
+		SetMode(ModeOff)
+		SetKeyRedactor(nil)
+	}()
+	key := []byte("user-key")
+
+	require.Equal(t, ModeOff, GetMode())
+	require.False(t, NeedRedact())
+	require.Equal(t, hex.EncodeToString(key), Key(key))
+
+	SetMode(ModeMarker)
+	require.True(t, NeedRedact())
+	require.Equal(t, "?", Key(key))
+
+	// the marker is used until a redactor is set.
+	SetMode(ModeCustom)
+	require.True(t, NeedRedact())
+	require.Equal(t, "?", Key(key))
+	SetKeyRedactor(hashKey)
+	require.Equal(t, hashKey(key), Key(key))
+	require.Equal(t, Key(key), Key([]byte("user-key")))
+	require.NotEqual(t, Key(key), Key([]byte("other-key")))
+
+	// the redactor is kept but not used in other modes.
+	SetMode(ModeMarker)
+	require.Equal(t, "?", Key(key))
+	SetMode(ModeOff)
+	require.Equal(t, hex.EncodeToString(key), Key(key))
+	SetMode(ModeCustom)
+	SetKeyRedactor(nil)
+	require.Equal(t, "?", Key(key))
+}
+
+func TestKeyConcurrently(t *testing.T) {
+	defer func() {
+		SetMode(ModeOff)
+		SetKeyRedactor(nil)
+	}()
+	key := []byte("k")
+	allowed := map[string]struct{}{hex.EncodeToString(key): {}, "?": {}, hashKey(key): {}}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			SetMode(Mode(i % 3))
+			if i%2 == 0 {
+				SetKeyRedactor(hashKey)
+			} else {
+				SetKeyRedactor(nil)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			_, ok := allowed[Key(key)]
+			require.True(t, ok)
+		}
+	}()
+	wg.Wait()
+}
@@ -185,3 +185,22 @@ func ResourceGroupNameFromCtx(ctx context.Context) string {
 	}
 	return ""
 }
+
+type resourceGroupTagKeyType struct{}
+
+var resourceGroupTagKey = resourceGroupTagKeyType{}
+
+// WithResourceGroupTag return a copy of the given context with a associated
+// resource group tag, which is stamped to the requests without a tag of their own.
+func WithResourceGroupTag(ctx context.Context, tag []byte) context.Context {
+	return context.WithValue(ctx, resourceGroupTagKey, tag)
+}
+
+// ResourceGroupTagFromCtx extract resource group tag from passed context,
+// nil is returned if the key is not set.
+func ResourceGroupTagFromCtx(ctx context.Context) []byte {
+	if val := ctx.Value(resourceGroupTagKey); val != nil {
+		return val.([]byte)
+	}
+	return nil
+}